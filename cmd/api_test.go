@@ -0,0 +1,520 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"taksopark/internal/DTO"
+	"taksopark/internal/config"
+	"taksopark/internal/repository"
+	"testing"
+)
+
+// client calls the API served over the in-memory repositories, with key as
+// the bearer credential when it is set.
+type client struct {
+	t   *testing.T
+	srv *httptest.Server
+	key string
+}
+
+func newClient(t *testing.T) *client {
+	t.Helper()
+	cfg := config.Default()
+	cfg.Auth.Enabled = false
+	return serve(t, cfg)
+}
+
+const bootstrapKey = "bootstrap-bootstrap-bootstrap-bootstrap"
+
+// newAuthClient serves the API with authentication on and calls it with
+// the bootstrap key.
+func newAuthClient(t *testing.T) *client {
+	t.Helper()
+	cfg := config.Default()
+	cfg.Auth.Enabled = true
+	cfg.Auth.JWTSecret = "0123456789abcdef0123456789abcdef"
+	cfg.Auth.BootstrapKey = bootstrapKey
+	c := serve(t, cfg)
+	c.key = bootstrapKey
+	return c
+}
+
+func serve(t *testing.T, cfg config.Config) *client {
+	t.Helper()
+	cfg.Documents.Dir = t.TempDir()
+	srv := httptest.NewServer(Handler(cfg, repository.NewMemory(repository.DeleteBlock)))
+	t.Cleanup(srv.Close)
+	return &client{t: t, srv: srv}
+}
+
+// as returns a client of the same server that authenticates with key.
+func (c *client) as(key string) *client {
+	return &client{t: c.t, srv: c.srv, key: key}
+}
+
+// newKey creates an API key and returns its id and secret.
+func (c *client) newKey(body object) (uint, string) {
+	c.t.Helper()
+	var res struct {
+		KeyID uint   `json:"key_id"`
+		Key   string `json:"key"`
+	}
+	if code := c.do(http.MethodPost, "/api-keys", body, &res); code != http.StatusCreated {
+		c.t.Fatalf("POST /api-keys: got %d", code)
+	}
+	return res.KeyID, res.Key
+}
+
+// do sends body as JSON and decodes the response into dst unless it is nil.
+func (c *client) do(method, path string, body any, dst any) int {
+	c.t.Helper()
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			c.t.Fatal(err)
+		}
+	}
+	req, err := http.NewRequest(method, c.srv.URL+path, &buf)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.key != "" {
+		req.Header.Set("Authorization", "Bearer "+c.key)
+	}
+
+	res, err := c.srv.Client().Do(req)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if dst != nil {
+		if err := json.NewDecoder(res.Body).Decode(dst); err != nil {
+			c.t.Fatalf("%s %s: decode response: %v", method, path, err)
+		}
+	}
+	return res.StatusCode
+}
+
+// mustCreate posts body to path and fails the test unless it is created.
+func (c *client) mustCreate(path string, body any) {
+	c.t.Helper()
+	var e DTO.ErrorResponse
+	if code := c.do(http.MethodPost, path, body, &e); code != http.StatusCreated {
+		c.t.Fatalf("POST %s: got %d %s", path, code, e.Error.Message)
+	}
+}
+
+// expectError checks that a request fails with status and code.
+func (c *client) expectError(method, path string, body any, status int, code string) DTO.Error {
+	c.t.Helper()
+	var e DTO.ErrorResponse
+	if got := c.do(method, path, body, &e); got != status || e.Error.Code != code {
+		c.t.Errorf("%s %s: got %d %q (%s), want %d %q", method, path, got, e.Error.Code, e.Error.Message, status, code)
+	}
+	return e.Error
+}
+
+type object = map[string]any
+
+// seed creates car 1 of model 1, driver 1, customer 1 and an economy tariff.
+func (c *client) seed() {
+	c.t.Helper()
+	c.mustCreate("/models", object{"model_name": "Solaris", "manufacturer": "Hyundai"})
+	c.mustCreate("/cars", object{"license_plate": "A001AA", "model_id": 1, "year": 2020})
+	c.mustCreate("/drivers", object{"first_name": "Ivan", "last_name": "Petrov", "lisence_number": "7701"})
+	c.mustCreate("/customers", object{"first_name": "Anna", "last_name": "Smirnova", "phone": "+79990000001"})
+	c.mustCreate("/tariffs", object{"class": "economy", "base_fare": 100, "per_km": 20, "per_minute": 5})
+}
+
+// completedTrip is a finished trip of driver 1 in car 1 with customer 1.
+func completedTrip() object {
+	return object{
+		"status": "completed", "customer_id": 1, "driver_id": 1, "car_id": 1,
+		"start_lat": 55.75, "start_lon": 37.61, "end_lat": 55.8, "end_lon": 37.7,
+		"start_time": "2026-10-01T09:00:00Z", "end_time": "2026-10-01T09:20:00Z",
+	}
+}
+
+// shift covers completedTrip.
+func shift() object {
+	return object{
+		"driver_id": 1, "car_id": 1, "odometer_in": 0, "odometer_out": 100,
+		"started_at": "2026-10-01T08:00:00Z", "ended_at": "2026-10-01T20:00:00Z",
+	}
+}
+
+func TestDuplicateUniqueValues(t *testing.T) {
+	c := newClient(t)
+	c.seed()
+
+	c.expectError(http.MethodPost, "/cars", object{"license_plate": "A001AA", "model_id": 1, "year": 2021},
+		http.StatusConflict, "duplicate")
+	c.expectError(http.MethodPost, "/drivers", object{"first_name": "Petr", "last_name": "Ivanov", "lisence_number": "7701"},
+		http.StatusConflict, "duplicate")
+
+	c.mustCreate("/cars", object{"license_plate": "B002BB", "model_id": 1, "year": 2021})
+	c.expectError(http.MethodPut, "/cars/2", object{"license_plate": "A001AA", "model_id": 1, "year": 2021},
+		http.StatusConflict, "duplicate")
+}
+
+func TestMissingReferences(t *testing.T) {
+	c := newClient(t)
+	c.seed()
+	c.mustCreate("/shifts", shift())
+
+	c.expectError(http.MethodPost, "/cars", object{"license_plate": "B002BB", "model_id": 9, "year": 2020},
+		http.StatusUnprocessableEntity, "invalid_reference")
+
+	for _, field := range []string{"driver_id", "car_id", "customer_id"} {
+		trip := completedTrip()
+		trip[field] = 9
+		c.expectError(http.MethodPost, "/trips", trip, http.StatusUnprocessableEntity, "invalid_reference")
+	}
+}
+
+func TestDeleteReferenced(t *testing.T) {
+	c := newClient(t)
+	c.seed()
+	c.mustCreate("/shifts", shift())
+	c.mustCreate("/trips", completedTrip())
+
+	for _, path := range []string{"/models/1", "/cars/1", "/drivers/1", "/customers/1"} {
+		c.expectError(http.MethodDelete, path, nil, http.StatusConflict, "in_use")
+	}
+
+	c.mustCreate("/models", object{"model_name": "Rio", "manufacturer": "Kia"})
+	if code := c.do(http.MethodDelete, "/models/2", nil, nil); code != http.StatusNoContent {
+		t.Errorf("DELETE /models/2: got %d, want %d", code, http.StatusNoContent)
+	}
+}
+
+// expectFields checks that a request fails validation on exactly fields.
+func (c *client) expectFields(method, path string, body any, fields ...string) {
+	c.t.Helper()
+	e := c.expectError(method, path, body, http.StatusUnprocessableEntity, "validation_failed")
+	var got []string
+	for _, f := range e.Fields {
+		got = append(got, f.Field)
+	}
+	if fmt.Sprint(got) != fmt.Sprint(fields) {
+		c.t.Errorf("%s %s: invalid fields %v, want %v", method, path, got, fields)
+	}
+}
+
+func TestUpdateKeepsLifecycle(t *testing.T) {
+	c := newClient(t)
+	c.seed()
+	c.mustCreate("/cars", object{"license_plate": "B002BB", "model_id": 1, "year": 2021})
+	c.mustCreate("/shifts", object{"driver_id": 1, "car_id": 1, "odometer_in": 0})
+
+	trip := object{"customer_id": 1, "start_lat": 55.75, "start_lon": 37.61, "end_lat": 55.8, "end_lon": 37.7}
+	c.mustCreate("/trips", trip)
+
+	assigned := object{"driver_id": 1, "car_id": 1, "start_time": "2026-10-01T09:00:00Z"}
+	for k, v := range trip {
+		assigned[k] = v
+	}
+	c.expectFields(http.MethodPut, "/trips/1", assigned, "driver_id", "car_id", "start_time")
+	c.expectFields(http.MethodPatch, "/trips/1", object{"end_time": "2026-10-01T09:20:00Z"}, "end_time")
+
+	var got struct {
+		Status     string  `json:"status"`
+		DriverID   *uint   `json:"driver_id"`
+		AssignedAt *string `json:"assigned_at"`
+	}
+	if code := c.do(http.MethodPost, "/trips/1/assign", object{"driver_id": 1, "car_id": 1}, &got); code != http.StatusOK {
+		t.Fatalf("assign: got %d", code)
+	}
+	if got.Status != "assigned" || got.DriverID == nil || got.AssignedAt == nil {
+		t.Errorf("assign: got status %s, driver %v, assigned at %v", got.Status, got.DriverID, got.AssignedAt)
+	}
+
+	c.expectFields(http.MethodPatch, "/trips/1", object{"car_id": 2}, "car_id")
+	c.expectFields(http.MethodPatch, "/trips/1", object{"driver_id": nil}, "driver_id")
+	if code := c.do(http.MethodPatch, "/trips/1", object{"end_lat": 55.9}, nil); code != http.StatusOK {
+		t.Errorf("PATCH end_lat: got %d, want %d", code, http.StatusOK)
+	}
+}
+
+func TestUpdateCompletedTrip(t *testing.T) {
+	c := newClient(t)
+	c.seed()
+	c.mustCreate("/cars", object{"license_plate": "B002BB", "model_id": 1, "year": 2021})
+	c.mustCreate("/shifts", shift())
+	c.mustCreate("/trips", completedTrip())
+
+	c.expectFields(http.MethodPatch, "/trips/1", object{"car_id": nil}, "car_id")
+	c.expectError(http.MethodPatch, "/trips/1", object{"car_id": 2}, http.StatusConflict, "no_shift")
+
+	c.mustCreate("/drivers", object{"first_name": "Petr", "last_name": "Ivanov", "lisence_number": "7702"})
+	c.mustCreate("/shifts", object{
+		"driver_id": 2, "car_id": 2, "odometer_in": 0, "odometer_out": 100,
+		"started_at": "2026-10-01T08:00:00Z", "ended_at": "2026-10-01T20:00:00Z",
+	})
+	c.mustCreate("/maintenance", object{"car_id": 2, "service_type": "oil", "odometer": 100})
+	c.expectError(http.MethodPatch, "/trips/1", object{"driver_id": 2, "car_id": 2}, http.StatusConflict, "car_unavailable")
+}
+
+func TestTransitionBodies(t *testing.T) {
+	c := newClient(t)
+	c.seed()
+	c.mustCreate("/trips", object{"customer_id": 1, "start_lat": 55.75, "start_lon": 37.61, "end_lat": 55.8, "end_lon": 37.7})
+
+	c.expectFields(http.MethodPost, "/trips/1/assign", object{}, "driver_id", "car_id")
+	c.expectFields(http.MethodPost, "/trips/1/assign", object{"driver_id": 1}, "car_id")
+	c.expectFields(http.MethodPost, "/trips/1/offer", object{"car_id": 1}, "driver_id")
+	c.expectFields(http.MethodPost, "/trips/1/cancel", object{}, "reason")
+	c.expectFields(http.MethodPost, "/trips/1/cancel", object{"reason": "  "}, "reason")
+}
+
+func TestCreateCompletedTripChecksAssignment(t *testing.T) {
+	c := newClient(t)
+	c.seed()
+	c.mustCreate("/shifts", shift())
+
+	c.mustCreate("/maintenance", object{"car_id": 1, "service_type": "oil", "odometer": 100})
+	c.expectError(http.MethodPost, "/trips", completedTrip(), http.StatusConflict, "car_unavailable")
+
+	c.mustCreate("/maintenance-rules", object{"model_id": 1, "service_type": "inspection", "interval_km": 50})
+	if code := c.do(http.MethodPost, "/maintenance/1/finish", object{"cost": 0}, nil); code != http.StatusOK {
+		t.Fatalf("finish maintenance: got %d", code)
+	}
+	e := c.expectError(http.MethodPost, "/trips", completedTrip(), http.StatusConflict, "car_unavailable")
+	if e.Details["reason"] != "maintenance_overdue" {
+		t.Errorf("reason: got %v, want maintenance_overdue", e.Details["reason"])
+	}
+
+	c.mustCreate("/cars", object{"license_plate": "B002BB", "model_id": 1, "year": 2021})
+	c.mustCreate("/drivers", object{"first_name": "Petr", "last_name": "Ivanov", "lisence_number": "7702"})
+	c.mustCreate("/shifts", object{
+		"driver_id": 2, "car_id": 2, "odometer_in": 0, "odometer_out": 10,
+		"started_at": "2026-10-01T08:00:00Z", "ended_at": "2026-10-01T20:00:00Z",
+	})
+	c.mustCreate("/drivers/2/documents", object{"kind": "licence", "number": "77 01", "issued_at": "2010-01-01T00:00:00Z", "expires_at": "2020-01-01T00:00:00Z"})
+	trip := completedTrip()
+	trip["driver_id"], trip["car_id"] = 2, 2
+	c.expectError(http.MethodPost, "/trips", trip, http.StatusConflict, "documents_expired")
+}
+
+func TestConsumptionByCar(t *testing.T) {
+	c := newClient(t)
+	c.seed()
+	c.mustCreate("/cars", object{"license_plate": "B002BB", "model_id": 1, "year": 2021})
+	for car, km := range map[int]int{1: 500, 2: 1000} {
+		c.mustCreate("/fuel-entries", object{"car_id": car, "odometer": 0, "amount": 10, "unit": "l", "filled_at": "2026-10-03T00:00:00Z"})
+		c.mustCreate("/fuel-entries", object{"car_id": car, "odometer": km, "amount": 60, "unit": "l", "filled_at": "2026-10-06T00:00:00Z"})
+	}
+
+	var got []DTO.CarConsumption
+	if code := c.do(http.MethodGet, "/cars/consumption?car_id=2", nil, &got); code != http.StatusOK {
+		t.Fatalf("consumption: got %d", code)
+	}
+	if len(got) != 1 || got[0].CarID != 2 || got[0].ModelPerHundredKm == nil || *got[0].ModelPerHundredKm != 8 {
+		t.Errorf("consumption of car 2: got %+v, want car 2 against the model average 8", got)
+	}
+	c.expectError(http.MethodGet, "/cars/consumption?car_id=x", nil, http.StatusBadRequest, "bad_request")
+}
+
+func TestUpdateKeepsFare(t *testing.T) {
+	c := newClient(t)
+	c.seed()
+	c.mustCreate("/customers", object{"first_name": "Oleg", "last_name": "Sidorov", "phone": "+79990000002"})
+	c.mustCreate("/shifts", shift())
+	c.mustCreate("/trips", completedTrip())
+
+	var before, after struct {
+		Cost float64 `json:"cost"`
+		Fare struct {
+			BaseFare float64 `json:"base_fare"`
+			Total    float64 `json:"total"`
+		} `json:"fare"`
+	}
+	c.do(http.MethodGet, "/trips/1", nil, &before)
+	if code := c.do(http.MethodPut, "/tariffs/1", object{"class": "economy", "base_fare": 500, "per_km": 50, "per_minute": 10}, nil); code != http.StatusOK {
+		t.Fatalf("PUT /tariffs/1: got %d", code)
+	}
+
+	if code := c.do(http.MethodPatch, "/trips/1", object{"customer_id": 2}, &after); code != http.StatusOK {
+		t.Fatalf("PATCH customer_id: got %d", code)
+	}
+	if after != before {
+		t.Errorf("PATCH customer_id: fare %+v, want %+v", after, before)
+	}
+
+	trip := completedTrip()
+	delete(trip, "status")
+	trip["customer_id"] = 2
+	if code := c.do(http.MethodPut, "/trips/1", trip, &after); code != http.StatusOK {
+		t.Fatalf("PUT customer_id: got %d", code)
+	}
+	if after != before {
+		t.Errorf("PUT customer_id: fare %+v, want %+v", after, before)
+	}
+
+	if code := c.do(http.MethodPatch, "/trips/1", object{"end_time": "2026-10-01T09:30:00Z"}, &after); code != http.StatusOK {
+		t.Fatalf("PATCH end_time: got %d", code)
+	}
+	if after.Fare.BaseFare != 500 {
+		t.Errorf("PATCH end_time: base fare %v, want the new tariff's 500", after.Fare.BaseFare)
+	}
+}
+
+func TestNearbyRejectsNonFinite(t *testing.T) {
+	c := newClient(t)
+	for _, query := range []string{
+		"lat=NaN&lon=37.61",
+		"lat=55.75&lon=nan",
+		"lat=55.75&lon=-Inf",
+		"lat=55.75&lon=37.61&radius=NaN",
+		"lat=55.75&lon=37.61&radius=Inf",
+		"lat=55.75&lon=37.61&radius=+Infinity",
+	} {
+		c.expectError(http.MethodGet, "/cars/nearby?"+query, nil, http.StatusBadRequest, "bad_request")
+	}
+}
+
+func TestAuditActorKey(t *testing.T) {
+	c := newAuthClient(t)
+	first, firstKey := c.newKey(object{"name": "ops", "role": "dispatcher"})
+	second, secondKey := c.newKey(object{"name": "ops", "role": "dispatcher"})
+	c.as(firstKey).mustCreate("/customers", object{"first_name": "Anna", "last_name": "Smirnova", "phone": "+79990000001"})
+	c.as(secondKey).mustCreate("/customers", object{"first_name": "Oleg", "last_name": "Sidorov", "phone": "+79990000002"})
+
+	var page struct {
+		Items []struct {
+			EntityID   uint   `json:"entity_id"`
+			Actor      string `json:"actor"`
+			ActorKeyID *uint  `json:"actor_key_id"`
+		} `json:"items"`
+	}
+	if code := c.do(http.MethodGet, "/audit?entity=customers&sort=audit_id", nil, &page); code != http.StatusOK {
+		t.Fatalf("GET /audit: got %d", code)
+	}
+	if len(page.Items) != 2 {
+		t.Fatalf("GET /audit: got %d entries, want 2", len(page.Items))
+	}
+	for i, want := range []uint{first, second} {
+		e := page.Items[i]
+		if e.Actor != "ops" || e.ActorKeyID == nil || *e.ActorKeyID != want {
+			t.Errorf("customer %d: actor %q key %v, want ops key %d", e.EntityID, e.Actor, e.ActorKeyID, want)
+		}
+	}
+
+	if code := c.do(http.MethodGet, fmt.Sprintf("/audit?entity=customers&actor_key_id=%d", second), nil, &page); code != http.StatusOK {
+		t.Fatalf("GET /audit: got %d", code)
+	}
+	if len(page.Items) != 1 || page.Items[0].EntityID != 2 {
+		t.Errorf("GET /audit?actor_key_id=%d: got %+v, want customer 2", second, page.Items)
+	}
+}
+
+func TestUpdateDriverTakesItsOwnShape(t *testing.T) {
+	c := newClient(t)
+	c.seed()
+
+	var driver object
+	if code := c.do(http.MethodGet, "/drivers/1", nil, &driver); code != http.StatusOK {
+		t.Fatalf("GET /drivers/1: got %d", code)
+	}
+	body := object{"first_name": driver["first_name"], "last_name": driver["last_name"], "lisence_number": "7799"}
+	if code := c.do(http.MethodPut, "/drivers/1", body, &driver); code != http.StatusOK {
+		t.Fatalf("PUT /drivers/1: got %d", code)
+	}
+	if driver["lisence_number"] != "7799" {
+		t.Errorf("PUT /drivers/1: lisence_number %v, want 7799", driver["lisence_number"])
+	}
+	c.expectError(http.MethodPut, "/drivers/1", object{"first_name": "Ivan", "last_name": "Petrov", "license_number": "7799"},
+		http.StatusBadRequest, "bad_request")
+}
+
+func TestTripAggregatesCountCompletedTrips(t *testing.T) {
+	c := newClient(t)
+	c.seed()
+	c.mustCreate("/drivers", object{"first_name": "Petr", "last_name": "Ivanov", "lisence_number": "7702"})
+	c.mustCreate("/cars", object{"license_plate": "B002BB", "model_id": 1, "year": 2021})
+	c.mustCreate("/shifts", shift())
+	c.mustCreate("/shifts", object{"driver_id": 2, "car_id": 2, "odometer_in": 0})
+	c.mustCreate("/trips", completedTrip())
+	// Trip 2 is under way and has a start time but no end time yet.
+	c.mustCreate("/trips", object{"customer_id": 1, "start_lat": 55.75, "start_lon": 37.61, "end_lat": 55.8, "end_lon": 37.7})
+	if code := c.do(http.MethodPost, "/trips/2/assign", object{"driver_id": 2, "car_id": 2}, nil); code != http.StatusOK {
+		t.Fatalf("POST /trips/2/assign: got %d", code)
+	}
+	for _, path := range []string{"/trips/2/depart", "/trips/2/pickup"} {
+		if code := c.do(http.MethodPost, path, nil, nil); code != http.StatusOK {
+			t.Fatalf("POST %s: got %d", path, code)
+		}
+	}
+
+	var counts []struct {
+		Person struct {
+			Name string `json:"name"`
+		} `json:"person"`
+		Count uint `json:"count"`
+	}
+	if code := c.do(http.MethodGet, "/drivers/count", nil, &counts); code != http.StatusOK {
+		t.Fatalf("GET /drivers/count: got %d", code)
+	}
+	if got := fmt.Sprint(counts); got != "[{{Ivan} 1} {{Petr} 0}]" {
+		t.Errorf("GET /drivers/count: got %s", got)
+	}
+
+	var stat struct {
+		Min, Max int
+		Avg      float32
+	}
+	if code := c.do(http.MethodGet, "/statistics", nil, &stat); code != http.StatusOK {
+		t.Fatalf("GET /statistics: got %d", code)
+	}
+	if stat.Min != 20 || stat.Max != 20 || stat.Avg != 20 {
+		t.Errorf("GET /statistics: got %+v, want 20 minutes", stat)
+	}
+}
+
+func TestUploadFileName(t *testing.T) {
+	c := newClient(t)
+	c.seed()
+	c.mustCreate("/drivers/1/documents", object{"kind": "licence", "number": "77 01", "issued_at": "2020-01-01T00:00:00Z", "expires_at": "2030-01-01T00:00:00Z"})
+
+	upload := func(name string) (int, []byte) {
+		req, err := http.NewRequest(http.MethodPut, c.srv.URL+"/drivers/1/documents/1/file", strings.NewReader("%PDF-1.4"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/pdf")
+		req.Header.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+		res, err := c.srv.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		body, err := io.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res.StatusCode, body
+	}
+
+	// The limit is in characters, like VARCHAR(255).
+	longest := strings.Repeat("я", 251) + ".pdf"
+	if code, body := upload(longest); code != http.StatusOK || !strings.Contains(string(body), longest) {
+		t.Errorf("upload of a 255 character name: got %d %s", code, body)
+	}
+	if code, body := upload("x" + longest); code != http.StatusBadRequest {
+		t.Errorf("upload of a 256 character name: got %d %s, want 400", code, body)
+	}
+	var doc struct {
+		FileName string `json:"file_name"`
+	}
+	c.do(http.MethodGet, "/drivers/1/documents/1", nil, &doc)
+	if doc.FileName != longest {
+		t.Errorf("refused upload changed the file name to %q", doc.FileName)
+	}
+}
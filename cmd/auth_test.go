@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"taksopark/internal/DTO"
+	"taksopark/internal/jwt"
+	"testing"
+	"time"
+)
+
+// token issues a bearer token with the credential of c.
+func (c *client) token() string {
+	c.t.Helper()
+	var res DTO.Token
+	if code := c.do(http.MethodPost, "/auth/token", nil, &res); code != http.StatusOK {
+		c.t.Fatalf("POST /auth/token: got %d", code)
+	}
+	return res.AccessToken
+}
+
+func TestAuthentication(t *testing.T) {
+	c := newAuthClient(t)
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	now := time.Now()
+
+	rotatedID, rotatedKey := c.newKey(object{"name": "rotated", "role": "dispatcher"})
+	rotatedToken := c.as(rotatedKey).token()
+	var rotation struct {
+		Key string `json:"key"`
+	}
+	if code := c.do(http.MethodPost, "/api-keys/"+fmt.Sprint(rotatedID)+"/rotate", nil, &rotation); code != http.StatusOK {
+		t.Fatalf("rotate: got %d", code)
+	}
+
+	revokedID, revokedKey := c.newKey(object{"name": "revoked", "role": "dispatcher"})
+	revokedToken := c.as(revokedKey).token()
+	if code := c.do(http.MethodPost, "/api-keys/"+fmt.Sprint(revokedID)+"/revoke", nil, nil); code != http.StatusOK {
+		t.Fatalf("revoke: got %d", code)
+	}
+
+	validID, validKey := c.newKey(object{"name": "valid", "role": "dispatcher"})
+	validToken := c.as(validKey).token()
+	sign := func(claims jwt.Claims, secret []byte) string {
+		token, err := jwt.Sign(claims, secret)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	hour := now.Add(time.Hour).Unix()
+
+	tests := []struct {
+		name   string
+		header string
+		want   int
+	}{
+		{"no credentials", "", http.StatusUnauthorized},
+		{"basic scheme", "Basic " + validKey, http.StatusUnauthorized},
+		{"empty bearer", "Bearer ", http.StatusUnauthorized},
+		{"unknown key", "Bearer tp_0000000000000000000000000000000000000000000000000", http.StatusUnauthorized},
+		{"key", "Bearer " + validKey, http.StatusOK},
+		{"token", "Bearer " + validToken, http.StatusOK},
+		{"bootstrap key", "Bearer " + bootstrapKey, http.StatusOK},
+		{"bootstrap token", "Bearer " + c.token(), http.StatusOK},
+		{"rotated key", "Bearer " + rotatedKey, http.StatusUnauthorized},
+		{"token of the rotated key", "Bearer " + rotatedToken, http.StatusUnauthorized},
+		{"new key of the rotated key", "Bearer " + rotation.Key, http.StatusOK},
+		{"revoked key", "Bearer " + revokedKey, http.StatusUnauthorized},
+		{"token of the revoked key", "Bearer " + revokedToken, http.StatusUnauthorized},
+		{"token signed with another secret", "Bearer " + sign(jwt.Claims{Subject: "valid", KeyID: validID, KeyPrefix: validKey[:11], ExpiresAt: hour}, []byte("another secret")), http.StatusUnauthorized},
+		{"expired token", "Bearer " + sign(jwt.Claims{Subject: "valid", KeyID: validID, KeyPrefix: validKey[:11], ExpiresAt: now.Add(-time.Minute).Unix()}, secret), http.StatusUnauthorized},
+		{"token of an unknown key", "Bearer " + sign(jwt.Claims{Subject: "valid", KeyID: 99, KeyPrefix: validKey[:11], ExpiresAt: hour}, secret), http.StatusUnauthorized},
+		{"token with another prefix", "Bearer " + sign(jwt.Claims{Subject: "valid", KeyID: validID, KeyPrefix: "tp_00000000", ExpiresAt: hour}, secret), http.StatusUnauthorized},
+		{"keyless token of another subject", "Bearer " + sign(jwt.Claims{Subject: "admin", ExpiresAt: hour}, secret), http.StatusUnauthorized},
+		{"malformed token", "Bearer a.b.c", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, c.srv.URL+"/models", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			res, err := c.srv.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res.Body.Close()
+			if res.StatusCode != tt.want {
+				t.Errorf("GET /models: got %d, want %d", res.StatusCode, tt.want)
+			}
+			if tt.want == http.StatusUnauthorized && res.Header.Get("WWW-Authenticate") == "" {
+				t.Error("401 without WWW-Authenticate")
+			}
+		})
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"taksopark/internal/models"
+
+	"gorm.io/gorm"
+)
+
+const backfillBatchSize = 500
+
+// Backfill measures trips stored before distance and average speed were
+// computed on save. Rows are updated directly, without touching any other
+// column.
+func Backfill(db *gorm.DB) error {
+	var trips []models.Trip
+	var n int
+	err := db.Model(&models.Trip{}).
+		Select("trip_id, start_lat, start_lon, end_lat, end_lon, start_time, end_time").
+		FindInBatches(&trips, backfillBatchSize, func(tx *gorm.DB, batch int) error {
+			for i := range trips {
+				trips[i].Measure()
+				err := db.Model(&models.Trip{}).Where("trip_id = ?", trips[i].TripID).UpdateColumns(map[string]any{
+					"distance_km":   trips[i].DistanceKm,
+					"avg_speed_kmh": trips[i].AvgSpeedKmh,
+				}).Error
+				if err != nil {
+					return err
+				}
+			}
+			n += len(trips)
+			return nil
+		}).Error
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("measured %d trips\n", n)
+	return nil
+}
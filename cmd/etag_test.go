@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"taksopark/internal/DTO"
+	"testing"
+)
+
+// conditional sends a request with a precondition header and returns the
+// status, the ETag and the body of the response.
+func (c *client) conditional(method, path, header, value string, body any) (int, string, []byte) {
+	c.t.Helper()
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			c.t.Fatal(err)
+		}
+	}
+	req, err := http.NewRequest(method, c.srv.URL+path, &buf)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if header != "" {
+		req.Header.Set(header, value)
+	}
+
+	res, err := c.srv.Client().Do(req)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	defer res.Body.Close()
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return res.StatusCode, res.Header.Get("ETag"), data
+}
+
+func TestIfNoneMatch(t *testing.T) {
+	c := newClient(t)
+	c.seed()
+
+	tests := []struct {
+		value string
+		want  int
+	}{
+		{"", http.StatusOK},
+		{`"1"`, http.StatusNotModified},
+		{`W/"1"`, http.StatusNotModified},
+		{`"0", "1"`, http.StatusNotModified},
+		{"*", http.StatusNotModified},
+		{`"2"`, http.StatusOK},
+		{"1", http.StatusOK},
+	}
+	for _, tt := range tests {
+		code, tag, body := c.conditional(http.MethodGet, "/cars/1", "If-None-Match", tt.value, nil)
+		if code != tt.want || tag != `"1"` {
+			t.Errorf("GET /cars/1 with If-None-Match %s: got %d %s, want %d \"1\"", tt.value, code, tag, tt.want)
+		}
+		if code == http.StatusNotModified && len(body) != 0 {
+			t.Errorf("GET /cars/1 with If-None-Match %s: 304 with body %s", tt.value, body)
+		}
+	}
+
+	// Lists have a weak tag of their content.
+	code, tag, _ := c.conditional(http.MethodGet, "/cars", "", "", nil)
+	if code != http.StatusOK || !strings.HasPrefix(tag, `W/"`) {
+		t.Fatalf("GET /cars: got %d with ETag %q", code, tag)
+	}
+	if code, _, _ := c.conditional(http.MethodGet, "/cars", "If-None-Match", tag, nil); code != http.StatusNotModified {
+		t.Errorf("GET /cars with its ETag: got %d, want 304", code)
+	}
+	if code, _, _ := c.conditional(http.MethodGet, "/cars?limit=1", "If-None-Match", tag, nil); code != http.StatusOK {
+		t.Errorf("GET /cars?limit=1 with the ETag of /cars: got %d, want 200", code)
+	}
+	c.mustCreate("/cars", object{"license_plate": "B002BB", "model_id": 1, "year": 2021})
+	if code, changed, _ := c.conditional(http.MethodGet, "/cars", "If-None-Match", tag, nil); code != http.StatusOK || changed == tag {
+		t.Errorf("GET /cars after a change: got %d with ETag %s, want 200 and a new tag", code, changed)
+	}
+}
+
+func TestIfMatch(t *testing.T) {
+	c := newClient(t)
+	c.seed()
+	car := object{"license_plate": "A001AA", "model_id": 1, "year": 2021}
+
+	// A stale version is refused with the current one.
+	code, _, body := c.conditional(http.MethodPut, "/cars/1", "If-Match", `"2"`, car)
+	var e DTO.ErrorResponse
+	if err := json.Unmarshal(body, &e); err != nil {
+		t.Fatal(err)
+	}
+	if code != http.StatusPreconditionFailed || e.Error.Code != "precondition_failed" || e.Error.Details["version"] != float64(1) {
+		t.Errorf("PUT /cars/1 with a stale version: got %d %s", code, body)
+	}
+	var stored struct {
+		Year    int  `json:"year"`
+		Version uint `json:"version"`
+	}
+	c.do(http.MethodGet, "/cars/1", nil, &stored)
+	if stored.Year != 2020 || stored.Version != 1 {
+		t.Errorf("refused PUT changed the car to %+v", stored)
+	}
+
+	c.mustCreate("/cars", object{"license_plate": "B002BB", "model_id": 1, "year": 2021})
+	c.mustCreate("/shifts", object{"driver_id": 1, "car_id": 2, "odometer_in": 0})
+	c.mustCreate("/trips", object{"customer_id": 1, "start_lat": 55.75, "start_lon": 37.61, "end_lat": 55.8, "end_lon": 37.7})
+	tests := []struct {
+		name, method, path, value string
+		body                      any
+		want                      int
+		tag                       string
+	}{
+		{"current version", http.MethodPut, "/cars/1", `"1"`, car, http.StatusOK, `"2"`},
+		{"lost update", http.MethodPut, "/cars/1", `"1"`, car, http.StatusPreconditionFailed, ""},
+		{"weak tags never match", http.MethodPatch, "/cars/1", `W/"2"`, object{"year": 2022}, http.StatusPreconditionFailed, ""},
+		{"one of a list", http.MethodPatch, "/cars/1", `"1", "2"`, object{"year": 2022}, http.StatusOK, `"3"`},
+		{"any version", http.MethodPatch, "/cars/1", "*", object{"year": 2023}, http.StatusOK, `"4"`},
+		{"unconditional", http.MethodPatch, "/cars/1", "", object{"year": 2024}, http.StatusOK, `"5"`},
+		{"stale delete", http.MethodDelete, "/cars/1", `"4"`, nil, http.StatusPreconditionFailed, ""},
+		{"stale transition", http.MethodPost, "/trips/1/assign", `"2"`, object{"driver_id": 1, "car_id": 2}, http.StatusPreconditionFailed, ""},
+		{"delete", http.MethodDelete, "/cars/1", `"5"`, nil, http.StatusNoContent, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, tag, body := c.conditional(tt.method, tt.path, "If-Match", tt.value, tt.body)
+			if code != tt.want || (tt.tag != "" && tag != tt.tag) {
+				t.Errorf("%s %s with If-Match %s: got %d %s %s, want %d %s", tt.method, tt.path, tt.value, code, tag, body, tt.want, tt.tag)
+			}
+		})
+	}
+}
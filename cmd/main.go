@@ -2,84 +2,266 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
+	"taksopark/internal/config"
+	"taksopark/internal/migrations"
+	"taksopark/internal/repository"
 	"taksopark/internal/services"
+	_ "time/tzdata"
 
+	"github.com/glebarez/sqlite"
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
 )
 
-var db *gorm.DB
+func InitDB(cfg config.DBConfig) (*gorm.DB, error) {
+	var dialector gorm.Dialector
+	switch cfg.Driver {
+	case config.DriverSQLite:
+		dialector = sqlite.Open(sqliteDSN(cfg.DSN))
+	default:
+		dialector = mysql.Open(cfg.DSN)
+	}
 
-func InitDB() {
-	dbconnect := "root:1234@tcp(127.0.0.1:3306)/taksopark?charset=utf8mb4&parseTime=True&loc=Local"
+	db, err := gorm.Open(dialector, &gorm.Config{TranslateError: true})
+	if err != nil {
+		return nil, err
+	}
 
-	var err error
-	db, err = gorm.Open(mysql.Open(dbconnect), &gorm.Config{})
+	sqlDB, err := db.DB()
 	if err != nil {
-		log.Fatalf("Connection error to database: %v", err)
+		return nil, err
+	}
+	sqlDB.SetMaxOpenConns(cfg.MaxOpenConns)
+	sqlDB.SetMaxIdleConns(cfg.MaxIdleConns)
+	sqlDB.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+
+	return db, nil
+}
+
+// sqliteDSN turns on foreign key enforcement, which SQLite keeps disabled
+// by default for every new connection, and makes transactions take the
+// write lock up front so that check-then-write sequences do not race.
+func sqliteDSN(dsn string) string {
+	if strings.Contains(dsn, "foreign_keys") {
+		return dsn
+	}
+	sep := "?"
+	if strings.Contains(dsn, "?") {
+		sep = "&"
+	}
+	return dsn + sep + "_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_txlock=immediate"
+}
+
+func CheckSchema(db *gorm.DB) error {
+	m, err := migrations.NewMigrator(db)
+	if err != nil {
+		return err
+	}
+	if err := m.Check(); err != nil {
+		if errors.Is(err, migrations.ErrOutdated) {
+			return fmt.Errorf("%w, run \"taksopark migrate up\"", err)
+		}
+		return err
 	}
+	return nil
+}
 
+const usage = "usage: taksopark [flags] [migrate up|down|status|to <version> | backfill]"
+
+// runCommand runs a maintenance command instead of the server.
+func runCommand(db *gorm.DB, args []string) error {
+	switch args[0] {
+	case "migrate":
+		return Migrate(db, args[1:])
+	case "backfill":
+		if err := CheckSchema(db); err != nil {
+			return err
+		}
+		return Backfill(db)
+	default:
+		return errors.New(usage)
+	}
 }
 
-func Run() error {
+// bodyLimit caps request bodies at max bytes, document files at files
+// bytes.
+func bodyLimit(next http.Handler, max, files int) http.Handler {
+	limit := http.NewServeMux()
+	limit.Handle("PUT /drivers/{id}/documents/{doc}/file", http.MaxBytesHandler(next, int64(files)))
+	limit.Handle("/", http.MaxBytesHandler(next, int64(max)))
+	return limit
+}
 
-	service := services.NewService(db)
+// Handler routes the API to the services over repos, with the
+// middleware every request passes through.
+func Handler(cfg config.Config, repos repository.Repositories) http.Handler {
+	service := services.NewService(repos, cfg)
 
 	h := http.NewServeMux()
-	h.HandleFunc("POST /cars", service.Cars.Create)
-	h.HandleFunc("GET /cars", service.Cars.GetAll)
-	h.HandleFunc("GET /cars/{id}", service.Cars.Get)
-	h.HandleFunc("PUT /cars/{id}", service.Cars.Update)
-	h.HandleFunc("PATCH /cars/{id}", service.Cars.UpdateSomething)
-	h.HandleFunc("DELETE /cars/{id}", service.Cars.Delete)
-
-	h.HandleFunc("POST /customers", service.Customers.Create)
-	h.HandleFunc("GET /customers", service.Customers.GetAll)
-	h.HandleFunc("GET /customers/{id}", service.Customers.Get)
-	h.HandleFunc("PUT /customers/{id}", service.Customers.Update)
-	h.HandleFunc("PATCH /customers/{id}", service.Customers.UpdateSomething)
-	h.HandleFunc("DELETE /customers/{id}", service.Customers.Delete)
-
-	h.HandleFunc("POST /models", service.Models.Create)
-	h.HandleFunc("GET /models", service.Models.GetAll)
-	h.HandleFunc("GET /models/{id}", service.Models.Get)
-	h.HandleFunc("PUT /models/{id}", service.Models.Update)
-	h.HandleFunc("PATCH /models/{id}", service.Models.UpdateSomething)
-	h.HandleFunc("DELETE /models/{id}", service.Models.Delete)
-
-	h.HandleFunc("POST /drivers", service.Drivers.Create)
-	h.HandleFunc("GET /drivers", service.Drivers.GetAll)
-	h.HandleFunc("GET /drivers/{id}", service.Drivers.Get)
-	h.HandleFunc("PUT /drivers/{id}", service.Drivers.Update)
-	h.HandleFunc("PATCH /drivers/{id}", service.Drivers.UpdateSomething)
-	h.HandleFunc("DELETE /drivers/{id}", service.Drivers.Delete)
-
-	h.HandleFunc("POST /trips", service.Trips.Create)
-	h.HandleFunc("GET /trips", service.Trips.GetAll)
-	h.HandleFunc("GET /trips/{id}", service.Trips.Get)
-	h.HandleFunc("PUT /trips/{id}", service.Trips.Update)
-	h.HandleFunc("PATCH /trips/{id}", service.Trips.UpdateSomething)
-	h.HandleFunc("DELETE /trips/{id}", service.Trips.Delete)
-
-	h.HandleFunc("GET /cars/year/{year}", service.Query.CarOfYear)
-	h.HandleFunc("GET /drivers/count", service.Query.DriverTripCounter)
-	h.HandleFunc("GET /drivers/autocount", service.Query.DriverTripAutoCounter)
-	h.HandleFunc("GET /clients/trips/{n}", service.Query.ClientTripMoreThan)
-	h.HandleFunc("GET /drivers/best", service.Query.BestDrivers)
-	h.HandleFunc("GET /statistics", service.Query.Statistic)
+	handle := func(pattern string, perm services.Permission, fn http.HandlerFunc) {
+		h.Handle(pattern, service.Auth.Require(perm, fn))
+	}
+
+	handle("POST /cars", services.PermCarsWrite, service.Cars.Create)
+	handle("GET /cars", services.PermCarsRead, service.Cars.GetAll)
+	handle("GET /cars/{id}", services.PermCarsRead, service.Cars.Get)
+	handle("PUT /cars/{id}", services.PermCarsWrite, service.Cars.Update)
+	handle("PATCH /cars/{id}", services.PermCarsWrite, service.Cars.UpdateSomething)
+	handle("DELETE /cars/{id}", services.PermCarsWrite, service.Cars.Delete)
+	handle("POST /cars/{id}/restore", services.PermCarsWrite, service.Cars.Restore)
+
+	handle("POST /customers", services.PermCustomersWrite, service.Customers.Create)
+	handle("GET /customers", services.PermCustomersRead, service.Customers.GetAll)
+	handle("GET /customers/{id}", services.PermCustomersRead, service.Customers.Get)
+	handle("PUT /customers/{id}", services.PermCustomersWrite, service.Customers.Update)
+	handle("PATCH /customers/{id}", services.PermCustomersWrite, service.Customers.UpdateSomething)
+	handle("DELETE /customers/{id}", services.PermCustomersWrite, service.Customers.Delete)
+	handle("POST /customers/{id}/restore", services.PermCustomersWrite, service.Customers.Restore)
+
+	handle("POST /models", services.PermModelsWrite, service.Models.Create)
+	handle("GET /models", services.PermModelsRead, service.Models.GetAll)
+	handle("GET /models/{id}", services.PermModelsRead, service.Models.Get)
+	handle("PUT /models/{id}", services.PermModelsWrite, service.Models.Update)
+	handle("PATCH /models/{id}", services.PermModelsWrite, service.Models.UpdateSomething)
+	handle("DELETE /models/{id}", services.PermModelsWrite, service.Models.Delete)
+
+	handle("POST /drivers", services.PermDriversWrite, service.Drivers.Create)
+	handle("GET /drivers", services.PermDriversRead, service.Drivers.GetAll)
+	handle("GET /drivers/{id}", services.PermDriversRead, service.Drivers.Get)
+	handle("PUT /drivers/{id}", services.PermDriversWrite, service.Drivers.Update)
+	handle("PATCH /drivers/{id}", services.PermDriversWrite, service.Drivers.UpdateSomething)
+	handle("DELETE /drivers/{id}", services.PermDriversWrite, service.Drivers.Delete)
+	handle("POST /drivers/{id}/restore", services.PermDriversWrite, service.Drivers.Restore)
+	handle("POST /drivers/{id}/documents", services.PermDriversWrite, service.Documents.Create)
+	handle("GET /drivers/{id}/documents", services.PermDriversRead, service.Documents.GetAll)
+	handle("GET /drivers/{id}/documents/{doc}", services.PermDriversRead, service.Documents.Get)
+	handle("PUT /drivers/{id}/documents/{doc}", services.PermDriversWrite, service.Documents.Update)
+	handle("DELETE /drivers/{id}/documents/{doc}", services.PermDriversWrite, service.Documents.Delete)
+	handle("PUT /drivers/{id}/documents/{doc}/file", services.PermDriversWrite, service.Documents.Upload)
+	handle("GET /drivers/{id}/documents/{doc}/file", services.PermDriversRead, service.Documents.Download)
+
+	handle("POST /trips", services.PermTripsCreate, service.Trips.Create)
+	handle("GET /trips", services.PermTripsRead, service.Trips.GetAll)
+	handle("GET /trips/{id}", services.PermTripsRead, service.Trips.Get)
+	handle("PUT /trips/{id}", services.PermTripsWrite, service.Trips.Update)
+	handle("PATCH /trips/{id}", services.PermTripsWrite, service.Trips.UpdateSomething)
+	handle("DELETE /trips/{id}", services.PermTripsWrite, service.Trips.Delete)
+	handle("POST /trips/{id}/restore", services.PermTripsWrite, service.Trips.Restore)
+	handle("POST /trips/{id}/assign", services.PermTripsAssign, service.Trips.Assign)
+	handle("POST /trips/{id}/depart", services.PermTripsDrive, service.Trips.Depart)
+	handle("POST /trips/{id}/pickup", services.PermTripsDrive, service.Trips.Pickup)
+	handle("POST /trips/{id}/complete", services.PermTripsDrive, service.Trips.Complete)
+	handle("POST /trips/{id}/cancel", services.PermTripsAssign, service.Trips.Cancel)
+	handle("POST /trips/{id}/offer", services.PermTripsAssign, service.Trips.Offer)
+
+	handle("GET /me", services.PermSelfService, service.Me.Profile)
+	handle("POST /me/online", services.PermSelfService, service.Me.Online)
+	handle("POST /me/offline", services.PermSelfService, service.Me.Offline)
+	handle("GET /me/trips", services.PermSelfService, service.Me.Trips)
+	handle("GET /me/trips/current", services.PermSelfService, service.Me.CurrentTrip)
+	handle("GET /me/trips/{id}", services.PermSelfService, service.Me.Trip)
+	handle("GET /me/earnings", services.PermSelfService, service.Me.Earnings)
+	handle("GET /me/offers", services.PermSelfService, service.Me.Offers)
+	handle("POST /me/offers/{id}/accept", services.PermSelfService, service.Me.Accept)
+	handle("POST /me/offers/{id}/decline", services.PermSelfService, service.Me.Decline)
+	handle("GET /me/shift", services.PermSelfService, service.Me.CurrentShift)
+	handle("POST /me/shift/open", services.PermSelfService, service.Me.OpenShift)
+	handle("POST /me/shift/close", services.PermSelfService, service.Me.CloseShift)
 
+	handle("POST /shifts", services.PermShiftsWrite, service.Shifts.Create)
+	handle("GET /shifts", services.PermShiftsRead, service.Shifts.GetAll)
+	handle("GET /shifts/{id}", services.PermShiftsRead, service.Shifts.Get)
+	handle("POST /shifts/{id}/close", services.PermShiftsWrite, service.Shifts.Close)
+
+	handle("POST /maintenance", services.PermMaintenanceWrite, service.Maintenance.Create)
+	handle("GET /maintenance", services.PermMaintenanceRead, service.Maintenance.GetAll)
+	handle("GET /maintenance/{id}", services.PermMaintenanceRead, service.Maintenance.Get)
+	handle("PUT /maintenance/{id}", services.PermMaintenanceWrite, service.Maintenance.Update)
+	handle("DELETE /maintenance/{id}", services.PermMaintenanceWrite, service.Maintenance.Delete)
+	handle("POST /maintenance/{id}/finish", services.PermMaintenanceWrite, service.Maintenance.Finish)
+	handle("POST /maintenance-rules", services.PermMaintenanceWrite, service.Maintenance.CreateRule)
+	handle("GET /maintenance-rules", services.PermMaintenanceRead, service.Maintenance.GetRules)
+	handle("GET /maintenance-rules/{id}", services.PermMaintenanceRead, service.Maintenance.GetRule)
+	handle("PUT /maintenance-rules/{id}", services.PermMaintenanceWrite, service.Maintenance.UpdateRule)
+	handle("DELETE /maintenance-rules/{id}", services.PermMaintenanceWrite, service.Maintenance.DeleteRule)
+
+	handle("POST /odometer-readings", services.PermCarsWrite, service.Fuel.CreateReading)
+	handle("GET /odometer-readings", services.PermCarsRead, service.Fuel.GetReadings)
+	handle("GET /odometer-readings/{id}", services.PermCarsRead, service.Fuel.GetReading)
+	handle("DELETE /odometer-readings/{id}", services.PermCarsWrite, service.Fuel.DeleteReading)
+	handle("POST /fuel-entries", services.PermCarsWrite, service.Fuel.Create)
+	handle("GET /fuel-entries", services.PermCarsRead, service.Fuel.GetAll)
+	handle("GET /fuel-entries/{id}", services.PermCarsRead, service.Fuel.Get)
+	handle("PUT /fuel-entries/{id}", services.PermCarsWrite, service.Fuel.Update)
+	handle("DELETE /fuel-entries/{id}", services.PermCarsWrite, service.Fuel.Delete)
+	handle("POST /cars/{id}/positions", services.PermPositionsWrite, service.Positions.Record)
+	handle("GET /cars/positions", services.PermCarsRead, service.Positions.GetAll)
+	handle("GET /cars/nearby", services.PermCarsRead, service.Positions.Nearby)
+
+	handle("POST /tariffs", services.PermTariffsWrite, service.Tariffs.Create)
+	handle("GET /tariffs", services.PermTariffsRead, service.Tariffs.GetAll)
+	handle("GET /tariffs/{id}", services.PermTariffsRead, service.Tariffs.Get)
+	handle("PUT /tariffs/{id}", services.PermTariffsWrite, service.Tariffs.Update)
+	handle("DELETE /tariffs/{id}", services.PermTariffsWrite, service.Tariffs.Delete)
+	handle("POST /fares/estimate", services.PermFaresEstimate, service.Fares.Estimate)
+
+	handle("GET /audit", services.PermAuditRead, service.Audit.GetAll)
+
+	handle("POST /api-keys", services.PermKeysManage, service.APIKeys.Create)
+	handle("GET /api-keys", services.PermKeysManage, service.APIKeys.GetAll)
+	handle("GET /api-keys/{id}", services.PermKeysManage, service.APIKeys.Get)
+	handle("POST /api-keys/{id}/rotate", services.PermKeysManage, service.APIKeys.Rotate)
+	handle("POST /api-keys/{id}/revoke", services.PermKeysManage, service.APIKeys.Revoke)
+	handle("PUT /api-keys/{id}/role", services.PermKeysManage, service.APIKeys.SetRole)
+	handle("GET /roles", services.PermKeysManage, services.Roles)
+	if cfg.Auth.Enabled {
+		h.HandleFunc("POST /auth/token", service.Auth.Token)
+	}
+
+	if service.Features.Queries {
+		handle("GET /cars/year/{year}", services.PermReportsRead, service.Query.CarOfYear)
+		handle("GET /drivers/count", services.PermReportsRead, service.Query.DriverTripCounter)
+		handle("GET /drivers/autocount", services.PermReportsRead, service.Query.DriverTripAutoCounter)
+		handle("GET /clients/trips/{n}", services.PermReportsRead, service.Query.ClientTripMoreThan)
+		handle("GET /drivers/best", services.PermReportsRead, service.Query.BestDrivers)
+		handle("GET /statistics", services.PermReportsRead, service.Query.Statistic)
+		handle("GET /drivers/distance", services.PermReportsRead, service.Query.DriverDistances)
+		handle("GET /cars/distance", services.PermReportsRead, service.Query.CarDistances)
+		handle("GET /statistics/distance", services.PermReportsRead, service.Query.DailyDistances)
+		handle("GET /drivers/{id}/earnings", services.PermReportsRead, service.Query.DriverEarnings)
+		handle("GET /drivers/hours", services.PermReportsRead, service.Query.DriverHours)
+		handle("GET /drivers/expiring", services.PermReportsRead, service.Query.ExpiringDocuments)
+		handle("GET /cars/due-maintenance", services.PermReportsRead, service.Query.DueMaintenance)
+		handle("GET /cars/consumption", services.PermReportsRead, service.Query.FuelConsumption)
+	}
+
+	// Health checks stay open, everything else needs credentials.
+	root := http.NewServeMux()
+	root.HandleFunc("GET /health", services.Health)
+	root.Handle("/", service.Auth.Middleware(services.Audit(h)))
+
+	return services.RequestID(bodyLimit(root, cfg.Server.MaxBodyBytes, cfg.Documents.MaxFileBytes))
+}
+
+func Run(cfg config.Config, repos repository.Repositories) error {
 	server := http.Server{
-		Addr:    "localhost:8080",
-		Handler: h,
+		Addr:         cfg.Server.Addr,
+		Handler:      Handler(cfg, repos),
+		ReadTimeout:  cfg.Server.ReadTimeout,
+		WriteTimeout: cfg.Server.WriteTimeout,
+		IdleTimeout:  cfg.Server.IdleTimeout,
 	}
 
 	go func() {
-		log.Printf("run server: http://localhostlocalhost:8080")
+		log.Printf("run server: http://%s", cfg.Server.Addr)
 		err := server.ListenAndServe()
 		if err != nil {
 			log.Printf("error when listen and serve: %s", err)
@@ -91,14 +273,50 @@ func Run() error {
 	defer signal.Stop(ch)
 	sig := <-ch
 	log.Printf("%s - %v", "Reseived shutdown signal", sig)
-	return server.Shutdown(context.Background())
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer cancel()
+	return server.Shutdown(ctx)
 }
 
 func main() {
 
-	InitDB()
+	cfg, args, err := config.Load(os.Args[1:])
+	if err != nil {
+		log.Fatalf("Config error: %v", err)
+	}
+
+	if len(args) > 0 && args[0] != "migrate" && args[0] != "backfill" {
+		log.Fatalf("Unknown command %q, %s", args[0], usage)
+	}
+
+	var repos repository.Repositories
+	if cfg.DB.Driver == config.DriverMemory {
+		if len(args) > 0 {
+			log.Fatalf("Command %q is not available for the %q driver", args[0], cfg.DB.Driver)
+		}
+		log.Printf("using in-memory storage, data will be lost on shutdown")
+		repos = repository.NewMemory(repository.DeleteMode(cfg.Deletion.OnTrips))
+	} else {
+		db, err := InitDB(cfg.DB)
+		if err != nil {
+			log.Fatalf("Connection error to database: %v", err)
+		}
+
+		if len(args) > 0 {
+			if err := runCommand(db, args); err != nil {
+				log.Fatalf("Command %s error: %v", args[0], err)
+			}
+			return
+		}
+
+		if err := CheckSchema(db); err != nil {
+			log.Fatalf("Schema error: %v", err)
+		}
+		repos = repository.NewGorm(db, repository.DeleteMode(cfg.Deletion.OnTrips))
+	}
 
-	if err := Run(); err != nil {
+	if err := Run(cfg, repos); err != nil {
 		log.Fatalf("Server stopped with error: %v", err)
 	}
 
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"taksopark/internal/migrations"
+	"text/tabwriter"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const migrateUsage = "usage: taksopark [flags] migrate up|down|status|to <version>"
+
+func Migrate(db *gorm.DB, args []string) error {
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+
+	m, err := migrations.NewMigrator(db)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "up":
+		if err := m.Up(); err != nil {
+			return err
+		}
+	case "down":
+		if err := m.Down(); err != nil {
+			return err
+		}
+	case "to":
+		if len(args) != 2 {
+			return errors.New(migrateUsage)
+		}
+		version, err := strconv.ParseUint(args[1], 10, 32)
+		if err != nil {
+			return fmt.Errorf("invalid version %q", args[1])
+		}
+		if err := m.To(uint(version)); err != nil {
+			return err
+		}
+	case "status":
+		return printStatus(m)
+	default:
+		return errors.New(migrateUsage)
+	}
+
+	current, err := m.Current()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("schema version: %d (latest %d)\n", current, m.Latest())
+	return nil
+}
+
+func printStatus(m *migrations.Migrator) error {
+	status, err := m.Status()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tSTATE\tAPPLIED AT")
+	for _, st := range status {
+		state, appliedAt := "pending", ""
+		if st.Applied {
+			state = "applied"
+			appliedAt = st.AppliedAt.Format(time.DateTime)
+		}
+		if st.Modified {
+			state = "modified"
+		}
+		if st.Dirty {
+			state = "dirty"
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", st.Version, st.Name, state, appliedAt)
+	}
+	return w.Flush()
+}
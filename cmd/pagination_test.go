@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"taksopark/internal/DTO"
+	"testing"
+)
+
+type customerPage = DTO.Page[struct {
+	CustomerID uint `json:"customer_id"`
+}]
+
+// encodedCursor is the cursor of the page after the row with id.
+func encodedCursor(id uint) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprint(id)))
+}
+
+// list fetches one page of customers and returns the ids on it.
+func (c *client) list(path string) (string, customerPage) {
+	c.t.Helper()
+	var page customerPage
+	if code := c.do(http.MethodGet, path, nil, &page); code != http.StatusOK {
+		c.t.Fatalf("GET %s: got %d", path, code)
+	}
+	ids := []uint{}
+	for _, item := range page.Items {
+		ids = append(ids, item.CustomerID)
+	}
+	return fmt.Sprint(ids), page
+}
+
+// walk follows the next links from path and returns the ids of every page.
+func (c *client) walk(path string) []string {
+	c.t.Helper()
+	var pages []string
+	for path != "" && len(pages) < 10 {
+		ids, page := c.list(path)
+		pages = append(pages, ids)
+		path = page.Next
+	}
+	return pages
+}
+
+// customers creates customers 1 to 5, in reverse order of their last names.
+func (c *client) customers() {
+	c.t.Helper()
+	for i, name := range []string{"Egorova", "Dmitrieva", "Chernova", "Belova", "Antonova"} {
+		c.mustCreate("/customers", object{"first_name": "Anna", "last_name": name, "phone": fmt.Sprintf("+7999000000%d", i+1)})
+	}
+}
+
+func TestPagination(t *testing.T) {
+	c := newClient(t)
+	c.customers()
+
+	ids, page := c.list("/customers?limit=2")
+	if ids != "[1 2]" || page.Total != 5 || page.Limit != 2 || page.Offset != 0 {
+		t.Errorf("first page: got %s %+v", ids, page)
+	}
+	if page.NextCursor != encodedCursor(2) || page.Next != "/customers?limit=2&offset=2" {
+		t.Errorf("first page: next cursor %q, next %q", page.NextCursor, page.Next)
+	}
+
+	ids, page = c.list("/customers?limit=2&cursor=" + page.NextCursor)
+	if ids != "[3 4]" || page.Total != 5 {
+		t.Errorf("page after the cursor: got %s %+v", ids, page)
+	}
+	if page.Next != "/customers?cursor="+encodedCursor(4)+"&limit=2" {
+		t.Errorf("page after the cursor: next %q", page.Next)
+	}
+
+	// Next links keep the pagination mode, the filters and the sort.
+	tests := []struct {
+		name, path string
+		want       []string
+	}{
+		{"offset", "/customers?limit=2", []string{"[1 2]", "[3 4]", "[5]"}},
+		{"cursor", "/customers?limit=2&cursor=" + encodedCursor(1), []string{"[2 3]", "[4 5]", "[]"}},
+		{"descending cursor", "/customers?limit=2&sort=-customer_id&cursor=" + encodedCursor(5), []string{"[4 3]", "[2 1]", "[]"}},
+		{"filter", "/customers?limit=1&phone_prefix=%2B79990000004", []string{"[4]"}},
+		{"filter and cursor", "/customers?limit=1&phone_prefix=%2B7999&cursor=" + encodedCursor(3), []string{"[4]", "[5]", "[]"}},
+		{"offset past the end", "/customers?limit=2&offset=9", []string{"[]"}},
+		// Cursors need the primary key order, other sorts fall back to
+		// offsets.
+		{"sort by name", "/customers?limit=2&sort=last_name", []string{"[5 4]", "[3 2]", "[1]"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := c.walk(tt.path); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("GET %s: got pages %v, want %v", tt.path, got, tt.want)
+			}
+		})
+	}
+
+	_, page = c.list("/customers?limit=2&sort=last_name")
+	if page.NextCursor != "" || page.Next != "/customers?limit=2&offset=2&sort=last_name" {
+		t.Errorf("sort by name: next cursor %q, next %q", page.NextCursor, page.Next)
+	}
+	_, page = c.list("/customers?limit=5")
+	if page.Next != "" {
+		t.Errorf("last page: next %q", page.Next)
+	}
+}
+
+func TestPaginationErrors(t *testing.T) {
+	c := newClient(t)
+	c.customers()
+
+	for _, path := range []string{
+		"/customers?limit=0",
+		"/customers?limit=501",
+		"/customers?offset=-1",
+		"/customers?cursor=not-a-cursor",
+		"/customers?cursor=" + encodedCursor(0),
+		"/customers?cursor=" + encodedCursor(2) + "&offset=2",
+		"/customers?cursor=" + encodedCursor(2) + "&sort=last_name",
+		"/customers?cursor=" + encodedCursor(2) + "&sort=customer_id,last_name",
+		"/customers?sort=email",
+	} {
+		c.expectError(http.MethodGet, path, nil, http.StatusBadRequest, "bad_request")
+	}
+}
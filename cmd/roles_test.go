@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"taksopark/internal/DTO"
+	"testing"
+)
+
+func TestRolePermissions(t *testing.T) {
+	c := newAuthClient(t)
+	c.seed()
+	keys := map[string]*client{}
+	for _, role := range []string{"admin", "dispatcher", "accountant"} {
+		_, key := c.newKey(object{"name": role, "role": role})
+		keys[role] = c.as(key)
+	}
+	_, key := c.newKey(object{"name": "driver", "role": "driver", "driver_id": 1})
+	keys["driver"] = c.as(key)
+
+	// One route for every permission and the roles that have it. Ids are
+	// of missing records, allowed requests fail after the permission check.
+	routes := []struct {
+		method, path, perm, roles string
+	}{
+		{"GET", "/cars", "cars:read", "admin dispatcher accountant"},
+		{"POST", "/cars", "cars:write", "admin"},
+		{"GET", "/models", "models:read", "admin dispatcher accountant"},
+		{"POST", "/models", "models:write", "admin"},
+		{"GET", "/drivers", "drivers:read", "admin dispatcher accountant"},
+		{"DELETE", "/drivers/99", "drivers:write", "admin"},
+		{"GET", "/customers", "customers:read", "admin dispatcher accountant"},
+		{"POST", "/customers", "customers:write", "admin dispatcher"},
+		{"GET", "/trips", "trips:read", "admin dispatcher accountant driver"},
+		{"POST", "/trips", "trips:create", "admin dispatcher"},
+		{"PATCH", "/trips/99", "trips:write", "admin"},
+		{"POST", "/trips/99/assign", "trips:assign", "admin dispatcher"},
+		{"POST", "/trips/99/depart", "trips:drive", "admin dispatcher driver"},
+		{"GET", "/tariffs", "tariffs:read", "admin dispatcher accountant"},
+		{"POST", "/tariffs", "tariffs:write", "admin"},
+		{"POST", "/fares/estimate", "fares:estimate", "admin dispatcher accountant"},
+		{"GET", "/drivers/hours", "reports:read", "admin accountant"},
+		{"GET", "/audit", "audit:read", "admin"},
+		{"GET", "/api-keys", "keys:manage", "admin"},
+		{"GET", "/me/trips", "self:service", "admin driver"},
+		{"GET", "/shifts", "shifts:read", "admin dispatcher accountant"},
+		{"POST", "/shifts", "shifts:write", "admin dispatcher"},
+		{"GET", "/maintenance", "maintenance:read", "admin dispatcher accountant"},
+		{"POST", "/maintenance", "maintenance:write", "admin"},
+		{"POST", "/cars/99/positions", "positions:write", "admin driver"},
+	}
+	for _, route := range routes {
+		for role, k := range keys {
+			var raw json.RawMessage
+			code := k.do(route.method, route.path, object{}, &raw)
+			// Allowed requests may succeed with anything but an error.
+			var e DTO.ErrorResponse
+			json.Unmarshal(raw, &e)
+			denied := code == http.StatusForbidden && e.Error.Details["permission"] == route.perm
+			if allowed := strings.Contains(" "+route.roles+" ", " "+role+" "); allowed == denied {
+				t.Errorf("%s %s as %s: got %d %s, want allowed %v", route.method, route.path, role, code, e.Error.Message, allowed)
+			}
+		}
+	}
+}
+
+func TestDriverScope(t *testing.T) {
+	c := newAuthClient(t)
+	c.seed()
+	c.mustCreate("/cars", object{"license_plate": "B002BB", "model_id": 1, "year": 2021})
+	c.mustCreate("/drivers", object{"first_name": "Petr", "last_name": "Ivanov", "lisence_number": "7702"})
+	c.mustCreate("/shifts", object{"driver_id": 1, "car_id": 1, "odometer_in": 0})
+	c.mustCreate("/shifts", object{"driver_id": 2, "car_id": 2, "odometer_in": 0})
+	trip := object{"customer_id": 1, "start_lat": 55.75, "start_lon": 37.61, "end_lat": 55.8, "end_lon": 37.7}
+	c.mustCreate("/trips", trip)
+	c.mustCreate("/trips", trip)
+	for path, body := range map[string]object{
+		"/trips/1/assign": {"driver_id": 2, "car_id": 2},
+		"/trips/2/assign": {"driver_id": 1, "car_id": 1},
+	} {
+		if code := c.do(http.MethodPost, path, body, nil); code != http.StatusOK {
+			t.Fatalf("POST %s: got %d", path, code)
+		}
+	}
+
+	_, key := c.newKey(object{"name": "first", "role": "driver", "driver_id": 1})
+	first := c.as(key)
+	_, key = c.newKey(object{"name": "second", "role": "driver", "driver_id": 2})
+	second := c.as(key)
+
+	// Trips of other drivers are missing rather than forbidden.
+	first.expectError(http.MethodGet, "/trips/1", nil, http.StatusNotFound, "not_found")
+	first.expectError(http.MethodGet, "/me/trips/1", nil, http.StatusNotFound, "not_found")
+	first.expectError(http.MethodPost, "/trips/1/depart", nil, http.StatusNotFound, "not_found")
+
+	for path, want := range map[string]string{
+		"/trips":             "[2]",
+		"/trips?driver_id=2": "[]",
+		"/me/trips":          "[2]",
+	} {
+		var page struct {
+			Items []struct {
+				TripID uint `json:"trip_id"`
+			} `json:"items"`
+		}
+		if code := first.do(http.MethodGet, path, nil, &page); code != http.StatusOK {
+			t.Fatalf("GET %s: got %d", path, code)
+		}
+		ids := []uint{}
+		for _, trip := range page.Items {
+			ids = append(ids, trip.TripID)
+		}
+		if got := fmt.Sprint(ids); got != want {
+			t.Errorf("GET %s as driver 1: got trips %s, want %s", path, got, want)
+		}
+	}
+
+	if code := second.do(http.MethodGet, "/trips/1", nil, nil); code != http.StatusOK {
+		t.Errorf("GET /trips/1 as driver 2: got %d", code)
+	}
+	if code := second.do(http.MethodPost, "/trips/1/depart", nil, nil); code != http.StatusOK {
+		t.Errorf("POST /trips/1/depart as driver 2: got %d", code)
+	}
+}
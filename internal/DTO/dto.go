@@ -1,6 +1,7 @@
 package DTO
 
 import (
+	"encoding/json"
 	"time"
 
 	_ "gorm.io/driver/mysql"
@@ -36,109 +37,418 @@ type Statistic struct {
 	Avg float32 `json:"avg" gorm:"column:avg"`
 }
 
+type PersonDistance struct {
+	Person     `json:"person"`
+	DistanceKm float64 `json:"distance_km" gorm:"column:distance_km"`
+}
+
+type CarDistance struct {
+	CarID        uint    `json:"car_id" gorm:"column:car_id"`
+	LicensePlate string  `json:"license_plate" gorm:"column:license_plate"`
+	DistanceKm   float64 `json:"distance_km" gorm:"column:distance_km"`
+}
+
+type DayDistance struct {
+	Day        string  `json:"day" gorm:"column:day"`
+	Trips      uint    `json:"trips" gorm:"column:trips"`
+	DistanceKm float64 `json:"distance_km" gorm:"column:distance_km"`
+}
+
+type DayEarnings struct {
+	Day        string  `json:"day" gorm:"column:day"`
+	Trips      uint    `json:"trips" gorm:"column:trips"`
+	DistanceKm float64 `json:"distance_km" gorm:"column:distance_km"`
+	Earnings   float64 `json:"earnings" gorm:"column:earnings"`
+}
+
+// DriverHours is the time a driver spent on shifts during a period.
+type DriverHours struct {
+	DriverID uint `json:"driver_id"`
+	Person   `json:"person"`
+	Shifts   uint    `json:"shifts"`
+	Hours    float64 `json:"hours"`
+}
+
+// ExpiringDocument is the latest document of one kind of a driver, it
+// expires before the end of the report period or has expired already.
+type ExpiringDocument struct {
+	DriverID   uint `json:"driver_id"`
+	Person     `json:"person"`
+	DocumentID uint      `json:"document_id"`
+	Kind       string    `json:"kind"`
+	Number     string    `json:"number"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	Expired    bool      `json:"expired"`
+}
+
+// DueMaintenance tells when a car needs the service one maintenance rule
+// of its model requires. Odometer is the latest known reading of the car,
+// the service falls due at DueOdometer or at DueAt, whichever comes first.
+type DueMaintenance struct {
+	CarID          uint       `json:"car_id"`
+	LicensePlate   string     `json:"license_plate"`
+	ModelID        uint       `json:"model_id"`
+	RuleID         uint       `json:"rule_id"`
+	ServiceType    string     `json:"service_type"`
+	LastRecordID   *uint      `json:"last_record_id"`
+	LastServicedAt *time.Time `json:"last_serviced_at"`
+	Odometer       uint       `json:"odometer"`
+	DueOdometer    *uint      `json:"due_odometer"`
+	DueAt          *time.Time `json:"due_at"`
+	Overdue        bool       `json:"overdue"`
+}
+
+// NearbyCar is a free car by its latest position, DistanceKm away from
+// the point searched around. DriverID is the driver on shift in the car.
+type NearbyCar struct {
+	CarID        uint      `json:"car_id"`
+	LicensePlate string    `json:"license_plate"`
+	ModelID      uint      `json:"model_id"`
+	Class        string    `json:"class"`
+	DriverID     uint      `json:"driver_id"`
+	Lat          float64   `json:"lat"`
+	Lon          float64   `json:"lon"`
+	SpeedKmh     *float64  `json:"speed_kmh"`
+	Heading      *float64  `json:"heading"`
+	RecordedAt   time.Time `json:"recorded_at"`
+	DistanceKm   float64   `json:"distance_km"`
+}
+
+// CarConsumption is what a car used per 100 km during a period by the
+// fuel entries of one unit. Distance is driven between the first and the
+// last entry, the fuel of the first entry was used before the period.
+// Deviation compares PerHundredKm with the average of the cars of the same
+// model, in percent.
+type CarConsumption struct {
+	CarID             uint     `json:"car_id"`
+	LicensePlate      string   `json:"license_plate"`
+	ModelID           uint     `json:"model_id"`
+	Unit              string   `json:"unit"`
+	Entries           uint     `json:"entries"`
+	DistanceKm        uint     `json:"distance_km"`
+	Amount            float64  `json:"amount"`
+	PerHundredKm      *float64 `json:"per_100_km"`
+	FuelCost          float64  `json:"fuel_cost"`
+	TripKm            float64  `json:"trip_km"`
+	CostPerTripKm     *float64 `json:"cost_per_trip_km"`
+	ModelPerHundredKm *float64 `json:"model_per_100_km"`
+	DeviationPct      *float64 `json:"deviation_pct"`
+	Anomaly           bool     `json:"anomaly"`
+}
+
+// Earnings sums up the completed trips of a driver, Days breaks the sums
+// down by the day the trips ended on.
+type Earnings struct {
+	DriverID   uint          `json:"driver_id"`
+	Trips      uint          `json:"trips"`
+	DistanceKm float64       `json:"distance_km"`
+	Earnings   float64       `json:"earnings"`
+	Days       []DayEarnings `json:"days"`
+}
+
 type CreateCarRequest struct {
-	LicensePlate string `json:"license_plate"`
-	ModelID      uint   `json:"model_id"`
-	Year         int    `json:"year"`
-	Notes        string `json:"notes"`
+	LicensePlate string          `json:"license_plate" validate:"required,max=20"`
+	ModelID      uint            `json:"model_id" validate:"required"`
+	Year         int             `json:"year" validate:"required,min=1901,max=2155"`
+	Notes        json.RawMessage `json:"notes" validate:"object"`
 }
 
 type UpdateCarRequest struct {
-	LicensePlate string `json:"license_plate"`
-	ModelID      uint   `json:"model_id"`
-	Year         int    `json:"year"`
-	Notes        string `json:"notes"`
+	LicensePlate string          `json:"license_plate" validate:"required,max=20"`
+	ModelID      uint            `json:"model_id" validate:"required"`
+	Year         int             `json:"year" validate:"required,min=1901,max=2155"`
+	Notes        json.RawMessage `json:"notes" validate:"object"`
 }
 
 type UpdateSomethingCarRequest struct {
-	LicensePlate *string `json:"license_plate,omitempty"`
-	ModelID      *uint   `json:"model_id,omitempty"`
-	Year         *int    `json:"year,omitempty"`
-	Notes        *string `json:"notes,omitempty"`
+	LicensePlate string          `json:"license_plate" validate:"required,max=20"`
+	ModelID      uint            `json:"model_id" validate:"required"`
+	Year         int             `json:"year" validate:"required,min=1901,max=2155"`
+	Notes        json.RawMessage `json:"notes" validate:"object"`
 }
 
 type CreateCustomerRequest struct {
-	FirstName string `json:"first_name"`
-	LastName  string `json:"last_name"`
-	Phone     string `json:"phone"`
+	FirstName string `json:"first_name" validate:"required,max=100"`
+	LastName  string `json:"last_name" validate:"required,max=100"`
+	Phone     string `json:"phone" validate:"required,phone"`
 }
 
 type UpdateCustomerRequest struct {
-	FirstName string `gorm:"size:100" json:"first_name"`
-	LastName  string `gorm:"size:100" json:"last_name"`
-	Phone     string `gorm:"size:15" json:"phone"`
+	FirstName string `json:"first_name" validate:"required,max=100"`
+	LastName  string `json:"last_name" validate:"required,max=100"`
+	Phone     string `json:"phone" validate:"required,phone"`
 }
 
 type UpdateSomethingCustomerRequest struct {
-	FirstName *string `json:"first_name,omitempty"`
-	LastName  *string `json:"last_name,omitempty"`
-	Phone     *string `json:"phone,omitempty"`
+	FirstName string `json:"first_name" validate:"required,max=100"`
+	LastName  string `json:"last_name" validate:"required,max=100"`
+	Phone     string `json:"phone" validate:"required,phone"`
 }
 
 type CreateDriverRequest struct {
-	FirstName     string `json:"first_name"`
-	LastName      string `json:"last_name"`
-	LisenceNumber string `json:"lisence_number"`
+	FirstName     string `json:"first_name" validate:"required,max=100"`
+	LastName      string `json:"last_name" validate:"required,max=100"`
+	LisenceNumber string `json:"lisence_number" validate:"required,max=191"`
 }
 
 type UpdateDriverRequest struct {
-	FirstName     string `gorm:"size:100" json:"first_name"`
-	LastName      string `gorm:"size:100" json:"last_name"`
-	LicenseNumber string `gorm:"uniqueIndex" json:"license_number"`
+	FirstName     string `json:"first_name" validate:"required,max=100"`
+	LastName      string `json:"last_name" validate:"required,max=100"`
+	LisenceNumber string `json:"lisence_number" validate:"required,max=191"`
 }
 type UpdateSomethingDriverRequest struct {
-	FirstName     *string `json:"first_name,omitempty"`
-	LastName      *string `json:"last_name,omitempty"`
-	LisenceNumber *string `json:"lisence_number,omitempty"`
+	FirstName     string `json:"first_name" validate:"required,max=100"`
+	LastName      string `json:"last_name" validate:"required,max=100"`
+	LisenceNumber string `json:"lisence_number" validate:"required,max=191"`
 }
 
+// CreateModelRequest creates a car model. Models without a class, seats
+// or fuel type are economy cars with 4 seats running on petrol.
 type CreateModelRequest struct {
-	ModelName    string `json:"model_name"`
-	Manufacturer string `json:"manufacturer"`
+	ModelName            string `json:"model_name" validate:"required,max=100"`
+	Manufacturer         string `json:"manufacturer" validate:"required,max=100"`
+	Class                string `json:"class" validate:"max=50"`
+	Seats                uint   `json:"seats" validate:"max=60"`
+	LuggageL             uint   `json:"luggage_l" validate:"max=10000"`
+	FuelType             string `json:"fuel_type" validate:"oneof=petrol diesel lpg hybrid electric"`
+	WheelchairAccessible bool   `json:"wheelchair_accessible"`
+	LowFloor             bool   `json:"low_floor"`
 }
 type UpdateModelRequest struct {
-	ModelName    string `gorm:"size:100" json:"model_name"`
-	Manufacturer string `gorm:"size:100" json:"manufacturer"`
+	ModelName            string `json:"model_name" validate:"required,max=100"`
+	Manufacturer         string `json:"manufacturer" validate:"required,max=100"`
+	Class                string `json:"class" validate:"max=50"`
+	Seats                uint   `json:"seats" validate:"max=60"`
+	LuggageL             uint   `json:"luggage_l" validate:"max=10000"`
+	FuelType             string `json:"fuel_type" validate:"oneof=petrol diesel lpg hybrid electric"`
+	WheelchairAccessible bool   `json:"wheelchair_accessible"`
+	LowFloor             bool   `json:"low_floor"`
 }
 
 type UpdateSomethingModelRequest struct {
-	ModelName    *string `json:"model_name,omitempty"`
-	Manufacturer *string `json:"manufacturer,omitempty"`
+	ModelName            string `json:"model_name" validate:"required,max=100"`
+	Manufacturer         string `json:"manufacturer" validate:"required,max=100"`
+	Class                string `json:"class" validate:"required,max=50"`
+	Seats                uint   `json:"seats" validate:"required,max=60"`
+	LuggageL             uint   `json:"luggage_l" validate:"max=10000"`
+	FuelType             string `json:"fuel_type" validate:"required,oneof=petrol diesel lpg hybrid electric"`
+	WheelchairAccessible bool   `json:"wheelchair_accessible"`
+	LowFloor             bool   `json:"low_floor"`
 }
+
+// CreateTripRequest creates a trip. A trip requested with a class can only
+// get cars of that class.
 type CreateTripRequest struct {
-	DriverID   uint      `json:"driver_id"`
-	CarID      uint      `json:"car_id"`
-	CustomerID uint      `json:"customer_id"`
-	StartLat   float64   `gorm:"type:decimal(9,6)" json:"start_lat"`
-	StartLon   float64   `gorm:"type:decimal(9,6)" json:"start_lon"`
-	EndLat     float64   `gorm:"type:decimal(9,6)" json:"end_lat"`
-	EndLon     float64   `gorm:"type:decimal(9,6)" json:"end_lon"`
-	StartTime  time.Time `gorm:"type:datetime(8)" json:"start_time"`
-	EndTime    time.Time `gorm:"type:datetime(8)" json:"end_time"`
-	Cost       float64   `gorm:"type:decimal(10,2)" json:"cost"`
+	Status     string     `json:"status" validate:"oneof=requested completed"`
+	DriverID   *uint      `json:"driver_id" validate:"positive"`
+	CarID      *uint      `json:"car_id" validate:"positive"`
+	CustomerID uint       `json:"customer_id" validate:"required"`
+	StartLat   float64    `json:"start_lat" validate:"lat"`
+	StartLon   float64    `json:"start_lon" validate:"lon"`
+	EndLat     float64    `json:"end_lat" validate:"lat"`
+	EndLon     float64    `json:"end_lon" validate:"lon"`
+	StartTime  *time.Time `json:"start_time"`
+	EndTime    *time.Time `json:"end_time" validate:"after=start_time"`
+	Class      string     `json:"class" validate:"max=50"`
 }
 
 type UpdateTripRequest struct {
-	DriverID   uint      `json:"driver_id"`
-	CarID      uint      `json:"car_id"`
-	CustomerID uint      `json:"customer_id"`
-	StartLat   float64   `json:"start_lat"`
-	StartLon   float64   `json:"start_lon"`
-	EndLat     float64   `json:"end_lat"`
-	EndLon     float64   `json:"end_lon"`
-	StartTime  time.Time `json:"start_time"`
-	EndTime    time.Time `json:"end_time"`
-	Cost       float64   `json:"cost"`
+	DriverID   *uint      `json:"driver_id" validate:"positive"`
+	CarID      *uint      `json:"car_id" validate:"positive"`
+	CustomerID uint       `json:"customer_id" validate:"required"`
+	StartLat   float64    `json:"start_lat" validate:"lat"`
+	StartLon   float64    `json:"start_lon" validate:"lon"`
+	EndLat     float64    `json:"end_lat" validate:"lat"`
+	EndLon     float64    `json:"end_lon" validate:"lon"`
+	StartTime  *time.Time `json:"start_time"`
+	EndTime    *time.Time `json:"end_time" validate:"after=start_time"`
 }
 type UpdateSomethingTripRequest struct {
-	DriverID   *uint      `json:"driver_id,omitempty"`
-	CarID      *uint      `json:"car_id,omitempty"`
-	CustomerID *uint      `json:"customer_id,omitempty"`
-	StartLat   *float64   `json:"start_lat,omitempty"`
-	StartLon   *float64   `json:"start_lon,omitempty"`
-	EndLat     *float64   `json:"end_lat,omitempty"`
-	EndLon     *float64   `json:"end_lon,omitempty"`
-	StartTime  *time.Time `json:"start_time,omitempty"`
-	EndTime    *time.Time `json:"end_time,omitempty"`
-	Cost       *float64   `json:"cost,omitempty"`
+	DriverID   *uint      `json:"driver_id" validate:"positive"`
+	CarID      *uint      `json:"car_id" validate:"positive"`
+	CustomerID uint       `json:"customer_id" validate:"required"`
+	StartLat   float64    `json:"start_lat" validate:"lat"`
+	StartLon   float64    `json:"start_lon" validate:"lon"`
+	EndLat     float64    `json:"end_lat" validate:"lat"`
+	EndLon     float64    `json:"end_lon" validate:"lon"`
+	StartTime  *time.Time `json:"start_time"`
+	EndTime    *time.Time `json:"end_time" validate:"after=start_time"`
+}
+
+type AssignTripRequest struct {
+	DriverID uint `json:"driver_id" validate:"required"`
+	CarID    uint `json:"car_id" validate:"required"`
+}
+
+type CancelTripRequest struct {
+	Reason string `json:"reason" validate:"required,max=255"`
+}
+
+// PositionRequest is a GPS ping of a car, recorded now unless
+// recorded_at is given.
+type PositionRequest struct {
+	Lat        *float64   `json:"lat" validate:"required,lat"`
+	Lon        *float64   `json:"lon" validate:"required,lon"`
+	SpeedKmh   *float64   `json:"speed_kmh" validate:"min=0,max=400"`
+	Heading    *float64   `json:"heading" validate:"min=0,max=360"`
+	RecordedAt *time.Time `json:"recorded_at"`
+}
+
+// CreateShiftRequest opens a shift now or records one that started
+// earlier. EndedAt and OdometerOut record a shift that is already over and
+// go together.
+type CreateShiftRequest struct {
+	DriverID    uint       `json:"driver_id" validate:"required"`
+	CarID       uint       `json:"car_id" validate:"required"`
+	OdometerIn  *uint      `json:"odometer_in" validate:"required"`
+	StartedAt   *time.Time `json:"started_at"`
+	EndedAt     *time.Time `json:"ended_at" validate:"after=started_at"`
+	OdometerOut *uint      `json:"odometer_out"`
+}
+
+// OpenShiftRequest opens a shift of the driver who makes the request.
+type OpenShiftRequest struct {
+	CarID      uint  `json:"car_id" validate:"required"`
+	OdometerIn *uint `json:"odometer_in" validate:"required"`
+}
+
+type CloseShiftRequest struct {
+	OdometerOut *uint `json:"odometer_out" validate:"required"`
+}
+
+// DocumentRequest creates or replaces a driver document. Categories are
+// given for a licence only, e.g. "B,C".
+type DocumentRequest struct {
+	Kind       string     `json:"kind" validate:"required,oneof=licence medical permit"`
+	Number     string     `json:"number" validate:"required,notblank,max=50"`
+	Categories string     `json:"categories" validate:"max=50"`
+	IssuedAt   *time.Time `json:"issued_at"`
+	ExpiresAt  *time.Time `json:"expires_at" validate:"required,after=issued_at"`
+}
+
+// MaintenanceRequest creates or replaces a maintenance record. A record
+// without finished_at is a service that is still going on, the car stays
+// in the workshop until it is finished.
+type MaintenanceRequest struct {
+	CarID       uint       `json:"car_id" validate:"required"`
+	ServiceType string     `json:"service_type" validate:"required,notblank,max=50"`
+	Workshop    string     `json:"workshop" validate:"max=100"`
+	Odometer    *uint      `json:"odometer" validate:"required"`
+	Cost        float64    `json:"cost" validate:"min=0"`
+	StartedAt   *time.Time `json:"started_at"`
+	FinishedAt  *time.Time `json:"finished_at" validate:"after=started_at"`
+}
+
+type FinishMaintenanceRequest struct {
+	Cost *float64 `json:"cost" validate:"required,min=0"`
+}
+
+// MaintenanceRuleRequest creates or replaces a maintenance rule, at least
+// one of the intervals is required.
+type MaintenanceRuleRequest struct {
+	ModelID        uint   `json:"model_id" validate:"required"`
+	ServiceType    string `json:"service_type" validate:"required,notblank,max=50"`
+	IntervalKm     *uint  `json:"interval_km" validate:"positive"`
+	IntervalMonths *uint  `json:"interval_months" validate:"positive"`
+}
+
+// OdometerRequest records an odometer reading of a car, read_at defaults
+// to now.
+type OdometerRequest struct {
+	CarID    uint       `json:"car_id" validate:"required"`
+	Odometer *uint      `json:"odometer" validate:"required"`
+	ReadAt   *time.Time `json:"read_at"`
+}
+
+// FuelRequest creates or replaces a fuel entry, filled_at defaults to now.
+// Amount is in litres or kWh by unit, price is the total.
+type FuelRequest struct {
+	CarID    uint       `json:"car_id" validate:"required"`
+	Odometer *uint      `json:"odometer" validate:"required"`
+	Amount   float64    `json:"amount" validate:"required,positive"`
+	Unit     string     `json:"unit" validate:"required,oneof=l kwh"`
+	Price    float64    `json:"price" validate:"min=0"`
+	Station  string     `json:"station" validate:"max=100"`
+	FilledAt *time.Time `json:"filled_at"`
+}
+
+// TariffRequest creates or replaces a tariff. Multipliers default to 1 and
+// the night to 22:00-06:00 when omitted.
+type TariffRequest struct {
+	Class             string   `json:"class" validate:"required,max=50"`
+	BaseFare          float64  `json:"base_fare" validate:"min=0"`
+	PerKm             float64  `json:"per_km" validate:"min=0"`
+	PerMinute         float64  `json:"per_minute" validate:"min=0"`
+	MinimumFare       float64  `json:"minimum_fare" validate:"min=0"`
+	NightMultiplier   *float64 `json:"night_multiplier,omitempty" validate:"positive"`
+	WeekendMultiplier *float64 `json:"weekend_multiplier,omitempty" validate:"positive"`
+	NightStart        *int     `json:"night_start,omitempty" validate:"min=0,max=23"`
+	NightEnd          *int     `json:"night_end,omitempty" validate:"min=0,max=23"`
+}
+
+// FareEstimateRequest asks for a quote. The tariff is taken from the class of
+// CarID when it is set, from Class otherwise. The ride starts now and takes
+// as long as the distance needs at the average speed unless told otherwise.
+type FareEstimateRequest struct {
+	Class           string     `json:"class" validate:"max=50"`
+	CarID           *uint      `json:"car_id,omitempty" validate:"positive"`
+	StartLat        float64    `json:"start_lat" validate:"lat"`
+	StartLon        float64    `json:"start_lon" validate:"lon"`
+	EndLat          float64    `json:"end_lat" validate:"lat"`
+	EndLon          float64    `json:"end_lon" validate:"lon"`
+	StartTime       *time.Time `json:"start_time,omitempty"`
+	DurationMinutes *float64   `json:"duration_minutes,omitempty" validate:"min=0"`
+}
+
+// CreateAPIKeyRequest creates a key. DriverID names the driver a key with
+// the driver role belongs to and must be empty for other roles.
+type CreateAPIKeyRequest struct {
+	Name     string `json:"name" validate:"required,max=100"`
+	Role     string `json:"role" validate:"required,oneof=admin dispatcher accountant driver"`
+	DriverID *uint  `json:"driver_id" validate:"positive"`
+}
+
+type APIKeyRoleRequest struct {
+	Role     string `json:"role" validate:"required,oneof=admin dispatcher accountant driver"`
+	DriverID *uint  `json:"driver_id" validate:"positive"`
+}
+
+type Role struct {
+	Role        string   `json:"role"`
+	Permissions []string `json:"permissions"`
+}
+
+// Token is a bearer token issued by POST /auth/token.
+type Token struct {
+	AccessToken string    `json:"access_token"`
+	TokenType   string    `json:"token_type"`
+	ExpiresIn   int       `json:"expires_in"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+// ErrorResponse is the body of every error response.
+type ErrorResponse struct {
+	Error Error `json:"error"`
+}
+
+type Error struct {
+	Code      string         `json:"code"`
+	Message   string         `json:"message"`
+	Details   map[string]any `json:"details,omitempty"`
+	Fields    []FieldError   `json:"fields,omitempty"`
+	RequestID string         `json:"request_id,omitempty"`
+}
+
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+type Page[T any] struct {
+	Items      []T    `json:"items"`
+	Total      int64  `json:"total"`
+	Limit      int    `json:"limit"`
+	Offset     int    `json:"offset"`
+	NextCursor string `json:"next_cursor,omitempty"`
+	Next       string `json:"next,omitempty"`
 }
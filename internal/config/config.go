@@ -0,0 +1,407 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"taksopark/internal/validate"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const envPrefix = "TAKSOPARK_"
+
+const (
+	DriverMySQL  = "mysql"
+	DriverSQLite = "sqlite"
+	DriverMemory = "memory"
+)
+
+// minSecretLen is the shortest JWT secret or bootstrap key accepted.
+const minSecretLen = 32
+
+const (
+	OnTripsBlock   = "block"
+	OnTripsArchive = "archive"
+)
+
+type Config struct {
+	DB        DBConfig        `yaml:"db"`
+	Server    ServerConfig    `yaml:"server"`
+	Features  FeaturesConfig  `yaml:"features"`
+	Fares     FaresConfig     `yaml:"fares"`
+	Deletion  DeletionConfig  `yaml:"deletion"`
+	Auth      AuthConfig      `yaml:"auth"`
+	Documents DocumentsConfig `yaml:"documents"`
+	Cars      CarsConfig      `yaml:"cars"`
+}
+
+type DBConfig struct {
+	Driver          string        `yaml:"driver"`
+	DSN             string        `yaml:"dsn"`
+	MaxOpenConns    int           `yaml:"max_open_conns"`
+	MaxIdleConns    int           `yaml:"max_idle_conns"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime"`
+}
+
+type ServerConfig struct {
+	Addr            string        `yaml:"addr"`
+	ReadTimeout     time.Duration `yaml:"read_timeout"`
+	WriteTimeout    time.Duration `yaml:"write_timeout"`
+	IdleTimeout     time.Duration `yaml:"idle_timeout"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	MaxBodyBytes    int           `yaml:"max_body_bytes"`
+}
+
+type FeaturesConfig struct {
+	Queries bool `yaml:"queries"`
+}
+
+// FaresConfig holds the time zone that decides night and weekend fares and
+// the average speed in km/h used to estimate the duration of a ride.
+type FaresConfig struct {
+	TimeZone     string `yaml:"timezone"`
+	AverageSpeed int    `yaml:"average_speed"`
+}
+
+// DeletionConfig decides what deleting a car, driver or customer that still
+// has trips does: "block" refuses it, "archive" deletes the finished trips
+// along with the record.
+type DeletionConfig struct {
+	OnTrips string `yaml:"on_trips"`
+}
+
+// AuthConfig controls authentication. JWTSecret signs the bearer tokens
+// issued by POST /auth/token, which live for TokenTTL. BootstrapKey, when
+// set, is accepted like a stored API key so that the first keys can be
+// created.
+type AuthConfig struct {
+	Enabled      bool          `yaml:"enabled"`
+	JWTSecret    string        `yaml:"jwt_secret"`
+	TokenTTL     time.Duration `yaml:"token_ttl"`
+	BootstrapKey string        `yaml:"bootstrap_key"`
+}
+
+// DocumentsConfig sets the directory driver document files are stored in
+// and the largest file that can be uploaded.
+type DocumentsConfig struct {
+	Dir          string `yaml:"dir"`
+	MaxFileBytes int    `yaml:"max_file_bytes"`
+}
+
+// CarsConfig names the file with the JSON Schema the notes of cars must
+// match, without it any JSON object is accepted. PositionHistory is how
+// many of the newest GPS positions of every car are kept.
+type CarsConfig struct {
+	NotesSchema     string `yaml:"notes_schema"`
+	PositionHistory int    `yaml:"position_history"`
+}
+
+func (c FaresConfig) Location() (*time.Location, error) {
+	return time.LoadLocation(c.TimeZone)
+}
+
+// Schema reads the notes schema, it is nil when none is configured.
+func (c CarsConfig) Schema() (*validate.Schema, error) {
+	if c.NotesSchema == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(c.NotesSchema)
+	if err != nil {
+		return nil, err
+	}
+	return validate.ParseSchema(data)
+}
+
+func Default() Config {
+	return Config{
+		DB: DBConfig{
+			Driver:          DriverMySQL,
+			MaxOpenConns:    10,
+			MaxIdleConns:    5,
+			ConnMaxLifetime: time.Hour,
+		},
+		Server: ServerConfig{
+			Addr:            "localhost:8080",
+			ReadTimeout:     10 * time.Second,
+			WriteTimeout:    10 * time.Second,
+			IdleTimeout:     time.Minute,
+			ShutdownTimeout: 15 * time.Second,
+			MaxBodyBytes:    1 << 20,
+		},
+		Features: FeaturesConfig{
+			Queries: true,
+		},
+		Fares: FaresConfig{
+			TimeZone:     "Local",
+			AverageSpeed: 30,
+		},
+		Deletion: DeletionConfig{
+			OnTrips: OnTripsBlock,
+		},
+		Auth: AuthConfig{
+			Enabled:  true,
+			TokenTTL: 15 * time.Minute,
+		},
+		Documents: DocumentsConfig{
+			Dir:          "documents",
+			MaxFileBytes: 10 << 20,
+		},
+		Cars: CarsConfig{
+			PositionHistory: 100,
+		},
+	}
+}
+
+// Load builds the configuration from defaults, then the YAML file, then
+// TAKSOPARK_* environment variables, then command line flags. Each later
+// source overrides the earlier ones. Positional arguments left after the
+// flags are returned as well.
+func Load(args []string) (Config, []string, error) {
+	cfg := Default()
+
+	fs := flag.NewFlagSet("taksopark", flag.ContinueOnError)
+	path := fs.String("config", os.Getenv(envPrefix+"CONFIG"), "path to YAML config file")
+	driver := fs.String("db-driver", "", "storage driver: mysql, sqlite or memory")
+	dsn := fs.String("db-dsn", "", "database DSN")
+	maxOpen := fs.Int("db-max-open-conns", 0, "max open DB connections")
+	maxIdle := fs.Int("db-max-idle-conns", 0, "max idle DB connections")
+	lifetime := fs.Duration("db-conn-max-lifetime", 0, "max DB connection lifetime")
+	addr := fs.String("addr", "", "HTTP listen address")
+	readTimeout := fs.Duration("read-timeout", 0, "HTTP read timeout")
+	writeTimeout := fs.Duration("write-timeout", 0, "HTTP write timeout")
+	idleTimeout := fs.Duration("idle-timeout", 0, "HTTP idle timeout")
+	shutdownTimeout := fs.Duration("shutdown-timeout", 0, "graceful shutdown timeout")
+	maxBodyBytes := fs.Int("max-body-bytes", 0, "max request body size in bytes")
+	queries := fs.Bool("feature-queries", false, "enable custom query endpoints")
+	timeZone := fs.String("fares-timezone", "", "time zone for night and weekend fares")
+	averageSpeed := fs.Int("fares-average-speed", 0, "average speed in km/h for fare estimates")
+	onTrips := fs.String("delete-on-trips", "", "what deleting a record with trips does: block or archive")
+	auth := fs.Bool("auth", false, "require authentication")
+	tokenTTL := fs.Duration("auth-token-ttl", 0, "lifetime of issued bearer tokens")
+	documentsDir := fs.String("documents-dir", "", "directory for driver document files")
+	maxFileBytes := fs.Int("documents-max-file-bytes", 0, "max driver document file size in bytes")
+	notesSchema := fs.String("cars-notes-schema", "", "path to the JSON Schema of car notes")
+	positionHistory := fs.Int("cars-position-history", 0, "number of GPS positions kept per car")
+	if err := fs.Parse(args); err != nil {
+		return cfg, nil, fmt.Errorf("config: %w", err)
+	}
+
+	if *path != "" {
+		if err := loadFile(&cfg, *path); err != nil {
+			return cfg, nil, err
+		}
+	}
+
+	if err := loadEnv(&cfg); err != nil {
+		return cfg, nil, err
+	}
+
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "db-driver":
+			cfg.DB.Driver = *driver
+		case "db-dsn":
+			cfg.DB.DSN = *dsn
+		case "db-max-open-conns":
+			cfg.DB.MaxOpenConns = *maxOpen
+		case "db-max-idle-conns":
+			cfg.DB.MaxIdleConns = *maxIdle
+		case "db-conn-max-lifetime":
+			cfg.DB.ConnMaxLifetime = *lifetime
+		case "addr":
+			cfg.Server.Addr = *addr
+		case "read-timeout":
+			cfg.Server.ReadTimeout = *readTimeout
+		case "write-timeout":
+			cfg.Server.WriteTimeout = *writeTimeout
+		case "idle-timeout":
+			cfg.Server.IdleTimeout = *idleTimeout
+		case "shutdown-timeout":
+			cfg.Server.ShutdownTimeout = *shutdownTimeout
+		case "max-body-bytes":
+			cfg.Server.MaxBodyBytes = *maxBodyBytes
+		case "feature-queries":
+			cfg.Features.Queries = *queries
+		case "fares-timezone":
+			cfg.Fares.TimeZone = *timeZone
+		case "fares-average-speed":
+			cfg.Fares.AverageSpeed = *averageSpeed
+		case "delete-on-trips":
+			cfg.Deletion.OnTrips = *onTrips
+		case "auth":
+			cfg.Auth.Enabled = *auth
+		case "auth-token-ttl":
+			cfg.Auth.TokenTTL = *tokenTTL
+		case "documents-dir":
+			cfg.Documents.Dir = *documentsDir
+		case "documents-max-file-bytes":
+			cfg.Documents.MaxFileBytes = *maxFileBytes
+		case "cars-notes-schema":
+			cfg.Cars.NotesSchema = *notesSchema
+		case "cars-position-history":
+			cfg.Cars.PositionHistory = *positionHistory
+		}
+	})
+
+	return cfg, fs.Args(), cfg.Validate()
+}
+
+func loadFile(cfg *Config, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("config: read %s: %w", path, err)
+	}
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return fmt.Errorf("config: parse %s: %w", path, err)
+	}
+	return nil
+}
+
+func loadEnv(cfg *Config) error {
+	var errs []error
+
+	str := func(name string, dst *string) {
+		if v, ok := os.LookupEnv(envPrefix + name); ok {
+			*dst = v
+		}
+	}
+	num := func(name string, dst *int) {
+		if v, ok := os.LookupEnv(envPrefix + name); ok {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s%s: invalid integer %q", envPrefix, name, v))
+				return
+			}
+			*dst = n
+		}
+	}
+	dur := func(name string, dst *time.Duration) {
+		if v, ok := os.LookupEnv(envPrefix + name); ok {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s%s: invalid duration %q", envPrefix, name, v))
+				return
+			}
+			*dst = d
+		}
+	}
+	boolean := func(name string, dst *bool) {
+		if v, ok := os.LookupEnv(envPrefix + name); ok {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s%s: invalid boolean %q", envPrefix, name, v))
+				return
+			}
+			*dst = b
+		}
+	}
+
+	str("DB_DRIVER", &cfg.DB.Driver)
+	str("DB_DSN", &cfg.DB.DSN)
+	num("DB_MAX_OPEN_CONNS", &cfg.DB.MaxOpenConns)
+	num("DB_MAX_IDLE_CONNS", &cfg.DB.MaxIdleConns)
+	dur("DB_CONN_MAX_LIFETIME", &cfg.DB.ConnMaxLifetime)
+	str("ADDR", &cfg.Server.Addr)
+	dur("READ_TIMEOUT", &cfg.Server.ReadTimeout)
+	dur("WRITE_TIMEOUT", &cfg.Server.WriteTimeout)
+	dur("IDLE_TIMEOUT", &cfg.Server.IdleTimeout)
+	dur("SHUTDOWN_TIMEOUT", &cfg.Server.ShutdownTimeout)
+	num("MAX_BODY_BYTES", &cfg.Server.MaxBodyBytes)
+	boolean("FEATURE_QUERIES", &cfg.Features.Queries)
+	str("FARES_TIMEZONE", &cfg.Fares.TimeZone)
+	num("FARES_AVERAGE_SPEED", &cfg.Fares.AverageSpeed)
+	str("DELETE_ON_TRIPS", &cfg.Deletion.OnTrips)
+	boolean("AUTH_ENABLED", &cfg.Auth.Enabled)
+	str("AUTH_JWT_SECRET", &cfg.Auth.JWTSecret)
+	dur("AUTH_TOKEN_TTL", &cfg.Auth.TokenTTL)
+	str("AUTH_BOOTSTRAP_KEY", &cfg.Auth.BootstrapKey)
+	str("DOCUMENTS_DIR", &cfg.Documents.Dir)
+	num("DOCUMENTS_MAX_FILE_BYTES", &cfg.Documents.MaxFileBytes)
+	str("CARS_NOTES_SCHEMA", &cfg.Cars.NotesSchema)
+	num("CARS_POSITION_HISTORY", &cfg.Cars.PositionHistory)
+
+	if len(errs) > 0 {
+		return fmt.Errorf("config: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+func (c Config) Validate() error {
+	var errs []error
+
+	switch c.DB.Driver {
+	case DriverMySQL, DriverSQLite:
+		if strings.TrimSpace(c.DB.DSN) == "" {
+			errs = append(errs, errors.New("db.dsn is required (set it in the config file, TAKSOPARK_DB_DSN or -db-dsn)"))
+		}
+	case DriverMemory:
+	default:
+		errs = append(errs, fmt.Errorf("db.driver %q is not supported, use %q, %q or %q", c.DB.Driver, DriverMySQL, DriverSQLite, DriverMemory))
+	}
+	if c.DB.MaxOpenConns < 0 {
+		errs = append(errs, errors.New("db.max_open_conns must not be negative"))
+	}
+	if c.DB.MaxIdleConns < 0 {
+		errs = append(errs, errors.New("db.max_idle_conns must not be negative"))
+	}
+	if c.DB.MaxOpenConns > 0 && c.DB.MaxIdleConns > c.DB.MaxOpenConns {
+		errs = append(errs, errors.New("db.max_idle_conns must not exceed db.max_open_conns"))
+	}
+	if c.DB.ConnMaxLifetime < 0 {
+		errs = append(errs, errors.New("db.conn_max_lifetime must not be negative"))
+	}
+	if strings.TrimSpace(c.Server.Addr) == "" {
+		errs = append(errs, errors.New("server.addr is required"))
+	}
+	if c.Server.ReadTimeout < 0 || c.Server.WriteTimeout < 0 || c.Server.IdleTimeout < 0 {
+		errs = append(errs, errors.New("server timeouts must not be negative"))
+	}
+	if c.Server.ShutdownTimeout <= 0 {
+		errs = append(errs, errors.New("server.shutdown_timeout must be positive"))
+	}
+	if c.Server.MaxBodyBytes <= 0 {
+		errs = append(errs, errors.New("server.max_body_bytes must be positive"))
+	}
+	if _, err := c.Fares.Location(); err != nil {
+		errs = append(errs, fmt.Errorf("fares.timezone: %w", err))
+	}
+	if c.Fares.AverageSpeed <= 0 {
+		errs = append(errs, errors.New("fares.average_speed must be positive"))
+	}
+	if c.Deletion.OnTrips != OnTripsBlock && c.Deletion.OnTrips != OnTripsArchive {
+		errs = append(errs, fmt.Errorf("deletion.on_trips %q is not supported, use %q or %q", c.Deletion.OnTrips, OnTripsBlock, OnTripsArchive))
+	}
+	if c.Auth.Enabled {
+		if len(c.Auth.JWTSecret) < minSecretLen {
+			errs = append(errs, fmt.Errorf("auth.jwt_secret must be at least %d bytes (set it in the config file or TAKSOPARK_AUTH_JWT_SECRET)", minSecretLen))
+		}
+		if c.Auth.TokenTTL <= 0 {
+			errs = append(errs, errors.New("auth.token_ttl must be positive"))
+		}
+		if c.Auth.BootstrapKey != "" && len(c.Auth.BootstrapKey) < minSecretLen {
+			errs = append(errs, fmt.Errorf("auth.bootstrap_key must be at least %d bytes", minSecretLen))
+		}
+	}
+	if strings.TrimSpace(c.Documents.Dir) == "" {
+		errs = append(errs, errors.New("documents.dir is required"))
+	}
+	if c.Documents.MaxFileBytes <= 0 {
+		errs = append(errs, errors.New("documents.max_file_bytes must be positive"))
+	}
+	if _, err := c.Cars.Schema(); err != nil {
+		errs = append(errs, fmt.Errorf("cars.notes_schema: %w", err))
+	}
+	if c.Cars.PositionHistory <= 0 {
+		errs = append(errs, errors.New("cars.position_history must be positive"))
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid config: %w", errors.Join(errs...))
+	}
+	return nil
+}
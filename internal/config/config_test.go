@@ -0,0 +1,180 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// clearEnv unsets the TAKSOPARK_* variables of the environment the tests
+// run in, they are restored afterwards.
+func clearEnv(t *testing.T) {
+	t.Helper()
+	for _, kv := range os.Environ() {
+		name, _, _ := strings.Cut(kv, "=")
+		if strings.HasPrefix(name, envPrefix) {
+			t.Setenv(name, "")
+			os.Unsetenv(name)
+		}
+	}
+}
+
+func writeFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	clearEnv(t)
+	path := writeFile(t, `
+db:
+  driver: sqlite
+  dsn: file.db
+  max_open_conns: 20
+  max_idle_conns: 2
+server:
+  addr: file:1
+  read_timeout: 1s
+  write_timeout: 2s
+auth:
+  enabled: false
+`)
+	t.Setenv("TAKSOPARK_DB_DSN", "env.db")
+	t.Setenv("TAKSOPARK_ADDR", "env:2")
+	t.Setenv("TAKSOPARK_WRITE_TIMEOUT", "3s")
+
+	cfg, args, err := Load([]string{"-config", path, "-addr", "flag:3", "-feature-queries=false", "migrate", "up"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Default()
+	want.DB.Driver = DriverSQLite              // file
+	want.DB.DSN = "env.db"                     // env over file
+	want.DB.MaxOpenConns = 20                  // file
+	want.DB.MaxIdleConns = 2                   // file
+	want.Server.Addr = "flag:3"                // flag over env over file
+	want.Server.ReadTimeout = time.Second      // file
+	want.Server.WriteTimeout = 3 * time.Second // env over file
+	want.Features.Queries = false              // flag over default
+	want.Auth.Enabled = false                  // file
+	if cfg != want {
+		t.Errorf("Load:\n got %+v\nwant %+v", cfg, want)
+	}
+	if strings.Join(args, " ") != "migrate up" {
+		t.Errorf("Load: args %q, want migrate up", args)
+	}
+}
+
+func TestLoadConfigPathFromEnv(t *testing.T) {
+	clearEnv(t)
+	t.Setenv("TAKSOPARK_CONFIG", writeFile(t, "db:\n  driver: memory\nauth:\n  enabled: false\n"))
+	cfg, _, err := Load(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.DB.Driver != DriverMemory {
+		t.Errorf("db.driver: got %q, want %q", cfg.DB.Driver, DriverMemory)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		file string
+		args []string
+		want string
+	}{
+		{"unknown flag", nil, "", []string{"-nope"}, "flag provided but not defined"},
+		{"missing file", nil, "", []string{"-config", "/nonexistent/config.yaml"}, "read /nonexistent/config.yaml"},
+		{"malformed file", nil, "db: [", nil, "parse"},
+		{"invalid integer", map[string]string{"TAKSOPARK_DB_MAX_OPEN_CONNS": "many"}, "", nil, `TAKSOPARK_DB_MAX_OPEN_CONNS: invalid integer "many"`},
+		{"invalid duration", map[string]string{"TAKSOPARK_READ_TIMEOUT": "10"}, "", nil, `TAKSOPARK_READ_TIMEOUT: invalid duration "10"`},
+		{"invalid boolean", map[string]string{"TAKSOPARK_AUTH_ENABLED": "maybe"}, "", nil, `TAKSOPARK_AUTH_ENABLED: invalid boolean "maybe"`},
+		{"validation", nil, "", []string{"-db-driver", "sqlite"}, "db.dsn is required"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearEnv(t)
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			args := tt.args
+			if tt.file != "" {
+				args = append([]string{"-config", writeFile(t, tt.file)}, args...)
+			}
+			_, _, err := Load(args)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Load: got %v, want an error with %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	valid := func() Config {
+		cfg := Default()
+		cfg.DB.DSN = "user:pass@/taksopark"
+		cfg.Auth.JWTSecret = strings.Repeat("s", minSecretLen)
+		return cfg
+	}
+	if err := valid().Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		change func(*Config)
+		want   []string
+	}{
+		{"no dsn", func(c *Config) { c.DB.DSN = " " }, []string{"db.dsn is required"}},
+		{"memory needs no dsn", func(c *Config) { c.DB.Driver, c.DB.DSN = DriverMemory, "" }, nil},
+		{"unknown driver", func(c *Config) { c.DB.Driver = "postgres" }, []string{`db.driver "postgres" is not supported`}},
+		{"connections", func(c *Config) { c.DB.MaxOpenConns, c.DB.MaxIdleConns = 2, 3 }, []string{"db.max_idle_conns must not exceed db.max_open_conns"}},
+		{"negative connections", func(c *Config) { c.DB.MaxOpenConns, c.DB.MaxIdleConns, c.DB.ConnMaxLifetime = -1, -1, -1 }, []string{
+			"db.max_open_conns must not be negative", "db.max_idle_conns must not be negative", "db.conn_max_lifetime must not be negative",
+		}},
+		{"server", func(c *Config) {
+			c.Server = ServerConfig{Addr: "", ReadTimeout: -1}
+		}, []string{"server.addr is required", "server timeouts must not be negative", "server.shutdown_timeout must be positive", "server.max_body_bytes must be positive"}},
+		{"fares", func(c *Config) { c.Fares = FaresConfig{TimeZone: "Mars/Olympus", AverageSpeed: 0} }, []string{"fares.timezone", "fares.average_speed must be positive"}},
+		{"deletion", func(c *Config) { c.Deletion.OnTrips = "cascade" }, []string{`deletion.on_trips "cascade" is not supported`}},
+		{"auth", func(c *Config) {
+			c.Auth = AuthConfig{Enabled: true, JWTSecret: "short", BootstrapKey: "short"}
+		}, []string{"auth.jwt_secret must be at least 32 bytes", "auth.token_ttl must be positive", "auth.bootstrap_key must be at least 32 bytes"}},
+		{"auth disabled", func(c *Config) { c.Auth = AuthConfig{Enabled: false} }, nil},
+		{"documents", func(c *Config) { c.Documents = DocumentsConfig{} }, []string{"documents.dir is required", "documents.max_file_bytes must be positive"}},
+		{"cars", func(c *Config) { c.Cars = CarsConfig{NotesSchema: "/nonexistent/schema.json"} }, []string{"cars.notes_schema", "cars.position_history must be positive"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := valid()
+			tt.change(&cfg)
+			err := cfg.Validate()
+			if len(tt.want) == 0 {
+				if err != nil {
+					t.Errorf("Validate: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("Validate: got nil, want %q", tt.want)
+			}
+			// Every problem is reported, one per line.
+			if got := len(strings.Split(err.Error(), "\n")); got != len(tt.want) {
+				t.Errorf("Validate: got %d errors, want %d: %v", got, len(tt.want), err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("Validate: got %v, want %q", err, want)
+				}
+			}
+		})
+	}
+}
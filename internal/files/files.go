@@ -0,0 +1,65 @@
+package files
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// Store keeps uploaded files in a directory on local disk. Files are named
+// by random keys, the names clients gave them are kept by the caller.
+type Store struct {
+	dir string
+}
+
+func NewStore(dir string) Store {
+	return Store{dir: dir}
+}
+
+// Save writes the contents of r to a new file and returns its key and
+// size. A file that fails to be written completely is removed.
+func (s Store) Save(r io.Reader) (key string, size int64, err error) {
+	if err := os.MkdirAll(s.dir, 0o750); err != nil {
+		return "", 0, err
+	}
+
+	b := make([]byte, 16)
+	rand.Read(b)
+	key = hex.EncodeToString(b)
+
+	tmp, err := os.CreateTemp(s.dir, key+".*.tmp")
+	if err != nil {
+		return "", 0, err
+	}
+	defer os.Remove(tmp.Name())
+
+	size, err = io.Copy(tmp, r)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", 0, err
+	}
+	return key, size, os.Rename(tmp.Name(), s.path(key))
+}
+
+func (s Store) Open(key string) (*os.File, error) {
+	return os.Open(s.path(key))
+}
+
+// Remove deletes the file key, a missing file is not an error.
+func (s Store) Remove(key string) error {
+	err := os.Remove(s.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (s Store) path(key string) string {
+	return filepath.Join(s.dir, filepath.Base(key))
+}
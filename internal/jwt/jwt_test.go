@@ -0,0 +1,80 @@
+package jwt
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+var secret = []byte("0123456789abcdef0123456789abcdef")
+
+// forge signs header and claims, given as JSON, with secret whatever the
+// header says.
+func forge(header, claims string) string {
+	return signed(encoding.EncodeToString([]byte(header)) + "." + encoding.EncodeToString([]byte(claims)))
+}
+
+// signed appends the signature of unsigned with secret.
+func signed(unsigned string) string {
+	return unsigned + "." + encoding.EncodeToString(signature(unsigned, secret))
+}
+
+func TestSignParse(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	claims := Claims{Subject: "ops", KeyID: 3, KeyPrefix: "tp_01234567", IssuedAt: now.Unix(), ExpiresAt: now.Add(time.Hour).Unix()}
+	token, err := Sign(claims, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := Parse(token, secret, now)
+	if err != nil || got != claims {
+		t.Fatalf("Parse: got %+v, %v, want %+v", got, err, claims)
+	}
+
+	parts := strings.Split(token, ".")
+	tampered := parts[0] + "." + encoding.EncodeToString([]byte(`{"sub":"ops","key_id":4,"exp":1700003600}`)) + "." + parts[2]
+
+	tests := []struct {
+		name  string
+		token string
+		now   time.Time
+		want  error
+	}{
+		{"wrong secret", mustSign(t, claims, []byte("another secret")), now, ErrInvalid},
+		{"tampered claims", tampered, now, ErrInvalid},
+		{"signature of another token", parts[0] + "." + parts[1] + "." + strings.Split(forge(`{"alg":"HS256"}`, `{"exp":1}`), ".")[2], now, ErrInvalid},
+		{"alg none unsigned", encoding.EncodeToString([]byte(`{"alg":"none"}`)) + "." + parts[1] + ".", now, ErrInvalid},
+		{"alg none signed", forge(`{"alg":"none"}`, `{"sub":"ops","key_id":3,"exp":1700003600}`), now, ErrInvalid},
+		{"alg HS512", forge(`{"alg":"HS512"}`, `{"sub":"ops","key_id":3,"exp":1700003600}`), now, ErrInvalid},
+		{"alg missing", forge(`{}`, `{"sub":"ops","key_id":3,"exp":1700003600}`), now, ErrInvalid},
+		{"no expiry", forge(`{"alg":"HS256"}`, `{"sub":"ops","key_id":3}`), now, ErrInvalid},
+		{"expires now", token, now.Add(time.Hour), ErrExpired},
+		{"expired", token, now.Add(2 * time.Hour), ErrExpired},
+		{"empty", "", now, ErrInvalid},
+		{"two segments", parts[0] + "." + parts[1], now, ErrInvalid},
+		{"four segments", token + "." + parts[2], now, ErrInvalid},
+		{"signature not base64", parts[0] + "." + parts[1] + ".!!!", now, ErrInvalid},
+		{"header not base64", signed("!!!." + parts[1]), now, ErrInvalid},
+		{"claims not base64", signed(parts[0] + ".!!!"), now, ErrInvalid},
+		{"header not JSON", forge(`alg=HS256`, `{"exp":1700003600}`), now, ErrInvalid},
+		{"claims not JSON", forge(`{"alg":"HS256"}`, `exp=1700003600`), now, ErrInvalid},
+		{"claims of the wrong type", forge(`{"alg":"HS256"}`, `{"key_id":"3","exp":1700003600}`), now, ErrInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Parse(tt.token, secret, tt.now); !errors.Is(err, tt.want) {
+				t.Errorf("Parse: got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func mustSign(t *testing.T, claims Claims, secret []byte) string {
+	t.Helper()
+	token, err := Sign(claims, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
@@ -0,0 +1,357 @@
+package migrations
+
+import (
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+//go:embed mysql/*.sql sqlite/*.sql
+var files embed.FS
+
+var ErrOutdated = errors.New("database schema is behind the code")
+
+type Migration struct {
+	Version  uint
+	Name     string
+	Up       string
+	Down     string
+	Checksum string
+}
+
+type Status struct {
+	Version   uint       `json:"version"`
+	Name      string     `json:"name"`
+	Applied   bool       `json:"applied"`
+	AppliedAt *time.Time `json:"applied_at,omitempty"`
+	Modified  bool       `json:"modified"`
+	Dirty     bool       `json:"dirty"`
+}
+
+type schemaMigration struct {
+	Version   uint      `gorm:"primaryKey;autoIncrement:false"`
+	Name      string    `gorm:"size:255"`
+	Checksum  string    `gorm:"size:64"`
+	AppliedAt time.Time `gorm:"type:datetime"`
+	// Dirty marks a migration that failed halfway on a database that cannot
+	// roll back DDL, its row is written before the script runs.
+	Dirty bool `gorm:"not null;default:false"`
+}
+
+func (schemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+type Migrator struct {
+	db         *gorm.DB
+	migrations []Migration
+	// transactional is false for dialects whose DDL statements commit
+	// implicitly, such as MySQL.
+	transactional bool
+}
+
+// NewMigrator picks the migration set matching the dialect of db.
+func NewMigrator(db *gorm.DB) (*Migrator, error) {
+	dialect := db.Dialector.Name()
+	if _, err := fs.Stat(files, dialect); err != nil {
+		return nil, fmt.Errorf("no migrations for dialect %q", dialect)
+	}
+
+	list, err := load(files, dialect)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, migrations: list, transactional: dialect != "mysql"}, nil
+}
+
+// load reads <version>_<name>.up.sql / .down.sql pairs from dir.
+func load(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[uint]*Migration{}
+	for _, e := range entries {
+		name := e.Name()
+		var direction string
+		switch {
+		case strings.HasSuffix(name, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(name, ".down.sql"):
+			direction = "down"
+		default:
+			continue
+		}
+
+		base := strings.TrimSuffix(name, "."+direction+".sql")
+		num, title, ok := strings.Cut(base, "_")
+		if !ok {
+			return nil, fmt.Errorf("migration %s: expected <version>_<name>", name)
+		}
+		version, err := strconv.ParseUint(num, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s: invalid version: %w", name, err)
+		}
+
+		data, err := fs.ReadFile(fsys, path.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[uint(version)]
+		if !ok {
+			m = &Migration{Version: uint(version), Name: title}
+			byVersion[uint(version)] = m
+		}
+		if direction == "up" {
+			m.Up = string(data)
+		} else {
+			m.Down = string(data)
+		}
+	}
+
+	list := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s: both up and down files are required", m.Version, m.Name)
+		}
+		sum := sha256.Sum256([]byte(m.Up + "\x00" + m.Down))
+		m.Checksum = hex.EncodeToString(sum[:])
+		list = append(list, *m)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Version < list[j].Version })
+
+	return list, nil
+}
+
+// Latest returns the schema version the code expects.
+func (m *Migrator) Latest() uint {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+func (m *Migrator) applied() (map[uint]schemaMigration, error) {
+	if err := m.db.AutoMigrate(&schemaMigration{}); err != nil {
+		return nil, err
+	}
+
+	var rows []schemaMigration
+	if err := m.db.Order("version").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	res := make(map[uint]schemaMigration, len(rows))
+	for _, row := range rows {
+		res[row.Version] = row
+	}
+	return res, nil
+}
+
+// Current returns the highest applied version, 0 for an empty database.
+func (m *Migrator) Current() (uint, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return 0, err
+	}
+
+	var current uint
+	for v := range applied {
+		current = max(current, v)
+	}
+	return current, nil
+}
+
+func (m *Migrator) Status() ([]Status, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]Status, 0, len(m.migrations))
+	for _, mig := range m.migrations {
+		st := Status{Version: mig.Version, Name: mig.Name}
+		if row, ok := applied[mig.Version]; ok {
+			st.Applied = true
+			st.AppliedAt = &row.AppliedAt
+			st.Modified = row.Checksum != mig.Checksum
+			st.Dirty = row.Dirty
+		}
+		res = append(res, st)
+	}
+	return res, nil
+}
+
+// Check returns ErrOutdated when some migration is not applied yet and an
+// error when an applied migration was edited after it ran or failed halfway.
+func (m *Migrator) Check() error {
+	status, err := m.Status()
+	if err != nil {
+		return err
+	}
+
+	for _, st := range status {
+		if st.Dirty {
+			return dirtyError(st.Version, st.Name)
+		}
+		if st.Modified {
+			return fmt.Errorf("migration %d_%s was modified after it was applied", st.Version, st.Name)
+		}
+		if !st.Applied {
+			return fmt.Errorf("%w: migration %d_%s is not applied", ErrOutdated, st.Version, st.Name)
+		}
+	}
+	return nil
+}
+
+func (m *Migrator) Up() error {
+	return m.To(m.Latest())
+}
+
+// Down reverts the last applied migration.
+func (m *Migrator) Down() error {
+	current, err := m.Current()
+	if err != nil {
+		return err
+	}
+	if current == 0 {
+		return nil
+	}
+
+	var target uint
+	for _, mig := range m.migrations {
+		if mig.Version < current {
+			target = mig.Version
+		}
+	}
+	return m.To(target)
+}
+
+func dirtyError(version uint, name string) error {
+	return fmt.Errorf("migration %d_%s failed halfway and left the schema dirty, "+
+		"repair the schema by hand and then clear the dirty flag or delete its row in schema_migrations", version, name)
+}
+
+// To applies or reverts migrations until the schema is at version. It
+// refuses to run while a migration is dirty.
+func (m *Migrator) To(version uint) error {
+	if version != 0 && !m.known(version) {
+		return fmt.Errorf("unknown migration version %d", version)
+	}
+
+	applied, err := m.applied()
+	if err != nil {
+		return err
+	}
+	for _, row := range applied {
+		if row.Dirty {
+			return dirtyError(row.Version, row.Name)
+		}
+	}
+
+	for _, mig := range m.migrations {
+		if mig.Version > version {
+			break
+		}
+		if _, ok := applied[mig.Version]; ok {
+			continue
+		}
+		if err := m.up(mig); err != nil {
+			return fmt.Errorf("migration %d_%s up: %w", mig.Version, mig.Name, err)
+		}
+	}
+
+	for i := len(m.migrations) - 1; i >= 0; i-- {
+		mig := m.migrations[i]
+		if mig.Version <= version {
+			break
+		}
+		if _, ok := applied[mig.Version]; !ok {
+			continue
+		}
+		if err := m.down(mig); err != nil {
+			return fmt.Errorf("migration %d_%s down: %w", mig.Version, mig.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// up applies mig and records it. The script and its row share a
+// transaction where DDL can be rolled back, elsewhere the row is written as
+// dirty first and cleared once the script succeeded.
+func (m *Migrator) up(mig Migration) error {
+	row := schemaMigration{Version: mig.Version, Name: mig.Name, Checksum: mig.Checksum, AppliedAt: time.Now()}
+	if m.transactional {
+		return m.db.Transaction(func(tx *gorm.DB) error {
+			if err := exec(tx, mig.Up); err != nil {
+				return err
+			}
+			return tx.Create(&row).Error
+		})
+	}
+
+	row.Dirty = true
+	if err := m.db.Create(&row).Error; err != nil {
+		return err
+	}
+	if err := exec(m.db, mig.Up); err != nil {
+		return err
+	}
+	return m.db.Model(&row).Update("dirty", false).Error
+}
+
+// down reverts mig and deletes its row, like up does.
+func (m *Migrator) down(mig Migration) error {
+	if m.transactional {
+		return m.db.Transaction(func(tx *gorm.DB) error {
+			if err := exec(tx, mig.Down); err != nil {
+				return err
+			}
+			return tx.Delete(&schemaMigration{}, mig.Version).Error
+		})
+	}
+
+	if err := m.db.Model(&schemaMigration{Version: mig.Version}).Update("dirty", true).Error; err != nil {
+		return err
+	}
+	if err := exec(m.db, mig.Down); err != nil {
+		return err
+	}
+	return m.db.Delete(&schemaMigration{}, mig.Version).Error
+}
+
+func (m *Migrator) known(version uint) bool {
+	for _, mig := range m.migrations {
+		if mig.Version == version {
+			return true
+		}
+	}
+	return false
+}
+
+// exec runs a script statement by statement, since the driver is not
+// configured for multi-statement queries.
+func exec(db *gorm.DB, script string) error {
+	for _, stmt := range strings.Split(script, ";") {
+		if strings.TrimSpace(stmt) == "" {
+			continue
+		}
+		if err := db.Exec(stmt).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,267 @@
+package migrations
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func openDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// testFiles are three migrations that each create one table.
+func testFiles() fstest.MapFS {
+	return fstest.MapFS{
+		"sqlite/0001_a.up.sql":   {Data: []byte("CREATE TABLE a (id INTEGER);")},
+		"sqlite/0001_a.down.sql": {Data: []byte("DROP TABLE a;")},
+		"sqlite/0002_b.up.sql":   {Data: []byte("CREATE TABLE b (id INTEGER);\nCREATE INDEX idx_b ON b (id);")},
+		"sqlite/0002_b.down.sql": {Data: []byte("DROP TABLE b;")},
+		"sqlite/0003_c.up.sql":   {Data: []byte("CREATE TABLE c (id INTEGER);")},
+		"sqlite/0003_c.down.sql": {Data: []byte("DROP TABLE c;")},
+		"sqlite/README.md":       {Data: []byte("not a migration")},
+	}
+}
+
+func newTestMigrator(t *testing.T, db *gorm.DB, files fstest.MapFS, transactional bool) *Migrator {
+	t.Helper()
+	list, err := load(files, "sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Migrator{db: db, migrations: list, transactional: transactional}
+}
+
+// expectTables checks which of the tables a, b and c exist.
+func expectTables(t *testing.T, db *gorm.DB, want string) {
+	t.Helper()
+	var got []string
+	for _, table := range []string{"a", "b", "c"} {
+		if db.Migrator().HasTable(table) {
+			got = append(got, table)
+		}
+	}
+	if strings.Join(got, " ") != want {
+		t.Errorf("tables: got %q, want %q", strings.Join(got, " "), want)
+	}
+}
+
+func expectVersion(t *testing.T, m *Migrator, want uint) {
+	t.Helper()
+	got, err := m.Current()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Errorf("version: got %d, want %d", got, want)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	list, err := load(testFiles(), "sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 3 || list[0].Version != 1 || list[1].Name != "b" || list[2].Down != "DROP TABLE c;" {
+		t.Errorf("load: got %+v", list)
+	}
+	if list[0].Checksum == list[1].Checksum || len(list[0].Checksum) != 64 {
+		t.Errorf("load: checksums %q and %q", list[0].Checksum, list[1].Checksum)
+	}
+
+	tests := map[string]fstest.MapFS{
+		"expected <version>_<name>": {"sqlite/0001.up.sql": {}},
+		"invalid version":           {"sqlite/one_a.up.sql": {}},
+		"both up and down files":    {"sqlite/0001_a.up.sql": {Data: []byte("CREATE TABLE a (id INTEGER);")}},
+	}
+	for want, files := range tests {
+		if _, err := load(files, "sqlite"); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("load: got %v, want %q", err, want)
+		}
+	}
+}
+
+func TestUpDownTo(t *testing.T) {
+	db := openDB(t)
+	m := newTestMigrator(t, db, testFiles(), true)
+
+	if err := m.Check(); !errors.Is(err, ErrOutdated) {
+		t.Errorf("Check of an empty database: got %v, want %v", err, ErrOutdated)
+	}
+	if err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+	expectVersion(t, m, 3)
+	expectTables(t, db, "a b c")
+	if err := m.Check(); err != nil {
+		t.Errorf("Check: %v", err)
+	}
+	if err := m.Up(); err != nil {
+		t.Errorf("Up when up to date: %v", err)
+	}
+
+	if err := m.Down(); err != nil {
+		t.Fatal(err)
+	}
+	expectVersion(t, m, 2)
+	expectTables(t, db, "a b")
+	if err := m.Check(); !errors.Is(err, ErrOutdated) {
+		t.Errorf("Check: got %v, want %v", err, ErrOutdated)
+	}
+
+	if err := m.To(1); err != nil {
+		t.Fatal(err)
+	}
+	expectTables(t, db, "a")
+	if err := m.To(3); err != nil {
+		t.Fatal(err)
+	}
+	expectTables(t, db, "a b c")
+	if err := m.To(0); err != nil {
+		t.Fatal(err)
+	}
+	expectVersion(t, m, 0)
+	expectTables(t, db, "")
+	if err := m.Down(); err != nil {
+		t.Errorf("Down of an empty database: %v", err)
+	}
+
+	if err := m.To(7); err == nil || !strings.Contains(err.Error(), "unknown migration version 7") {
+		t.Errorf("To(7): got %v", err)
+	}
+}
+
+func TestChecksumMismatch(t *testing.T) {
+	db := openDB(t)
+	if err := newTestMigrator(t, db, testFiles(), true).Up(); err != nil {
+		t.Fatal(err)
+	}
+
+	files := testFiles()
+	files["sqlite/0002_b.up.sql"] = &fstest.MapFile{Data: []byte("CREATE TABLE b (id INTEGER, name TEXT);")}
+	m := newTestMigrator(t, db, files, true)
+
+	status, err := m.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, st := range status {
+		if st.Modified != (st.Version == 2) || !st.Applied {
+			t.Errorf("status of %d: %+v", st.Version, st)
+		}
+	}
+	if err := m.Check(); err == nil || !strings.Contains(err.Error(), "migration 2_b was modified after it was applied") {
+		t.Errorf("Check: got %v", err)
+	}
+}
+
+// failingFiles adds a migration that creates a table and then fails.
+func failingFiles() fstest.MapFS {
+	files := testFiles()
+	files["sqlite/0004_broken.up.sql"] = &fstest.MapFile{Data: []byte("CREATE TABLE d (id INTEGER);\nINSERT INTO missing VALUES (1);")}
+	files["sqlite/0004_broken.down.sql"] = &fstest.MapFile{Data: []byte("DROP TABLE d;")}
+	return files
+}
+
+func TestFailedMigrationRollsBack(t *testing.T) {
+	db := openDB(t)
+	m := newTestMigrator(t, db, failingFiles(), true)
+
+	if err := m.Up(); err == nil || !strings.Contains(err.Error(), "migration 4_broken up") {
+		t.Fatalf("Up: got %v", err)
+	}
+	expectVersion(t, m, 3)
+	if db.Migrator().HasTable("d") {
+		t.Error("the failed migration left table d behind")
+	}
+	status, err := m.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st := status[3]; st.Applied || st.Dirty {
+		t.Errorf("status of the failed migration: %+v", st)
+	}
+}
+
+func TestDirtyMigration(t *testing.T) {
+	db := openDB(t)
+	// Like on MySQL, the script runs outside a transaction.
+	m := newTestMigrator(t, db, failingFiles(), false)
+
+	if err := m.Up(); err == nil {
+		t.Fatal("Up: got nil, want the error of the broken migration")
+	}
+	if !db.Migrator().HasTable("d") {
+		t.Error("table d is missing, the script did not run outside a transaction")
+	}
+	status, err := m.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st := status[3]; !st.Applied || !st.Dirty {
+		t.Errorf("status of the failed migration: %+v", st)
+	}
+
+	for name, run := range map[string]func() error{"Check": m.Check, "Up": m.Up, "Down": m.Down} {
+		if err := run(); err == nil || !strings.Contains(err.Error(), "migration 4_broken failed halfway") {
+			t.Errorf("%s: got %v, want the dirty migration error", name, err)
+		}
+	}
+	expectTables(t, db, "a b c")
+
+	// Once repaired by hand and the row is deleted, migrating works again.
+	if err := db.Exec("DROP TABLE d").Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Delete(&schemaMigration{}, 4).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := m.To(1); err != nil {
+		t.Errorf("To(1) after the repair: %v", err)
+	}
+	expectTables(t, db, "a")
+}
+
+func TestEmbeddedMigrations(t *testing.T) {
+	db := openDB(t)
+	m, err := NewMigrator(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Check(); err != nil {
+		t.Errorf("Check: %v", err)
+	}
+	expectVersion(t, m, m.Latest())
+
+	if err := m.To(0); err != nil {
+		t.Fatal(err)
+	}
+	tables, err := db.Migrator().GetTables()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var left []string
+	for _, table := range tables {
+		// sqlite_sequence belongs to SQLite and cannot be dropped.
+		if table != "schema_migrations" && table != "sqlite_sequence" {
+			left = append(left, table)
+		}
+	}
+	if len(left) != 0 {
+		t.Errorf("tables left after reverting every migration: %v", left)
+	}
+}
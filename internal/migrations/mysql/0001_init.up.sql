@@ -0,0 +1,52 @@
+CREATE TABLE drivers (
+    driver_id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+    first_name VARCHAR(100),
+    last_name VARCHAR(100),
+    lisence_number VARCHAR(191),
+    PRIMARY KEY (driver_id),
+    UNIQUE INDEX idx_drivers_lisence_number (lisence_number)
+);
+
+CREATE TABLE car_models (
+    model_id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+    model_name VARCHAR(100),
+    manufacturer VARCHAR(100),
+    PRIMARY KEY (model_id)
+);
+
+CREATE TABLE cars (
+    car_id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+    license_plate VARCHAR(100),
+    model_id BIGINT UNSIGNED,
+    year YEAR,
+    notes JSON,
+    PRIMARY KEY (car_id),
+    UNIQUE INDEX idx_cars_license_plate (license_plate),
+    CONSTRAINT fk_cars_model FOREIGN KEY (model_id) REFERENCES car_models (model_id)
+);
+
+CREATE TABLE customers (
+    customer_id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+    first_name VARCHAR(100),
+    last_name VARCHAR(100),
+    phone VARCHAR(15),
+    PRIMARY KEY (customer_id)
+);
+
+CREATE TABLE trips (
+    trip_id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+    driver_id BIGINT UNSIGNED,
+    car_id BIGINT UNSIGNED,
+    customer_id BIGINT UNSIGNED,
+    start_lat DECIMAL(9,6),
+    start_lon DECIMAL(9,6),
+    end_lat DECIMAL(9,6),
+    end_lon DECIMAL(9,6),
+    start_time DATETIME(6),
+    end_time DATETIME(6),
+    cost DECIMAL(10,2),
+    PRIMARY KEY (trip_id),
+    CONSTRAINT fk_trips_driver FOREIGN KEY (driver_id) REFERENCES drivers (driver_id),
+    CONSTRAINT fk_trips_car FOREIGN KEY (car_id) REFERENCES cars (car_id),
+    CONSTRAINT fk_trips_customer FOREIGN KEY (customer_id) REFERENCES customers (customer_id)
+);
@@ -0,0 +1,11 @@
+DROP INDEX idx_trips_status ON trips;
+
+ALTER TABLE trips
+    DROP COLUMN status,
+    DROP COLUMN requested_at,
+    DROP COLUMN assigned_at,
+    DROP COLUMN en_route_at,
+    DROP COLUMN picked_up_at,
+    DROP COLUMN completed_at,
+    DROP COLUMN cancelled_at,
+    DROP COLUMN cancel_reason;
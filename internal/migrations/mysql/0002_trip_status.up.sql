@@ -0,0 +1,13 @@
+ALTER TABLE trips
+    ADD COLUMN status VARCHAR(20) NOT NULL DEFAULT 'completed' AFTER trip_id,
+    ADD COLUMN requested_at DATETIME(6) NULL,
+    ADD COLUMN assigned_at DATETIME(6) NULL,
+    ADD COLUMN en_route_at DATETIME(6) NULL,
+    ADD COLUMN picked_up_at DATETIME(6) NULL,
+    ADD COLUMN completed_at DATETIME(6) NULL,
+    ADD COLUMN cancelled_at DATETIME(6) NULL,
+    ADD COLUMN cancel_reason VARCHAR(255) NOT NULL DEFAULT '';
+
+UPDATE trips SET picked_up_at = start_time, completed_at = end_time;
+
+CREATE INDEX idx_trips_status ON trips (status);
@@ -0,0 +1,18 @@
+ALTER TABLE car_models ADD COLUMN class VARCHAR(50) NOT NULL DEFAULT 'economy';
+
+CREATE TABLE tariffs (
+    tariff_id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+    class VARCHAR(50) NOT NULL,
+    base_fare DECIMAL(10,2) NOT NULL DEFAULT 0,
+    per_km DECIMAL(10,2) NOT NULL DEFAULT 0,
+    per_minute DECIMAL(10,2) NOT NULL DEFAULT 0,
+    minimum_fare DECIMAL(10,2) NOT NULL DEFAULT 0,
+    night_multiplier DECIMAL(4,2) NOT NULL DEFAULT 1,
+    weekend_multiplier DECIMAL(4,2) NOT NULL DEFAULT 1,
+    night_start TINYINT UNSIGNED NOT NULL DEFAULT 22,
+    night_end TINYINT UNSIGNED NOT NULL DEFAULT 6,
+    PRIMARY KEY (tariff_id),
+    UNIQUE INDEX idx_tariffs_class (class)
+);
+
+ALTER TABLE trips ADD COLUMN fare JSON NULL;
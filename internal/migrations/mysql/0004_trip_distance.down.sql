@@ -0,0 +1,3 @@
+ALTER TABLE trips
+    DROP COLUMN distance_km,
+    DROP COLUMN avg_speed_kmh;
@@ -0,0 +1,3 @@
+ALTER TABLE trips
+    ADD COLUMN distance_km DECIMAL(10,3) NULL AFTER end_lon,
+    ADD COLUMN avg_speed_kmh DOUBLE NULL AFTER distance_km;
@@ -0,0 +1,8 @@
+ALTER TABLE drivers ADD COLUMN deleted_at DATETIME(6) NULL;
+CREATE INDEX idx_drivers_deleted_at ON drivers (deleted_at);
+ALTER TABLE cars ADD COLUMN deleted_at DATETIME(6) NULL;
+CREATE INDEX idx_cars_deleted_at ON cars (deleted_at);
+ALTER TABLE customers ADD COLUMN deleted_at DATETIME(6) NULL;
+CREATE INDEX idx_customers_deleted_at ON customers (deleted_at);
+ALTER TABLE trips ADD COLUMN deleted_at DATETIME(6) NULL;
+CREATE INDEX idx_trips_deleted_at ON trips (deleted_at);
@@ -0,0 +1,14 @@
+CREATE TABLE audit_entries (
+    audit_id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+    entity VARCHAR(50) NOT NULL,
+    entity_id BIGINT UNSIGNED NOT NULL,
+    action VARCHAR(20) NOT NULL,
+    actor VARCHAR(100) NOT NULL,
+    request_id VARCHAR(100) NOT NULL DEFAULT '',
+    changes JSON,
+    created_at DATETIME(6) NOT NULL,
+    PRIMARY KEY (audit_id),
+    INDEX idx_audit_entries_entity (entity, entity_id),
+    INDEX idx_audit_entries_actor (actor),
+    INDEX idx_audit_entries_created_at (created_at)
+);
@@ -0,0 +1,12 @@
+CREATE TABLE api_keys (
+    key_id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+    name VARCHAR(100) NOT NULL,
+    prefix VARCHAR(20) NOT NULL,
+    hash CHAR(64) NOT NULL,
+    created_at DATETIME(6) NOT NULL,
+    rotated_at DATETIME(6) NULL,
+    revoked_at DATETIME(6) NULL,
+    version INT UNSIGNED NOT NULL DEFAULT 1,
+    PRIMARY KEY (key_id),
+    UNIQUE INDEX idx_api_keys_hash (hash)
+);
@@ -0,0 +1,2 @@
+ALTER TABLE api_keys DROP FOREIGN KEY fk_api_keys_driver;
+ALTER TABLE api_keys DROP COLUMN driver_id, DROP COLUMN role;
@@ -0,0 +1,4 @@
+ALTER TABLE api_keys
+    ADD COLUMN role VARCHAR(20) NOT NULL DEFAULT 'admin',
+    ADD COLUMN driver_id BIGINT UNSIGNED NULL,
+    ADD CONSTRAINT fk_api_keys_driver FOREIGN KEY (driver_id) REFERENCES drivers (driver_id);
@@ -0,0 +1,4 @@
+ALTER TABLE trips DROP FOREIGN KEY fk_trips_offered_driver;
+ALTER TABLE trips DROP FOREIGN KEY fk_trips_offered_car;
+ALTER TABLE trips DROP COLUMN offered_driver_id, DROP COLUMN offered_car_id, DROP COLUMN offered_at;
+ALTER TABLE drivers DROP COLUMN online, DROP COLUMN online_since;
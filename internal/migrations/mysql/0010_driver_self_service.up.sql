@@ -0,0 +1,10 @@
+ALTER TABLE drivers
+    ADD COLUMN online BOOLEAN NOT NULL DEFAULT FALSE,
+    ADD COLUMN online_since DATETIME(6) NULL;
+
+ALTER TABLE trips
+    ADD COLUMN offered_driver_id BIGINT UNSIGNED NULL,
+    ADD COLUMN offered_car_id BIGINT UNSIGNED NULL,
+    ADD COLUMN offered_at DATETIME(6) NULL,
+    ADD CONSTRAINT fk_trips_offered_driver FOREIGN KEY (offered_driver_id) REFERENCES drivers (driver_id),
+    ADD CONSTRAINT fk_trips_offered_car FOREIGN KEY (offered_car_id) REFERENCES cars (car_id);
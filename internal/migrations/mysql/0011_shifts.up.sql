@@ -0,0 +1,15 @@
+CREATE TABLE shifts (
+    shift_id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+    driver_id BIGINT UNSIGNED NOT NULL,
+    car_id BIGINT UNSIGNED NOT NULL,
+    started_at DATETIME(6) NOT NULL,
+    ended_at DATETIME(6) NULL,
+    odometer_in INT UNSIGNED NOT NULL,
+    odometer_out INT UNSIGNED NULL,
+    version INT UNSIGNED NOT NULL DEFAULT 1,
+    PRIMARY KEY (shift_id),
+    INDEX idx_shifts_driver (driver_id, ended_at),
+    INDEX idx_shifts_car (car_id, ended_at),
+    CONSTRAINT fk_shifts_driver FOREIGN KEY (driver_id) REFERENCES drivers (driver_id),
+    CONSTRAINT fk_shifts_car FOREIGN KEY (car_id) REFERENCES cars (car_id)
+);
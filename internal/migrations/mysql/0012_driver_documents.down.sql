@@ -0,0 +1 @@
+DROP TABLE driver_documents;
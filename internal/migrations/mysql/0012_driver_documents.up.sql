@@ -0,0 +1,18 @@
+CREATE TABLE driver_documents (
+    document_id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+    driver_id BIGINT UNSIGNED NOT NULL,
+    kind VARCHAR(20) NOT NULL,
+    number VARCHAR(50) NOT NULL DEFAULT '',
+    categories VARCHAR(50) NOT NULL DEFAULT '',
+    issued_at DATETIME(6) NULL,
+    expires_at DATETIME(6) NOT NULL,
+    file_name VARCHAR(255) NOT NULL DEFAULT '',
+    content_type VARCHAR(100) NOT NULL DEFAULT '',
+    file_size BIGINT NOT NULL DEFAULT 0,
+    file_key VARCHAR(64) NOT NULL DEFAULT '',
+    version INT UNSIGNED NOT NULL DEFAULT 1,
+    PRIMARY KEY (document_id),
+    INDEX idx_driver_documents_driver (driver_id, kind),
+    INDEX idx_driver_documents_expires (expires_at),
+    CONSTRAINT fk_driver_documents_driver FOREIGN KEY (driver_id) REFERENCES drivers (driver_id)
+);
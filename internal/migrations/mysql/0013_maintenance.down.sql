@@ -0,0 +1,2 @@
+DROP TABLE maintenance_rules;
+DROP TABLE maintenance_records;
@@ -0,0 +1,26 @@
+CREATE TABLE maintenance_records (
+    record_id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+    car_id BIGINT UNSIGNED NOT NULL,
+    service_type VARCHAR(50) NOT NULL,
+    workshop VARCHAR(100) NOT NULL DEFAULT '',
+    odometer INT UNSIGNED NOT NULL,
+    cost DECIMAL(10,2) NOT NULL DEFAULT 0,
+    started_at DATETIME(6) NOT NULL,
+    finished_at DATETIME(6) NULL,
+    version INT UNSIGNED NOT NULL DEFAULT 1,
+    PRIMARY KEY (record_id),
+    INDEX idx_maintenance_records_car (car_id, service_type, finished_at),
+    CONSTRAINT fk_maintenance_records_car FOREIGN KEY (car_id) REFERENCES cars (car_id)
+);
+
+CREATE TABLE maintenance_rules (
+    rule_id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+    model_id BIGINT UNSIGNED NOT NULL,
+    service_type VARCHAR(50) NOT NULL,
+    interval_km INT UNSIGNED NULL,
+    interval_months INT UNSIGNED NULL,
+    version INT UNSIGNED NOT NULL DEFAULT 1,
+    PRIMARY KEY (rule_id),
+    UNIQUE INDEX idx_maintenance_rules_model (model_id, service_type),
+    CONSTRAINT fk_maintenance_rules_model FOREIGN KEY (model_id) REFERENCES car_models (model_id)
+);
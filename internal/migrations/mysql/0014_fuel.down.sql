@@ -0,0 +1,2 @@
+DROP TABLE fuel_entries;
+DROP TABLE odometer_readings;
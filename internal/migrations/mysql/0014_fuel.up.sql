@@ -0,0 +1,25 @@
+CREATE TABLE odometer_readings (
+    reading_id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+    car_id BIGINT UNSIGNED NOT NULL,
+    odometer INT UNSIGNED NOT NULL,
+    read_at DATETIME(6) NOT NULL,
+    version INT UNSIGNED NOT NULL DEFAULT 1,
+    PRIMARY KEY (reading_id),
+    INDEX idx_odometer_readings_car (car_id, read_at),
+    CONSTRAINT fk_odometer_readings_car FOREIGN KEY (car_id) REFERENCES cars (car_id)
+);
+
+CREATE TABLE fuel_entries (
+    entry_id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+    car_id BIGINT UNSIGNED NOT NULL,
+    filled_at DATETIME(6) NOT NULL,
+    odometer INT UNSIGNED NOT NULL,
+    amount DECIMAL(10,3) NOT NULL,
+    unit VARCHAR(10) NOT NULL,
+    price DECIMAL(10,2) NOT NULL DEFAULT 0,
+    station VARCHAR(100) NOT NULL DEFAULT '',
+    version INT UNSIGNED NOT NULL DEFAULT 1,
+    PRIMARY KEY (entry_id),
+    INDEX idx_fuel_entries_car (car_id, filled_at),
+    CONSTRAINT fk_fuel_entries_car FOREIGN KEY (car_id) REFERENCES cars (car_id)
+);
@@ -0,0 +1,3 @@
+ALTER TABLE trips DROP COLUMN class;
+ALTER TABLE car_models DROP COLUMN seats, DROP COLUMN luggage_l, DROP COLUMN fuel_type,
+    DROP COLUMN wheelchair_accessible, DROP COLUMN low_floor;
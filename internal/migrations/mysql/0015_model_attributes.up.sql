@@ -0,0 +1,8 @@
+ALTER TABLE car_models
+    ADD COLUMN seats TINYINT UNSIGNED NOT NULL DEFAULT 4,
+    ADD COLUMN luggage_l SMALLINT UNSIGNED NOT NULL DEFAULT 0,
+    ADD COLUMN fuel_type VARCHAR(20) NOT NULL DEFAULT 'petrol',
+    ADD COLUMN wheelchair_accessible BOOLEAN NOT NULL DEFAULT FALSE,
+    ADD COLUMN low_floor BOOLEAN NOT NULL DEFAULT FALSE;
+
+ALTER TABLE trips ADD COLUMN class VARCHAR(50) NOT NULL DEFAULT '';
@@ -0,0 +1,12 @@
+CREATE TABLE car_positions (
+    position_id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+    car_id BIGINT UNSIGNED NOT NULL,
+    lat DECIMAL(9,6) NOT NULL,
+    lon DECIMAL(9,6) NOT NULL,
+    speed_kmh DOUBLE NULL,
+    heading DOUBLE NULL,
+    recorded_at DATETIME(6) NOT NULL,
+    PRIMARY KEY (position_id),
+    INDEX idx_car_positions_car (car_id, recorded_at),
+    CONSTRAINT fk_car_positions_car FOREIGN KEY (car_id) REFERENCES cars (car_id)
+);
@@ -0,0 +1 @@
+ALTER TABLE audit_entries DROP INDEX idx_audit_entries_actor_key_id, DROP COLUMN actor_key_id;
@@ -0,0 +1,3 @@
+ALTER TABLE audit_entries
+    ADD COLUMN actor_key_id BIGINT UNSIGNED NULL AFTER actor,
+    ADD INDEX idx_audit_entries_actor_key_id (actor_key_id);
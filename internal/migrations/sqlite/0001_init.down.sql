@@ -0,0 +1,5 @@
+DROP TABLE trips;
+DROP TABLE customers;
+DROP TABLE cars;
+DROP TABLE car_models;
+DROP TABLE drivers;
@@ -0,0 +1,49 @@
+CREATE TABLE drivers (
+    driver_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    first_name VARCHAR(100),
+    last_name VARCHAR(100),
+    lisence_number VARCHAR(191)
+);
+
+CREATE UNIQUE INDEX idx_drivers_lisence_number ON drivers (lisence_number);
+
+CREATE TABLE car_models (
+    model_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    model_name VARCHAR(100),
+    manufacturer VARCHAR(100)
+);
+
+CREATE TABLE cars (
+    car_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    license_plate VARCHAR(100),
+    model_id INTEGER,
+    year INTEGER,
+    notes TEXT,
+    CONSTRAINT fk_cars_model FOREIGN KEY (model_id) REFERENCES car_models (model_id)
+);
+
+CREATE UNIQUE INDEX idx_cars_license_plate ON cars (license_plate);
+
+CREATE TABLE customers (
+    customer_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    first_name VARCHAR(100),
+    last_name VARCHAR(100),
+    phone VARCHAR(15)
+);
+
+CREATE TABLE trips (
+    trip_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    driver_id INTEGER,
+    car_id INTEGER,
+    customer_id INTEGER,
+    start_lat NUMERIC(9,6),
+    start_lon NUMERIC(9,6),
+    end_lat NUMERIC(9,6),
+    end_lon NUMERIC(9,6),
+    start_time DATETIME,
+    end_time DATETIME,
+    cost NUMERIC(10,2),
+    CONSTRAINT fk_trips_driver FOREIGN KEY (driver_id) REFERENCES drivers (driver_id),
+    CONSTRAINT fk_trips_car FOREIGN KEY (car_id) REFERENCES cars (car_id),
+    CONSTRAINT fk_trips_customer FOREIGN KEY (customer_id) REFERENCES customers (customer_id)
+);
@@ -0,0 +1,10 @@
+DROP INDEX idx_trips_status;
+
+ALTER TABLE trips DROP COLUMN status;
+ALTER TABLE trips DROP COLUMN requested_at;
+ALTER TABLE trips DROP COLUMN assigned_at;
+ALTER TABLE trips DROP COLUMN en_route_at;
+ALTER TABLE trips DROP COLUMN picked_up_at;
+ALTER TABLE trips DROP COLUMN completed_at;
+ALTER TABLE trips DROP COLUMN cancelled_at;
+ALTER TABLE trips DROP COLUMN cancel_reason;
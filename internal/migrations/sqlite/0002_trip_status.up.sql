@@ -0,0 +1,12 @@
+ALTER TABLE trips ADD COLUMN status VARCHAR(20) NOT NULL DEFAULT 'completed';
+ALTER TABLE trips ADD COLUMN requested_at DATETIME;
+ALTER TABLE trips ADD COLUMN assigned_at DATETIME;
+ALTER TABLE trips ADD COLUMN en_route_at DATETIME;
+ALTER TABLE trips ADD COLUMN picked_up_at DATETIME;
+ALTER TABLE trips ADD COLUMN completed_at DATETIME;
+ALTER TABLE trips ADD COLUMN cancelled_at DATETIME;
+ALTER TABLE trips ADD COLUMN cancel_reason VARCHAR(255) NOT NULL DEFAULT '';
+
+UPDATE trips SET picked_up_at = start_time, completed_at = end_time;
+
+CREATE INDEX idx_trips_status ON trips (status);
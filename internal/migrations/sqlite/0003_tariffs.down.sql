@@ -0,0 +1,5 @@
+ALTER TABLE trips DROP COLUMN fare;
+
+DROP TABLE tariffs;
+
+ALTER TABLE car_models DROP COLUMN class;
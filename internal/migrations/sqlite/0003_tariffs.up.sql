@@ -0,0 +1,18 @@
+ALTER TABLE car_models ADD COLUMN class VARCHAR(50) NOT NULL DEFAULT 'economy';
+
+CREATE TABLE tariffs (
+    tariff_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    class VARCHAR(50) NOT NULL,
+    base_fare NUMERIC(10,2) NOT NULL DEFAULT 0,
+    per_km NUMERIC(10,2) NOT NULL DEFAULT 0,
+    per_minute NUMERIC(10,2) NOT NULL DEFAULT 0,
+    minimum_fare NUMERIC(10,2) NOT NULL DEFAULT 0,
+    night_multiplier NUMERIC(4,2) NOT NULL DEFAULT 1,
+    weekend_multiplier NUMERIC(4,2) NOT NULL DEFAULT 1,
+    night_start INTEGER NOT NULL DEFAULT 22,
+    night_end INTEGER NOT NULL DEFAULT 6
+);
+
+CREATE UNIQUE INDEX idx_tariffs_class ON tariffs (class);
+
+ALTER TABLE trips ADD COLUMN fare TEXT;
@@ -0,0 +1,2 @@
+ALTER TABLE trips DROP COLUMN distance_km;
+ALTER TABLE trips DROP COLUMN avg_speed_kmh;
@@ -0,0 +1,2 @@
+ALTER TABLE trips ADD COLUMN distance_km NUMERIC(10,3);
+ALTER TABLE trips ADD COLUMN avg_speed_kmh REAL;
@@ -0,0 +1,6 @@
+ALTER TABLE tariffs DROP COLUMN version;
+ALTER TABLE trips DROP COLUMN version;
+ALTER TABLE customers DROP COLUMN version;
+ALTER TABLE drivers DROP COLUMN version;
+ALTER TABLE cars DROP COLUMN version;
+ALTER TABLE car_models DROP COLUMN version;
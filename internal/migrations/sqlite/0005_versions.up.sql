@@ -0,0 +1,6 @@
+ALTER TABLE car_models ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE cars ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE drivers ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE customers ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE trips ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE tariffs ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
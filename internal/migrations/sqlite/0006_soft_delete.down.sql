@@ -0,0 +1,8 @@
+DROP INDEX idx_trips_deleted_at;
+ALTER TABLE trips DROP COLUMN deleted_at;
+DROP INDEX idx_customers_deleted_at;
+ALTER TABLE customers DROP COLUMN deleted_at;
+DROP INDEX idx_cars_deleted_at;
+ALTER TABLE cars DROP COLUMN deleted_at;
+DROP INDEX idx_drivers_deleted_at;
+ALTER TABLE drivers DROP COLUMN deleted_at;
@@ -0,0 +1,14 @@
+CREATE TABLE audit_entries (
+    audit_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    entity VARCHAR(50) NOT NULL,
+    entity_id INTEGER NOT NULL,
+    action VARCHAR(20) NOT NULL,
+    actor VARCHAR(100) NOT NULL,
+    request_id VARCHAR(100) NOT NULL DEFAULT '',
+    changes TEXT,
+    created_at DATETIME NOT NULL
+);
+
+CREATE INDEX idx_audit_entries_entity ON audit_entries (entity, entity_id);
+CREATE INDEX idx_audit_entries_actor ON audit_entries (actor);
+CREATE INDEX idx_audit_entries_created_at ON audit_entries (created_at);
@@ -0,0 +1,12 @@
+CREATE TABLE api_keys (
+    key_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name VARCHAR(100) NOT NULL,
+    prefix VARCHAR(20) NOT NULL,
+    hash CHAR(64) NOT NULL,
+    created_at DATETIME NOT NULL,
+    rotated_at DATETIME,
+    revoked_at DATETIME,
+    version INTEGER NOT NULL DEFAULT 1
+);
+
+CREATE UNIQUE INDEX idx_api_keys_hash ON api_keys (hash);
@@ -0,0 +1,20 @@
+-- SQLite cannot drop a column that is part of a foreign key, so the table
+-- is rebuilt without it.
+CREATE TABLE api_keys_old (
+    key_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name VARCHAR(100) NOT NULL,
+    prefix VARCHAR(20) NOT NULL,
+    hash CHAR(64) NOT NULL,
+    created_at DATETIME NOT NULL,
+    rotated_at DATETIME,
+    revoked_at DATETIME,
+    version INTEGER NOT NULL DEFAULT 1
+);
+
+INSERT INTO api_keys_old (key_id, name, prefix, hash, created_at, rotated_at, revoked_at, version)
+SELECT key_id, name, prefix, hash, created_at, rotated_at, revoked_at, version FROM api_keys;
+
+DROP TABLE api_keys;
+ALTER TABLE api_keys_old RENAME TO api_keys;
+
+CREATE UNIQUE INDEX idx_api_keys_hash ON api_keys (hash);
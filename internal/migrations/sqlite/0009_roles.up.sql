@@ -0,0 +1,2 @@
+ALTER TABLE api_keys ADD COLUMN role VARCHAR(20) NOT NULL DEFAULT 'admin';
+ALTER TABLE api_keys ADD COLUMN driver_id INTEGER REFERENCES drivers (driver_id);
@@ -0,0 +1,48 @@
+ALTER TABLE drivers DROP COLUMN online_since;
+ALTER TABLE drivers DROP COLUMN online;
+
+-- SQLite cannot drop a column that is part of a foreign key, so the trips
+-- table is rebuilt without the offer columns.
+CREATE TABLE trips_old (
+    trip_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    driver_id INTEGER,
+    car_id INTEGER,
+    customer_id INTEGER,
+    start_lat NUMERIC(9,6),
+    start_lon NUMERIC(9,6),
+    end_lat NUMERIC(9,6),
+    end_lon NUMERIC(9,6),
+    start_time DATETIME,
+    end_time DATETIME,
+    cost NUMERIC(10,2),
+    status VARCHAR(20) NOT NULL DEFAULT 'completed',
+    requested_at DATETIME,
+    assigned_at DATETIME,
+    en_route_at DATETIME,
+    picked_up_at DATETIME,
+    completed_at DATETIME,
+    cancelled_at DATETIME,
+    cancel_reason VARCHAR(255) NOT NULL DEFAULT '',
+    fare TEXT,
+    distance_km NUMERIC(10,3),
+    avg_speed_kmh REAL,
+    version INTEGER NOT NULL DEFAULT 1,
+    deleted_at DATETIME,
+    CONSTRAINT fk_trips_driver FOREIGN KEY (driver_id) REFERENCES drivers (driver_id),
+    CONSTRAINT fk_trips_car FOREIGN KEY (car_id) REFERENCES cars (car_id),
+    CONSTRAINT fk_trips_customer FOREIGN KEY (customer_id) REFERENCES customers (customer_id)
+);
+
+INSERT INTO trips_old (trip_id, driver_id, car_id, customer_id, start_lat, start_lon, end_lat, end_lon,
+    start_time, end_time, cost, status, requested_at, assigned_at, en_route_at, picked_up_at,
+    completed_at, cancelled_at, cancel_reason, fare, distance_km, avg_speed_kmh, version, deleted_at)
+SELECT trip_id, driver_id, car_id, customer_id, start_lat, start_lon, end_lat, end_lon,
+    start_time, end_time, cost, status, requested_at, assigned_at, en_route_at, picked_up_at,
+    completed_at, cancelled_at, cancel_reason, fare, distance_km, avg_speed_kmh, version, deleted_at
+FROM trips;
+
+DROP TABLE trips;
+ALTER TABLE trips_old RENAME TO trips;
+
+CREATE INDEX idx_trips_status ON trips (status);
+CREATE INDEX idx_trips_deleted_at ON trips (deleted_at);
@@ -0,0 +1,5 @@
+ALTER TABLE drivers ADD COLUMN online BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE drivers ADD COLUMN online_since DATETIME;
+ALTER TABLE trips ADD COLUMN offered_driver_id INTEGER REFERENCES drivers (driver_id);
+ALTER TABLE trips ADD COLUMN offered_car_id INTEGER REFERENCES cars (car_id);
+ALTER TABLE trips ADD COLUMN offered_at DATETIME;
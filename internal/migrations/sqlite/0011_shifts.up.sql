@@ -0,0 +1,13 @@
+CREATE TABLE shifts (
+    shift_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    driver_id INTEGER NOT NULL REFERENCES drivers (driver_id),
+    car_id INTEGER NOT NULL REFERENCES cars (car_id),
+    started_at DATETIME NOT NULL,
+    ended_at DATETIME,
+    odometer_in INTEGER NOT NULL,
+    odometer_out INTEGER,
+    version INTEGER NOT NULL DEFAULT 1
+);
+
+CREATE INDEX idx_shifts_driver ON shifts (driver_id, ended_at);
+CREATE INDEX idx_shifts_car ON shifts (car_id, ended_at);
@@ -0,0 +1,17 @@
+CREATE TABLE driver_documents (
+    document_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    driver_id INTEGER NOT NULL REFERENCES drivers (driver_id),
+    kind VARCHAR(20) NOT NULL,
+    number VARCHAR(50) NOT NULL DEFAULT '',
+    categories VARCHAR(50) NOT NULL DEFAULT '',
+    issued_at DATETIME,
+    expires_at DATETIME NOT NULL,
+    file_name VARCHAR(255) NOT NULL DEFAULT '',
+    content_type VARCHAR(100) NOT NULL DEFAULT '',
+    file_size INTEGER NOT NULL DEFAULT 0,
+    file_key VARCHAR(64) NOT NULL DEFAULT '',
+    version INTEGER NOT NULL DEFAULT 1
+);
+
+CREATE INDEX idx_driver_documents_driver ON driver_documents (driver_id, kind);
+CREATE INDEX idx_driver_documents_expires ON driver_documents (expires_at);
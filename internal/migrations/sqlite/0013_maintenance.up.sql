@@ -0,0 +1,24 @@
+CREATE TABLE maintenance_records (
+    record_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    car_id INTEGER NOT NULL REFERENCES cars (car_id),
+    service_type VARCHAR(50) NOT NULL,
+    workshop VARCHAR(100) NOT NULL DEFAULT '',
+    odometer INTEGER NOT NULL,
+    cost NUMERIC(10,2) NOT NULL DEFAULT 0,
+    started_at DATETIME NOT NULL,
+    finished_at DATETIME,
+    version INTEGER NOT NULL DEFAULT 1
+);
+
+CREATE INDEX idx_maintenance_records_car ON maintenance_records (car_id, service_type, finished_at);
+
+CREATE TABLE maintenance_rules (
+    rule_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    model_id INTEGER NOT NULL REFERENCES car_models (model_id),
+    service_type VARCHAR(50) NOT NULL,
+    interval_km INTEGER,
+    interval_months INTEGER,
+    version INTEGER NOT NULL DEFAULT 1
+);
+
+CREATE UNIQUE INDEX idx_maintenance_rules_model ON maintenance_rules (model_id, service_type);
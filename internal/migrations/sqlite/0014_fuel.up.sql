@@ -0,0 +1,23 @@
+CREATE TABLE odometer_readings (
+    reading_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    car_id INTEGER NOT NULL REFERENCES cars (car_id),
+    odometer INTEGER NOT NULL,
+    read_at DATETIME NOT NULL,
+    version INTEGER NOT NULL DEFAULT 1
+);
+
+CREATE INDEX idx_odometer_readings_car ON odometer_readings (car_id, read_at);
+
+CREATE TABLE fuel_entries (
+    entry_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    car_id INTEGER NOT NULL REFERENCES cars (car_id),
+    filled_at DATETIME NOT NULL,
+    odometer INTEGER NOT NULL,
+    amount NUMERIC(10,3) NOT NULL,
+    unit VARCHAR(10) NOT NULL,
+    price NUMERIC(10,2) NOT NULL DEFAULT 0,
+    station VARCHAR(100) NOT NULL DEFAULT '',
+    version INTEGER NOT NULL DEFAULT 1
+);
+
+CREATE INDEX idx_fuel_entries_car ON fuel_entries (car_id, filled_at);
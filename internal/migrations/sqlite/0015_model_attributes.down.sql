@@ -0,0 +1,6 @@
+ALTER TABLE trips DROP COLUMN class;
+ALTER TABLE car_models DROP COLUMN low_floor;
+ALTER TABLE car_models DROP COLUMN wheelchair_accessible;
+ALTER TABLE car_models DROP COLUMN fuel_type;
+ALTER TABLE car_models DROP COLUMN luggage_l;
+ALTER TABLE car_models DROP COLUMN seats;
@@ -0,0 +1,7 @@
+ALTER TABLE car_models ADD COLUMN seats INTEGER NOT NULL DEFAULT 4;
+ALTER TABLE car_models ADD COLUMN luggage_l INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE car_models ADD COLUMN fuel_type VARCHAR(20) NOT NULL DEFAULT 'petrol';
+ALTER TABLE car_models ADD COLUMN wheelchair_accessible BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE car_models ADD COLUMN low_floor BOOLEAN NOT NULL DEFAULT FALSE;
+
+ALTER TABLE trips ADD COLUMN class VARCHAR(50) NOT NULL DEFAULT '';
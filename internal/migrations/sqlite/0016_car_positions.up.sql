@@ -0,0 +1,11 @@
+CREATE TABLE car_positions (
+    position_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    car_id INTEGER NOT NULL REFERENCES cars (car_id),
+    lat NUMERIC(9,6) NOT NULL,
+    lon NUMERIC(9,6) NOT NULL,
+    speed_kmh REAL,
+    heading REAL,
+    recorded_at DATETIME NOT NULL
+);
+
+CREATE INDEX idx_car_positions_car ON car_positions (car_id, recorded_at);
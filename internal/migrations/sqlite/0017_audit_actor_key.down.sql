@@ -0,0 +1,2 @@
+DROP INDEX idx_audit_entries_actor_key_id;
+ALTER TABLE audit_entries DROP COLUMN actor_key_id;
@@ -0,0 +1,3 @@
+ALTER TABLE audit_entries ADD COLUMN actor_key_id INTEGER;
+
+CREATE INDEX idx_audit_entries_actor_key_id ON audit_entries (actor_key_id);
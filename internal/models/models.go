@@ -1,54 +1,816 @@
 package models
 
 import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
 	"time"
 
 	_ "gorm.io/driver/mysql"
-	_ "gorm.io/gorm"
+	"gorm.io/gorm"
 )
 
+// Driver is edited by the office, except for Online, which drivers switch
+// themselves. Only online drivers are offered trips.
 type Driver struct {
-	DriverID      uint   `gorm:"primaryKey;autoIncrement" json:"driver_id"`
-	FirstName     string `gorm:"size:100" json:"first_name"`
-	LastName      string `gorm:"size:100" json:"last_name"`
-	LisenceNumber string `gorm:"uniqueIndex" json:"lisence_number"`
+	DriverID      uint           `gorm:"primaryKey;autoIncrement" json:"driver_id"`
+	FirstName     string         `gorm:"size:100" json:"first_name"`
+	LastName      string         `gorm:"size:100" json:"last_name"`
+	LisenceNumber string         `gorm:"uniqueIndex" json:"lisence_number"`
+	Online        bool           `gorm:"not null;default:false" json:"online"`
+	OnlineSince   *time.Time     `gorm:"type:datetime(6)" json:"online_since"`
+	Version       uint           `gorm:"not null;default:1" json:"version"`
+	DeletedAt     gorm.DeletedAt `gorm:"index" json:"deleted_at"`
 }
 
+// SetOnline switches the driver online or offline at at. OnlineSince keeps
+// the time the driver went online.
+func (d *Driver) SetOnline(online bool, at time.Time) {
+	if d.Online == online {
+		return
+	}
+	d.Online = online
+	d.OnlineSince = nil
+	if online {
+		d.OnlineSince = &at
+	}
+}
+
+// CarModel describes cars of one model: the class their trips are sold
+// in, how many passengers and how much luggage, in litres, they take, what
+// they run on and whether they suit passengers with reduced mobility.
 type CarModel struct {
-	ModelID      uint   `gorm:"primaryKey;autoIncrement" json:"model_id"`
-	ModelName    string `gorm:"size:100" json:"model_name"`
-	Manufacturer string `gorm:"size:100" json:"manufacturer"`
+	ModelID              uint     `gorm:"primaryKey;autoIncrement" json:"model_id"`
+	ModelName            string   `gorm:"size:100" json:"model_name"`
+	Manufacturer         string   `gorm:"size:100" json:"manufacturer"`
+	Class                string   `gorm:"size:50;default:economy" json:"class"`
+	Seats                uint     `gorm:"not null;default:4" json:"seats"`
+	LuggageL             uint     `gorm:"not null;default:0" json:"luggage_l"`
+	FuelType             FuelType `gorm:"size:20;default:petrol" json:"fuel_type"`
+	WheelchairAccessible bool     `gorm:"not null;default:false" json:"wheelchair_accessible"`
+	LowFloor             bool     `gorm:"not null;default:false" json:"low_floor"`
+	Version              uint     `gorm:"not null;default:1" json:"version"`
 }
 
+// DefaultClass is the class of car models created without one.
+const DefaultClass = "economy"
+
+// DefaultSeats is the number of passenger seats of car models created
+// without one.
+const DefaultSeats = 4
+
+type FuelType string
+
+const (
+	FuelPetrol   FuelType = "petrol"
+	FuelDiesel   FuelType = "diesel"
+	FuelLPG      FuelType = "lpg"
+	FuelHybrid   FuelType = "hybrid"
+	FuelElectric FuelType = "electric"
+)
+
 type Car struct {
-	CarID        uint     `gorm:"primaryKey;autoIncrement" json:"car_id"`
-	LicensePlate string   `gorm:"size:100;uniqueIndex" json:"license_plate"`
-	ModelID      uint     `json:"model_id"`
-	Model        CarModel `gorm:"foreignKey:ModelID;references:ModelID" json:"model"`
-	Year         uint     `gorm:"type:year" json:"year"`
-	Notes        string   `gorm:"type:json" json:"notes"`
+	CarID        uint           `gorm:"primaryKey;autoIncrement" json:"car_id"`
+	LicensePlate string         `gorm:"size:100;uniqueIndex" json:"license_plate"`
+	ModelID      uint           `json:"model_id"`
+	Model        CarModel       `gorm:"foreignKey:ModelID;references:ModelID" json:"model"`
+	Year         uint           `gorm:"type:year" json:"year"`
+	Notes        Notes          `gorm:"type:json" json:"notes"`
+	Version      uint           `gorm:"not null;default:1" json:"version"`
+	DeletedAt    gorm.DeletedAt `gorm:"index" json:"deleted_at"`
+}
+
+// Notes holds free-form details of a car, such as its colour, as a JSON
+// object. Cars without notes store NULL.
+type Notes json.RawMessage
+
+func (n Notes) MarshalJSON() ([]byte, error) {
+	if len(n) == 0 {
+		return []byte("null"), nil
+	}
+	return n, nil
+}
+
+func (n *Notes) UnmarshalJSON(b []byte) error {
+	if string(b) == "null" {
+		*n = nil
+		return nil
+	}
+	*n = slices.Clone(b)
+	return nil
+}
+
+// Value stores the notes as text, MySQL rejects binary strings in JSON
+// columns.
+func (n Notes) Value() (driver.Value, error) {
+	if len(n) == 0 {
+		return nil, nil
+	}
+	return string(n), nil
+}
+
+// Scan reads notes stored as JSON. Empty strings, which older versions
+// stored for cars without notes, are read as no notes.
+func (n *Notes) Scan(src any) error {
+	switch src := src.(type) {
+	case nil:
+		*n = nil
+	case []byte:
+		*n = slices.Clone(src)
+	case string:
+		*n = Notes(src)
+	default:
+		return fmt.Errorf("cannot scan %T into Notes", src)
+	}
+	if len(*n) == 0 {
+		*n = nil
+	}
+	return nil
+}
+
+// Lookup returns the value at path, names of nested object fields, and
+// whether it is there. Numbers are float64 like in encoding/json.
+func (n Notes) Lookup(path []string) (any, bool) {
+	var v any
+	if json.Unmarshal(n, &v) != nil {
+		return nil, false
+	}
+	for _, name := range path {
+		obj, ok := v.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		if v, ok = obj[name]; !ok {
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// InService returns when the car came into service as far as it is known:
+// the start of its year, or the zero time when the year is not set.
+func (c *Car) InService() time.Time {
+	if c.Year == 0 {
+		return time.Time{}
+	}
+	return time.Date(int(c.Year), time.January, 1, 0, 0, 0, 0, time.UTC)
 }
 
 type Customer struct {
-	CustomerID uint   `gorm:"primaryKey;autoIncrement" json:"customer_id"`
-	FirstName  string `gorm:"size:100" json:"first_name"`
-	LastName   string `gorm:"size:100" json:"last_name"`
-	Phone      string `gorm:"size:15" json:"phone"`
+	CustomerID uint           `gorm:"primaryKey;autoIncrement" json:"customer_id"`
+	FirstName  string         `gorm:"size:100" json:"first_name"`
+	LastName   string         `gorm:"size:100" json:"last_name"`
+	Phone      string         `gorm:"size:15" json:"phone"`
+	Version    uint           `gorm:"not null;default:1" json:"version"`
+	DeletedAt  gorm.DeletedAt `gorm:"index" json:"deleted_at"`
+}
+
+type TripStatus string
+
+const (
+	TripRequested  TripStatus = "requested"
+	TripAssigned   TripStatus = "assigned"
+	TripEnRoute    TripStatus = "en_route"
+	TripInProgress TripStatus = "in_progress"
+	TripCompleted  TripStatus = "completed"
+	TripCancelled  TripStatus = "cancelled"
+)
+
+var (
+	ErrIllegalTransition = errors.New("illegal trip status transition")
+	// ErrNotOffered means the trip is not offered to the driver who
+	// answers the offer.
+	ErrNotOffered = errors.New("trip is not offered to the driver")
+)
+
+var tripTransitions = map[TripStatus][]TripStatus{
+	TripRequested:  {TripAssigned, TripCancelled},
+	TripAssigned:   {TripEnRoute, TripInProgress, TripCancelled},
+	TripEnRoute:    {TripInProgress, TripCancelled},
+	TripInProgress: {TripCompleted},
 }
 
+// Trip is a ride of a customer. While a requested trip is offered to a
+// driver, OfferedDriverID and OfferedCarID hold the driver and car it would
+// be assigned to if the driver accepts.
 type Trip struct {
-	TripID     uint      `gorm:"primaryKey;autoIncrement" json:"trip_id"`
-	DriverID   uint      `json:"driver_id"`
-	Driver     Driver    `gorm:"foreignKey:DriverID;references:DriverID" json:"driver"`
+	TripID          uint           `gorm:"primaryKey;autoIncrement" json:"trip_id"`
+	Status          TripStatus     `gorm:"size:20;index" json:"status"`
+	DriverID        *uint          `json:"driver_id"`
+	Driver          *Driver        `gorm:"foreignKey:DriverID;references:DriverID" json:"driver"`
+	CarID           *uint          `json:"car_id"`
+	Car             *Car           `gorm:"foreignKey:CarID;references:CarID" json:"car"`
+	CustomerID      uint           `json:"customer_id"`
+	Customer        Customer       `gorm:"foreignKey:CustomerID;references:CustomerID" json:"customer"`
+	StartLat        float64        `gorm:"type:decimal(9,6)" json:"start_lat"`
+	StartLon        float64        `gorm:"type:decimal(9,6)" json:"start_lon"`
+	EndLat          float64        `gorm:"type:decimal(9,6)" json:"end_lat"`
+	EndLon          float64        `gorm:"type:decimal(9,6)" json:"end_lon"`
+	DistanceKm      *float64       `gorm:"type:decimal(10,3)" json:"distance_km"`
+	AvgSpeedKmh     *float64       `json:"avg_speed_kmh"`
+	StartTime       *time.Time     `gorm:"type:datetime(6)" json:"start_time"`
+	EndTime         *time.Time     `gorm:"type:datetime(6)" json:"end_time"`
+	Cost            float64        `gorm:"type:decimal(10,2)" json:"cost"`
+	RequestedAt     *time.Time     `gorm:"type:datetime(6)" json:"requested_at"`
+	AssignedAt      *time.Time     `gorm:"type:datetime(6)" json:"assigned_at"`
+	EnRouteAt       *time.Time     `gorm:"type:datetime(6)" json:"en_route_at"`
+	PickedUpAt      *time.Time     `gorm:"type:datetime(6)" json:"picked_up_at"`
+	CompletedAt     *time.Time     `gorm:"type:datetime(6)" json:"completed_at"`
+	CancelledAt     *time.Time     `gorm:"type:datetime(6)" json:"cancelled_at"`
+	CancelReason    string         `gorm:"size:255" json:"cancel_reason,omitempty"`
+	Class           string         `gorm:"size:50" json:"class,omitempty"`
+	OfferedDriverID *uint          `json:"offered_driver_id"`
+	OfferedCarID    *uint          `json:"offered_car_id"`
+	OfferedAt       *time.Time     `gorm:"type:datetime(6)" json:"offered_at"`
+	Fare            *Fare          `gorm:"type:json;serializer:json" json:"fare"`
+	Version         uint           `gorm:"not null;default:1" json:"version"`
+	DeletedAt       gorm.DeletedAt `gorm:"index" json:"deleted_at"`
+}
+
+var ErrInvalidTimes = errors.New("end_time must be after start_time")
+
+// farFuture ends the busy period of trips that are still running.
+var farFuture = time.Date(9999, 12, 31, 0, 0, 0, 0, time.UTC)
+
+func (t *Trip) ValidateTimes() error {
+	if t.StartTime != nil && t.EndTime != nil && !t.EndTime.After(*t.StartTime) {
+		return ErrInvalidTimes
+	}
+	return nil
+}
+
+// Busy returns the period during which the trip occupies its driver and
+// car. Cancelled trips and trips without a driver or car occupy nothing, a
+// trip that has not ended yet is busy indefinitely.
+func (t *Trip) Busy() (start, end time.Time, ok bool) {
+	if t.Status == TripCancelled || (t.DriverID == nil && t.CarID == nil) {
+		return start, end, false
+	}
+
+	switch {
+	case t.StartTime != nil:
+		start = *t.StartTime
+	case t.AssignedAt != nil:
+		start = *t.AssignedAt
+	default:
+		return start, end, false
+	}
+
+	end = farFuture
+	if t.EndTime != nil {
+		end = *t.EndTime
+	}
+	return start, end, true
+}
+
+// Finished reports whether the trip is completed or cancelled.
+func (t *Trip) Finished() bool {
+	return t.Status == TripCompleted || t.Status == TripCancelled
+}
+
+// Measure stores the great-circle distance of the trip and, once it has
+// ended, its average speed.
+func (t *Trip) Measure() {
+	km := math.Round(Distance(t.StartLat, t.StartLon, t.EndLat, t.EndLon)*1000) / 1000
+	t.DistanceKm = &km
+	t.AvgSpeedKmh = nil
+	if t.StartTime != nil && t.EndTime != nil && t.EndTime.After(*t.StartTime) {
+		speed := math.Round(km/t.EndTime.Sub(*t.StartTime).Hours()*100) / 100
+		t.AvgSpeedKmh = &speed
+	}
+}
+
+// BeforeSave stores all times in UTC, so that they compare correctly in
+// databases that keep datetimes as text, and measures the trip.
+func (t *Trip) BeforeSave(*gorm.DB) error {
+	t.Measure()
+	for _, ts := range []**time.Time{&t.StartTime, &t.EndTime, &t.RequestedAt, &t.AssignedAt, &t.EnRouteAt, &t.PickedUpAt, &t.CompletedAt, &t.CancelledAt, &t.OfferedAt} {
+		if *ts != nil {
+			utc := (*ts).UTC()
+			*ts = &utc
+		}
+	}
+	return nil
+}
+
+// Transition moves the trip to status to and records when it happened.
+// Pickup and completion also fix the start and end time of the ride.
+func (t *Trip) Transition(to TripStatus, at time.Time) error {
+	allowed := false
+	for _, next := range tripTransitions[t.Status] {
+		if next == to {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return fmt.Errorf("%w: %s -> %s", ErrIllegalTransition, t.Status, to)
+	}
+
+	if to == TripAssigned || to == TripCancelled {
+		t.clearOffer()
+	}
+
+	switch to {
+	case TripAssigned:
+		t.AssignedAt = &at
+	case TripEnRoute:
+		t.EnRouteAt = &at
+	case TripInProgress:
+		t.PickedUpAt = &at
+		t.StartTime = &at
+	case TripCompleted:
+		t.CompletedAt = &at
+		t.EndTime = &at
+	case TripCancelled:
+		t.CancelledAt = &at
+	}
+	t.Status = to
+	return nil
+}
+
+// Offer proposes the requested trip to a driver with a car, replacing any
+// earlier offer.
+func (t *Trip) Offer(driverID, carID uint, at time.Time) error {
+	if t.Status != TripRequested {
+		return fmt.Errorf("%w: only requested trips can be offered, the trip is %s", ErrIllegalTransition, t.Status)
+	}
+	t.OfferedDriverID = &driverID
+	t.OfferedCarID = &carID
+	t.OfferedAt = &at
+	return nil
+}
+
+// OfferedTo reports whether the trip is offered to the driver driverID.
+func (t *Trip) OfferedTo(driverID uint) bool {
+	return t.Status == TripRequested && t.OfferedDriverID != nil && *t.OfferedDriverID == driverID
+}
+
+// Accept assigns the trip to the driver it is offered to, with the offered
+// car.
+func (t *Trip) Accept(driverID uint, at time.Time) error {
+	if !t.OfferedTo(driverID) {
+		return ErrNotOffered
+	}
+	carID := *t.OfferedCarID
+	if err := t.Transition(TripAssigned, at); err != nil {
+		return err
+	}
+	t.DriverID = &driverID
+	t.CarID = &carID
+	return nil
+}
+
+// Decline withdraws the offer, the trip stays requested.
+func (t *Trip) Decline(driverID uint) error {
+	if !t.OfferedTo(driverID) {
+		return ErrNotOffered
+	}
+	t.clearOffer()
+	return nil
+}
+
+func (t *Trip) clearOffer() {
+	t.OfferedDriverID, t.OfferedCarID, t.OfferedAt = nil, nil, nil
+}
+
+// Shift is a period during which a driver works in one car. It is open
+// until EndedAt and OdometerOut are set. Odometer readings are in
+// kilometres.
+type Shift struct {
+	ShiftID     uint       `gorm:"primaryKey;autoIncrement" json:"shift_id"`
+	DriverID    uint       `json:"driver_id"`
+	Driver      Driver     `gorm:"foreignKey:DriverID;references:DriverID" json:"driver"`
+	CarID       uint       `json:"car_id"`
+	Car         Car        `gorm:"foreignKey:CarID;references:CarID" json:"car"`
+	StartedAt   time.Time  `gorm:"type:datetime(6)" json:"started_at"`
+	EndedAt     *time.Time `gorm:"type:datetime(6)" json:"ended_at"`
+	OdometerIn  uint       `json:"odometer_in"`
+	OdometerOut *uint      `json:"odometer_out"`
+	Version     uint       `gorm:"not null;default:1" json:"version"`
+}
+
+var (
+	ErrShiftClosed = errors.New("shift is already closed")
+	ErrOdometer    = errors.New("odometer_out must not be less than odometer_in")
+)
+
+func (s *Shift) Open() bool {
+	return s.EndedAt == nil
+}
+
+// Busy returns the period of the shift, an open shift lasts indefinitely.
+func (s *Shift) Busy() (start, end time.Time) {
+	end = farFuture
+	if s.EndedAt != nil {
+		end = *s.EndedAt
+	}
+	return s.StartedAt, end
+}
+
+// Close ends the shift at at with the odometer reading odometer.
+func (s *Shift) Close(odometer uint, at time.Time) error {
+	if !s.Open() {
+		return ErrShiftClosed
+	}
+	if odometer < s.OdometerIn {
+		return ErrOdometer
+	}
+	s.EndedAt = &at
+	s.OdometerOut = &odometer
+	return nil
+}
+
+// Covers reports whether trip is made by the driver and car of the shift
+// while it lasts.
+func (s *Shift) Covers(trip *Trip) bool {
+	start, end, ok := trip.Busy()
+	if !ok || trip.DriverID == nil || trip.CarID == nil || *trip.DriverID != s.DriverID || *trip.CarID != s.CarID {
+		return false
+	}
+	shiftStart, shiftEnd := s.Busy()
+	return !start.Before(shiftStart) && !end.After(shiftEnd)
+}
+
+// Hours returns how many hours of the shift fall between from and to.
+func (s *Shift) Hours(from, to time.Time) float64 {
+	start, end := s.Busy()
+	start, end = maxTime(start, from), minTime(end, to)
+	if !end.After(start) {
+		return 0
+	}
+	return end.Sub(start).Hours()
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+func minTime(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
+
+// NeedsShift reports whether trip occupies a driver and a car, which must
+// then be on a shift together for the whole trip.
+func (t *Trip) NeedsShift() bool {
+	_, _, ok := t.Busy()
+	return ok && t.DriverID != nil && t.CarID != nil
+}
+
+type DocumentKind string
+
+const (
+	DocumentLicence DocumentKind = "licence"
+	DocumentMedical DocumentKind = "medical"
+	DocumentPermit  DocumentKind = "permit"
+)
+
+var DocumentKinds = []DocumentKind{DocumentLicence, DocumentMedical, DocumentPermit}
+
+// DriverDocument is a driving licence, medical certificate or taxi permit
+// of a driver. Categories lists the licence categories separated by commas.
+// An uploaded scan is stored on disk under FileKey.
+type DriverDocument struct {
+	DocumentID  uint         `gorm:"primaryKey;autoIncrement" json:"document_id"`
+	DriverID    uint         `json:"driver_id"`
+	Kind        DocumentKind `gorm:"size:20" json:"kind"`
+	Number      string       `gorm:"size:50" json:"number"`
+	Categories  string       `gorm:"size:50" json:"categories"`
+	IssuedAt    *time.Time   `gorm:"type:datetime(6)" json:"issued_at"`
+	ExpiresAt   time.Time    `gorm:"type:datetime(6)" json:"expires_at"`
+	FileName    string       `gorm:"size:255" json:"file_name"`
+	ContentType string       `gorm:"size:100" json:"content_type"`
+	FileSize    int64        `json:"file_size"`
+	FileKey     string       `gorm:"size:64" json:"-"`
+	Version     uint         `gorm:"not null;default:1" json:"version"`
+}
+
+func (d *DriverDocument) Expired(at time.Time) bool {
+	return !d.ExpiresAt.After(at)
+}
+
+// ExpiredDocuments returns the kinds of which every document in docs has
+// expired at at. A renewed document replaces the expired one, kinds there
+// are no documents of are not reported.
+func ExpiredDocuments(docs []DriverDocument, at time.Time) []DocumentKind {
+	valid := map[DocumentKind]bool{}
+	seen := map[DocumentKind]bool{}
+	for _, d := range docs {
+		seen[d.Kind] = true
+		valid[d.Kind] = valid[d.Kind] || !d.Expired(at)
+	}
+	var expired []DocumentKind
+	for _, kind := range DocumentKinds {
+		if seen[kind] && !valid[kind] {
+			expired = append(expired, kind)
+		}
+	}
+	return expired
+}
+
+// MaintenanceRecord is a service of a car in a workshop. The car is in the
+// workshop from StartedAt until FinishedAt is set. Odometer is the reading
+// the car came in with, in kilometres.
+type MaintenanceRecord struct {
+	RecordID    uint       `gorm:"primaryKey;autoIncrement" json:"record_id"`
+	CarID       uint       `json:"car_id"`
+	ServiceType string     `gorm:"size:50" json:"service_type"`
+	Workshop    string     `gorm:"size:100" json:"workshop"`
+	Odometer    uint       `json:"odometer"`
+	Cost        float64    `gorm:"type:decimal(10,2)" json:"cost"`
+	StartedAt   time.Time  `gorm:"type:datetime(6)" json:"started_at"`
+	FinishedAt  *time.Time `gorm:"type:datetime(6)" json:"finished_at"`
+	Version     uint       `gorm:"not null;default:1" json:"version"`
+}
+
+func (m *MaintenanceRecord) InWorkshop() bool {
+	return m.FinishedAt == nil
+}
+
+var ErrMaintenanceFinished = errors.New("maintenance is already finished")
+
+// Finish ends the service at at.
+func (m *MaintenanceRecord) Finish(cost float64, at time.Time) error {
+	if !m.InWorkshop() {
+		return ErrMaintenanceFinished
+	}
+	m.Cost = cost
+	m.FinishedAt = &at
+	return nil
+}
+
+// MaintenanceRule says how often cars of a model need a service: every
+// IntervalKm kilometres or every IntervalMonths months, whichever comes
+// first. Either interval may be missing.
+type MaintenanceRule struct {
+	RuleID         uint   `gorm:"primaryKey;autoIncrement" json:"rule_id"`
+	ModelID        uint   `json:"model_id"`
+	ServiceType    string `gorm:"size:50" json:"service_type"`
+	IntervalKm     *uint  `json:"interval_km"`
+	IntervalMonths *uint  `json:"interval_months"`
+	Version        uint   `gorm:"not null;default:1" json:"version"`
+}
+
+// Due returns the odometer reading and the time at which the service falls
+// due again after last, the latest finished service of its type. A car
+// that never had it is counted from 0 km and from since.
+func (r *MaintenanceRule) Due(last *MaintenanceRecord, since time.Time) (odometer *uint, at *time.Time) {
+	var fromKm uint
+	fromTime := since
+	if last != nil {
+		fromKm, fromTime = last.Odometer, *last.FinishedAt
+	}
+	if r.IntervalKm != nil {
+		km := fromKm + *r.IntervalKm
+		odometer = &km
+	}
+	if r.IntervalMonths != nil && !fromTime.IsZero() {
+		t := fromTime.AddDate(0, int(*r.IntervalMonths), 0)
+		at = &t
+	}
+	return odometer, at
+}
+
+// OdometerReading is the odometer of a car in kilometres read at ReadAt.
+type OdometerReading struct {
+	ReadingID uint      `gorm:"primaryKey;autoIncrement" json:"reading_id"`
+	CarID     uint      `json:"car_id"`
+	Odometer  uint      `json:"odometer"`
+	ReadAt    time.Time `gorm:"type:datetime(6)" json:"read_at"`
+	Version   uint      `gorm:"not null;default:1" json:"version"`
+}
+
+type FuelUnit string
+
+const (
+	FuelLitres FuelUnit = "l"
+	FuelKWh    FuelUnit = "kwh"
+)
+
+// FuelEntry is a refuelling or a charge of a car. Amount is in litres or
+// kWh by Unit and Price is what the whole entry cost. Cars are filled up
+// or charged fully, so Amount is what the car used since the previous
+// entry. Odometer is read when the car is filled up and counts as an
+// odometer reading.
+type FuelEntry struct {
+	EntryID  uint      `gorm:"primaryKey;autoIncrement" json:"entry_id"`
+	CarID    uint      `json:"car_id"`
+	FilledAt time.Time `gorm:"type:datetime(6)" json:"filled_at"`
+	Odometer uint      `json:"odometer"`
+	Amount   float64   `gorm:"type:decimal(10,3)" json:"amount"`
+	Unit     FuelUnit  `gorm:"size:10" json:"unit"`
+	Price    float64   `gorm:"type:decimal(10,2)" json:"price"`
+	Station  string    `gorm:"size:100" json:"station"`
+	Version  uint      `gorm:"not null;default:1" json:"version"`
+}
+
+// Reading returns the odometer reading taken with the entry.
+func (e *FuelEntry) Reading() OdometerReading {
+	return OdometerReading{CarID: e.CarID, Odometer: e.Odometer, ReadAt: e.FilledAt}
+}
+
+// CarPosition is a GPS ping of a car. SpeedKmh and Heading, in degrees
+// clockwise from north, are set when the device reports them. Only the
+// newest positions of a car are kept.
+type CarPosition struct {
+	PositionID uint      `gorm:"primaryKey;autoIncrement" json:"position_id"`
 	CarID      uint      `json:"car_id"`
-	Car        Car       `gorm:"foreignKey:CarID;references:CarID" json:"car"`
-	CustomerID uint      `json:"customer_id"`
-	Customer   Customer  `gorm:"foreignKey:CustomerID;references:CustomerID" json:"customer"`
-	StartLat   float64   `gorm:"type:decimal(9,6)" json:"start_lat"`
-	StartLon   float64   `gorm:"type:decimal(9,6)" json:"start_lon"`
-	EndLat     float64   `gorm:"type:decimal(9,6)" json:"end_lat"`
-	EndLon     float64   `gorm:"type:decimal(9,6)" json:"end_lon"`
-	StartTime  time.Time `gorm:"type:datetime(8)" json:"start_time"`
-	EndTime    time.Time `gorm:"type:datetime(8)" json:"end_time"`
-	Cost       float64   `gorm:"type:decimal(10,2)" json:"cost"`
+	Lat        float64   `gorm:"type:decimal(9,6)" json:"lat"`
+	Lon        float64   `gorm:"type:decimal(9,6)" json:"lon"`
+	SpeedKmh   *float64  `json:"speed_kmh"`
+	Heading    *float64  `json:"heading"`
+	RecordedAt time.Time `gorm:"type:datetime(6)" json:"recorded_at"`
+}
+
+// Tariff prices trips made by cars of one class. Night hours are local
+// hours of the day, the night may wrap around midnight.
+type Tariff struct {
+	TariffID          uint    `gorm:"primaryKey;autoIncrement" json:"tariff_id"`
+	Class             string  `gorm:"size:50;uniqueIndex" json:"class"`
+	BaseFare          float64 `gorm:"type:decimal(10,2)" json:"base_fare"`
+	PerKm             float64 `gorm:"type:decimal(10,2)" json:"per_km"`
+	PerMinute         float64 `gorm:"type:decimal(10,2)" json:"per_minute"`
+	MinimumFare       float64 `gorm:"type:decimal(10,2)" json:"minimum_fare"`
+	NightMultiplier   float64 `gorm:"type:decimal(4,2)" json:"night_multiplier"`
+	WeekendMultiplier float64 `gorm:"type:decimal(4,2)" json:"weekend_multiplier"`
+	NightStart        int     `json:"night_start"`
+	NightEnd          int     `json:"night_end"`
+	Version           uint    `gorm:"not null;default:1" json:"version"`
+}
+
+func (t *Tariff) night(hour int) bool {
+	if t.NightStart <= t.NightEnd {
+		return hour >= t.NightStart && hour < t.NightEnd
+	}
+	return hour >= t.NightStart || hour < t.NightEnd
+}
+
+// Fare is the price of a trip broken down into its parts. It keeps a copy
+// of everything it was computed from, so later tariff changes do not
+// affect trips that are already priced.
+type Fare struct {
+	TariffID       uint    `json:"tariff_id"`
+	Class          string  `json:"class"`
+	DistanceKm     float64 `json:"distance_km"`
+	Minutes        float64 `json:"minutes"`
+	BaseFare       float64 `json:"base_fare"`
+	DistanceFare   float64 `json:"distance_fare"`
+	TimeFare       float64 `json:"time_fare"`
+	Night          bool    `json:"night"`
+	Weekend        bool    `json:"weekend"`
+	Multiplier     float64 `json:"multiplier"`
+	MinimumApplied bool    `json:"minimum_applied"`
+	Total          float64 `json:"total"`
+}
+
+func roundMoney(v float64) float64 {
+	return math.Round(v*100) / 100
+}
+
+// Fare prices a ride of km kilometres that starts at start and lasts d.
+// Night and weekend are decided by the start time in loc, both multipliers
+// apply when a weekend ride starts at night.
+func (t *Tariff) Fare(km float64, start time.Time, d time.Duration, loc *time.Location) Fare {
+	local := start.In(loc)
+	f := Fare{
+		TariffID:     t.TariffID,
+		Class:        t.Class,
+		DistanceKm:   math.Round(km*1000) / 1000,
+		Minutes:      math.Round(d.Minutes()*100) / 100,
+		BaseFare:     t.BaseFare,
+		DistanceFare: roundMoney(km * t.PerKm),
+		TimeFare:     roundMoney(d.Minutes() * t.PerMinute),
+		Night:        t.night(local.Hour()),
+		Weekend:      local.Weekday() == time.Saturday || local.Weekday() == time.Sunday,
+		Multiplier:   1,
+	}
+	if f.Night {
+		f.Multiplier *= t.NightMultiplier
+	}
+	if f.Weekend {
+		f.Multiplier *= t.WeekendMultiplier
+	}
+
+	f.Total = roundMoney((f.BaseFare + f.DistanceFare + f.TimeFare) * f.Multiplier)
+	if f.Total < t.MinimumFare {
+		f.Total = t.MinimumFare
+		f.MinimumApplied = true
+	}
+	return f
+}
+
+const earthRadiusKm = 6371.0088
+
+// Distance returns the great-circle distance in kilometres between two
+// points given in degrees.
+func Distance(lat1, lon1, lat2, lon2 float64) float64 {
+	rad := math.Pi / 180
+	dLat := (lat2 - lat1) * rad
+	dLon := (lon2 - lon1) * rad
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusKm * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+// BoundingBox returns the latitudes and longitudes in degrees that enclose
+// all points within km of lat and lon. When the box crosses the 180th
+// meridian minLon is greater than maxLon, around the poles it spans all
+// longitudes.
+func BoundingBox(lat, lon, km float64) (minLat, maxLat, minLon, maxLon float64) {
+	deg := km / earthRadiusKm * 180 / math.Pi
+	minLat, maxLat = lat-deg, lat+deg
+	if minLat <= -90 || maxLat >= 90 {
+		return math.Max(minLat, -90), math.Min(maxLat, 90), -180, 180
+	}
+	dLon := math.Asin(math.Sin(km/earthRadiusKm)/math.Cos(lat*math.Pi/180)) * 180 / math.Pi
+	minLon, maxLon = lon-dLon, lon+dLon
+	if minLon < -180 {
+		minLon += 360
+	}
+	if maxLon > 180 {
+		maxLon -= 360
+	}
+	return minLat, maxLat, minLon, maxLon
+}
+
+// Price sets the fare and cost of a finished trip from tariff.
+func (t *Trip) Price(tariff *Tariff, loc *time.Location) {
+	if t.StartTime == nil || t.EndTime == nil {
+		return
+	}
+	t.Measure()
+	fare := tariff.Fare(*t.DistanceKm, *t.StartTime, t.EndTime.Sub(*t.StartTime), loc)
+	t.Fare = &fare
+	t.Cost = fare.Total
+}
+
+const (
+	AuditCreate  = "create"
+	AuditUpdate  = "update"
+	AuditDelete  = "delete"
+	AuditRestore = "restore"
+)
+
+// AuditEntry records one write of a record: who made it, when, in which
+// request and how its columns changed. ActorKeyID is the API key of the
+// actor, key names are not unique.
+type AuditEntry struct {
+	AuditID    uint              `gorm:"primaryKey;autoIncrement" json:"audit_id"`
+	Entity     string            `gorm:"size:50" json:"entity"`
+	EntityID   uint              `json:"entity_id"`
+	Action     string            `gorm:"size:20" json:"action"`
+	Actor      string            `gorm:"size:100" json:"actor"`
+	ActorKeyID *uint             `gorm:"index" json:"actor_key_id"`
+	RequestID  string            `gorm:"size:100" json:"request_id"`
+	Changes    map[string]Change `gorm:"type:json;serializer:json" json:"changes"`
+	CreatedAt  time.Time         `gorm:"type:datetime(6)" json:"created_at"`
+}
+
+// Change holds the JSON values of a column before and after a write. Before
+// is null for created records, After for deleted ones.
+type Change struct {
+	Before json.RawMessage `json:"before"`
+	After  json.RawMessage `json:"after"`
+}
+
+// Role decides what the holder of an API key may do.
+type Role string
+
+const (
+	RoleAdmin      Role = "admin"
+	RoleDispatcher Role = "dispatcher"
+	RoleAccountant Role = "accountant"
+	RoleDriver     Role = "driver"
+)
+
+var Roles = []Role{RoleAdmin, RoleDispatcher, RoleAccountant, RoleDriver}
+
+// APIKey authenticates a client. Only the SHA-256 hash of the secret is
+// stored, the secret itself is shown once when the key is created or
+// rotated. Prefix is the start of the secret, enough to tell keys apart.
+// Keys with the driver role belong to the driver DriverID.
+type APIKey struct {
+	KeyID     uint       `gorm:"primaryKey;autoIncrement" json:"key_id"`
+	Name      string     `gorm:"size:100" json:"name"`
+	Prefix    string     `gorm:"size:20" json:"prefix"`
+	Hash      string     `gorm:"size:64;uniqueIndex" json:"-"`
+	Role      Role       `gorm:"size:20;default:admin" json:"role"`
+	DriverID  *uint      `json:"driver_id"`
+	CreatedAt time.Time  `gorm:"type:datetime(6)" json:"created_at"`
+	RotatedAt *time.Time `gorm:"type:datetime(6)" json:"rotated_at"`
+	RevokedAt *time.Time `gorm:"type:datetime(6)" json:"revoked_at"`
+	Version   uint       `gorm:"not null;default:1" json:"version"`
+}
+
+func (k *APIKey) Revoked() bool {
+	return k.RevokedAt != nil
 }
@@ -0,0 +1,140 @@
+package patch
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func decode(t *testing.T, s string) any {
+	t.Helper()
+	var v any
+	if err := json.Unmarshal([]byte(s), &v); err != nil {
+		t.Fatalf("decode %s: %v", s, err)
+	}
+	return v
+}
+
+func TestApply(t *testing.T) {
+	const doc = `{"a":1,"list":[1,2,3],"obj":{"x":"y"},"a/b":2,"m~n":3}`
+	tests := []struct {
+		name string
+		ops  string
+		want string
+		err  error
+	}{
+		{"add member", `[{"op":"add","path":"/b","value":2}]`, `{"a":1,"b":2,"list":[1,2,3],"obj":{"x":"y"},"a/b":2,"m~n":3}`, nil},
+		{"add replaces member", `[{"op":"add","path":"/a","value":{"z":null}}]`, `{"a":{"z":null},"list":[1,2,3],"obj":{"x":"y"},"a/b":2,"m~n":3}`, nil},
+		{"add null", `[{"op":"add","path":"/obj/n","value":null}]`, `{"a":1,"list":[1,2,3],"obj":{"x":"y","n":null},"a/b":2,"m~n":3}`, nil},
+		{"add inserts into array", `[{"op":"add","path":"/list/1","value":9}]`, `{"a":1,"list":[1,9,2,3],"obj":{"x":"y"},"a/b":2,"m~n":3}`, nil},
+		{"add at array end", `[{"op":"add","path":"/list/3","value":9}]`, `{"a":1,"list":[1,2,3,9],"obj":{"x":"y"},"a/b":2,"m~n":3}`, nil},
+		{"add appends with dash", `[{"op":"add","path":"/list/-","value":9}]`, `{"a":1,"list":[1,2,3,9],"obj":{"x":"y"},"a/b":2,"m~n":3}`, nil},
+		{"add whole document", `[{"op":"add","path":"","value":[1]}]`, `[1]`, nil},
+		{"add without value", `[{"op":"add","path":"/b"}]`, "", errMalformed},
+		{"add without parent", `[{"op":"add","path":"/missing/b","value":1}]`, "", ErrFailed},
+		{"add past array end", `[{"op":"add","path":"/list/4","value":9}]`, "", ErrFailed},
+		{"add into scalar", `[{"op":"add","path":"/a/b","value":9}]`, "", ErrFailed},
+		{"remove member", `[{"op":"remove","path":"/a"}]`, `{"list":[1,2,3],"obj":{"x":"y"},"a/b":2,"m~n":3}`, nil},
+		{"remove array element", `[{"op":"remove","path":"/list/0"}]`, `{"a":1,"list":[2,3],"obj":{"x":"y"},"a/b":2,"m~n":3}`, nil},
+		{"remove missing member", `[{"op":"remove","path":"/b"}]`, "", ErrFailed},
+		{"remove with dash", `[{"op":"remove","path":"/list/-"}]`, "", ErrFailed},
+		{"remove out of range", `[{"op":"remove","path":"/list/3"}]`, "", ErrFailed},
+		{"remove leading zero index", `[{"op":"remove","path":"/list/01"}]`, "", ErrFailed},
+		{"remove negative index", `[{"op":"remove","path":"/list/-1"}]`, "", ErrFailed},
+		{"replace member", `[{"op":"replace","path":"/obj/x","value":"z"}]`, `{"a":1,"list":[1,2,3],"obj":{"x":"z"},"a/b":2,"m~n":3}`, nil},
+		{"replace array element", `[{"op":"replace","path":"/list/2","value":0}]`, `{"a":1,"list":[1,2,0],"obj":{"x":"y"},"a/b":2,"m~n":3}`, nil},
+		{"replace missing member", `[{"op":"replace","path":"/b","value":1}]`, "", ErrFailed},
+		{"move member", `[{"op":"move","from":"/obj/x","path":"/x"}]`, `{"a":1,"x":"y","list":[1,2,3],"obj":{},"a/b":2,"m~n":3}`, nil},
+		{"move array element", `[{"op":"move","from":"/list/0","path":"/list/-"}]`, `{"a":1,"list":[2,3,1],"obj":{"x":"y"},"a/b":2,"m~n":3}`, nil},
+		{"move into itself", `[{"op":"move","from":"/obj","path":"/obj/inner"}]`, "", ErrFailed},
+		{"move missing member", `[{"op":"move","from":"/b","path":"/c"}]`, "", ErrFailed},
+		{"copy member", `[{"op":"copy","from":"/obj","path":"/copy"},{"op":"replace","path":"/copy/x","value":"z"}]`, `{"a":1,"list":[1,2,3],"obj":{"x":"y"},"copy":{"x":"z"},"a/b":2,"m~n":3}`, nil},
+		{"copy into array", `[{"op":"copy","from":"/a","path":"/list/0"}]`, `{"a":1,"list":[1,1,2,3],"obj":{"x":"y"},"a/b":2,"m~n":3}`, nil},
+		{"test passes", `[{"op":"test","path":"/list","value":[1,2,3]},{"op":"test","path":"/obj","value":{"x":"y"}}]`, doc, nil},
+		{"test fails", `[{"op":"test","path":"/a","value":"1"}]`, "", ErrFailed},
+		{"test of missing member", `[{"op":"test","path":"/b","value":null}]`, "", ErrFailed},
+		{"escaped slash", `[{"op":"replace","path":"/a~1b","value":5}]`, `{"a":1,"list":[1,2,3],"obj":{"x":"y"},"a/b":5,"m~n":3}`, nil},
+		{"escaped tilde", `[{"op":"remove","path":"/m~0n"}]`, `{"a":1,"list":[1,2,3],"obj":{"x":"y"},"a/b":2}`, nil},
+		{"escapes are read in order", `[{"op":"add","path":"/~01","value":0}]`, `{"a":1,"list":[1,2,3],"obj":{"x":"y"},"a/b":2,"m~n":3,"~1":0}`, nil},
+		{"path without slash", `[{"op":"remove","path":"a"}]`, "", errMalformed},
+		{"unknown op", `[{"op":"increment","path":"/a"}]`, "", errMalformed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ops []Operation
+			if err := json.Unmarshal([]byte(tt.ops), &ops); err != nil {
+				t.Fatal(err)
+			}
+			d := decode(t, doc)
+			got, err := Apply(d, ops)
+			if !reflect.DeepEqual(d, decode(t, doc)) {
+				t.Errorf("Apply changed its input to %v", d)
+			}
+			switch {
+			case tt.err == nil && err != nil:
+				t.Fatalf("Apply: %v", err)
+			case tt.err == nil:
+				if want := decode(t, tt.want); !reflect.DeepEqual(got, want) {
+					t.Errorf("Apply: got %v, want %v", got, want)
+				}
+			case err == nil:
+				t.Errorf("Apply: got %v, want an error", got)
+			case tt.err == ErrFailed && !errors.Is(err, ErrFailed):
+				t.Errorf("Apply: got %v, want %v", err, ErrFailed)
+			case tt.err == errMalformed && errors.Is(err, ErrFailed):
+				t.Errorf("Apply: got %v, want a malformed patch error", err)
+			}
+		})
+	}
+}
+
+// errMalformed stands for the errors of malformed patches, which are not
+// ErrFailed.
+var errMalformed = errors.New("malformed patch")
+
+func TestApplyIsAtomic(t *testing.T) {
+	doc := decode(t, `{"a":1,"list":[1,2]}`)
+	var ops []Operation
+	err := json.Unmarshal([]byte(`[
+		{"op":"add","path":"/b","value":2},
+		{"op":"remove","path":"/list/0"},
+		{"op":"test","path":"/a","value":2}
+	]`), &ops)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Apply(doc, ops); !errors.Is(err, ErrFailed) {
+		t.Fatalf("Apply: got %v, want %v", err, ErrFailed)
+	}
+	if want := decode(t, `{"a":1,"list":[1,2]}`); !reflect.DeepEqual(doc, want) {
+		t.Errorf("failed patch left %v, want %v", doc, want)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	tests := []struct {
+		name, doc, patch, want string
+	}{
+		{"replace member", `{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{"add member", `{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{"null deletes", `{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{"null of missing member", `{"a":"b"}`, `{"c":null}`, `{"a":"b"}`},
+		{"null deletes nested", `{"a":{"b":1,"c":2}}`, `{"a":{"b":null}}`, `{"a":{"c":2}}`},
+		{"null inside new object is dropped", `{}`, `{"a":{"b":null,"c":1}}`, `{"a":{"c":1}}`},
+		{"arrays are replaced", `{"a":[1,2]}`, `{"a":[3]}`, `{"a":[3]}`},
+		{"object replaces scalar", `{"a":"b"}`, `{"a":{"c":1}}`, `{"a":{"c":1}}`},
+		{"scalar replaces object", `{"a":{"b":1}}`, `{"a":1}`, `{"a":1}`},
+		{"non-object patch replaces", `{"a":"b"}`, `["c"]`, `["c"]`},
+		{"empty patch", `{"a":"b"}`, `{}`, `{"a":"b"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Merge(decode(t, tt.doc), decode(t, tt.patch))
+			if want := decode(t, tt.want); !reflect.DeepEqual(got, want) {
+				t.Errorf("Merge: got %v, want %v", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"reflect"
+	"sync"
+	"taksopark/internal/models"
+	"time"
+
+	"gorm.io/gorm/schema"
+)
+
+type auditKey struct{}
+
+// AuditInfo tells who makes the writes done with a context. Writes with a
+// context without it are recorded as made by SystemActor. ActorKeyID is set
+// when the actor is an API key.
+type AuditInfo struct {
+	Actor      string
+	ActorKeyID *uint
+	RequestID  string
+}
+
+const SystemActor = "system"
+
+func WithAuditInfo(ctx context.Context, info AuditInfo) context.Context {
+	return context.WithValue(ctx, auditKey{}, info)
+}
+
+func auditInfo(ctx context.Context) AuditInfo {
+	info, ok := ctx.Value(auditKey{}).(AuditInfo)
+	if !ok || info.Actor == "" {
+		info.Actor = SystemActor
+	}
+	return info
+}
+
+var schemas sync.Map
+
+// schemaOf returns the gorm schema of a model, which names its table and
+// columns the same way for both storages.
+func schemaOf[T any]() *schema.Schema {
+	s, err := schema.Parse(new(T), &schemas, schema.NamingStrategy{})
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// primaryKey returns the primary key of v, zero when it is not set yet.
+func primaryKey[T any](v *T) uint {
+	id, _ := schemaOf[T]().PrioritizedPrimaryField.ValueOf(context.Background(), reflect.ValueOf(v).Elem())
+	n, _ := id.(uint)
+	return n
+}
+
+// columnValues encodes every column of v, associations and columns hidden
+// from JSON, like key hashes, are left out. A nil v has no columns.
+func columnValues[T any](v *T) map[string]json.RawMessage {
+	res := map[string]json.RawMessage{}
+	if v == nil {
+		return res
+	}
+	rv := reflect.ValueOf(v).Elem()
+	for _, f := range schemaOf[T]().Fields {
+		if f.DBName == "" || f.Tag.Get("json") == "-" {
+			continue
+		}
+		value, _ := f.ValueOf(context.Background(), rv)
+		data, err := json.Marshal(value)
+		if err != nil {
+			data = []byte("null")
+		}
+		res[f.DBName] = data
+	}
+	return res
+}
+
+// newAuditEntry describes a write of the row id that turned before into
+// after. before is nil for created rows and after for removed ones.
+func newAuditEntry[T any](ctx context.Context, action string, id uint, before, after *T) *models.AuditEntry {
+	old, cur := columnValues(before), columnValues(after)
+	changes := map[string]models.Change{}
+	for column := range old {
+		if _, ok := cur[column]; !ok {
+			cur[column] = json.RawMessage("null")
+		}
+	}
+	for column, value := range cur {
+		prev, ok := old[column]
+		if !ok {
+			prev = json.RawMessage("null")
+		}
+		if !bytes.Equal(prev, value) {
+			changes[column] = models.Change{Before: prev, After: value}
+		}
+	}
+
+	info := auditInfo(ctx)
+	return &models.AuditEntry{
+		Entity:     schemaOf[T]().Table,
+		EntityID:   id,
+		Action:     action,
+		Actor:      info.Actor,
+		ActorKeyID: info.ActorKeyID,
+		RequestID:  info.RequestID,
+		Changes:    changes,
+		CreatedAt:  time.Now().UTC().Truncate(time.Microsecond),
+	}
+}
@@ -0,0 +1,1027 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"taksopark/internal/DTO"
+	"taksopark/internal/models"
+	"time"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// NewGorm expects db to be opened with TranslateError enabled so that
+// constraint violations can be told apart. onTrips decides what deleting a
+// car, driver or customer does to its trips.
+func NewGorm(db *gorm.DB, onTrips DeleteMode) Repositories {
+	return Repositories{
+		Cars:             &gormRepository[models.Car]{db: db, pk: "car_id", preloads: []string{"Model"}, soft: true, tripRef: "car_id", onTrips: onTrips},
+		Models:           &gormRepository[models.CarModel]{db: db, pk: "model_id"},
+		Drivers:          &gormRepository[models.Driver]{db: db, pk: "driver_id", soft: true, tripRef: "driver_id", onTrips: onTrips},
+		Customers:        &gormRepository[models.Customer]{db: db, pk: "customer_id", soft: true, tripRef: "customer_id", onTrips: onTrips},
+		Trips:            &gormRepository[models.Trip]{db: db, pk: "trip_id", preloads: []string{"Customer", "Driver", "Car", "Car.Model"}, validate: checkTrip, soft: true},
+		Shifts:           &gormRepository[models.Shift]{db: db, pk: "shift_id", preloads: []string{"Driver", "Car", "Car.Model"}, validate: checkShift},
+		Documents:        &gormRepository[models.DriverDocument]{db: db, pk: "document_id", validate: checkDocument},
+		Maintenance:      &gormRepository[models.MaintenanceRecord]{db: db, pk: "record_id", validate: checkMaintenance},
+		MaintenanceRules: &gormRepository[models.MaintenanceRule]{db: db, pk: "rule_id"},
+		Odometer:         &gormRepository[models.OdometerReading]{db: db, pk: "reading_id", validate: checkReading},
+		Fuel:             &gormRepository[models.FuelEntry]{db: db, pk: "entry_id", validate: checkFuel},
+		Positions:        &gormPositionRepository{gormRepository[models.CarPosition]{db: db, pk: "position_id"}},
+		Tariffs:          &gormRepository[models.Tariff]{db: db, pk: "tariff_id"},
+		Query:            &gormQueryRepository{db: db, dialect: db.Dialector.Name()},
+		Audit:            &gormRepository[models.AuditEntry]{db: db, pk: "audit_id"},
+		APIKeys:          &gormAPIKeyRepository{gormRepository[models.APIKey]{db: db, pk: "key_id", validate: checkAPIKey}},
+	}
+}
+
+func translate(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return ErrNotFound
+	case errors.Is(err, gorm.ErrDuplicatedKey):
+		return ErrDuplicate
+	case errors.Is(err, gorm.ErrForeignKeyViolated):
+		return ErrForeignKey
+	default:
+		return err
+	}
+}
+
+type gormRepository[T any] struct {
+	db       *gorm.DB
+	pk       string
+	preloads []string
+	// validate runs inside the write transaction right before the row is
+	// stored.
+	validate func(tx *gorm.DB, v *T) error
+	// soft tables have a deleted_at column, their rows are only marked as
+	// deleted.
+	soft bool
+	// tripRef is the trips column that references the table. Deleting a
+	// row applies onTrips to the trips that reference it.
+	tripRef string
+	onTrips DeleteMode
+}
+
+func (r *gormRepository[T]) query(ctx context.Context) *gorm.DB {
+	return r.preload(r.db.WithContext(ctx))
+}
+
+// preload adds the associations of T. Deleted records are loaded too, a
+// trip keeps showing its driver after the driver is deleted.
+func (r *gormRepository[T]) preload(q *gorm.DB) *gorm.DB {
+	for _, p := range r.preloads {
+		q = q.Preload(p, func(db *gorm.DB) *gorm.DB { return db.Unscoped() })
+	}
+	return q
+}
+
+// version returns the address of the Version field every model has.
+func version[T any](v *T) *uint {
+	return reflect.ValueOf(v).Elem().FieldByName("Version").Addr().Interface().(*uint)
+}
+
+// deletedAt returns the DeletedAt field of a soft-deleted model.
+func deletedAt[T any](v *T) gorm.DeletedAt {
+	return reflect.ValueOf(v).Elem().FieldByName("DeletedAt").Interface().(gorm.DeletedAt)
+}
+
+func (r *gormRepository[T]) save(tx *gorm.DB, v *T, create bool) error {
+	if r.validate != nil {
+		if err := r.validate(tx, v); err != nil {
+			return err
+		}
+	}
+	if create {
+		if err := tx.Omit(clause.Associations).Create(v).Error; err != nil {
+			return translate(err)
+		}
+		return audit[T](tx, models.AuditCreate, primaryKey(v), nil)
+	}
+
+	// Without a primary key the version condition alone would match other
+	// rows.
+	id := primaryKey(v)
+	if id == 0 {
+		return ErrNotFound
+	}
+	before := new(T)
+	if err := lockForUpdate(tx).First(before, id).Error; err != nil {
+		return translate(err)
+	}
+
+	ver := version(v)
+	expected := *ver
+	*ver = expected + 1
+	res := tx.Omit(clause.Associations).Select("*").Where("version = ?", expected).Updates(v)
+	if res.Error == nil && res.RowsAffected == 0 {
+		res.Error = ErrStale
+	}
+	if res.Error == nil {
+		res.Error = audit(tx, models.AuditUpdate, id, before)
+	}
+	if res.Error != nil {
+		*ver = expected
+		return translate(res.Error)
+	}
+	return nil
+}
+
+// audit appends a write of the row id to the audit log. The state after
+// the write is read back in tx, before is nil for created rows.
+func audit[T any](tx *gorm.DB, action string, id uint, before *T) error {
+	after := new(T)
+	err := tx.Unscoped().First(after, id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		after, err = nil, nil
+	}
+	if err != nil {
+		return translate(err)
+	}
+	return translate(tx.Create(newAuditEntry(tx.Statement.Context, action, id, before, after)).Error)
+}
+
+func (r *gormRepository[T]) Create(ctx context.Context, v *T) error {
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return r.save(tx, v, true)
+	})
+	if err != nil {
+		return err
+	}
+	return translate(r.query(ctx).First(v).Error)
+}
+
+func (r *gormRepository[T]) Get(ctx context.Context, id uint) (T, error) {
+	var v T
+	err := r.query(ctx).First(&v, id).Error
+	return v, translate(err)
+}
+
+func (r *gormRepository[T]) List(ctx context.Context, p ListParams) ([]T, int64, error) {
+	q := r.db.WithContext(ctx).Model(new(T))
+	if p.Deleted {
+		q = q.Unscoped().Where("deleted_at is not null")
+	}
+	for _, f := range p.Filters {
+		if len(f.Path) > 0 {
+			q = q.Where(jsonCondition(r.db.Dialector.Name(), f))
+			continue
+		}
+		if f.Op == OpPrefix {
+			prefix, _ := f.Value.(string)
+			q = q.Where(fmt.Sprintf("substr(%s, 1, ?) = ?", f.Column), utf8.RuneCountInString(prefix), prefix)
+			continue
+		}
+		if f.Op == OpNull {
+			if f.Value == true {
+				q = q.Where(fmt.Sprintf("%s is null", f.Column))
+			} else {
+				q = q.Where(fmt.Sprintf("%s is not null", f.Column))
+			}
+			continue
+		}
+		q = q.Where(fmt.Sprintf("%s %s ?", f.Column, f.Op), f.Value)
+	}
+
+	var total int64
+	if err := q.Count(&total).Error; err != nil {
+		return nil, 0, translate(err)
+	}
+
+	desc := false
+	for i, s := range p.Sort {
+		if i == 0 && s.Column == r.pk {
+			desc = s.Desc
+		}
+		q = q.Order(clause.OrderByColumn{Column: clause.Column{Name: s.Column}, Desc: s.Desc})
+	}
+	q = q.Order(clause.OrderByColumn{Column: clause.Column{Name: r.pk}, Desc: desc})
+
+	if p.Cursor != 0 {
+		op := ">"
+		if desc {
+			op = "<"
+		}
+		q = q.Where(fmt.Sprintf("%s %s ?", r.pk, op), p.Cursor)
+	} else if p.Offset > 0 {
+		q = q.Offset(p.Offset)
+	}
+	if p.Limit > 0 {
+		q = q.Limit(p.Limit)
+	}
+
+	res := []T{}
+	err := r.preload(q).Find(&res).Error
+	return res, total, translate(err)
+}
+
+func (r *gormRepository[T]) Update(ctx context.Context, v *T) error {
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return r.save(tx, v, false)
+	})
+	if err != nil {
+		return err
+	}
+	return translate(r.query(ctx).First(v).Error)
+}
+
+// Modify loads the row for update inside a transaction, lets fn change it
+// and saves the result. An error from fn rolls the transaction back and is
+// returned as is.
+func (r *gormRepository[T]) Modify(ctx context.Context, id uint, fn func(v *T) error) (T, error) {
+	var v T
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := lockForUpdate(tx).First(&v, id).Error; err != nil {
+			return translate(err)
+		}
+		if err := fn(&v); err != nil {
+			return err
+		}
+		return r.save(tx, &v, false)
+	})
+	if err != nil {
+		return v, err
+	}
+	return v, translate(r.query(ctx).First(&v).Error)
+}
+
+// jsonCondition compares the value at the path of f with its value, the
+// names in the path must not contain quotes. SQLite reads JSON values as
+// plain SQL values, so their JSON type is compared as well to tell "1" from
+// 1 and true.
+func jsonCondition(dialect string, f Filter) clause.Expr {
+	path := "$"
+	for _, name := range f.Path {
+		path += `."` + name + `"`
+	}
+
+	if dialect != "sqlite" {
+		literal, _ := json.Marshal(f.Value)
+		return gorm.Expr(fmt.Sprintf("json_extract(%s, ?) = cast(? as json)", f.Column), path, string(literal))
+	}
+	// Older versions stored empty strings for no JSON.
+	column := fmt.Sprintf("nullif(%s, '')", f.Column)
+	switch v := f.Value.(type) {
+	case string:
+		return gorm.Expr(fmt.Sprintf("json_type(%[1]s, ?) = 'text' and json_extract(%[1]s, ?) = ?", column), path, path, v)
+	case float64:
+		return gorm.Expr(fmt.Sprintf("json_type(%[1]s, ?) in ('integer', 'real') and json_extract(%[1]s, ?) = ?", column), path, path, v)
+	case bool:
+		return gorm.Expr(fmt.Sprintf("json_type(%s, ?) = ?", column), path, strconv.FormatBool(v))
+	default:
+		return gorm.Expr(fmt.Sprintf("json_type(%s, ?) = 'null'", column), path)
+	}
+}
+
+// lockForUpdate adds SELECT ... FOR UPDATE where the dialect supports it.
+// SQLite has no row locks, its write transactions are serialized anyway.
+func lockForUpdate(tx *gorm.DB) *gorm.DB {
+	if tx.Dialector.Name() == "sqlite" {
+		return tx
+	}
+	return tx.Clauses(clause.Locking{Strength: "UPDATE"})
+}
+
+// checkTrip makes sure that the driver, car and customer of trip exist and
+// are not deleted, and so do the driver and car it is offered to. The
+// driver and car rows are locked, so that concurrent bookings of the same
+// driver or car wait for each other, and then the shift that covers the
+// trip and trips that occupy them at the same time are looked for.
+func checkTrip(tx *gorm.DB, trip *models.Trip) error {
+	if trip.OfferedDriverID != nil {
+		if err := tx.Select("driver_id").First(&models.Driver{}, *trip.OfferedDriverID).Error; err != nil {
+			return lockError(err)
+		}
+	}
+	if trip.OfferedCarID != nil {
+		if err := tx.Select("car_id").First(&models.Car{}, *trip.OfferedCarID).Error; err != nil {
+			return lockError(err)
+		}
+	}
+
+	same := tx.Where("1 = 0")
+	if trip.DriverID != nil {
+		if err := lockForUpdate(tx).Select("driver_id").First(&models.Driver{}, *trip.DriverID).Error; err != nil {
+			return lockError(err)
+		}
+		same = same.Or("driver_id = ?", *trip.DriverID)
+	}
+	if trip.CarID != nil {
+		if err := lockForUpdate(tx).Select("car_id").First(&models.Car{}, *trip.CarID).Error; err != nil {
+			return lockError(err)
+		}
+		same = same.Or("car_id = ?", *trip.CarID)
+	}
+	if err := lockForUpdate(tx).Select("customer_id").First(&models.Customer{}, trip.CustomerID).Error; err != nil {
+		return lockError(err)
+	}
+
+	start, _, ok := trip.Busy()
+	if !ok {
+		return nil
+	}
+	if trip.NeedsShift() {
+		var shifts []models.Shift
+		err := tx.Where("driver_id = ? and car_id = ? and started_at <= ?", *trip.DriverID, *trip.CarID, start.UTC()).
+			Where("ended_at is null or ended_at > ?", start.UTC()).
+			Find(&shifts).Error
+		if err != nil {
+			return translate(err)
+		}
+		if err := findShift(trip, shifts); err != nil {
+			return err
+		}
+	}
+
+	q := tx.Where("trip_id <> ? and status <> ?", trip.TripID, models.TripCancelled).
+		Where("end_time is null or end_time > ?", start.UTC())
+
+	var others []models.Trip
+	if err := q.Where(same).Find(&others).Error; err != nil {
+		return translate(err)
+	}
+	return findOverlap(trip, others)
+}
+
+// checkShift makes sure that the driver and car of shift exist, are not
+// deleted and are not on another shift at the same time. Their rows are
+// locked like for trips. A shift is closed only when the trips of its
+// driver in its car are finished.
+func checkShift(tx *gorm.DB, shift *models.Shift) error {
+	if err := lockForUpdate(tx).Select("driver_id").First(&models.Driver{}, shift.DriverID).Error; err != nil {
+		return lockError(err)
+	}
+	if err := lockForUpdate(tx).Select("car_id").First(&models.Car{}, shift.CarID).Error; err != nil {
+		return lockError(err)
+	}
+
+	if shift.ShiftID != 0 && !shift.Open() {
+		var active int64
+		err := tx.Model(&models.Trip{}).
+			Where("driver_id = ? and car_id = ? and status in ?", shift.DriverID, shift.CarID, activeStatuses).
+			Count(&active).Error
+		if err != nil {
+			return translate(err)
+		}
+		if active > 0 {
+			return ErrActiveTrips
+		}
+	}
+
+	start, _ := shift.Busy()
+	var others []models.Shift
+	err := tx.Where("shift_id <> ? and (driver_id = ? or car_id = ?)", shift.ShiftID, shift.DriverID, shift.CarID).
+		Where("ended_at is null or ended_at > ?", start.UTC()).
+		Find(&others).Error
+	if err != nil {
+		return translate(err)
+	}
+	return findShiftOverlap(shift, others)
+}
+
+// checkDocument makes sure that the driver of doc exists and is not
+// deleted.
+func checkDocument(tx *gorm.DB, doc *models.DriverDocument) error {
+	return lockError(lockForUpdate(tx).Select("driver_id").First(&models.Driver{}, doc.DriverID).Error)
+}
+
+// checkMaintenance makes sure that the car of rec exists and is not
+// deleted.
+func checkMaintenance(tx *gorm.DB, rec *models.MaintenanceRecord) error {
+	return lockError(lockForUpdate(tx).Select("car_id").First(&models.Car{}, rec.CarID).Error)
+}
+
+// checkReading makes sure that the car of reading exists, is not deleted
+// and that the reading fits in with its other readings.
+func checkReading(tx *gorm.DB, reading *models.OdometerReading) error {
+	return checkCarOdometer(tx, *reading, reading.ReadingID, 0)
+}
+
+// checkFuel checks the odometer of entry like checkReading.
+func checkFuel(tx *gorm.DB, entry *models.FuelEntry) error {
+	return checkCarOdometer(tx, entry.Reading(), 0, entry.EntryID)
+}
+
+// checkCarOdometer locks the car of reading and checks it against the
+// odometer readings and fuel entries of the car, except the ones with the
+// ids readingID and entryID that are being changed.
+func checkCarOdometer(tx *gorm.DB, reading models.OdometerReading, readingID, entryID uint) error {
+	if err := lockForUpdate(tx).Select("car_id").First(&models.Car{}, reading.CarID).Error; err != nil {
+		return lockError(err)
+	}
+
+	var readings []models.OdometerReading
+	err := tx.Where("car_id = ? and reading_id <> ?", reading.CarID, readingID).Order("reading_id").Find(&readings).Error
+	if err != nil {
+		return translate(err)
+	}
+	var entries []models.FuelEntry
+	err = tx.Where("car_id = ? and entry_id <> ?", reading.CarID, entryID).Order("entry_id").Find(&entries).Error
+	if err != nil {
+		return translate(err)
+	}
+	return checkOdometer(reading, odometerReadings(readings, entries))
+}
+
+// checkAPIKey makes sure that the driver a key belongs to exists and is
+// not deleted.
+func checkAPIKey(tx *gorm.DB, key *models.APIKey) error {
+	if key.DriverID == nil {
+		return nil
+	}
+	return lockError(lockForUpdate(tx).Select("driver_id").First(&models.Driver{}, *key.DriverID).Error)
+}
+
+// lockError reports a missing referenced row as a foreign key violation.
+func lockError(err error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrForeignKey
+	}
+	return translate(err)
+}
+
+// deleted marks a row as deleted at the given time, or as live again when
+// at is nil, and bumps its version.
+func deleted(at any) map[string]any {
+	return map[string]any{"deleted_at": at, "version": gorm.Expr("version + 1")}
+}
+
+// Delete locks the row, so that its version cannot change before it is
+// removed.
+func (r *gormRepository[T]) Delete(ctx context.Context, id uint, expected uint) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		before := new(T)
+		if err := lockForUpdate(tx).First(before, id).Error; err != nil {
+			return translate(err)
+		}
+		if err := checkVersion(*version(before), expected); err != nil {
+			return err
+		}
+
+		if !r.soft {
+			if err := tx.Delete(new(T), id).Error; err != nil {
+				return translate(err)
+			}
+			return audit(tx, models.AuditDelete, id, before)
+		}
+
+		now := time.Now().UTC().Truncate(time.Microsecond)
+		if r.tripRef != "" {
+			if err := archiveTrips(tx, r.tripRef, id, now, r.onTrips); err != nil {
+				return err
+			}
+		}
+		if err := tx.Model(new(T)).Where(r.pk+" = ?", id).UpdateColumns(deleted(now)).Error; err != nil {
+			return translate(err)
+		}
+		return audit(tx, models.AuditDelete, id, before)
+	})
+}
+
+// archiveTrips applies mode to the live trips whose column references id,
+// a row that is being deleted at now.
+func archiveTrips(tx *gorm.DB, column string, id uint, now time.Time, mode DeleteMode) error {
+	var trips []models.Trip
+	if err := tx.Where(column+" = ?", id).Order("trip_id").Find(&trips).Error; err != nil {
+		return translate(err)
+	}
+	if len(trips) == 0 {
+		return nil
+	}
+	if mode != DeleteArchive {
+		return ErrForeignKey
+	}
+	for _, trip := range trips {
+		if !trip.Finished() {
+			return ErrActiveTrips
+		}
+	}
+	if err := tx.Model(&models.Trip{}).Where(column+" = ?", id).UpdateColumns(deleted(now)).Error; err != nil {
+		return translate(err)
+	}
+	for i := range trips {
+		if err := audit(tx, models.AuditDelete, trips[i].TripID, &trips[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Restore brings a deleted row back together with the trips that were
+// archived along with it. Restoring a live row changes nothing.
+func (r *gormRepository[T]) Restore(ctx context.Context, id uint) (T, error) {
+	var v T
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := lockForUpdate(tx.Unscoped()).First(&v, id).Error; err != nil {
+			return translate(err)
+		}
+		if !deletedAt(&v).Valid {
+			return nil
+		}
+		if r.validate != nil {
+			if err := r.validate(tx, &v); err != nil {
+				return err
+			}
+		}
+		if r.tripRef != "" {
+			at := tx.Unscoped().Model(new(T)).Select("deleted_at").Where(r.pk+" = ?", id)
+			if err := restoreTrips(tx, r.tripRef, id, at); err != nil {
+				return err
+			}
+		}
+		if err := tx.Unscoped().Model(new(T)).Where(r.pk+" = ?", id).UpdateColumns(deleted(nil)).Error; err != nil {
+			return translate(err)
+		}
+		return audit(tx, models.AuditRestore, id, &v)
+	})
+	if err != nil {
+		return v, err
+	}
+	// Scanning NULL does not reset DeletedAt in v, so the row is read into
+	// a fresh value.
+	var restored T
+	err = r.query(ctx).First(&restored, id).Error
+	return restored, translate(err)
+}
+
+// restoreTrips brings back the trips whose column references id that were
+// archived at the same time as the row, at selects its deleted_at.
+func restoreTrips(tx *gorm.DB, column string, id uint, at *gorm.DB) error {
+	var trips []models.Trip
+	err := tx.Unscoped().Where(column+" = ? and deleted_at = (?)", id, at).Order("trip_id").Find(&trips).Error
+	if err != nil || len(trips) == 0 {
+		return translate(err)
+	}
+	ids := make([]uint, len(trips))
+	for i, trip := range trips {
+		ids[i] = trip.TripID
+	}
+	if err := tx.Unscoped().Model(&models.Trip{}).Where("trip_id in ?", ids).UpdateColumns(deleted(nil)).Error; err != nil {
+		return translate(err)
+	}
+	for i := range trips {
+		if err := audit(tx, models.AuditRestore, trips[i].TripID, &trips[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type gormAPIKeyRepository struct {
+	gormRepository[models.APIKey]
+}
+
+func (r *gormAPIKeyRepository) FindByHash(ctx context.Context, hash string) (models.APIKey, error) {
+	var key models.APIKey
+	err := r.db.WithContext(ctx).Where("hash = ?", hash).First(&key).Error
+	return key, translate(err)
+}
+
+// gormPositionRepository writes positions without the audit log.
+type gormPositionRepository struct {
+	gormRepository[models.CarPosition]
+}
+
+// Record locks the car of pos, so that concurrent positions of one car are
+// trimmed one after another.
+func (r *gormPositionRepository) Record(ctx context.Context, pos *models.CarPosition, keep int) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := lockForUpdate(tx).Select("car_id").First(&models.Car{}, pos.CarID).Error; err != nil {
+			return lockError(err)
+		}
+		if err := tx.Create(pos).Error; err != nil {
+			return translate(err)
+		}
+
+		var ids []uint
+		err := tx.Model(&models.CarPosition{}).
+			Where("car_id = ?", pos.CarID).
+			Order("recorded_at desc, position_id desc").
+			Pluck("position_id", &ids).Error
+		if err != nil || len(ids) <= keep {
+			return translate(err)
+		}
+		return translate(tx.Delete(&models.CarPosition{}, ids[keep:]).Error)
+	})
+}
+
+type gormQueryRepository struct {
+	db      *gorm.DB
+	dialect string
+}
+
+// minutesBetween returns an SQL expression for the number of whole minutes
+// between two datetime columns, truncated towards zero like MySQL's
+// timestampdiff.
+func (q *gormQueryRepository) minutesBetween(start, end string) string {
+	if q.dialect == "sqlite" {
+		return fmt.Sprintf("(cast(round((julianday(%s) - julianday(%s)) * 86400000) as integer) / 60000)", end, start)
+	}
+	return fmt.Sprintf("timestampdiff(minute, %s, %s)", start, end)
+}
+
+// day returns an SQL expression for the date of a datetime column as
+// YYYY-MM-DD text.
+func (q *gormQueryRepository) day(column string) string {
+	if q.dialect == "sqlite" {
+		return fmt.Sprintf("strftime('%%Y-%%m-%%d', %s)", column)
+	}
+	return fmt.Sprintf("date_format(%s, '%%Y-%%m-%%d')", column)
+}
+
+func (q *gormQueryRepository) CarsOfYear(ctx context.Context, year int) ([]DTO.CarWithModel, error) {
+	res := []DTO.CarWithModel{}
+	err := q.db.WithContext(ctx).Raw(`
+	select car_id, license_plate, cars.year, model_name, manufacturer
+	from
+	cars inner join car_models cm on cars.model_id = cm.model_id
+	where cars.year = ? and cars.deleted_at is null
+	`, year).Scan(&res).Error
+	return res, err
+}
+
+// DriverTripCounts counts the completed trips of every driver.
+func (q *gormQueryRepository) DriverTripCounts(ctx context.Context) ([]DTO.PersonCount, error) {
+	res := []DTO.PersonCount{}
+	err := q.db.WithContext(ctx).Model(models.Driver{}).
+		Select("first_name, last_name, count(trips.trip_id) count").
+		Joins("left join trips on trips.driver_id=drivers.driver_id and trips.status = ? and trips.deleted_at is null", models.TripCompleted).
+		Group("drivers.driver_id").
+		Order("count desc").Scan(&res).Error
+	return res, err
+}
+
+func (q *gormQueryRepository) DriverCarTripCounts(ctx context.Context) ([]DTO.DriverCount, error) {
+	res := []DTO.DriverCount{}
+	err := q.db.WithContext(ctx).Model(models.Driver{}).
+		Select("first_name, last_name, license_plate, count(t.trip_id) count").
+		Joins("left join trips t on t.driver_id = drivers.driver_id and t.deleted_at is null").
+		Joins("join cars c on c.car_id = t.car_id").
+		Group("c.car_id, first_name, last_name, license_plate").
+		Scan(&res).Error
+	return res, err
+}
+
+func (q *gormQueryRepository) CustomersWithTripsMoreThan(ctx context.Context, n int) ([]DTO.PersonCount, error) {
+	res := []DTO.PersonCount{}
+	err := q.db.WithContext(ctx).Raw(`
+	select c.first_name, c.last_name, count(t.trip_id) as count
+	from customers c
+	left join trips t on c.customer_id = t.customer_id and t.deleted_at is null
+	where c.deleted_at is null
+	group by c.customer_id
+	having count(t.trip_id) > ?
+	`, n).Scan(&res).Error
+	return res, err
+}
+
+func (q *gormQueryRepository) BestDrivers(ctx context.Context) ([]DTO.Person, error) {
+	res := []DTO.Person{}
+	db := q.db.WithContext(ctx)
+
+	subQuery := db.Model(&models.Trip{}).
+		Select("driver_id, count(trip_id) as trip_count").
+		Where("driver_id is not null").
+		Group("driver_id")
+
+	maxTripCountSubQuery := db.Table("(?) as t", subQuery).
+		Select("max(t.trip_count)")
+
+	err := db.Model(&models.Driver{}).
+		Select("drivers.first_name, drivers.last_name").
+		Joins("JOIN (?) AS t ON drivers.driver_id = t.driver_id", subQuery).
+		Where("t.trip_count = (?)", maxTripCountSubQuery).
+		Scan(&res).Error
+	return res, err
+}
+
+// TripDurationStatistic works out the duration of completed trips.
+func (q *gormQueryRepository) TripDurationStatistic(ctx context.Context) (DTO.Statistic, error) {
+	var res DTO.Statistic
+	minutes := q.minutesBetween("start_time", "end_time")
+	err := q.db.WithContext(ctx).Model(models.Trip{}).
+		Select("coalesce(min("+minutes+"), 0) as min",
+			"coalesce(avg("+minutes+"), 0) as avg",
+			"coalesce(max("+minutes+"), 0) as max").
+		Where("status = ?", models.TripCompleted).
+		Scan(&res).Error
+	return res, err
+}
+
+// The distance aggregates count completed trips only.
+
+func (q *gormQueryRepository) DriverDistances(ctx context.Context) ([]DTO.PersonDistance, error) {
+	res := []DTO.PersonDistance{}
+	err := q.db.WithContext(ctx).Model(models.Driver{}).
+		Select("first_name, last_name, round(coalesce(sum(t.distance_km), 0), 3) distance_km").
+		Joins("left join trips t on t.driver_id = drivers.driver_id and t.status = ? and t.deleted_at is null", models.TripCompleted).
+		Group("drivers.driver_id, first_name, last_name").
+		Order("distance_km desc, drivers.driver_id").
+		Scan(&res).Error
+	return res, err
+}
+
+func (q *gormQueryRepository) CarDistances(ctx context.Context) ([]DTO.CarDistance, error) {
+	res := []DTO.CarDistance{}
+	err := q.db.WithContext(ctx).Model(models.Car{}).
+		Select("cars.car_id, license_plate, round(coalesce(sum(t.distance_km), 0), 3) distance_km").
+		Joins("left join trips t on t.car_id = cars.car_id and t.status = ? and t.deleted_at is null", models.TripCompleted).
+		Group("cars.car_id, license_plate").
+		Order("distance_km desc, cars.car_id").
+		Scan(&res).Error
+	return res, err
+}
+
+func (q *gormQueryRepository) DailyDistances(ctx context.Context) ([]DTO.DayDistance, error) {
+	res := []DTO.DayDistance{}
+	day := q.day("start_time")
+	err := q.db.WithContext(ctx).Model(models.Trip{}).
+		Select(day+" day, count(*) trips, round(coalesce(sum(distance_km), 0), 3) distance_km").
+		Where("status = ? and start_time is not null", models.TripCompleted).
+		Group(day).
+		Order("day").
+		Scan(&res).Error
+	return res, err
+}
+
+// DriverEarnings sums up the completed trips of a driver by the day they
+// ended on. from and to limit the end time, both are optional.
+func (q *gormQueryRepository) DriverEarnings(ctx context.Context, driverID uint, from, to *time.Time) (DTO.Earnings, error) {
+	res := DTO.Earnings{DriverID: driverID, Days: []DTO.DayEarnings{}}
+	db := q.db.WithContext(ctx)
+	if err := db.Select("driver_id").First(&models.Driver{}, driverID).Error; err != nil {
+		return res, translate(err)
+	}
+
+	day := q.day("end_time")
+	query := db.Model(models.Trip{}).
+		Select(day+" day, count(*) trips, round(coalesce(sum(distance_km), 0), 3) distance_km, round(coalesce(sum(cost), 0), 2) earnings").
+		Where("driver_id = ? and status = ? and end_time is not null", driverID, models.TripCompleted)
+	if from != nil {
+		query = query.Where("end_time >= ?", from.UTC())
+	}
+	if to != nil {
+		query = query.Where("end_time < ?", to.UTC())
+	}
+	if err := query.Group(day).Order("day").Scan(&res.Days).Error; err != nil {
+		return res, err
+	}
+	sumEarnings(&res)
+	return res, nil
+}
+
+// DriverHours sums up the hours drivers spent on shifts between from and
+// to.
+func (q *gormQueryRepository) DriverHours(ctx context.Context, from, to time.Time) ([]DTO.DriverHours, error) {
+	db := q.db.WithContext(ctx)
+
+	var shifts []models.Shift
+	err := db.Where("started_at < ?", to.UTC()).
+		Where("ended_at is null or ended_at > ?", from.UTC()).
+		Order("shift_id").
+		Find(&shifts).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var drivers []models.Driver
+	if err := db.Unscoped().Find(&drivers).Error; err != nil {
+		return nil, err
+	}
+	names := map[uint]DTO.Person{}
+	for _, d := range drivers {
+		names[d.DriverID] = DTO.Person{Name: d.FirstName, Surname: d.LastName}
+	}
+	return driverHours(shifts, names, from, to), nil
+}
+
+// ExpiringDocuments lists the latest documents of live drivers that expire
+// before before.
+func (q *gormQueryRepository) ExpiringDocuments(ctx context.Context, before time.Time) ([]DTO.ExpiringDocument, error) {
+	db := q.db.WithContext(ctx)
+
+	newer := db.Table("driver_documents newer").Select("1").
+		Where("newer.driver_id = driver_documents.driver_id and newer.kind = driver_documents.kind").
+		Where("newer.expires_at > driver_documents.expires_at or (newer.expires_at = driver_documents.expires_at and newer.document_id > driver_documents.document_id)")
+	var docs []models.DriverDocument
+	err := db.Where("expires_at < ?", before.UTC()).
+		Where("not exists (?)", newer).
+		Where("driver_id in (?)", db.Model(&models.Driver{}).Select("driver_id")).
+		Order("document_id").
+		Find(&docs).Error
+	if err != nil {
+		return nil, err
+	}
+	if len(docs) == 0 {
+		return []DTO.ExpiringDocument{}, nil
+	}
+	driverIDs := make([]uint, 0, len(docs))
+	for _, doc := range docs {
+		driverIDs = append(driverIDs, doc.DriverID)
+	}
+
+	var drivers []models.Driver
+	if err := db.Where("driver_id in ?", driverIDs).Find(&drivers).Error; err != nil {
+		return nil, err
+	}
+	names := map[uint]DTO.Person{}
+	for _, d := range drivers {
+		names[d.DriverID] = DTO.Person{Name: d.FirstName, Surname: d.LastName}
+	}
+	return expiringDocuments(docs, names, before), nil
+}
+
+// DueMaintenance reads the live cars with their rules, maintenance records
+// and the highest odometer readings of their shifts, odometer readings and
+// fuel entries.
+func (q *gormQueryRepository) DueMaintenance(ctx context.Context, at time.Time, carIDs ...uint) ([]DTO.DueMaintenance, error) {
+	db := q.db.WithContext(ctx)
+
+	var cars []models.Car
+	carsQuery := db.Order("car_id")
+	if len(carIDs) > 0 {
+		carsQuery = carsQuery.Where("car_id in ?", carIDs)
+	}
+	if err := carsQuery.Find(&cars).Error; err != nil {
+		return nil, err
+	}
+	if len(cars) == 0 {
+		return []DTO.DueMaintenance{}, nil
+	}
+
+	ids := make([]uint, len(cars))
+	modelIDs := make([]uint, len(cars))
+	for i, car := range cars {
+		ids[i], modelIDs[i] = car.CarID, car.ModelID
+	}
+
+	var rules []models.MaintenanceRule
+	if err := db.Where("model_id in ?", modelIDs).Order("rule_id").Find(&rules).Error; err != nil {
+		return nil, err
+	}
+
+	var records []models.MaintenanceRecord
+	if err := db.Where("car_id in ?", ids).Order("record_id").Find(&records).Error; err != nil {
+		return nil, err
+	}
+
+	var readings []struct {
+		CarID    uint
+		Odometer uint
+	}
+	odometers := map[uint]uint{}
+	for _, source := range []struct {
+		model  any
+		column string
+	}{
+		{&models.Shift{}, "coalesce(odometer_out, odometer_in)"},
+		{&models.OdometerReading{}, "odometer"},
+		{&models.FuelEntry{}, "odometer"},
+	} {
+		err := db.Model(source.model).
+			Select("car_id, max("+source.column+") as odometer").
+			Where("car_id in ?", ids).
+			Group("car_id").
+			Scan(&readings).Error
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range readings {
+			odometers[r.CarID] = max(odometers[r.CarID], r.Odometer)
+		}
+	}
+	return dueMaintenance(cars, rules, records, odometers, at), nil
+}
+
+// FuelConsumption reads the fuel entries of the live cars filled between
+// from and to and the kilometres of their trips completed meanwhile. With
+// carIDs only the cars of their models are read, the model averages need
+// all of them.
+func (q *gormQueryRepository) FuelConsumption(ctx context.Context, from, to *time.Time, carIDs ...uint) ([]DTO.CarConsumption, error) {
+	db := q.db.WithContext(ctx)
+
+	live := db.Model(&models.Car{}).Select("car_id")
+	if len(carIDs) > 0 {
+		live = live.Where("model_id in (?)", db.Model(&models.Car{}).Select("model_id").Where("car_id in ?", carIDs))
+	}
+	entries := db.Where("car_id in (?)", live).Order("car_id, odometer, filled_at, entry_id")
+	trips := db.Model(&models.Trip{}).
+		Select("car_id, coalesce(sum(distance_km), 0) as distance_km").
+		Where("status = ? and car_id in (?)", models.TripCompleted, live).
+		Group("car_id")
+	if from != nil {
+		entries = entries.Where("filled_at >= ?", from.UTC())
+		trips = trips.Where("end_time >= ?", from.UTC())
+	}
+	if to != nil {
+		entries = entries.Where("filled_at < ?", to.UTC())
+		trips = trips.Where("end_time < ?", to.UTC())
+	}
+
+	var fills []models.FuelEntry
+	if err := entries.Find(&fills).Error; err != nil {
+		return nil, err
+	}
+	if len(fills) == 0 {
+		return []DTO.CarConsumption{}, nil
+	}
+	ids := make([]uint, 0, len(fills))
+	for _, e := range fills {
+		if len(ids) == 0 || ids[len(ids)-1] != e.CarID {
+			ids = append(ids, e.CarID)
+		}
+	}
+	var cars []models.Car
+	if err := db.Where("car_id in ?", ids).Order("car_id").Find(&cars).Error; err != nil {
+		return nil, err
+	}
+
+	var distances []struct {
+		CarID      uint
+		DistanceKm float64
+	}
+	if err := trips.Scan(&distances).Error; err != nil {
+		return nil, err
+	}
+	tripKm := map[uint]float64{}
+	for _, d := range distances {
+		tripKm[d.CarID] = d.DistanceKm
+	}
+	return fuelConsumption(cars, fills, tripKm, carIDs), nil
+}
+
+// NearbyCars reads the latest positions of the live cars recorded since
+// since that fall into the bounding box of the radius, and for those cars
+// the open shifts and what keeps them busy: unfinished trips, pending
+// offers and open maintenance records.
+func (q *gormQueryRepository) NearbyCars(ctx context.Context, lat, lon, radiusKm float64, since time.Time) ([]DTO.NearbyCar, error) {
+	db := q.db.WithContext(ctx)
+
+	latest := db.Model(&models.CarPosition{}).
+		Select("car_id, max(recorded_at) as recorded_at").
+		Where("recorded_at >= ?", since.UTC()).
+		Group("car_id")
+	minLat, maxLat, minLon, maxLon := models.BoundingBox(lat, lon, radiusKm)
+	inBox := db.Where("car_positions.lon >= ? and car_positions.lon <= ?", minLon, maxLon)
+	if minLon > maxLon {
+		inBox = db.Where("car_positions.lon >= ? or car_positions.lon <= ?", minLon, maxLon)
+	}
+	var positions []models.CarPosition
+	err := db.Select("car_positions.*").
+		Joins("join (?) latest on latest.car_id = car_positions.car_id and latest.recorded_at = car_positions.recorded_at", latest).
+		Where("car_positions.car_id in (?)", db.Model(&models.Car{}).Select("car_id")).
+		Where("car_positions.lat between ? and ?", minLat, maxLat).
+		Where(inBox).
+		Order("car_positions.car_id, car_positions.position_id desc").
+		Find(&positions).Error
+	if err != nil {
+		return nil, err
+	}
+	if len(positions) == 0 {
+		return []DTO.NearbyCar{}, nil
+	}
+	carIDs := make([]uint, 0, len(positions))
+	for _, pos := range positions {
+		if len(carIDs) == 0 || carIDs[len(carIDs)-1] != pos.CarID {
+			carIDs = append(carIDs, pos.CarID)
+		}
+	}
+
+	var cars []models.Car
+	if err := db.Preload("Model").Where("car_id in ?", carIDs).Order("car_id").Find(&cars).Error; err != nil {
+		return nil, err
+	}
+
+	var shifts []models.Shift
+	if err := db.Where("ended_at is null and car_id in ?", carIDs).Find(&shifts).Error; err != nil {
+		return nil, err
+	}
+	onShift := map[uint]uint{}
+	for _, shift := range shifts {
+		onShift[shift.CarID] = shift.DriverID
+	}
+
+	busy := map[uint]bool{}
+	for _, source := range []*gorm.DB{
+		db.Model(&models.Trip{}).Select("car_id").Where("status in ? and car_id in ?", activeStatuses, carIDs),
+		db.Model(&models.Trip{}).Select("offered_car_id").Where("status = ? and offered_car_id in ?", models.TripRequested, carIDs),
+		db.Model(&models.MaintenanceRecord{}).Select("car_id").Where("finished_at is null and car_id in ?", carIDs),
+	} {
+		var ids []uint
+		if err := source.Scan(&ids).Error; err != nil {
+			return nil, err
+		}
+		for _, id := range ids {
+			busy[id] = true
+		}
+	}
+	return nearbyCars(cars, positions, onShift, busy, lat, lon, radiusKm), nil
+}
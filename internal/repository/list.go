@@ -0,0 +1,186 @@
+package repository
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+	"time"
+)
+
+type FilterOp string
+
+const (
+	OpEq     FilterOp = "="
+	OpGte    FilterOp = ">="
+	OpLte    FilterOp = "<="
+	OpPrefix FilterOp = "prefix"
+	OpIn     FilterOp = "in"
+	OpNull   FilterOp = "null"
+)
+
+// Filter compares Column with Value. The value of an OpIn filter is a []any,
+// the value of an OpNull filter is true for NULL and false for NOT NULL.
+// With a Path, Column holds JSON objects and an OpEq filter compares the
+// value at the path of nested field names with Value, a string, float64,
+// bool or nil for JSON null.
+type Filter struct {
+	Column string
+	Path   []string
+	Op     FilterOp
+	Value  any
+}
+
+// jsonDocument is implemented by JSON column values the in-memory
+// implementation filters by path.
+type jsonDocument interface {
+	Lookup(path []string) (any, bool)
+}
+
+type Sort struct {
+	Column string
+	Desc   bool
+}
+
+// ListParams describes one page of a listing. Columns in Filters and Sort
+// must already be checked against a whitelist by the caller. When Cursor is
+// set the page starts right after the row with that primary key, which only
+// makes sense when rows are ordered by the primary key alone. Deleted lists
+// soft-deleted rows instead of live ones.
+type ListParams struct {
+	Filters []Filter
+	Sort    []Sort
+	Limit   int
+	Offset  int
+	Cursor  uint
+	Deleted bool
+}
+
+// columns maps column names to accessors for the in-memory implementation.
+type columns[T any] map[string]func(T) any
+
+// compareValues orders NULLs (nil) before any value, as both MySQL and
+// SQLite do for ascending sorts.
+func compareValues(a, b any) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return -1
+	case b == nil:
+		return 1
+	}
+
+	switch av := a.(type) {
+	case uint:
+		return cmp.Compare(av, b.(uint))
+	case int:
+		return cmp.Compare(av, b.(int))
+	case float64:
+		return cmp.Compare(av, b.(float64))
+	case string:
+		return strings.Compare(av, b.(string))
+	case time.Time:
+		return av.Compare(b.(time.Time))
+	case bool:
+		switch bv := b.(bool); {
+		case av == bv:
+			return 0
+		case bv:
+			return -1
+		}
+		return 1
+	}
+	return 0
+}
+
+func matches(v any, f Filter) bool {
+	if len(f.Path) > 0 {
+		doc, ok := v.(jsonDocument)
+		if !ok {
+			return false
+		}
+		found, ok := doc.Lookup(f.Path)
+		return ok && found == f.Value
+	}
+	if f.Op == OpNull {
+		return (v == nil) == f.Value
+	}
+	if v == nil {
+		return false
+	}
+	if f.Op == OpPrefix {
+		s, _ := v.(string)
+		prefix, _ := f.Value.(string)
+		return strings.HasPrefix(s, prefix)
+	}
+	if f.Op == OpIn {
+		values, _ := f.Value.([]any)
+		return slices.ContainsFunc(values, func(value any) bool { return compareValues(v, value) == 0 })
+	}
+
+	c := compareValues(v, f.Value)
+	switch f.Op {
+	case OpGte:
+		return c >= 0
+	case OpLte:
+		return c <= 0
+	default:
+		return c == 0
+	}
+}
+
+// listMemory applies params to rows, which must be ordered by primary key.
+// Tables with a deleted_at column keep only live or only deleted rows.
+func listMemory[T any](rows []T, cols columns[T], pk string, p ListParams) ([]T, int64) {
+	res := rows[:0:0]
+	deletedAt, soft := cols["deleted_at"]
+	for _, row := range rows {
+		ok := !soft || (deletedAt(row) != nil) == p.Deleted
+		for _, f := range p.Filters {
+			if !matches(cols[f.Column](row), f) {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			res = append(res, row)
+		}
+	}
+	total := int64(len(res))
+
+	order := append(slices.Clone(p.Sort), Sort{Column: pk, Desc: len(p.Sort) > 0 && p.Sort[0].Column == pk && p.Sort[0].Desc})
+	slices.SortStableFunc(res, func(a, b T) int {
+		for _, s := range order {
+			c := compareValues(cols[s.Column](a), cols[s.Column](b))
+			if s.Desc {
+				c = -c
+			}
+			if c != 0 {
+				return c
+			}
+		}
+		return 0
+	})
+
+	if p.Cursor != 0 {
+		desc := order[0].Desc
+		i := slices.IndexFunc(res, func(row T) bool {
+			id := cols[pk](row).(uint)
+			if desc {
+				return id < p.Cursor
+			}
+			return id > p.Cursor
+		})
+		if i < 0 {
+			i = len(res)
+		}
+		res = res[i:]
+	} else {
+		res = res[min(p.Offset, len(res)):]
+	}
+
+	if p.Limit > 0 && len(res) > p.Limit {
+		res = res[:p.Limit]
+	}
+	return res, total
+}
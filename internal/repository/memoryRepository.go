@@ -0,0 +1,2070 @@
+package repository
+
+import (
+	"cmp"
+	"context"
+	"math"
+	"slices"
+	"strings"
+	"sync"
+	"taksopark/internal/DTO"
+	"taksopark/internal/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// memoryStore keeps all entities behind one lock so that unique and
+// foreign key checks see a consistent state, like the SQL schema does.
+type memoryStore struct {
+	mu               sync.RWMutex
+	cars             map[uint]models.Car
+	carModels        map[uint]models.CarModel
+	drivers          map[uint]models.Driver
+	customers        map[uint]models.Customer
+	trips            map[uint]models.Trip
+	shifts           map[uint]models.Shift
+	documents        map[uint]models.DriverDocument
+	maintenance      map[uint]models.MaintenanceRecord
+	maintenanceRules map[uint]models.MaintenanceRule
+	odometer         map[uint]models.OdometerReading
+	fuel             map[uint]models.FuelEntry
+	positions        map[uint]models.CarPosition
+	tariffs          map[uint]models.Tariff
+	audit            map[uint]models.AuditEntry
+	apiKeys          map[uint]models.APIKey
+	nextID           map[string]uint
+	onTrips          DeleteMode
+}
+
+func NewMemory(onTrips DeleteMode) Repositories {
+	s := &memoryStore{
+		cars:             map[uint]models.Car{},
+		carModels:        map[uint]models.CarModel{},
+		drivers:          map[uint]models.Driver{},
+		customers:        map[uint]models.Customer{},
+		trips:            map[uint]models.Trip{},
+		shifts:           map[uint]models.Shift{},
+		documents:        map[uint]models.DriverDocument{},
+		maintenance:      map[uint]models.MaintenanceRecord{},
+		maintenanceRules: map[uint]models.MaintenanceRule{},
+		odometer:         map[uint]models.OdometerReading{},
+		fuel:             map[uint]models.FuelEntry{},
+		positions:        map[uint]models.CarPosition{},
+		tariffs:          map[uint]models.Tariff{},
+		audit:            map[uint]models.AuditEntry{},
+		apiKeys:          map[uint]models.APIKey{},
+		nextID:           map[string]uint{},
+		onTrips:          onTrips,
+	}
+	return Repositories{
+		Cars:             &memoryCarRepository{s: s},
+		Models:           &memoryModelRepository{s: s},
+		Drivers:          &memoryDriverRepository{s: s},
+		Customers:        &memoryCustomerRepository{s: s},
+		Trips:            &memoryTripRepository{s: s},
+		Shifts:           &memoryShiftRepository{s: s},
+		Documents:        &memoryDocumentRepository{s: s},
+		Maintenance:      &memoryMaintenanceRepository{s: s},
+		MaintenanceRules: &memoryMaintenanceRuleRepository{s: s},
+		Odometer:         &memoryOdometerRepository{s: s},
+		Fuel:             &memoryFuelRepository{s: s},
+		Positions:        &memoryPositionRepository{s: s},
+		Tariffs:          &memoryTariffRepository{s: s},
+		Query:            &memoryQueryRepository{s: s},
+		Audit:            &memoryAuditRepository{s: s},
+		APIKeys:          &memoryAPIKeyRepository{s: s},
+	}
+}
+
+// assignID returns id if it is free, or the next free id when id is zero.
+func assignID[T any](s *memoryStore, table string, rows map[uint]T, id uint) (uint, error) {
+	if id != 0 {
+		if _, ok := rows[id]; ok {
+			return 0, ErrDuplicate
+		}
+		s.nextID[table] = max(s.nextID[table], id)
+		return id, nil
+	}
+	s.nextID[table]++
+	return s.nextID[table], nil
+}
+
+// nextVersion returns the version an updated row gets, or ErrStale when the
+// caller did not read the stored one.
+func nextVersion(stored, expected uint) (uint, error) {
+	if stored != expected {
+		return 0, ErrStale
+	}
+	return stored + 1, nil
+}
+
+func checkVersion(stored, version uint) error {
+	if version != 0 && stored != version {
+		return ErrStale
+	}
+	return nil
+}
+
+// deletedTime returns nil for live rows, like the deleted_at column.
+func deletedTime(at gorm.DeletedAt) any {
+	if !at.Valid {
+		return nil
+	}
+	return at.Time
+}
+
+func deletedNow() gorm.DeletedAt {
+	return gorm.DeletedAt{Time: time.Now().UTC().Truncate(time.Microsecond), Valid: true}
+}
+
+// live drops soft-deleted rows.
+func live[T any](rows []T, cols columns[T]) []T {
+	return slices.DeleteFunc(rows, func(row T) bool { return cols["deleted_at"](row) != nil })
+}
+
+// put stores row under id and records the write in the audit log.
+func put[T any](s *memoryStore, ctx context.Context, rows map[uint]T, action string, id uint, row T) {
+	var before *T
+	if old, ok := rows[id]; ok {
+		before = &old
+	}
+	rows[id] = row
+	s.record(newAuditEntry(ctx, action, id, before, &row))
+}
+
+// remove deletes the row id for good and records it in the audit log.
+func remove[T any](s *memoryStore, ctx context.Context, rows map[uint]T, id uint) {
+	before := rows[id]
+	delete(rows, id)
+	s.record(newAuditEntry[T](ctx, models.AuditDelete, id, &before, nil))
+}
+
+func (s *memoryStore) record(entry *models.AuditEntry) {
+	s.nextID["audit_entries"]++
+	entry.AuditID = s.nextID["audit_entries"]
+	s.audit[entry.AuditID] = *entry
+}
+
+// archiveTrips applies the delete mode to the live trips matched by ref,
+// whose referenced row is being deleted at at.
+func (s *memoryStore) archiveTrips(ctx context.Context, ref func(trip models.Trip) bool, at gorm.DeletedAt) error {
+	var archived []models.Trip
+	for _, trip := range sortedValues(s.trips) {
+		if trip.DeletedAt.Valid || !ref(trip) {
+			continue
+		}
+		if s.onTrips != DeleteArchive {
+			return ErrForeignKey
+		}
+		if !trip.Finished() {
+			return ErrActiveTrips
+		}
+		archived = append(archived, trip)
+	}
+	for _, trip := range archived {
+		trip.DeletedAt = at
+		trip.Version++
+		put(s, ctx, s.trips, models.AuditDelete, trip.TripID, trip)
+	}
+	return nil
+}
+
+// restoreTrips brings back the trips matched by ref that were archived at
+// the same time as the row they reference.
+func (s *memoryStore) restoreTrips(ctx context.Context, ref func(trip models.Trip) bool, at gorm.DeletedAt) {
+	for _, trip := range sortedValues(s.trips) {
+		if trip.DeletedAt.Valid && trip.DeletedAt.Time.Equal(at.Time) && ref(trip) {
+			trip.DeletedAt = gorm.DeletedAt{}
+			trip.Version++
+			put(s, ctx, s.trips, models.AuditRestore, trip.TripID, trip)
+		}
+	}
+}
+
+func sortedValues[T any](rows map[uint]T) []T {
+	ids := make([]uint, 0, len(rows))
+	for id := range rows {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+
+	res := make([]T, 0, len(ids))
+	for _, id := range ids {
+		res = append(res, rows[id])
+	}
+	return res
+}
+
+func (s *memoryStore) car(id uint) models.Car {
+	car := s.cars[id]
+	car.Model = s.carModels[car.ModelID]
+	return car
+}
+
+func (s *memoryStore) trip(id uint) models.Trip {
+	trip := s.trips[id]
+	trip.Driver, trip.Car = nil, nil
+	if trip.DriverID != nil {
+		driver := s.drivers[*trip.DriverID]
+		trip.Driver = &driver
+	}
+	if trip.CarID != nil {
+		car := s.car(*trip.CarID)
+		trip.Car = &car
+	}
+	trip.Customer = s.customers[trip.CustomerID]
+	return trip
+}
+
+func isRef(ref *uint, id uint) bool {
+	return ref != nil && *ref == id
+}
+
+// nullable returns nil for NULL columns so that filters and sorting treat
+// them the way SQL does.
+func nullable[T any](v *T) any {
+	if v == nil {
+		return nil
+	}
+	return *v
+}
+
+type memoryCarRepository struct {
+	s *memoryStore
+}
+
+func (r *memoryCarRepository) check(car *models.Car) error {
+	if _, ok := r.s.carModels[car.ModelID]; !ok {
+		return ErrForeignKey
+	}
+	for _, other := range r.s.cars {
+		if other.CarID != car.CarID && other.LicensePlate == car.LicensePlate {
+			return ErrDuplicate
+		}
+	}
+	return nil
+}
+
+func (r *memoryCarRepository) Create(ctx context.Context, car *models.Car) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	if err := r.check(car); err != nil {
+		return err
+	}
+	id, err := assignID(r.s, "cars", r.s.cars, car.CarID)
+	if err != nil {
+		return err
+	}
+	car.CarID = id
+	car.Version = 1
+	car.Model = models.CarModel{}
+	put(r.s, ctx, r.s.cars, models.AuditCreate, id, *car)
+	*car = r.s.car(id)
+	return nil
+}
+
+func (r *memoryCarRepository) Get(ctx context.Context, id uint) (models.Car, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	if car, ok := r.s.cars[id]; !ok || car.DeletedAt.Valid {
+		return models.Car{}, ErrNotFound
+	}
+	return r.s.car(id), nil
+}
+
+var carColumns = columns[models.Car]{
+	"car_id":        func(c models.Car) any { return c.CarID },
+	"license_plate": func(c models.Car) any { return c.LicensePlate },
+	"model_id":      func(c models.Car) any { return c.ModelID },
+	"year":          func(c models.Car) any { return c.Year },
+	"notes":         func(c models.Car) any { return c.Notes },
+	"deleted_at":    func(c models.Car) any { return deletedTime(c.DeletedAt) },
+}
+
+func (r *memoryCarRepository) List(ctx context.Context, p ListParams) ([]models.Car, int64, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	res, total := listMemory(sortedValues(r.s.cars), carColumns, "car_id", p)
+	for i := range res {
+		res[i] = r.s.car(res[i].CarID)
+	}
+	return res, total, nil
+}
+
+func (r *memoryCarRepository) Update(ctx context.Context, car *models.Car) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	stored, ok := r.s.cars[car.CarID]
+	if !ok || stored.DeletedAt.Valid {
+		return ErrNotFound
+	}
+	next, err := nextVersion(stored.Version, car.Version)
+	if err != nil {
+		return err
+	}
+	if err := r.check(car); err != nil {
+		return err
+	}
+	car.Model = models.CarModel{}
+	car.Version = next
+	put(r.s, ctx, r.s.cars, models.AuditUpdate, car.CarID, *car)
+	*car = r.s.car(car.CarID)
+	return nil
+}
+
+func (r *memoryCarRepository) Delete(ctx context.Context, id uint, version uint) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	stored, ok := r.s.cars[id]
+	if !ok || stored.DeletedAt.Valid {
+		return ErrNotFound
+	}
+	if err := checkVersion(stored.Version, version); err != nil {
+		return err
+	}
+	at := deletedNow()
+	err := r.s.archiveTrips(ctx, func(trip models.Trip) bool { return isRef(trip.CarID, id) }, at)
+	if err != nil {
+		return err
+	}
+	stored.DeletedAt = at
+	stored.Version++
+	put(r.s, ctx, r.s.cars, models.AuditDelete, id, stored)
+	return nil
+}
+
+func (r *memoryCarRepository) Restore(ctx context.Context, id uint) (models.Car, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	stored, ok := r.s.cars[id]
+	if !ok {
+		return models.Car{}, ErrNotFound
+	}
+	if stored.DeletedAt.Valid {
+		r.s.restoreTrips(ctx, func(trip models.Trip) bool { return isRef(trip.CarID, id) }, stored.DeletedAt)
+		stored.DeletedAt = gorm.DeletedAt{}
+		stored.Version++
+		put(r.s, ctx, r.s.cars, models.AuditRestore, id, stored)
+	}
+	return r.s.car(id), nil
+}
+
+type memoryModelRepository struct {
+	s *memoryStore
+}
+
+func (r *memoryModelRepository) Create(ctx context.Context, model *models.CarModel) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	id, err := assignID(r.s, "car_models", r.s.carModels, model.ModelID)
+	if err != nil {
+		return err
+	}
+	model.ModelID = id
+	model.Version = 1
+	put(r.s, ctx, r.s.carModels, models.AuditCreate, id, *model)
+	return nil
+}
+
+func (r *memoryModelRepository) Get(ctx context.Context, id uint) (models.CarModel, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	model, ok := r.s.carModels[id]
+	if !ok {
+		return models.CarModel{}, ErrNotFound
+	}
+	return model, nil
+}
+
+var modelColumns = columns[models.CarModel]{
+	"model_id":              func(m models.CarModel) any { return m.ModelID },
+	"model_name":            func(m models.CarModel) any { return m.ModelName },
+	"manufacturer":          func(m models.CarModel) any { return m.Manufacturer },
+	"class":                 func(m models.CarModel) any { return m.Class },
+	"seats":                 func(m models.CarModel) any { return m.Seats },
+	"luggage_l":             func(m models.CarModel) any { return m.LuggageL },
+	"fuel_type":             func(m models.CarModel) any { return string(m.FuelType) },
+	"wheelchair_accessible": func(m models.CarModel) any { return m.WheelchairAccessible },
+	"low_floor":             func(m models.CarModel) any { return m.LowFloor },
+}
+
+func (r *memoryModelRepository) List(ctx context.Context, p ListParams) ([]models.CarModel, int64, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	res, total := listMemory(sortedValues(r.s.carModels), modelColumns, "model_id", p)
+	return res, total, nil
+}
+
+func (r *memoryModelRepository) Update(ctx context.Context, model *models.CarModel) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	stored, ok := r.s.carModels[model.ModelID]
+	if !ok {
+		return ErrNotFound
+	}
+	next, err := nextVersion(stored.Version, model.Version)
+	if err != nil {
+		return err
+	}
+	model.Version = next
+	put(r.s, ctx, r.s.carModels, models.AuditUpdate, model.ModelID, *model)
+	return nil
+}
+
+func (r *memoryModelRepository) Delete(ctx context.Context, id uint, version uint) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	stored, ok := r.s.carModels[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if err := checkVersion(stored.Version, version); err != nil {
+		return err
+	}
+	for _, car := range r.s.cars {
+		if car.ModelID == id {
+			return ErrForeignKey
+		}
+	}
+	for _, rule := range r.s.maintenanceRules {
+		if rule.ModelID == id {
+			return ErrForeignKey
+		}
+	}
+	remove(r.s, ctx, r.s.carModels, id)
+	return nil
+}
+
+type memoryDriverRepository struct {
+	s *memoryStore
+}
+
+func (r *memoryDriverRepository) check(driver *models.Driver) error {
+	for _, other := range r.s.drivers {
+		if other.DriverID != driver.DriverID && other.LisenceNumber == driver.LisenceNumber {
+			return ErrDuplicate
+		}
+	}
+	return nil
+}
+
+func (r *memoryDriverRepository) Create(ctx context.Context, driver *models.Driver) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	if err := r.check(driver); err != nil {
+		return err
+	}
+	id, err := assignID(r.s, "drivers", r.s.drivers, driver.DriverID)
+	if err != nil {
+		return err
+	}
+	driver.DriverID = id
+	driver.Version = 1
+	put(r.s, ctx, r.s.drivers, models.AuditCreate, id, *driver)
+	return nil
+}
+
+func (r *memoryDriverRepository) Get(ctx context.Context, id uint) (models.Driver, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	driver, ok := r.s.drivers[id]
+	if !ok || driver.DeletedAt.Valid {
+		return models.Driver{}, ErrNotFound
+	}
+	return driver, nil
+}
+
+var driverColumns = columns[models.Driver]{
+	"driver_id":      func(d models.Driver) any { return d.DriverID },
+	"first_name":     func(d models.Driver) any { return d.FirstName },
+	"last_name":      func(d models.Driver) any { return d.LastName },
+	"lisence_number": func(d models.Driver) any { return d.LisenceNumber },
+	"online":         func(d models.Driver) any { return d.Online },
+	"deleted_at":     func(d models.Driver) any { return deletedTime(d.DeletedAt) },
+}
+
+func (r *memoryDriverRepository) List(ctx context.Context, p ListParams) ([]models.Driver, int64, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	res, total := listMemory(sortedValues(r.s.drivers), driverColumns, "driver_id", p)
+	return res, total, nil
+}
+
+func (r *memoryDriverRepository) Update(ctx context.Context, driver *models.Driver) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	stored, ok := r.s.drivers[driver.DriverID]
+	if !ok || stored.DeletedAt.Valid {
+		return ErrNotFound
+	}
+	next, err := nextVersion(stored.Version, driver.Version)
+	if err != nil {
+		return err
+	}
+	if err := r.check(driver); err != nil {
+		return err
+	}
+	driver.Version = next
+	put(r.s, ctx, r.s.drivers, models.AuditUpdate, driver.DriverID, *driver)
+	return nil
+}
+
+func (r *memoryDriverRepository) Modify(ctx context.Context, id uint, fn func(driver *models.Driver) error) (models.Driver, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	driver, ok := r.s.drivers[id]
+	if !ok || driver.DeletedAt.Valid {
+		return models.Driver{}, ErrNotFound
+	}
+	if err := fn(&driver); err != nil {
+		return models.Driver{}, err
+	}
+	driver.DriverID = id
+	if err := r.check(&driver); err != nil {
+		return models.Driver{}, err
+	}
+	driver.Version = r.s.drivers[id].Version + 1
+	put(r.s, ctx, r.s.drivers, models.AuditUpdate, id, driver)
+	return driver, nil
+}
+
+func (r *memoryDriverRepository) Delete(ctx context.Context, id uint, version uint) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	stored, ok := r.s.drivers[id]
+	if !ok || stored.DeletedAt.Valid {
+		return ErrNotFound
+	}
+	if err := checkVersion(stored.Version, version); err != nil {
+		return err
+	}
+	at := deletedNow()
+	err := r.s.archiveTrips(ctx, func(trip models.Trip) bool { return isRef(trip.DriverID, id) }, at)
+	if err != nil {
+		return err
+	}
+	stored.DeletedAt = at
+	stored.Version++
+	put(r.s, ctx, r.s.drivers, models.AuditDelete, id, stored)
+	return nil
+}
+
+func (r *memoryDriverRepository) Restore(ctx context.Context, id uint) (models.Driver, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	stored, ok := r.s.drivers[id]
+	if !ok {
+		return models.Driver{}, ErrNotFound
+	}
+	if stored.DeletedAt.Valid {
+		r.s.restoreTrips(ctx, func(trip models.Trip) bool { return isRef(trip.DriverID, id) }, stored.DeletedAt)
+		stored.DeletedAt = gorm.DeletedAt{}
+		stored.Version++
+		put(r.s, ctx, r.s.drivers, models.AuditRestore, id, stored)
+	}
+	return stored, nil
+}
+
+type memoryCustomerRepository struct {
+	s *memoryStore
+}
+
+func (r *memoryCustomerRepository) Create(ctx context.Context, customer *models.Customer) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	id, err := assignID(r.s, "customers", r.s.customers, customer.CustomerID)
+	if err != nil {
+		return err
+	}
+	customer.CustomerID = id
+	customer.Version = 1
+	put(r.s, ctx, r.s.customers, models.AuditCreate, id, *customer)
+	return nil
+}
+
+func (r *memoryCustomerRepository) Get(ctx context.Context, id uint) (models.Customer, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	customer, ok := r.s.customers[id]
+	if !ok || customer.DeletedAt.Valid {
+		return models.Customer{}, ErrNotFound
+	}
+	return customer, nil
+}
+
+var customerColumns = columns[models.Customer]{
+	"customer_id": func(c models.Customer) any { return c.CustomerID },
+	"first_name":  func(c models.Customer) any { return c.FirstName },
+	"last_name":   func(c models.Customer) any { return c.LastName },
+	"phone":       func(c models.Customer) any { return c.Phone },
+	"deleted_at":  func(c models.Customer) any { return deletedTime(c.DeletedAt) },
+}
+
+func (r *memoryCustomerRepository) List(ctx context.Context, p ListParams) ([]models.Customer, int64, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	res, total := listMemory(sortedValues(r.s.customers), customerColumns, "customer_id", p)
+	return res, total, nil
+}
+
+func (r *memoryCustomerRepository) Update(ctx context.Context, customer *models.Customer) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	stored, ok := r.s.customers[customer.CustomerID]
+	if !ok || stored.DeletedAt.Valid {
+		return ErrNotFound
+	}
+	next, err := nextVersion(stored.Version, customer.Version)
+	if err != nil {
+		return err
+	}
+	customer.Version = next
+	put(r.s, ctx, r.s.customers, models.AuditUpdate, customer.CustomerID, *customer)
+	return nil
+}
+
+func (r *memoryCustomerRepository) Delete(ctx context.Context, id uint, version uint) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	stored, ok := r.s.customers[id]
+	if !ok || stored.DeletedAt.Valid {
+		return ErrNotFound
+	}
+	if err := checkVersion(stored.Version, version); err != nil {
+		return err
+	}
+	at := deletedNow()
+	err := r.s.archiveTrips(ctx, func(trip models.Trip) bool { return trip.CustomerID == id }, at)
+	if err != nil {
+		return err
+	}
+	stored.DeletedAt = at
+	stored.Version++
+	put(r.s, ctx, r.s.customers, models.AuditDelete, id, stored)
+	return nil
+}
+
+func (r *memoryCustomerRepository) Restore(ctx context.Context, id uint) (models.Customer, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	stored, ok := r.s.customers[id]
+	if !ok {
+		return models.Customer{}, ErrNotFound
+	}
+	if stored.DeletedAt.Valid {
+		r.s.restoreTrips(ctx, func(trip models.Trip) bool { return trip.CustomerID == id }, stored.DeletedAt)
+		stored.DeletedAt = gorm.DeletedAt{}
+		stored.Version++
+		put(r.s, ctx, r.s.customers, models.AuditRestore, id, stored)
+	}
+	return stored, nil
+}
+
+type memoryTripRepository struct {
+	s *memoryStore
+}
+
+// check requires the driver, car and customer of trip, and the driver and
+// car it is offered to, to exist and not to be deleted, and a shift to
+// cover the trip.
+func (r *memoryTripRepository) check(trip *models.Trip) error {
+	if trip.OfferedDriverID != nil {
+		if driver, ok := r.s.drivers[*trip.OfferedDriverID]; !ok || driver.DeletedAt.Valid {
+			return ErrForeignKey
+		}
+	}
+	if trip.OfferedCarID != nil {
+		if car, ok := r.s.cars[*trip.OfferedCarID]; !ok || car.DeletedAt.Valid {
+			return ErrForeignKey
+		}
+	}
+	if trip.DriverID != nil {
+		if driver, ok := r.s.drivers[*trip.DriverID]; !ok || driver.DeletedAt.Valid {
+			return ErrForeignKey
+		}
+	}
+	if trip.CarID != nil {
+		if car, ok := r.s.cars[*trip.CarID]; !ok || car.DeletedAt.Valid {
+			return ErrForeignKey
+		}
+	}
+	if customer, ok := r.s.customers[trip.CustomerID]; !ok || customer.DeletedAt.Valid {
+		return ErrForeignKey
+	}
+	if err := findShift(trip, sortedValues(r.s.shifts)); err != nil {
+		return err
+	}
+	return findOverlap(trip, live(sortedValues(r.s.trips), tripColumns))
+}
+
+func (r *memoryTripRepository) Create(ctx context.Context, trip *models.Trip) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	if err := r.check(trip); err != nil {
+		return err
+	}
+	id, err := assignID(r.s, "trips", r.s.trips, trip.TripID)
+	if err != nil {
+		return err
+	}
+	trip.TripID = id
+	trip.Version = 1
+	trip.Measure()
+	trip.Driver, trip.Car, trip.Customer = nil, nil, models.Customer{}
+	put(r.s, ctx, r.s.trips, models.AuditCreate, id, *trip)
+	*trip = r.s.trip(id)
+	return nil
+}
+
+func (r *memoryTripRepository) Get(ctx context.Context, id uint) (models.Trip, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	if trip, ok := r.s.trips[id]; !ok || trip.DeletedAt.Valid {
+		return models.Trip{}, ErrNotFound
+	}
+	return r.s.trip(id), nil
+}
+
+var tripColumns = columns[models.Trip]{
+	"trip_id":           func(t models.Trip) any { return t.TripID },
+	"status":            func(t models.Trip) any { return string(t.Status) },
+	"class":             func(t models.Trip) any { return t.Class },
+	"driver_id":         func(t models.Trip) any { return nullable(t.DriverID) },
+	"car_id":            func(t models.Trip) any { return nullable(t.CarID) },
+	"offered_driver_id": func(t models.Trip) any { return nullable(t.OfferedDriverID) },
+	"customer_id":       func(t models.Trip) any { return t.CustomerID },
+	"start_time":        func(t models.Trip) any { return nullable(t.StartTime) },
+	"end_time":          func(t models.Trip) any { return nullable(t.EndTime) },
+	"cost":              func(t models.Trip) any { return t.Cost },
+	"distance_km":       func(t models.Trip) any { return nullable(t.DistanceKm) },
+	"deleted_at":        func(t models.Trip) any { return deletedTime(t.DeletedAt) },
+}
+
+func (r *memoryTripRepository) List(ctx context.Context, p ListParams) ([]models.Trip, int64, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	res, total := listMemory(sortedValues(r.s.trips), tripColumns, "trip_id", p)
+	for i := range res {
+		res[i] = r.s.trip(res[i].TripID)
+	}
+	return res, total, nil
+}
+
+func (r *memoryTripRepository) Update(ctx context.Context, trip *models.Trip) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	stored, ok := r.s.trips[trip.TripID]
+	if !ok || stored.DeletedAt.Valid {
+		return ErrNotFound
+	}
+	next, err := nextVersion(stored.Version, trip.Version)
+	if err != nil {
+		return err
+	}
+	if err := r.check(trip); err != nil {
+		return err
+	}
+	trip.Measure()
+	trip.Driver, trip.Car, trip.Customer = nil, nil, models.Customer{}
+	trip.Version = next
+	put(r.s, ctx, r.s.trips, models.AuditUpdate, trip.TripID, *trip)
+	*trip = r.s.trip(trip.TripID)
+	return nil
+}
+
+func (r *memoryTripRepository) Modify(ctx context.Context, id uint, fn func(trip *models.Trip) error) (models.Trip, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	trip, ok := r.s.trips[id]
+	if !ok || trip.DeletedAt.Valid {
+		return models.Trip{}, ErrNotFound
+	}
+	if err := fn(&trip); err != nil {
+		return models.Trip{}, err
+	}
+	trip.TripID = id
+	trip.Version = r.s.trips[id].Version + 1
+	if err := r.check(&trip); err != nil {
+		return models.Trip{}, err
+	}
+	trip.Measure()
+	trip.Driver, trip.Car, trip.Customer = nil, nil, models.Customer{}
+	put(r.s, ctx, r.s.trips, models.AuditUpdate, id, trip)
+	return r.s.trip(id), nil
+}
+
+func (r *memoryTripRepository) Delete(ctx context.Context, id uint, version uint) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	stored, ok := r.s.trips[id]
+	if !ok || stored.DeletedAt.Valid {
+		return ErrNotFound
+	}
+	if err := checkVersion(stored.Version, version); err != nil {
+		return err
+	}
+	stored.DeletedAt = deletedNow()
+	stored.Version++
+	put(r.s, ctx, r.s.trips, models.AuditDelete, id, stored)
+	return nil
+}
+
+func (r *memoryTripRepository) Restore(ctx context.Context, id uint) (models.Trip, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	stored, ok := r.s.trips[id]
+	if !ok {
+		return models.Trip{}, ErrNotFound
+	}
+	if stored.DeletedAt.Valid {
+		if err := r.check(&stored); err != nil {
+			return models.Trip{}, err
+		}
+		stored.DeletedAt = gorm.DeletedAt{}
+		stored.Version++
+		put(r.s, ctx, r.s.trips, models.AuditRestore, id, stored)
+	}
+	return r.s.trip(id), nil
+}
+
+type memoryShiftRepository struct {
+	s *memoryStore
+}
+
+// check requires the driver and car of shift to exist, not to be deleted
+// and not to be on another shift at the same time. A shift is closed only
+// when the trips of its driver in its car are finished.
+func (r *memoryShiftRepository) check(shift *models.Shift) error {
+	if driver, ok := r.s.drivers[shift.DriverID]; !ok || driver.DeletedAt.Valid {
+		return ErrForeignKey
+	}
+	if car, ok := r.s.cars[shift.CarID]; !ok || car.DeletedAt.Valid {
+		return ErrForeignKey
+	}
+	if shift.ShiftID != 0 && !shift.Open() {
+		for _, trip := range live(sortedValues(r.s.trips), tripColumns) {
+			if isRef(trip.DriverID, shift.DriverID) && isRef(trip.CarID, shift.CarID) && slices.Contains(activeStatuses, any(string(trip.Status))) {
+				return ErrActiveTrips
+			}
+		}
+	}
+	return findShiftOverlap(shift, sortedValues(r.s.shifts))
+}
+
+func (s *memoryStore) shift(id uint) models.Shift {
+	shift := s.shifts[id]
+	shift.Driver = s.drivers[shift.DriverID]
+	shift.Car = s.car(shift.CarID)
+	return shift
+}
+
+func (r *memoryShiftRepository) Create(ctx context.Context, shift *models.Shift) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	if err := r.check(shift); err != nil {
+		return err
+	}
+	id, err := assignID(r.s, "shifts", r.s.shifts, shift.ShiftID)
+	if err != nil {
+		return err
+	}
+	shift.ShiftID = id
+	shift.Version = 1
+	shift.Driver, shift.Car = models.Driver{}, models.Car{}
+	put(r.s, ctx, r.s.shifts, models.AuditCreate, id, *shift)
+	*shift = r.s.shift(id)
+	return nil
+}
+
+func (r *memoryShiftRepository) Get(ctx context.Context, id uint) (models.Shift, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	if _, ok := r.s.shifts[id]; !ok {
+		return models.Shift{}, ErrNotFound
+	}
+	return r.s.shift(id), nil
+}
+
+var shiftColumns = columns[models.Shift]{
+	"shift_id":   func(s models.Shift) any { return s.ShiftID },
+	"driver_id":  func(s models.Shift) any { return s.DriverID },
+	"car_id":     func(s models.Shift) any { return s.CarID },
+	"started_at": func(s models.Shift) any { return s.StartedAt },
+	"ended_at":   func(s models.Shift) any { return nullable(s.EndedAt) },
+}
+
+func (r *memoryShiftRepository) List(ctx context.Context, p ListParams) ([]models.Shift, int64, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	res, total := listMemory(sortedValues(r.s.shifts), shiftColumns, "shift_id", p)
+	for i := range res {
+		res[i] = r.s.shift(res[i].ShiftID)
+	}
+	return res, total, nil
+}
+
+func (r *memoryShiftRepository) Modify(ctx context.Context, id uint, fn func(shift *models.Shift) error) (models.Shift, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	shift, ok := r.s.shifts[id]
+	if !ok {
+		return models.Shift{}, ErrNotFound
+	}
+	if err := fn(&shift); err != nil {
+		return models.Shift{}, err
+	}
+	shift.ShiftID = id
+	if err := r.check(&shift); err != nil {
+		return models.Shift{}, err
+	}
+	shift.Version = r.s.shifts[id].Version + 1
+	shift.Driver, shift.Car = models.Driver{}, models.Car{}
+	put(r.s, ctx, r.s.shifts, models.AuditUpdate, id, shift)
+	return r.s.shift(id), nil
+}
+
+type memoryDocumentRepository struct {
+	s *memoryStore
+}
+
+func (r *memoryDocumentRepository) check(doc *models.DriverDocument) error {
+	if driver, ok := r.s.drivers[doc.DriverID]; !ok || driver.DeletedAt.Valid {
+		return ErrForeignKey
+	}
+	return nil
+}
+
+func (r *memoryDocumentRepository) Create(ctx context.Context, doc *models.DriverDocument) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	if err := r.check(doc); err != nil {
+		return err
+	}
+	id, err := assignID(r.s, "driver_documents", r.s.documents, doc.DocumentID)
+	if err != nil {
+		return err
+	}
+	doc.DocumentID = id
+	doc.Version = 1
+	put(r.s, ctx, r.s.documents, models.AuditCreate, id, *doc)
+	return nil
+}
+
+func (r *memoryDocumentRepository) Get(ctx context.Context, id uint) (models.DriverDocument, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	doc, ok := r.s.documents[id]
+	if !ok {
+		return models.DriverDocument{}, ErrNotFound
+	}
+	return doc, nil
+}
+
+var documentColumns = columns[models.DriverDocument]{
+	"document_id": func(d models.DriverDocument) any { return d.DocumentID },
+	"driver_id":   func(d models.DriverDocument) any { return d.DriverID },
+	"kind":        func(d models.DriverDocument) any { return string(d.Kind) },
+	"expires_at":  func(d models.DriverDocument) any { return d.ExpiresAt },
+}
+
+func (r *memoryDocumentRepository) List(ctx context.Context, p ListParams) ([]models.DriverDocument, int64, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	res, total := listMemory(sortedValues(r.s.documents), documentColumns, "document_id", p)
+	return res, total, nil
+}
+
+func (r *memoryDocumentRepository) Modify(ctx context.Context, id uint, fn func(doc *models.DriverDocument) error) (models.DriverDocument, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	doc, ok := r.s.documents[id]
+	if !ok {
+		return models.DriverDocument{}, ErrNotFound
+	}
+	if err := fn(&doc); err != nil {
+		return models.DriverDocument{}, err
+	}
+	doc.DocumentID = id
+	if err := r.check(&doc); err != nil {
+		return models.DriverDocument{}, err
+	}
+	doc.Version = r.s.documents[id].Version + 1
+	put(r.s, ctx, r.s.documents, models.AuditUpdate, id, doc)
+	return doc, nil
+}
+
+func (r *memoryDocumentRepository) Delete(ctx context.Context, id uint, version uint) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	stored, ok := r.s.documents[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if err := checkVersion(stored.Version, version); err != nil {
+		return err
+	}
+	remove(r.s, ctx, r.s.documents, id)
+	return nil
+}
+
+type memoryMaintenanceRepository struct {
+	s *memoryStore
+}
+
+func (r *memoryMaintenanceRepository) check(rec *models.MaintenanceRecord) error {
+	if car, ok := r.s.cars[rec.CarID]; !ok || car.DeletedAt.Valid {
+		return ErrForeignKey
+	}
+	return nil
+}
+
+func (r *memoryMaintenanceRepository) Create(ctx context.Context, rec *models.MaintenanceRecord) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	if err := r.check(rec); err != nil {
+		return err
+	}
+	id, err := assignID(r.s, "maintenance_records", r.s.maintenance, rec.RecordID)
+	if err != nil {
+		return err
+	}
+	rec.RecordID = id
+	rec.Version = 1
+	put(r.s, ctx, r.s.maintenance, models.AuditCreate, id, *rec)
+	return nil
+}
+
+func (r *memoryMaintenanceRepository) Get(ctx context.Context, id uint) (models.MaintenanceRecord, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	rec, ok := r.s.maintenance[id]
+	if !ok {
+		return models.MaintenanceRecord{}, ErrNotFound
+	}
+	return rec, nil
+}
+
+var maintenanceColumns = columns[models.MaintenanceRecord]{
+	"record_id":    func(m models.MaintenanceRecord) any { return m.RecordID },
+	"car_id":       func(m models.MaintenanceRecord) any { return m.CarID },
+	"service_type": func(m models.MaintenanceRecord) any { return m.ServiceType },
+	"started_at":   func(m models.MaintenanceRecord) any { return m.StartedAt },
+	"finished_at":  func(m models.MaintenanceRecord) any { return nullable(m.FinishedAt) },
+}
+
+func (r *memoryMaintenanceRepository) List(ctx context.Context, p ListParams) ([]models.MaintenanceRecord, int64, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	res, total := listMemory(sortedValues(r.s.maintenance), maintenanceColumns, "record_id", p)
+	return res, total, nil
+}
+
+func (r *memoryMaintenanceRepository) Modify(ctx context.Context, id uint, fn func(rec *models.MaintenanceRecord) error) (models.MaintenanceRecord, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	rec, ok := r.s.maintenance[id]
+	if !ok {
+		return models.MaintenanceRecord{}, ErrNotFound
+	}
+	if err := fn(&rec); err != nil {
+		return models.MaintenanceRecord{}, err
+	}
+	rec.RecordID = id
+	if err := r.check(&rec); err != nil {
+		return models.MaintenanceRecord{}, err
+	}
+	rec.Version = r.s.maintenance[id].Version + 1
+	put(r.s, ctx, r.s.maintenance, models.AuditUpdate, id, rec)
+	return rec, nil
+}
+
+func (r *memoryMaintenanceRepository) Delete(ctx context.Context, id uint, version uint) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	stored, ok := r.s.maintenance[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if err := checkVersion(stored.Version, version); err != nil {
+		return err
+	}
+	remove(r.s, ctx, r.s.maintenance, id)
+	return nil
+}
+
+type memoryMaintenanceRuleRepository struct {
+	s *memoryStore
+}
+
+func (r *memoryMaintenanceRuleRepository) check(rule *models.MaintenanceRule) error {
+	if _, ok := r.s.carModels[rule.ModelID]; !ok {
+		return ErrForeignKey
+	}
+	for _, other := range r.s.maintenanceRules {
+		if other.RuleID != rule.RuleID && other.ModelID == rule.ModelID && other.ServiceType == rule.ServiceType {
+			return ErrDuplicate
+		}
+	}
+	return nil
+}
+
+func (r *memoryMaintenanceRuleRepository) Create(ctx context.Context, rule *models.MaintenanceRule) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	if err := r.check(rule); err != nil {
+		return err
+	}
+	id, err := assignID(r.s, "maintenance_rules", r.s.maintenanceRules, rule.RuleID)
+	if err != nil {
+		return err
+	}
+	rule.RuleID = id
+	rule.Version = 1
+	put(r.s, ctx, r.s.maintenanceRules, models.AuditCreate, id, *rule)
+	return nil
+}
+
+func (r *memoryMaintenanceRuleRepository) Get(ctx context.Context, id uint) (models.MaintenanceRule, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	rule, ok := r.s.maintenanceRules[id]
+	if !ok {
+		return models.MaintenanceRule{}, ErrNotFound
+	}
+	return rule, nil
+}
+
+var maintenanceRuleColumns = columns[models.MaintenanceRule]{
+	"rule_id":      func(m models.MaintenanceRule) any { return m.RuleID },
+	"model_id":     func(m models.MaintenanceRule) any { return m.ModelID },
+	"service_type": func(m models.MaintenanceRule) any { return m.ServiceType },
+}
+
+func (r *memoryMaintenanceRuleRepository) List(ctx context.Context, p ListParams) ([]models.MaintenanceRule, int64, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	res, total := listMemory(sortedValues(r.s.maintenanceRules), maintenanceRuleColumns, "rule_id", p)
+	return res, total, nil
+}
+
+func (r *memoryMaintenanceRuleRepository) Update(ctx context.Context, rule *models.MaintenanceRule) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	stored, ok := r.s.maintenanceRules[rule.RuleID]
+	if !ok {
+		return ErrNotFound
+	}
+	next, err := nextVersion(stored.Version, rule.Version)
+	if err != nil {
+		return err
+	}
+	if err := r.check(rule); err != nil {
+		return err
+	}
+	rule.Version = next
+	put(r.s, ctx, r.s.maintenanceRules, models.AuditUpdate, rule.RuleID, *rule)
+	return nil
+}
+
+func (r *memoryMaintenanceRuleRepository) Delete(ctx context.Context, id uint, version uint) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	stored, ok := r.s.maintenanceRules[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if err := checkVersion(stored.Version, version); err != nil {
+		return err
+	}
+	remove(r.s, ctx, r.s.maintenanceRules, id)
+	return nil
+}
+
+// checkCarOdometer checks reading against the odometer readings and fuel
+// entries of its car except the ones with the ids readingID and entryID,
+// like the gorm repository.
+func (s *memoryStore) checkCarOdometer(reading models.OdometerReading, readingID, entryID uint) error {
+	if car, ok := s.cars[reading.CarID]; !ok || car.DeletedAt.Valid {
+		return ErrForeignKey
+	}
+	readings := slices.DeleteFunc(sortedValues(s.odometer), func(o models.OdometerReading) bool {
+		return o.CarID != reading.CarID || o.ReadingID == readingID
+	})
+	entries := slices.DeleteFunc(sortedValues(s.fuel), func(e models.FuelEntry) bool {
+		return e.CarID != reading.CarID || e.EntryID == entryID
+	})
+	return checkOdometer(reading, odometerReadings(readings, entries))
+}
+
+type memoryOdometerRepository struct {
+	s *memoryStore
+}
+
+func (r *memoryOdometerRepository) Create(ctx context.Context, reading *models.OdometerReading) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	if err := r.s.checkCarOdometer(*reading, reading.ReadingID, 0); err != nil {
+		return err
+	}
+	id, err := assignID(r.s, "odometer_readings", r.s.odometer, reading.ReadingID)
+	if err != nil {
+		return err
+	}
+	reading.ReadingID = id
+	reading.Version = 1
+	put(r.s, ctx, r.s.odometer, models.AuditCreate, id, *reading)
+	return nil
+}
+
+func (r *memoryOdometerRepository) Get(ctx context.Context, id uint) (models.OdometerReading, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	reading, ok := r.s.odometer[id]
+	if !ok {
+		return models.OdometerReading{}, ErrNotFound
+	}
+	return reading, nil
+}
+
+var odometerColumns = columns[models.OdometerReading]{
+	"reading_id": func(o models.OdometerReading) any { return o.ReadingID },
+	"car_id":     func(o models.OdometerReading) any { return o.CarID },
+	"odometer":   func(o models.OdometerReading) any { return o.Odometer },
+	"read_at":    func(o models.OdometerReading) any { return o.ReadAt },
+}
+
+func (r *memoryOdometerRepository) List(ctx context.Context, p ListParams) ([]models.OdometerReading, int64, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	res, total := listMemory(sortedValues(r.s.odometer), odometerColumns, "reading_id", p)
+	return res, total, nil
+}
+
+func (r *memoryOdometerRepository) Delete(ctx context.Context, id uint, version uint) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	stored, ok := r.s.odometer[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if err := checkVersion(stored.Version, version); err != nil {
+		return err
+	}
+	remove(r.s, ctx, r.s.odometer, id)
+	return nil
+}
+
+type memoryFuelRepository struct {
+	s *memoryStore
+}
+
+func (r *memoryFuelRepository) Create(ctx context.Context, entry *models.FuelEntry) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	if err := r.s.checkCarOdometer(entry.Reading(), 0, entry.EntryID); err != nil {
+		return err
+	}
+	id, err := assignID(r.s, "fuel_entries", r.s.fuel, entry.EntryID)
+	if err != nil {
+		return err
+	}
+	entry.EntryID = id
+	entry.Version = 1
+	put(r.s, ctx, r.s.fuel, models.AuditCreate, id, *entry)
+	return nil
+}
+
+func (r *memoryFuelRepository) Get(ctx context.Context, id uint) (models.FuelEntry, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	entry, ok := r.s.fuel[id]
+	if !ok {
+		return models.FuelEntry{}, ErrNotFound
+	}
+	return entry, nil
+}
+
+var fuelColumns = columns[models.FuelEntry]{
+	"entry_id":  func(e models.FuelEntry) any { return e.EntryID },
+	"car_id":    func(e models.FuelEntry) any { return e.CarID },
+	"filled_at": func(e models.FuelEntry) any { return e.FilledAt },
+	"odometer":  func(e models.FuelEntry) any { return e.Odometer },
+	"unit":      func(e models.FuelEntry) any { return string(e.Unit) },
+}
+
+func (r *memoryFuelRepository) List(ctx context.Context, p ListParams) ([]models.FuelEntry, int64, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	res, total := listMemory(sortedValues(r.s.fuel), fuelColumns, "entry_id", p)
+	return res, total, nil
+}
+
+func (r *memoryFuelRepository) Modify(ctx context.Context, id uint, fn func(entry *models.FuelEntry) error) (models.FuelEntry, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	entry, ok := r.s.fuel[id]
+	if !ok {
+		return models.FuelEntry{}, ErrNotFound
+	}
+	if err := fn(&entry); err != nil {
+		return models.FuelEntry{}, err
+	}
+	entry.EntryID = id
+	if err := r.s.checkCarOdometer(entry.Reading(), 0, id); err != nil {
+		return models.FuelEntry{}, err
+	}
+	entry.Version = r.s.fuel[id].Version + 1
+	put(r.s, ctx, r.s.fuel, models.AuditUpdate, id, entry)
+	return entry, nil
+}
+
+func (r *memoryFuelRepository) Delete(ctx context.Context, id uint, version uint) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	stored, ok := r.s.fuel[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if err := checkVersion(stored.Version, version); err != nil {
+		return err
+	}
+	remove(r.s, ctx, r.s.fuel, id)
+	return nil
+}
+
+type memoryTariffRepository struct {
+	s *memoryStore
+}
+
+func (r *memoryTariffRepository) check(tariff *models.Tariff) error {
+	for _, other := range r.s.tariffs {
+		if other.TariffID != tariff.TariffID && other.Class == tariff.Class {
+			return ErrDuplicate
+		}
+	}
+	return nil
+}
+
+func (r *memoryTariffRepository) Create(ctx context.Context, tariff *models.Tariff) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	if err := r.check(tariff); err != nil {
+		return err
+	}
+	id, err := assignID(r.s, "tariffs", r.s.tariffs, tariff.TariffID)
+	if err != nil {
+		return err
+	}
+	tariff.TariffID = id
+	tariff.Version = 1
+	put(r.s, ctx, r.s.tariffs, models.AuditCreate, id, *tariff)
+	return nil
+}
+
+func (r *memoryTariffRepository) Get(ctx context.Context, id uint) (models.Tariff, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	tariff, ok := r.s.tariffs[id]
+	if !ok {
+		return models.Tariff{}, ErrNotFound
+	}
+	return tariff, nil
+}
+
+var tariffColumns = columns[models.Tariff]{
+	"tariff_id": func(t models.Tariff) any { return t.TariffID },
+	"class":     func(t models.Tariff) any { return t.Class },
+}
+
+func (r *memoryTariffRepository) List(ctx context.Context, p ListParams) ([]models.Tariff, int64, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	res, total := listMemory(sortedValues(r.s.tariffs), tariffColumns, "tariff_id", p)
+	return res, total, nil
+}
+
+func (r *memoryTariffRepository) Update(ctx context.Context, tariff *models.Tariff) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	stored, ok := r.s.tariffs[tariff.TariffID]
+	if !ok {
+		return ErrNotFound
+	}
+	next, err := nextVersion(stored.Version, tariff.Version)
+	if err != nil {
+		return err
+	}
+	if err := r.check(tariff); err != nil {
+		return err
+	}
+	tariff.Version = next
+	put(r.s, ctx, r.s.tariffs, models.AuditUpdate, tariff.TariffID, *tariff)
+	return nil
+}
+
+func (r *memoryTariffRepository) Delete(ctx context.Context, id uint, version uint) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	stored, ok := r.s.tariffs[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if err := checkVersion(stored.Version, version); err != nil {
+		return err
+	}
+	remove(r.s, ctx, r.s.tariffs, id)
+	return nil
+}
+
+type memoryAPIKeyRepository struct {
+	s *memoryStore
+}
+
+func (r *memoryAPIKeyRepository) check(key *models.APIKey) error {
+	if key.DriverID != nil {
+		if driver, ok := r.s.drivers[*key.DriverID]; !ok || driver.DeletedAt.Valid {
+			return ErrForeignKey
+		}
+	}
+	for _, other := range r.s.apiKeys {
+		if other.KeyID != key.KeyID && other.Hash == key.Hash {
+			return ErrDuplicate
+		}
+	}
+	return nil
+}
+
+func (r *memoryAPIKeyRepository) Create(ctx context.Context, key *models.APIKey) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	if err := r.check(key); err != nil {
+		return err
+	}
+	id, err := assignID(r.s, "api_keys", r.s.apiKeys, key.KeyID)
+	if err != nil {
+		return err
+	}
+	key.KeyID = id
+	key.Version = 1
+	put(r.s, ctx, r.s.apiKeys, models.AuditCreate, id, *key)
+	return nil
+}
+
+func (r *memoryAPIKeyRepository) Get(ctx context.Context, id uint) (models.APIKey, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	key, ok := r.s.apiKeys[id]
+	if !ok {
+		return models.APIKey{}, ErrNotFound
+	}
+	return key, nil
+}
+
+func (r *memoryAPIKeyRepository) FindByHash(ctx context.Context, hash string) (models.APIKey, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	for _, key := range r.s.apiKeys {
+		if key.Hash == hash {
+			return key, nil
+		}
+	}
+	return models.APIKey{}, ErrNotFound
+}
+
+var apiKeyColumns = columns[models.APIKey]{
+	"key_id":     func(k models.APIKey) any { return k.KeyID },
+	"name":       func(k models.APIKey) any { return k.Name },
+	"role":       func(k models.APIKey) any { return string(k.Role) },
+	"created_at": func(k models.APIKey) any { return k.CreatedAt },
+}
+
+func (r *memoryAPIKeyRepository) List(ctx context.Context, p ListParams) ([]models.APIKey, int64, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	res, total := listMemory(sortedValues(r.s.apiKeys), apiKeyColumns, "key_id", p)
+	return res, total, nil
+}
+
+func (r *memoryAPIKeyRepository) Modify(ctx context.Context, id uint, fn func(key *models.APIKey) error) (models.APIKey, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	key, ok := r.s.apiKeys[id]
+	if !ok {
+		return models.APIKey{}, ErrNotFound
+	}
+	if err := fn(&key); err != nil {
+		return models.APIKey{}, err
+	}
+	key.KeyID = id
+	key.Version = r.s.apiKeys[id].Version + 1
+	if err := r.check(&key); err != nil {
+		return models.APIKey{}, err
+	}
+	put(r.s, ctx, r.s.apiKeys, models.AuditUpdate, id, key)
+	return key, nil
+}
+
+type memoryAuditRepository struct {
+	s *memoryStore
+}
+
+var auditColumns = columns[models.AuditEntry]{
+	"audit_id":     func(e models.AuditEntry) any { return e.AuditID },
+	"entity":       func(e models.AuditEntry) any { return e.Entity },
+	"entity_id":    func(e models.AuditEntry) any { return e.EntityID },
+	"action":       func(e models.AuditEntry) any { return e.Action },
+	"actor":        func(e models.AuditEntry) any { return e.Actor },
+	"actor_key_id": func(e models.AuditEntry) any { return nullable(e.ActorKeyID) },
+	"created_at":   func(e models.AuditEntry) any { return e.CreatedAt },
+}
+
+func (r *memoryAuditRepository) List(ctx context.Context, p ListParams) ([]models.AuditEntry, int64, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	res, total := listMemory(sortedValues(r.s.audit), auditColumns, "audit_id", p)
+	return res, total, nil
+}
+
+type memoryPositionRepository struct {
+	s *memoryStore
+}
+
+func (r *memoryPositionRepository) Record(ctx context.Context, pos *models.CarPosition, keep int) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	if car, ok := r.s.cars[pos.CarID]; !ok || car.DeletedAt.Valid {
+		return ErrForeignKey
+	}
+	id, err := assignID(r.s, "car_positions", r.s.positions, pos.PositionID)
+	if err != nil {
+		return err
+	}
+	pos.PositionID = id
+	r.s.positions[id] = *pos
+
+	var kept []models.CarPosition
+	for _, p := range r.s.positions {
+		if p.CarID == pos.CarID {
+			kept = append(kept, p)
+		}
+	}
+	slices.SortFunc(kept, newestPositionFirst)
+	for _, p := range kept[min(keep, len(kept)):] {
+		delete(r.s.positions, p.PositionID)
+	}
+	return nil
+}
+
+func newestPositionFirst(a, b models.CarPosition) int {
+	return cmp.Or(b.RecordedAt.Compare(a.RecordedAt), cmp.Compare(b.PositionID, a.PositionID))
+}
+
+var positionColumns = columns[models.CarPosition]{
+	"position_id": func(p models.CarPosition) any { return p.PositionID },
+	"car_id":      func(p models.CarPosition) any { return p.CarID },
+	"recorded_at": func(p models.CarPosition) any { return p.RecordedAt },
+}
+
+func (r *memoryPositionRepository) List(ctx context.Context, p ListParams) ([]models.CarPosition, int64, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	res, total := listMemory(sortedValues(r.s.positions), positionColumns, "position_id", p)
+	return res, total, nil
+}
+
+type memoryQueryRepository struct {
+	s *memoryStore
+}
+
+func (q *memoryQueryRepository) tripsByDriver() map[uint]uint {
+	res := map[uint]uint{}
+	for _, trip := range live(sortedValues(q.s.trips), tripColumns) {
+		if trip.DriverID != nil {
+			res[*trip.DriverID]++
+		}
+	}
+	return res
+}
+
+func (q *memoryQueryRepository) CarsOfYear(ctx context.Context, year int) ([]DTO.CarWithModel, error) {
+	q.s.mu.RLock()
+	defer q.s.mu.RUnlock()
+
+	res := []DTO.CarWithModel{}
+	for _, car := range live(sortedValues(q.s.cars), carColumns) {
+		if int(car.Year) != year {
+			continue
+		}
+		model := q.s.carModels[car.ModelID]
+		res = append(res, DTO.CarWithModel{
+			CarID:        car.CarID,
+			LicensePlate: car.LicensePlate,
+			ModelName:    model.ModelName,
+			Year:         car.Year,
+			Manufacturer: model.Manufacturer,
+		})
+	}
+	return res, nil
+}
+
+// DriverTripCounts counts the completed trips of every driver.
+func (q *memoryQueryRepository) DriverTripCounts(ctx context.Context) ([]DTO.PersonCount, error) {
+	q.s.mu.RLock()
+	defer q.s.mu.RUnlock()
+
+	counts := map[uint]uint{}
+	for _, trip := range live(sortedValues(q.s.trips), tripColumns) {
+		if trip.Status == models.TripCompleted && trip.DriverID != nil {
+			counts[*trip.DriverID]++
+		}
+	}
+	res := []DTO.PersonCount{}
+	for _, driver := range live(sortedValues(q.s.drivers), driverColumns) {
+		res = append(res, DTO.PersonCount{
+			Person: DTO.Person{Name: driver.FirstName, Surname: driver.LastName},
+			Count:  counts[driver.DriverID],
+		})
+	}
+	slices.SortStableFunc(res, func(a, b DTO.PersonCount) int {
+		return cmp.Compare(b.Count, a.Count)
+	})
+	return res, nil
+}
+
+func (q *memoryQueryRepository) DriverCarTripCounts(ctx context.Context) ([]DTO.DriverCount, error) {
+	q.s.mu.RLock()
+	defer q.s.mu.RUnlock()
+
+	type key struct {
+		carID               uint
+		firstName, lastName string
+	}
+	index := map[key]int{}
+	res := []DTO.DriverCount{}
+	for _, trip := range live(sortedValues(q.s.trips), tripColumns) {
+		if trip.DriverID == nil || trip.CarID == nil {
+			continue
+		}
+		driver := q.s.drivers[*trip.DriverID]
+		if driver.DeletedAt.Valid {
+			continue
+		}
+		k := key{*trip.CarID, driver.FirstName, driver.LastName}
+		i, ok := index[k]
+		if !ok {
+			i = len(res)
+			index[k] = i
+			res = append(res, DTO.DriverCount{
+				PersonCount: DTO.PersonCount{
+					Person: DTO.Person{Name: driver.FirstName, Surname: driver.LastName},
+				},
+				LicensePlate: q.s.cars[*trip.CarID].LicensePlate,
+			})
+		}
+		res[i].Count++
+	}
+	return res, nil
+}
+
+func (q *memoryQueryRepository) CustomersWithTripsMoreThan(ctx context.Context, n int) ([]DTO.PersonCount, error) {
+	q.s.mu.RLock()
+	defer q.s.mu.RUnlock()
+
+	counts := map[uint]uint{}
+	for _, trip := range live(sortedValues(q.s.trips), tripColumns) {
+		counts[trip.CustomerID]++
+	}
+
+	res := []DTO.PersonCount{}
+	for _, customer := range live(sortedValues(q.s.customers), customerColumns) {
+		count := counts[customer.CustomerID]
+		if int(count) <= n {
+			continue
+		}
+		res = append(res, DTO.PersonCount{
+			Person: DTO.Person{Name: customer.FirstName, Surname: customer.LastName},
+			Count:  count,
+		})
+	}
+	return res, nil
+}
+
+func (q *memoryQueryRepository) BestDrivers(ctx context.Context) ([]DTO.Person, error) {
+	q.s.mu.RLock()
+	defer q.s.mu.RUnlock()
+
+	counts := q.tripsByDriver()
+	var best uint
+	for _, count := range counts {
+		best = max(best, count)
+	}
+
+	res := []DTO.Person{}
+	if best == 0 {
+		return res, nil
+	}
+	for _, driver := range live(sortedValues(q.s.drivers), driverColumns) {
+		if counts[driver.DriverID] == best {
+			res = append(res, DTO.Person{Name: driver.FirstName, Surname: driver.LastName})
+		}
+	}
+	return res, nil
+}
+
+// TripDurationStatistic works out the duration of completed trips.
+func (q *memoryQueryRepository) TripDurationStatistic(ctx context.Context) (DTO.Statistic, error) {
+	q.s.mu.RLock()
+	defer q.s.mu.RUnlock()
+
+	var res DTO.Statistic
+	var sum, n int
+	for _, trip := range live(sortedValues(q.s.trips), tripColumns) {
+		if trip.Status != models.TripCompleted || trip.StartTime == nil || trip.EndTime == nil {
+			continue
+		}
+		minutes := int(trip.EndTime.Sub(*trip.StartTime) / time.Minute)
+		if n == 0 {
+			res.Min, res.Max = minutes, minutes
+		}
+		res.Min = min(res.Min, minutes)
+		res.Max = max(res.Max, minutes)
+		sum += minutes
+		n++
+	}
+	if n > 0 {
+		res.Avg = float32(sum) / float32(n)
+	}
+	return res, nil
+}
+
+func roundKm(km float64) float64 {
+	return math.Round(km*1000) / 1000
+}
+
+// completedDistances sums the distance of completed trips by key.
+func (q *memoryQueryRepository) completedDistances(key func(trip models.Trip) (uint, bool)) map[uint]float64 {
+	res := map[uint]float64{}
+	for _, trip := range live(sortedValues(q.s.trips), tripColumns) {
+		if trip.Status != models.TripCompleted || trip.DistanceKm == nil {
+			continue
+		}
+		if k, ok := key(trip); ok {
+			res[k] += *trip.DistanceKm
+		}
+	}
+	return res
+}
+
+func (q *memoryQueryRepository) DriverDistances(ctx context.Context) ([]DTO.PersonDistance, error) {
+	q.s.mu.RLock()
+	defer q.s.mu.RUnlock()
+
+	km := q.completedDistances(func(trip models.Trip) (uint, bool) {
+		if trip.DriverID == nil {
+			return 0, false
+		}
+		return *trip.DriverID, true
+	})
+	res := []DTO.PersonDistance{}
+	for _, driver := range live(sortedValues(q.s.drivers), driverColumns) {
+		res = append(res, DTO.PersonDistance{
+			Person:     DTO.Person{Name: driver.FirstName, Surname: driver.LastName},
+			DistanceKm: roundKm(km[driver.DriverID]),
+		})
+	}
+	slices.SortStableFunc(res, func(a, b DTO.PersonDistance) int {
+		return cmp.Compare(b.DistanceKm, a.DistanceKm)
+	})
+	return res, nil
+}
+
+func (q *memoryQueryRepository) CarDistances(ctx context.Context) ([]DTO.CarDistance, error) {
+	q.s.mu.RLock()
+	defer q.s.mu.RUnlock()
+
+	km := q.completedDistances(func(trip models.Trip) (uint, bool) {
+		if trip.CarID == nil {
+			return 0, false
+		}
+		return *trip.CarID, true
+	})
+	res := []DTO.CarDistance{}
+	for _, car := range live(sortedValues(q.s.cars), carColumns) {
+		res = append(res, DTO.CarDistance{
+			CarID:        car.CarID,
+			LicensePlate: car.LicensePlate,
+			DistanceKm:   roundKm(km[car.CarID]),
+		})
+	}
+	slices.SortStableFunc(res, func(a, b DTO.CarDistance) int {
+		return cmp.Compare(b.DistanceKm, a.DistanceKm)
+	})
+	return res, nil
+}
+
+func (q *memoryQueryRepository) DailyDistances(ctx context.Context) ([]DTO.DayDistance, error) {
+	q.s.mu.RLock()
+	defer q.s.mu.RUnlock()
+
+	index := map[string]int{}
+	res := []DTO.DayDistance{}
+	for _, trip := range live(sortedValues(q.s.trips), tripColumns) {
+		if trip.Status != models.TripCompleted || trip.StartTime == nil {
+			continue
+		}
+		day := trip.StartTime.UTC().Format(time.DateOnly)
+		i, ok := index[day]
+		if !ok {
+			i = len(res)
+			index[day] = i
+			res = append(res, DTO.DayDistance{Day: day})
+		}
+		res[i].Trips++
+		if trip.DistanceKm != nil {
+			res[i].DistanceKm += *trip.DistanceKm
+		}
+	}
+	for i := range res {
+		res[i].DistanceKm = roundKm(res[i].DistanceKm)
+	}
+	slices.SortFunc(res, func(a, b DTO.DayDistance) int {
+		return strings.Compare(a.Day, b.Day)
+	})
+	return res, nil
+}
+
+func (q *memoryQueryRepository) DriverEarnings(ctx context.Context, driverID uint, from, to *time.Time) (DTO.Earnings, error) {
+	q.s.mu.RLock()
+	defer q.s.mu.RUnlock()
+
+	res := DTO.Earnings{DriverID: driverID, Days: []DTO.DayEarnings{}}
+	if driver, ok := q.s.drivers[driverID]; !ok || driver.DeletedAt.Valid {
+		return res, ErrNotFound
+	}
+
+	index := map[string]int{}
+	for _, trip := range live(sortedValues(q.s.trips), tripColumns) {
+		if !isRef(trip.DriverID, driverID) || trip.Status != models.TripCompleted || trip.EndTime == nil {
+			continue
+		}
+		if (from != nil && trip.EndTime.Before(*from)) || (to != nil && !trip.EndTime.Before(*to)) {
+			continue
+		}
+		day := trip.EndTime.UTC().Format(time.DateOnly)
+		i, ok := index[day]
+		if !ok {
+			i = len(res.Days)
+			index[day] = i
+			res.Days = append(res.Days, DTO.DayEarnings{Day: day})
+		}
+		res.Days[i].Trips++
+		res.Days[i].Earnings += trip.Cost
+		if trip.DistanceKm != nil {
+			res.Days[i].DistanceKm += *trip.DistanceKm
+		}
+	}
+	for i := range res.Days {
+		res.Days[i].DistanceKm = roundKm(res.Days[i].DistanceKm)
+		res.Days[i].Earnings = math.Round(res.Days[i].Earnings*100) / 100
+	}
+	slices.SortFunc(res.Days, func(a, b DTO.DayEarnings) int {
+		return strings.Compare(a.Day, b.Day)
+	})
+	sumEarnings(&res)
+	return res, nil
+}
+
+func (q *memoryQueryRepository) DriverHours(ctx context.Context, from, to time.Time) ([]DTO.DriverHours, error) {
+	q.s.mu.RLock()
+	defer q.s.mu.RUnlock()
+
+	names := map[uint]DTO.Person{}
+	for id, d := range q.s.drivers {
+		names[id] = DTO.Person{Name: d.FirstName, Surname: d.LastName}
+	}
+	return driverHours(sortedValues(q.s.shifts), names, from, to), nil
+}
+
+func (q *memoryQueryRepository) ExpiringDocuments(ctx context.Context, before time.Time) ([]DTO.ExpiringDocument, error) {
+	q.s.mu.RLock()
+	defer q.s.mu.RUnlock()
+
+	names := map[uint]DTO.Person{}
+	for id, d := range q.s.drivers {
+		if !d.DeletedAt.Valid {
+			names[id] = DTO.Person{Name: d.FirstName, Surname: d.LastName}
+		}
+	}
+	return expiringDocuments(sortedValues(q.s.documents), names, before), nil
+}
+
+func (q *memoryQueryRepository) DueMaintenance(ctx context.Context, at time.Time, carIDs ...uint) ([]DTO.DueMaintenance, error) {
+	q.s.mu.RLock()
+	defer q.s.mu.RUnlock()
+
+	cars := slices.DeleteFunc(sortedValues(q.s.cars), func(c models.Car) bool {
+		return c.DeletedAt.Valid || (len(carIDs) > 0 && !slices.Contains(carIDs, c.CarID))
+	})
+	odometers := map[uint]uint{}
+	for _, shift := range q.s.shifts {
+		reading := shift.OdometerIn
+		if shift.OdometerOut != nil {
+			reading = *shift.OdometerOut
+		}
+		odometers[shift.CarID] = max(odometers[shift.CarID], reading)
+	}
+	for _, reading := range odometerReadings(sortedValues(q.s.odometer), sortedValues(q.s.fuel)) {
+		odometers[reading.CarID] = max(odometers[reading.CarID], reading.Odometer)
+	}
+	records := slices.DeleteFunc(sortedValues(q.s.maintenance), func(m models.MaintenanceRecord) bool {
+		return !slices.ContainsFunc(cars, func(c models.Car) bool { return c.CarID == m.CarID })
+	})
+	return dueMaintenance(cars, sortedValues(q.s.maintenanceRules), records, odometers, at), nil
+}
+
+func (q *memoryQueryRepository) FuelConsumption(ctx context.Context, from, to *time.Time, carIDs ...uint) ([]DTO.CarConsumption, error) {
+	q.s.mu.RLock()
+	defer q.s.mu.RUnlock()
+
+	var modelIDs []uint
+	for _, id := range carIDs {
+		if car, ok := q.s.cars[id]; ok && !car.DeletedAt.Valid {
+			modelIDs = append(modelIDs, car.ModelID)
+		}
+	}
+	cars := slices.DeleteFunc(sortedValues(q.s.cars), func(c models.Car) bool {
+		return c.DeletedAt.Valid || (len(carIDs) > 0 && !slices.Contains(modelIDs, c.ModelID))
+	})
+	read := func(carID uint) bool {
+		return slices.ContainsFunc(cars, func(c models.Car) bool { return c.CarID == carID })
+	}
+
+	inPeriod := func(at time.Time) bool {
+		return (from == nil || !at.Before(*from)) && (to == nil || at.Before(*to))
+	}
+	entries := slices.DeleteFunc(sortedValues(q.s.fuel), func(e models.FuelEntry) bool { return !read(e.CarID) || !inPeriod(e.FilledAt) })
+	slices.SortStableFunc(entries, func(a, b models.FuelEntry) int {
+		return cmp.Or(cmp.Compare(a.CarID, b.CarID), cmp.Compare(a.Odometer, b.Odometer), a.FilledAt.Compare(b.FilledAt))
+	})
+
+	tripKm := map[uint]float64{}
+	for _, trip := range live(sortedValues(q.s.trips), tripColumns) {
+		if trip.CarID == nil || trip.Status != models.TripCompleted || trip.EndTime == nil || !inPeriod(*trip.EndTime) {
+			continue
+		}
+		if trip.DistanceKm != nil {
+			tripKm[*trip.CarID] += *trip.DistanceKm
+		}
+	}
+
+	return fuelConsumption(cars, entries, tripKm, carIDs), nil
+}
+
+func (q *memoryQueryRepository) NearbyCars(ctx context.Context, lat, lon, radiusKm float64, since time.Time) ([]DTO.NearbyCar, error) {
+	q.s.mu.RLock()
+	defer q.s.mu.RUnlock()
+
+	var cars []models.Car
+	for _, car := range live(sortedValues(q.s.cars), carColumns) {
+		cars = append(cars, q.s.car(car.CarID))
+	}
+	positions := slices.DeleteFunc(sortedValues(q.s.positions), func(p models.CarPosition) bool {
+		return p.RecordedAt.Before(since)
+	})
+	slices.SortStableFunc(positions, newestPositionFirst)
+
+	onShift := map[uint]uint{}
+	for _, shift := range q.s.shifts {
+		if shift.Open() {
+			onShift[shift.CarID] = shift.DriverID
+		}
+	}
+	busy := map[uint]bool{}
+	for _, trip := range live(sortedValues(q.s.trips), tripColumns) {
+		if trip.CarID != nil && slices.Contains(activeStatuses, any(string(trip.Status))) {
+			busy[*trip.CarID] = true
+		}
+		if trip.Status == models.TripRequested && trip.OfferedCarID != nil {
+			busy[*trip.OfferedCarID] = true
+		}
+	}
+	for _, rec := range q.s.maintenance {
+		if rec.FinishedAt == nil {
+			busy[rec.CarID] = true
+		}
+	}
+	return nearbyCars(cars, positions, onShift, busy, lat, lon, radiusKm), nil
+}
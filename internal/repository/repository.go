@@ -0,0 +1,611 @@
+package repository
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"taksopark/internal/DTO"
+	"taksopark/internal/models"
+	"time"
+)
+
+var (
+	ErrNotFound   = errors.New("record not found")
+	ErrDuplicate  = errors.New("duplicated key not allowed")
+	ErrForeignKey = errors.New("violates foreign key constraint")
+	ErrOverlap    = errors.New("trip overlaps another trip")
+	// ErrStale means the row was changed since it was read: its version
+	// is not the one the caller expected.
+	ErrStale = errors.New("record was changed by another request")
+	// ErrActiveTrips means a record cannot be archived together with its
+	// trips because some of them are not finished yet.
+	ErrActiveTrips = errors.New("record has trips that are not finished")
+	// ErrNoShift means a trip occupies a driver and a car that are not on
+	// a shift together at that time.
+	ErrNoShift = errors.New("driver is not on a shift in that car at that time")
+	// ErrShiftOverlap is wrapped by ShiftOverlapError.
+	ErrShiftOverlap = errors.New("shift overlaps another shift")
+	// ErrOdometerOrder is wrapped by OdometerError.
+	ErrOdometerOrder = errors.New("odometer readings must not decrease over time")
+)
+
+// DeleteMode decides what happens when a driver, car or customer that
+// still has trips is deleted.
+type DeleteMode string
+
+const (
+	// DeleteBlock refuses to delete a record that has trips.
+	DeleteBlock DeleteMode = "block"
+	// DeleteArchive deletes the record together with its trips, provided
+	// that all of them are finished.
+	DeleteArchive DeleteMode = "archive"
+)
+
+// OverlapError names the trip that already occupies the driver or car.
+type OverlapError struct {
+	TripID   uint
+	Resource string
+	ID       uint
+}
+
+func (e *OverlapError) Error() string {
+	return fmt.Sprintf("%s %d is already booked for trip %d at that time", e.Resource, e.ID, e.TripID)
+}
+
+func (e *OverlapError) Unwrap() error {
+	return ErrOverlap
+}
+
+// findOverlap returns an OverlapError for the first of others that shares
+// the driver or car with trip and is busy at the same time.
+func findOverlap(trip *models.Trip, others []models.Trip) error {
+	start, end, ok := trip.Busy()
+	if !ok {
+		return nil
+	}
+
+	for _, other := range others {
+		if other.TripID == trip.TripID {
+			continue
+		}
+		otherStart, otherEnd, ok := other.Busy()
+		if !ok || !start.Before(otherEnd) || !otherStart.Before(end) {
+			continue
+		}
+		if trip.DriverID != nil && other.DriverID != nil && *trip.DriverID == *other.DriverID {
+			return &OverlapError{TripID: other.TripID, Resource: "driver", ID: *trip.DriverID}
+		}
+		if trip.CarID != nil && other.CarID != nil && *trip.CarID == *other.CarID {
+			return &OverlapError{TripID: other.TripID, Resource: "car", ID: *trip.CarID}
+		}
+	}
+	return nil
+}
+
+// sumEarnings adds up the days of e.
+func sumEarnings(e *DTO.Earnings) {
+	for _, day := range e.Days {
+		e.Trips += day.Trips
+		e.DistanceKm += day.DistanceKm
+		e.Earnings += day.Earnings
+	}
+	e.DistanceKm = roundKm(e.DistanceKm)
+	e.Earnings = math.Round(e.Earnings*100) / 100
+}
+
+// ShiftOverlapError names the shift the driver or car is already on.
+type ShiftOverlapError struct {
+	ShiftID  uint
+	Resource string
+	ID       uint
+}
+
+func (e *ShiftOverlapError) Error() string {
+	return fmt.Sprintf("%s %d is already on shift %d at that time", e.Resource, e.ID, e.ShiftID)
+}
+
+func (e *ShiftOverlapError) Unwrap() error {
+	return ErrShiftOverlap
+}
+
+// findShiftOverlap returns a ShiftOverlapError for the first of others that
+// shares the driver or car with shift and lasts at the same time.
+func findShiftOverlap(shift *models.Shift, others []models.Shift) error {
+	start, end := shift.Busy()
+	for _, other := range others {
+		otherStart, otherEnd := other.Busy()
+		if other.ShiftID == shift.ShiftID || !start.Before(otherEnd) || !otherStart.Before(end) {
+			continue
+		}
+		if other.DriverID == shift.DriverID {
+			return &ShiftOverlapError{ShiftID: other.ShiftID, Resource: "driver", ID: shift.DriverID}
+		}
+		if other.CarID == shift.CarID {
+			return &ShiftOverlapError{ShiftID: other.ShiftID, Resource: "car", ID: shift.CarID}
+		}
+	}
+	return nil
+}
+
+// OdometerError names the reading of the same car that a new reading
+// contradicts: an earlier one that is higher, or a later one that is lower.
+type OdometerError struct {
+	Odometer uint
+	ReadAt   time.Time
+	Earlier  bool
+}
+
+func (e *OdometerError) Error() string {
+	if e.Earlier {
+		return fmt.Sprintf("must be at least %d km read at %s", e.Odometer, e.ReadAt.Format(time.RFC3339))
+	}
+	return fmt.Sprintf("must be at most %d km read at %s", e.Odometer, e.ReadAt.Format(time.RFC3339))
+}
+
+func (e *OdometerError) Unwrap() error {
+	return ErrOdometerOrder
+}
+
+// checkOdometer returns an OdometerError for the first of others that
+// reading does not fit in with.
+func checkOdometer(reading models.OdometerReading, others []models.OdometerReading) error {
+	for _, other := range others {
+		earlier := !other.ReadAt.After(reading.ReadAt)
+		if earlier && other.Odometer > reading.Odometer || !earlier && other.Odometer < reading.Odometer {
+			return &OdometerError{Odometer: other.Odometer, ReadAt: other.ReadAt, Earlier: earlier}
+		}
+	}
+	return nil
+}
+
+// odometerReadings merges readings and the odometers of fuel entries
+// sorted by the time they were read at.
+func odometerReadings(readings []models.OdometerReading, entries []models.FuelEntry) []models.OdometerReading {
+	for _, e := range entries {
+		readings = append(readings, e.Reading())
+	}
+	slices.SortStableFunc(readings, func(a, b models.OdometerReading) int {
+		return a.ReadAt.Compare(b.ReadAt)
+	})
+	return readings
+}
+
+// activeStatuses are the statuses of trips that occupy their driver and
+// car right now.
+var activeStatuses = []any{string(models.TripAssigned), string(models.TripEnRoute), string(models.TripInProgress)}
+
+// findShift returns ErrNoShift unless one of shifts covers trip.
+func findShift(trip *models.Trip, shifts []models.Shift) error {
+	if !trip.NeedsShift() {
+		return nil
+	}
+	for _, shift := range shifts {
+		if shift.Covers(trip) {
+			return nil
+		}
+	}
+	return ErrNoShift
+}
+
+// expiringDocuments returns the latest document of every kind of every
+// driver in drivers that expires before before, soonest first. drivers maps
+// driver ids to their names.
+func expiringDocuments(docs []models.DriverDocument, drivers map[uint]DTO.Person, before time.Time) []DTO.ExpiringDocument {
+	type key struct {
+		driverID uint
+		kind     models.DocumentKind
+	}
+	latest := map[key]models.DriverDocument{}
+	for _, doc := range docs {
+		k := key{doc.DriverID, doc.Kind}
+		if l, ok := latest[k]; !ok || !doc.ExpiresAt.Before(l.ExpiresAt) {
+			latest[k] = doc
+		}
+	}
+
+	res := []DTO.ExpiringDocument{}
+	for _, doc := range latest {
+		person, ok := drivers[doc.DriverID]
+		if !ok || !doc.ExpiresAt.Before(before) {
+			continue
+		}
+		res = append(res, DTO.ExpiringDocument{
+			DriverID:   doc.DriverID,
+			Person:     person,
+			DocumentID: doc.DocumentID,
+			Kind:       string(doc.Kind),
+			Number:     doc.Number,
+			ExpiresAt:  doc.ExpiresAt,
+		})
+	}
+	slices.SortFunc(res, func(a, b DTO.ExpiringDocument) int {
+		return cmp.Or(a.ExpiresAt.Compare(b.ExpiresAt), cmp.Compare(a.DriverID, b.DriverID), cmp.Compare(a.Kind, b.Kind))
+	})
+	return res
+}
+
+// dueMaintenance works out when the cars need the services the rules of
+// their models require. records are the maintenance records of the cars and
+// odometers their highest readings from shifts, odometer readings and fuel
+// entries, the odometers records were made with count as readings too. A
+// car is overdue once it reaches the due reading or time at at.
+func dueMaintenance(cars []models.Car, rules []models.MaintenanceRule, records []models.MaintenanceRecord, odometers map[uint]uint, at time.Time) []DTO.DueMaintenance {
+	type key struct {
+		carID       uint
+		serviceType string
+	}
+	last := map[key]models.MaintenanceRecord{}
+	for _, rec := range records {
+		odometers[rec.CarID] = max(odometers[rec.CarID], rec.Odometer)
+		if rec.InWorkshop() {
+			continue
+		}
+		k := key{rec.CarID, rec.ServiceType}
+		if l, ok := last[k]; !ok || !rec.FinishedAt.Before(*l.FinishedAt) {
+			last[k] = rec
+		}
+	}
+
+	byModel := map[uint][]models.MaintenanceRule{}
+	for _, rule := range rules {
+		byModel[rule.ModelID] = append(byModel[rule.ModelID], rule)
+	}
+
+	res := []DTO.DueMaintenance{}
+	for _, car := range cars {
+		for _, rule := range byModel[car.ModelID] {
+			due := DTO.DueMaintenance{
+				CarID:        car.CarID,
+				LicensePlate: car.LicensePlate,
+				ModelID:      car.ModelID,
+				RuleID:       rule.RuleID,
+				ServiceType:  rule.ServiceType,
+				Odometer:     odometers[car.CarID],
+			}
+			var lastRec *models.MaintenanceRecord
+			if l, ok := last[key{car.CarID, rule.ServiceType}]; ok {
+				lastRec = &l
+				due.LastRecordID, due.LastServicedAt = &l.RecordID, l.FinishedAt
+			}
+			due.DueOdometer, due.DueAt = rule.Due(lastRec, car.InService())
+			due.Overdue = (due.DueOdometer != nil && due.Odometer >= *due.DueOdometer) ||
+				(due.DueAt != nil && !due.DueAt.After(at))
+			res = append(res, due)
+		}
+	}
+	return res
+}
+
+// fuelConsumption works out what cars used per 100 km from their fuel
+// entries, sorted by car and odometer. tripKm maps car ids to the
+// kilometres of their completed trips in the same period. Only the cars
+// carIDs are reported when any are given, the averages of their models
+// still take in all cars.
+func fuelConsumption(cars []models.Car, entries []models.FuelEntry, tripKm map[uint]float64, carIDs []uint) []DTO.CarConsumption {
+	type key struct {
+		id   uint
+		unit models.FuelUnit
+	}
+	byCar := map[key][]models.FuelEntry{}
+	for _, e := range entries {
+		k := key{e.CarID, e.Unit}
+		byCar[k] = append(byCar[k], e)
+	}
+
+	// byModel sums up amounts and distances of the cars of a model.
+	type total struct{ amount, km float64 }
+	byModel := map[key]total{}
+	res := []DTO.CarConsumption{}
+	for _, car := range cars {
+		for _, unit := range []models.FuelUnit{models.FuelLitres, models.FuelKWh} {
+			fills := byCar[key{car.CarID, unit}]
+			if len(fills) == 0 {
+				continue
+			}
+			c := DTO.CarConsumption{
+				CarID:        car.CarID,
+				LicensePlate: car.LicensePlate,
+				ModelID:      car.ModelID,
+				Unit:         string(unit),
+				Entries:      uint(len(fills)),
+				DistanceKm:   fills[len(fills)-1].Odometer - fills[0].Odometer,
+				TripKm:       math.Round(tripKm[car.CarID]*1000) / 1000,
+			}
+			// The first fill only tops the tank up, what it took was used
+			// before the period.
+			for _, e := range fills[1:] {
+				c.Amount += e.Amount
+				c.FuelCost += e.Price
+			}
+			c.Amount = math.Round(c.Amount*1000) / 1000
+			c.FuelCost = math.Round(c.FuelCost*100) / 100
+			if c.DistanceKm > 0 {
+				per := math.Round(c.Amount/float64(c.DistanceKm)*100*100) / 100
+				c.PerHundredKm = &per
+				t := byModel[key{car.ModelID, unit}]
+				byModel[key{car.ModelID, unit}] = total{t.amount + c.Amount, t.km + float64(c.DistanceKm)}
+			}
+			if c.TripKm > 0 {
+				cost := math.Round(c.FuelCost/c.TripKm*100) / 100
+				c.CostPerTripKm = &cost
+			}
+			res = append(res, c)
+		}
+	}
+
+	for i, c := range res {
+		t := byModel[key{c.ModelID, models.FuelUnit(c.Unit)}]
+		if c.PerHundredKm == nil || t.amount == 0 {
+			continue
+		}
+		avg := math.Round(t.amount/t.km*100*100) / 100
+		deviation := math.Round((*c.PerHundredKm-avg)/avg*100*10) / 10
+		res[i].ModelPerHundredKm, res[i].DeviationPct = &avg, &deviation
+	}
+	if len(carIDs) > 0 {
+		res = slices.DeleteFunc(res, func(c DTO.CarConsumption) bool { return !slices.Contains(carIDs, c.CarID) })
+	}
+	return res
+}
+
+// nearbyCars picks the cars whose latest position is within radiusKm of lat
+// and lon, nearest first. Positions are ordered newest first for every car.
+// Only cars with a driver on shift, onShift maps them to the driver, that
+// are not busy with a trip, an offer or a workshop visit are free.
+func nearbyCars(cars []models.Car, positions []models.CarPosition, onShift map[uint]uint, busy map[uint]bool, lat, lon, radiusKm float64) []DTO.NearbyCar {
+	latest := map[uint]models.CarPosition{}
+	for _, pos := range positions {
+		if _, ok := latest[pos.CarID]; !ok {
+			latest[pos.CarID] = pos
+		}
+	}
+
+	res := []DTO.NearbyCar{}
+	for _, car := range cars {
+		pos, located := latest[car.CarID]
+		driverID, driven := onShift[car.CarID]
+		if !located || !driven || busy[car.CarID] {
+			continue
+		}
+		km := models.Distance(lat, lon, pos.Lat, pos.Lon)
+		if km > radiusKm {
+			continue
+		}
+		res = append(res, DTO.NearbyCar{
+			CarID:        car.CarID,
+			LicensePlate: car.LicensePlate,
+			ModelID:      car.ModelID,
+			Class:        car.Model.Class,
+			DriverID:     driverID,
+			Lat:          pos.Lat,
+			Lon:          pos.Lon,
+			SpeedKmh:     pos.SpeedKmh,
+			Heading:      pos.Heading,
+			RecordedAt:   pos.RecordedAt,
+			DistanceKm:   math.Round(km*1000) / 1000,
+		})
+	}
+	slices.SortStableFunc(res, func(a, b DTO.NearbyCar) int {
+		return cmp.Or(cmp.Compare(a.DistanceKm, b.DistanceKm), cmp.Compare(a.CarID, b.CarID))
+	})
+	return res
+}
+
+// driverHours sums up the hours of shifts between from and to by driver.
+// drivers maps driver ids to their names.
+func driverHours(shifts []models.Shift, drivers map[uint]DTO.Person, from, to time.Time) []DTO.DriverHours {
+	index := map[uint]int{}
+	res := []DTO.DriverHours{}
+	for _, shift := range shifts {
+		hours := shift.Hours(from, to)
+		if hours == 0 {
+			continue
+		}
+		i, ok := index[shift.DriverID]
+		if !ok {
+			i = len(res)
+			index[shift.DriverID] = i
+			res = append(res, DTO.DriverHours{DriverID: shift.DriverID, Person: drivers[shift.DriverID]})
+		}
+		res[i].Shifts++
+		res[i].Hours += hours
+	}
+	for i := range res {
+		res[i].Hours = math.Round(res[i].Hours*100) / 100
+	}
+	slices.SortFunc(res, func(a, b DTO.DriverHours) int {
+		return cmp.Or(cmp.Compare(b.Hours, a.Hours), cmp.Compare(a.DriverID, b.DriverID))
+	})
+	return res
+}
+
+// Update and Modify store a row only if its version is still the one that
+// was read and bump it. Delete checks the version unless it is zero.
+//
+// Cars, drivers, customers and trips are deleted softly: they disappear
+// from Get and List but can be listed with ListParams.Deleted and brought
+// back with Restore.
+type CarRepository interface {
+	Create(ctx context.Context, car *models.Car) error
+	Get(ctx context.Context, id uint) (models.Car, error)
+	List(ctx context.Context, p ListParams) ([]models.Car, int64, error)
+	Update(ctx context.Context, car *models.Car) error
+	Delete(ctx context.Context, id uint, version uint) error
+	Restore(ctx context.Context, id uint) (models.Car, error)
+}
+
+type ModelRepository interface {
+	Create(ctx context.Context, model *models.CarModel) error
+	Get(ctx context.Context, id uint) (models.CarModel, error)
+	List(ctx context.Context, p ListParams) ([]models.CarModel, int64, error)
+	Update(ctx context.Context, model *models.CarModel) error
+	Delete(ctx context.Context, id uint, version uint) error
+}
+
+type DriverRepository interface {
+	Create(ctx context.Context, driver *models.Driver) error
+	Get(ctx context.Context, id uint) (models.Driver, error)
+	List(ctx context.Context, p ListParams) ([]models.Driver, int64, error)
+	Update(ctx context.Context, driver *models.Driver) error
+	Modify(ctx context.Context, id uint, fn func(driver *models.Driver) error) (models.Driver, error)
+	Delete(ctx context.Context, id uint, version uint) error
+	Restore(ctx context.Context, id uint) (models.Driver, error)
+}
+
+type CustomerRepository interface {
+	Create(ctx context.Context, customer *models.Customer) error
+	Get(ctx context.Context, id uint) (models.Customer, error)
+	List(ctx context.Context, p ListParams) ([]models.Customer, int64, error)
+	Update(ctx context.Context, customer *models.Customer) error
+	Delete(ctx context.Context, id uint, version uint) error
+	Restore(ctx context.Context, id uint) (models.Customer, error)
+}
+
+type TripRepository interface {
+	Create(ctx context.Context, trip *models.Trip) error
+	Get(ctx context.Context, id uint) (models.Trip, error)
+	List(ctx context.Context, p ListParams) ([]models.Trip, int64, error)
+	Update(ctx context.Context, trip *models.Trip) error
+	Modify(ctx context.Context, id uint, fn func(trip *models.Trip) error) (models.Trip, error)
+	Delete(ctx context.Context, id uint, version uint) error
+	Restore(ctx context.Context, id uint) (models.Trip, error)
+}
+
+// ShiftRepository stores shifts. A driver and a car are on one shift at a
+// time at most, a shift is closed only when the trips of its driver in its
+// car are finished.
+type ShiftRepository interface {
+	Create(ctx context.Context, shift *models.Shift) error
+	Get(ctx context.Context, id uint) (models.Shift, error)
+	List(ctx context.Context, p ListParams) ([]models.Shift, int64, error)
+	Modify(ctx context.Context, id uint, fn func(shift *models.Shift) error) (models.Shift, error)
+}
+
+// DocumentRepository stores the documents of drivers. Deleted drivers
+// cannot get new documents.
+type DocumentRepository interface {
+	Create(ctx context.Context, doc *models.DriverDocument) error
+	Get(ctx context.Context, id uint) (models.DriverDocument, error)
+	List(ctx context.Context, p ListParams) ([]models.DriverDocument, int64, error)
+	Modify(ctx context.Context, id uint, fn func(doc *models.DriverDocument) error) (models.DriverDocument, error)
+	Delete(ctx context.Context, id uint, version uint) error
+}
+
+// MaintenanceRepository stores the maintenance records of cars. Deleted
+// cars cannot get new records.
+type MaintenanceRepository interface {
+	Create(ctx context.Context, rec *models.MaintenanceRecord) error
+	Get(ctx context.Context, id uint) (models.MaintenanceRecord, error)
+	List(ctx context.Context, p ListParams) ([]models.MaintenanceRecord, int64, error)
+	Modify(ctx context.Context, id uint, fn func(rec *models.MaintenanceRecord) error) (models.MaintenanceRecord, error)
+	Delete(ctx context.Context, id uint, version uint) error
+}
+
+// MaintenanceRuleRepository stores maintenance rules, a model has one rule
+// per service type at most.
+type MaintenanceRuleRepository interface {
+	Create(ctx context.Context, rule *models.MaintenanceRule) error
+	Get(ctx context.Context, id uint) (models.MaintenanceRule, error)
+	List(ctx context.Context, p ListParams) ([]models.MaintenanceRule, int64, error)
+	Update(ctx context.Context, rule *models.MaintenanceRule) error
+	Delete(ctx context.Context, id uint, version uint) error
+}
+
+// OdometerRepository stores odometer readings of cars. Together with the
+// odometers of fuel entries they must not decrease over time.
+type OdometerRepository interface {
+	Create(ctx context.Context, reading *models.OdometerReading) error
+	Get(ctx context.Context, id uint) (models.OdometerReading, error)
+	List(ctx context.Context, p ListParams) ([]models.OdometerReading, int64, error)
+	Delete(ctx context.Context, id uint, version uint) error
+}
+
+// FuelRepository stores fuel entries, their odometers are checked like
+// odometer readings.
+type FuelRepository interface {
+	Create(ctx context.Context, entry *models.FuelEntry) error
+	Get(ctx context.Context, id uint) (models.FuelEntry, error)
+	List(ctx context.Context, p ListParams) ([]models.FuelEntry, int64, error)
+	Modify(ctx context.Context, id uint, fn func(entry *models.FuelEntry) error) (models.FuelEntry, error)
+	Delete(ctx context.Context, id uint, version uint) error
+}
+
+// PositionRepository stores the GPS positions of cars. They are not
+// written to the audit log, there are far too many of them.
+type PositionRepository interface {
+	// Record stores pos and deletes all but the keep newest positions of
+	// its car.
+	Record(ctx context.Context, pos *models.CarPosition, keep int) error
+	List(ctx context.Context, p ListParams) ([]models.CarPosition, int64, error)
+}
+
+type TariffRepository interface {
+	Create(ctx context.Context, tariff *models.Tariff) error
+	Get(ctx context.Context, id uint) (models.Tariff, error)
+	List(ctx context.Context, p ListParams) ([]models.Tariff, int64, error)
+	Update(ctx context.Context, tariff *models.Tariff) error
+	Delete(ctx context.Context, id uint, version uint) error
+}
+
+// APIKeyRepository stores API keys. FindByHash looks a key up by the hash of
+// its secret, revoked keys included.
+type APIKeyRepository interface {
+	Create(ctx context.Context, key *models.APIKey) error
+	Get(ctx context.Context, id uint) (models.APIKey, error)
+	FindByHash(ctx context.Context, hash string) (models.APIKey, error)
+	List(ctx context.Context, p ListParams) ([]models.APIKey, int64, error)
+	Modify(ctx context.Context, id uint, fn func(key *models.APIKey) error) (models.APIKey, error)
+}
+
+// AuditRepository reads the audit log. Entries are written by the other
+// repositories together with the changes they describe.
+type AuditRepository interface {
+	List(ctx context.Context, p ListParams) ([]models.AuditEntry, int64, error)
+}
+
+type QueryRepository interface {
+	CarsOfYear(ctx context.Context, year int) ([]DTO.CarWithModel, error)
+	DriverTripCounts(ctx context.Context) ([]DTO.PersonCount, error)
+	DriverCarTripCounts(ctx context.Context) ([]DTO.DriverCount, error)
+	CustomersWithTripsMoreThan(ctx context.Context, n int) ([]DTO.PersonCount, error)
+	BestDrivers(ctx context.Context) ([]DTO.Person, error)
+	TripDurationStatistic(ctx context.Context) (DTO.Statistic, error)
+	DriverDistances(ctx context.Context) ([]DTO.PersonDistance, error)
+	CarDistances(ctx context.Context) ([]DTO.CarDistance, error)
+	DailyDistances(ctx context.Context) ([]DTO.DayDistance, error)
+	DriverEarnings(ctx context.Context, driverID uint, from, to *time.Time) (DTO.Earnings, error)
+	DriverHours(ctx context.Context, from, to time.Time) ([]DTO.DriverHours, error)
+	ExpiringDocuments(ctx context.Context, before time.Time) ([]DTO.ExpiringDocument, error)
+	// DueMaintenance lists the services the cars carIDs, or all live cars
+	// when none are given, need by the rules of their models.
+	DueMaintenance(ctx context.Context, at time.Time, carIDs ...uint) ([]DTO.DueMaintenance, error)
+	// FuelConsumption works out the consumption of the cars carIDs, or all
+	// live cars when none are given, from fuel entries filled between from
+	// and to.
+	FuelConsumption(ctx context.Context, from, to *time.Time, carIDs ...uint) ([]DTO.CarConsumption, error)
+	// NearbyCars lists the free cars whose latest position recorded since
+	// since is within radiusKm of lat and lon.
+	NearbyCars(ctx context.Context, lat, lon, radiusKm float64, since time.Time) ([]DTO.NearbyCar, error)
+}
+
+type Repositories struct {
+	Cars             CarRepository
+	Models           ModelRepository
+	Drivers          DriverRepository
+	Customers        CustomerRepository
+	Trips            TripRepository
+	Shifts           ShiftRepository
+	Documents        DocumentRepository
+	Maintenance      MaintenanceRepository
+	MaintenanceRules MaintenanceRuleRepository
+	Odometer         OdometerRepository
+	Fuel             FuelRepository
+	Positions        PositionRepository
+	Tariffs          TariffRepository
+	Query            QueryRepository
+	Audit            AuditRepository
+	APIKeys          APIKeyRepository
+}
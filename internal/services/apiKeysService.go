@@ -0,0 +1,200 @@
+package services
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"taksopark/internal/DTO"
+	"taksopark/internal/models"
+	"taksopark/internal/repository"
+	"time"
+)
+
+type APIKeyService struct {
+	repo repository.APIKeyRepository
+}
+
+func NewAPIKeyService(repo repository.APIKeyRepository) APIKeyService {
+	return APIKeyService{
+		repo: repo,
+	}
+}
+
+// issuedKey is a key together with its secret, which is only ever shown in
+// the response that creates or rotates it.
+type issuedKey struct {
+	models.APIKey
+	Key string `json:"key"`
+}
+
+var errKeyRevoked = errors.New("api key is revoked")
+
+func apiKeyWriteError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, repository.ErrNotFound):
+		writeError(w, newError(http.StatusNotFound, CodeNotFound, "api key not found"))
+	case errors.Is(err, repository.ErrForeignKey):
+		writeError(w, newError(http.StatusUnprocessableEntity, CodeInvalidReference, "driver does not exist"))
+	case errors.Is(err, errKeyRevoked):
+		writeError(w, newError(http.StatusConflict, CodeRevoked, err.Error()))
+	default:
+		writeError(w, err)
+	}
+}
+
+// roleFields checks that a driver ID is given for the driver role and only
+// for it.
+func roleFields(role string, driverID *uint) []DTO.FieldError {
+	switch {
+	case role == string(models.RoleDriver) && driverID == nil:
+		return []DTO.FieldError{{Field: "driver_id", Message: "is required for the driver role"}}
+	case role != string(models.RoleDriver) && driverID != nil:
+		return []DTO.FieldError{{Field: "driver_id", Message: "must be empty for roles other than driver"}}
+	}
+	return nil
+}
+
+func (s *APIKeyService) Create(w http.ResponseWriter, r *http.Request) {
+	req := new(DTO.CreateAPIKeyRequest)
+	if err := decode(r, req, func() []DTO.FieldError { return roleFields(req.Role, req.DriverID) }); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	secret, hash := newAPIKey()
+	key := &models.APIKey{
+		Name:      req.Name,
+		Prefix:    keyPrefix(secret),
+		Hash:      hash,
+		Role:      models.Role(req.Role),
+		DriverID:  req.DriverID,
+		CreatedAt: time.Now().UTC().Truncate(time.Microsecond),
+	}
+
+	if err := s.repo.Create(r.Context(), key); err != nil {
+		apiKeyWriteError(w, err)
+		return
+	}
+
+	setETag(w, key.Version)
+	response(w, http.StatusCreated, issuedKey{APIKey: *key, Key: secret})
+}
+
+func (s *APIKeyService) Get(w http.ResponseWriter, r *http.Request) {
+	idString := r.PathValue("id")
+	id, err := strconv.Atoi(idString)
+	if err != nil {
+		writeError(w, invalidID())
+		return
+	}
+
+	key, err := s.repo.Get(r.Context(), uint(id))
+	if err != nil {
+		apiKeyWriteError(w, err)
+		return
+	}
+
+	if notModified(w, r, etag(key.Version)) {
+		return
+	}
+	response(w, http.StatusOK, key)
+}
+
+var apiKeyListSpec = listSpec{
+	pk:   "key_id",
+	sort: []string{"key_id", "name", "created_at"},
+	filters: map[string]filterSpec{
+		"name": {column: "name", op: repository.OpEq, parse: parseString},
+		"role": {column: "role", op: repository.OpEq, parse: parseString},
+	},
+}
+
+func (s *APIKeyService) GetAll(w http.ResponseWriter, r *http.Request) {
+	params, err := parseListParams(r, apiKeyListSpec)
+	if err != nil {
+		responseError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	keys, total, err := s.repo.List(r.Context(), params)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	responseList(w, r, newPage(r, apiKeyListSpec, params, keys, total, func(k models.APIKey) uint { return k.KeyID }))
+}
+
+// modify changes a key that is not revoked yet.
+func (s *APIKeyService) modify(r *http.Request, fn func(key *models.APIKey, now time.Time)) (models.APIKey, error) {
+	idString := r.PathValue("id")
+	id, err := strconv.Atoi(idString)
+	if err != nil {
+		return models.APIKey{}, invalidID()
+	}
+
+	return s.repo.Modify(r.Context(), uint(id), func(key *models.APIKey) error {
+		if err := checkIfMatch(r, key.Version); err != nil {
+			return err
+		}
+		if key.Revoked() {
+			return errKeyRevoked
+		}
+		fn(key, time.Now().UTC().Truncate(time.Microsecond))
+		return nil
+	})
+}
+
+// Rotate replaces the secret of a key. The old secret and the tokens issued
+// before stop working at once.
+func (s *APIKeyService) Rotate(w http.ResponseWriter, r *http.Request) {
+	var secret string
+	key, err := s.modify(r, func(key *models.APIKey, now time.Time) {
+		secret, key.Hash = newAPIKey()
+		key.Prefix = keyPrefix(secret)
+		key.RotatedAt = &now
+	})
+	if err != nil {
+		apiKeyWriteError(w, err)
+		return
+	}
+
+	setETag(w, key.Version)
+	response(w, http.StatusOK, issuedKey{APIKey: key, Key: secret})
+}
+
+// SetRole gives a key another role. It takes effect with the next request,
+// tokens issued for the key included.
+func (s *APIKeyService) SetRole(w http.ResponseWriter, r *http.Request) {
+	req := new(DTO.APIKeyRoleRequest)
+	if err := decode(r, req, func() []DTO.FieldError { return roleFields(req.Role, req.DriverID) }); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	key, err := s.modify(r, func(key *models.APIKey, now time.Time) {
+		key.Role = models.Role(req.Role)
+		key.DriverID = req.DriverID
+	})
+	if err != nil {
+		apiKeyWriteError(w, err)
+		return
+	}
+
+	setETag(w, key.Version)
+	response(w, http.StatusOK, key)
+}
+
+// Revoke disables a key and the tokens issued for it for good.
+func (s *APIKeyService) Revoke(w http.ResponseWriter, r *http.Request) {
+	key, err := s.modify(r, func(key *models.APIKey, now time.Time) {
+		key.RevokedAt = &now
+	})
+	if err != nil {
+		apiKeyWriteError(w, err)
+		return
+	}
+
+	setETag(w, key.Version)
+	response(w, http.StatusOK, key)
+}
@@ -0,0 +1,70 @@
+package services
+
+import (
+	"net/http"
+	"taksopark/internal/models"
+	"taksopark/internal/repository"
+)
+
+const anonymousActor = "anonymous"
+
+// Audit passes the actor and the request ID to the repositories, which
+// record them with every write. The actor is the name and the id of the API
+// key the request is authenticated with, names are not unique. It is
+// anonymous when authentication is off.
+func Audit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		info := repository.AuditInfo{
+			Actor:     anonymousActor,
+			RequestID: RequestIDFrom(r.Context()),
+		}
+		if p, ok := PrincipalFrom(r.Context()); ok {
+			info.Actor = p.Name
+			if p.KeyID != 0 {
+				info.ActorKeyID = &p.KeyID
+			}
+		}
+		ctx := repository.WithAuditInfo(r.Context(), info)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+type AuditService struct {
+	repo repository.AuditRepository
+}
+
+func NewAuditService(repo repository.AuditRepository) AuditService {
+	return AuditService{
+		repo: repo,
+	}
+}
+
+var auditListSpec = listSpec{
+	pk:   "audit_id",
+	sort: []string{"audit_id", "created_at"},
+	filters: map[string]filterSpec{
+		"entity":       {column: "entity", op: repository.OpEq, parse: parseString},
+		"entity_id":    {column: "entity_id", op: repository.OpEq, parse: parseUint},
+		"action":       {column: "action", op: repository.OpEq, parse: parseString},
+		"actor":        {column: "actor", op: repository.OpEq, parse: parseString},
+		"actor_key_id": {column: "actor_key_id", op: repository.OpEq, parse: parseUint},
+		"from":         {column: "created_at", op: repository.OpGte, parse: parseTime},
+		"to":           {column: "created_at", op: repository.OpLte, parse: parseTime},
+	},
+}
+
+func (s *AuditService) GetAll(w http.ResponseWriter, r *http.Request) {
+	params, err := parseListParams(r, auditListSpec)
+	if err != nil {
+		responseError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	entries, total, err := s.repo.List(r.Context(), params)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	responseList(w, r, newPage(r, auditListSpec, params, entries, total, func(e models.AuditEntry) uint { return e.AuditID }))
+}
@@ -0,0 +1,209 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+	"taksopark/internal/DTO"
+	"taksopark/internal/config"
+	"taksopark/internal/jwt"
+	"taksopark/internal/models"
+	"taksopark/internal/repository"
+	"time"
+)
+
+const (
+	// apiKeyPrefix starts every API key so that leaked keys are easy to
+	// recognize.
+	apiKeyPrefix = "tp_"
+	// bootstrapActor is the name of the principal of auth.bootstrap_key.
+	bootstrapActor = "bootstrap"
+)
+
+// Principal is the authenticated caller: the API key it used directly or
+// through a token. KeyID is zero for the bootstrap key, which is an admin.
+// DriverID is set for drivers only.
+type Principal struct {
+	KeyID     uint
+	Name      string
+	Role      models.Role
+	DriverID  uint
+	keyPrefix string
+}
+
+func keyPrincipal(key models.APIKey) Principal {
+	p := Principal{KeyID: key.KeyID, Name: key.Name, Role: key.Role, keyPrefix: key.Prefix}
+	if key.DriverID != nil {
+		p.DriverID = *key.DriverID
+	}
+	return p
+}
+
+var bootstrapPrincipal = Principal{Name: bootstrapActor, Role: models.RoleAdmin}
+
+type principalKey struct{}
+
+func PrincipalFrom(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(Principal)
+	return p, ok
+}
+
+// Auth authenticates requests with an API key or a token issued for one,
+// both sent as "Authorization: Bearer ...". Tokens are told from keys by
+// the dots that separate their parts.
+type Auth struct {
+	keys      repository.APIKeyRepository
+	cfg       config.AuthConfig
+	bootstrap string
+}
+
+func NewAuth(keys repository.APIKeyRepository, cfg config.AuthConfig) Auth {
+	a := Auth{
+		keys: keys,
+		cfg:  cfg,
+	}
+	if cfg.BootstrapKey != "" {
+		a.bootstrap = hashKey(cfg.BootstrapKey)
+	}
+	return a
+}
+
+// newAPIKey returns a new random key and the hash it is stored under.
+func newAPIKey() (key, hash string) {
+	b := make([]byte, 24)
+	rand.Read(b)
+	key = apiKeyPrefix + hex.EncodeToString(b)
+	return key, hashKey(key)
+}
+
+func hashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func keyPrefix(key string) string {
+	return key[:len(apiKeyPrefix)+8]
+}
+
+var (
+	errNoCredentials = errors.New("authentication required")
+	errInvalidKey    = errors.New("invalid or revoked api key")
+	errInvalidToken  = errors.New("invalid or expired token")
+)
+
+func unauthorized(w http.ResponseWriter, err error) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="taksopark"`)
+	writeError(w, newError(http.StatusUnauthorized, CodeUnauthorized, err.Error()))
+}
+
+// Middleware rejects requests without valid credentials with 401 and makes
+// the principal available from PrincipalFrom. It lets everything through
+// when authentication is disabled.
+func (a *Auth) Middleware(next http.Handler) http.Handler {
+	if !a.cfg.Enabled {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		credential, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		credential = strings.TrimSpace(credential)
+		if !ok || credential == "" {
+			unauthorized(w, errNoCredentials)
+			return
+		}
+
+		var p Principal
+		var err error
+		if strings.Count(credential, ".") == 2 {
+			p, err = a.tokenPrincipal(r.Context(), credential)
+		} else {
+			p, err = a.keyPrincipal(r.Context(), credential)
+		}
+		switch {
+		case errors.Is(err, errInvalidKey), errors.Is(err, errInvalidToken):
+			unauthorized(w, err)
+			return
+		case err != nil:
+			writeError(w, err)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, p)))
+	})
+}
+
+func (a *Auth) keyPrincipal(ctx context.Context, credential string) (Principal, error) {
+	hash := hashKey(credential)
+	if a.bootstrap != "" && subtle.ConstantTimeCompare([]byte(hash), []byte(a.bootstrap)) == 1 {
+		return bootstrapPrincipal, nil
+	}
+
+	key, err := a.keys.FindByHash(ctx, hash)
+	switch {
+	case errors.Is(err, repository.ErrNotFound):
+		return Principal{}, errInvalidKey
+	case err != nil:
+		return Principal{}, err
+	case key.Revoked():
+		return Principal{}, errInvalidKey
+	}
+	return keyPrincipal(key), nil
+}
+
+// tokenPrincipal accepts a token only while the key it was issued for is
+// neither revoked nor rotated: a rotated key has another prefix.
+func (a *Auth) tokenPrincipal(ctx context.Context, credential string) (Principal, error) {
+	claims, err := jwt.Parse(credential, []byte(a.cfg.JWTSecret), time.Now())
+	if err != nil {
+		return Principal{}, errInvalidToken
+	}
+
+	if claims.KeyID == 0 {
+		if a.bootstrap == "" || claims.Subject != bootstrapActor {
+			return Principal{}, errInvalidToken
+		}
+		return bootstrapPrincipal, nil
+	}
+
+	key, err := a.keys.Get(ctx, claims.KeyID)
+	switch {
+	case errors.Is(err, repository.ErrNotFound):
+		return Principal{}, errInvalidToken
+	case err != nil:
+		return Principal{}, err
+	case key.Revoked(), key.Prefix != claims.KeyPrefix:
+		return Principal{}, errInvalidToken
+	}
+	return keyPrincipal(key), nil
+}
+
+// Token issues a bearer token for the key the request is authenticated
+// with.
+func (a *Auth) Token(w http.ResponseWriter, r *http.Request) {
+	p, _ := PrincipalFrom(r.Context())
+
+	now := time.Now()
+	expires := now.Add(a.cfg.TokenTTL)
+	token, err := jwt.Sign(jwt.Claims{
+		Subject:   p.Name,
+		KeyID:     p.KeyID,
+		KeyPrefix: p.keyPrefix,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: expires.Unix(),
+	}, []byte(a.cfg.JWTSecret))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	response(w, http.StatusOK, DTO.Token{
+		AccessToken: token,
+		TokenType:   "Bearer",
+		ExpiresIn:   int(a.cfg.TokenTTL.Seconds()),
+		ExpiresAt:   time.Unix(expires.Unix(), 0).UTC(),
+	})
+}
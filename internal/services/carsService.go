@@ -1,47 +1,82 @@
 package services
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
-	"errors"
 	"net/http"
 	"taksopark/internal/DTO"
+	"taksopark/internal/config"
 	"taksopark/internal/models"
+	"taksopark/internal/repository"
+	"taksopark/internal/validate"
 
 	"strconv"
-
-	"gorm.io/gorm"
 )
 
 type CarService struct {
-	db *gorm.DB
+	repo   repository.CarRepository
+	models repository.ModelRepository
+	notes  *validate.Schema
 }
 
-func NewCarService(init_db *gorm.DB) CarService {
+func NewCarService(repo repository.CarRepository, carModels repository.ModelRepository, cfg config.CarsConfig) CarService {
+	// The schema is checked by config.Validate.
+	notes, _ := cfg.Schema()
 	return CarService{
-		db: init_db,
+		repo:   repo,
+		models: carModels,
+		notes:  notes,
+	}
+}
+
+// checkNotes validates notes against the notes schema of the fleet, if
+// one is configured.
+func (s *CarService) checkNotes(notes json.RawMessage) []DTO.FieldError {
+	if s.notes == nil || isNull(notes) {
+		return nil
+	}
+	var v any
+	if err := json.Unmarshal(notes, &v); err != nil {
+		// Reported by the object rule.
+		return nil
+	}
+	return s.notes.Validate("notes", v)
+}
+
+func isNull(raw json.RawMessage) bool {
+	raw = bytes.TrimSpace(raw)
+	return len(raw) == 0 || bytes.Equal(raw, []byte("null"))
+}
+
+// carNotes converts the notes of a request, null clears them.
+func carNotes(raw json.RawMessage) models.Notes {
+	if isNull(raw) {
+		return nil
 	}
+	return models.Notes(bytes.TrimSpace(raw))
 }
 
 func (s *CarService) Create(w http.ResponseWriter, r *http.Request) {
 	req := new(DTO.CreateCarRequest)
-	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
-		responseError(w, http.StatusBadRequest, err)
+	if err := decode(r, req, func() []DTO.FieldError { return s.checkNotes(req.Notes) }); err != nil {
+		writeError(w, err)
 		return
 	}
-	defer r.Body.Close()
 
 	car := &models.Car{
 		LicensePlate: req.LicensePlate,
 		ModelID:      req.ModelID,
 		Year:         uint(req.Year),
-		Notes:        req.Notes,
+		Notes:        carNotes(req.Notes),
 	}
 
-	if err := s.db.Create(car).Error; err != nil {
-		responseError(w, http.StatusInternalServerError, err)
+	if err := s.repo.Create(r.Context(), car); err != nil {
+		writeError(w, err)
 		return
 	}
 
+	setETag(w, car.Version)
 	response(w, http.StatusCreated, car)
 }
 
@@ -49,32 +84,75 @@ func (s *CarService) Get(w http.ResponseWriter, r *http.Request) {
 	idString := r.PathValue("id")
 	id, err := strconv.Atoi(idString)
 	if err != nil {
-		responseError(w, http.StatusBadRequest, errors.New("invalid id"))
+		writeError(w, invalidID())
+		return
+	}
+
+	car, err := s.repo.Get(r.Context(), uint(id))
+
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if notModified(w, r, etag(car.Version)) {
 		return
 	}
+	response(w, http.StatusOK, car)
+}
 
-	var car models.Car
-	err = s.db.Preload("Model").First(&car, id).Error
+var carListSpec = listSpec{
+	pk:   "car_id",
+	sort: []string{"car_id", "license_plate", "model_id", "year"},
+	filters: map[string]filterSpec{
+		"model_id": {column: "model_id", op: repository.OpEq, parse: parseUint},
+		"year":     {column: "year", op: repository.OpEq, parse: parseUint},
+	},
+	json:    []string{"notes"},
+	deleted: true,
+}
 
-	switch {
-	case err == nil:
-		response(w, http.StatusOK, car)
-	case errors.Is(err, gorm.ErrRecordNotFound):
-		responseError(w, http.StatusNotFound, errors.New("record not found"))
-	default:
-		responseError(w, http.StatusInternalServerError, err)
+// byModel selects the cars of the car models matching filters.
+func (s *CarService) byModel(ctx context.Context, filters []repository.Filter) (repository.Filter, error) {
+	carModels, _, err := s.models.List(ctx, repository.ListParams{Filters: filters})
+	if err != nil {
+		return repository.Filter{}, err
 	}
+	ids := make([]any, len(carModels))
+	for i, m := range carModels {
+		ids[i] = m.ModelID
+	}
+	return repository.Filter{Column: "model_id", Op: repository.OpIn, Value: ids}, nil
 }
 
+// GetAll lists cars, the filters of GET /models select them by their model.
 func (s *CarService) GetAll(w http.ResponseWriter, r *http.Request) {
-	var cars []models.Car
-	err := s.db.Preload("Model").Find(&cars).Error
+	params, err := parseListParams(r, carListSpec)
+	if err != nil {
+		responseError(w, http.StatusBadRequest, err)
+		return
+	}
+	filters, err := parseFilters(r.URL.Query(), modelFilters)
+	if err != nil {
+		responseError(w, http.StatusBadRequest, err)
+		return
+	}
+	if len(filters) > 0 {
+		f, err := s.byModel(r.Context(), filters)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		params.Filters = append(params.Filters, f)
+	}
+
+	cars, total, err := s.repo.List(r.Context(), params)
 	if err != nil {
-		responseError(w, http.StatusInternalServerError, err)
+		writeError(w, err)
 		return
 	}
 
-	response(w, http.StatusOK, cars)
+	responseList(w, r, newPage(r, carListSpec, params, cars, total, func(c models.Car) uint { return c.CarID }))
 }
 
 func (s *CarService) Update(w http.ResponseWriter, r *http.Request) {
@@ -82,37 +160,37 @@ func (s *CarService) Update(w http.ResponseWriter, r *http.Request) {
 	idString := r.PathValue("id")
 	id, err := strconv.Atoi(idString)
 	if err != nil {
-		responseError(w, http.StatusBadRequest, errors.New("invalid id"))
+		writeError(w, invalidID())
 		return
 	}
 
 	req := new(DTO.UpdateCarRequest)
-	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
-		responseError(w, http.StatusBadRequest, err)
+	if err := decode(r, req, func() []DTO.FieldError { return s.checkNotes(req.Notes) }); err != nil {
+		writeError(w, err)
 		return
 	}
-	defer r.Body.Close()
 
-	var car models.Car
-	if err := s.db.Preload("Model").First(&car, id).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			responseError(w, http.StatusNotFound, errors.New("car not found"))
-			return
-		}
-		responseError(w, http.StatusInternalServerError, err)
+	car, err := s.repo.Get(r.Context(), uint(id))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := checkIfMatch(r, car.Version); err != nil {
+		writeError(w, err)
 		return
 	}
 
 	car.LicensePlate = req.LicensePlate
 	car.ModelID = req.ModelID
-	car.Notes = req.Notes
+	car.Notes = carNotes(req.Notes)
 	car.Year = uint(req.Year)
 
-	if err := s.db.Save(&car).Error; err != nil {
-		responseError(w, http.StatusInternalServerError, err)
+	if err := s.repo.Update(r.Context(), &car); err != nil {
+		writeError(w, err)
 		return
 	}
 
+	setETag(w, car.Version)
 	response(w, http.StatusOK, car)
 }
 
@@ -120,55 +198,86 @@ func (s *CarService) UpdateSomething(w http.ResponseWriter, r *http.Request) {
 	idString := r.PathValue("id")
 	id, err := strconv.Atoi(idString)
 	if err != nil {
-		responseError(w, http.StatusBadRequest, err)
+		writeError(w, invalidID())
 		return
 	}
 
-	req := new(DTO.UpdateSomethingCarRequest)
-	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
-		responseError(w, http.StatusBadRequest, err)
+	car, err := s.repo.Get(r.Context(), uint(id))
+	if err != nil {
+		writeError(w, err)
 		return
 	}
-	r.Body.Close()
+	if err := checkIfMatch(r, car.Version); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	req := &DTO.UpdateSomethingCarRequest{
+		LicensePlate: car.LicensePlate,
+		ModelID:      car.ModelID,
+		Year:         int(car.Year),
+		Notes:        json.RawMessage(car.Notes),
+	}
+	if err := decodePatch(r, req, func() []DTO.FieldError { return s.checkNotes(req.Notes) }); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	car.LicensePlate = req.LicensePlate
+	car.ModelID = req.ModelID
+	car.Year = uint(req.Year)
+	car.Notes = carNotes(req.Notes)
 
-	var car models.Car
-	err = s.db.First(&car, id).Error
+	err = s.repo.Update(r.Context(), &car)
 	if err != nil {
-		responseError(w, http.StatusBadRequest, err)
+		writeError(w, err)
 		return
 	}
 
-	switch {
-	case req.LicensePlate != nil:
-		car.LicensePlate = *req.LicensePlate
-	case req.ModelID != nil:
-		car.ModelID = *req.ModelID
-	case req.Year != nil:
-		car.Year = uint(*req.Year)
-	case req.Notes != nil:
-		car.Notes = *req.Notes
+	setETag(w, car.Version)
+	response(w, http.StatusOK, car)
+}
+
+func (s *CarService) Delete(w http.ResponseWriter, r *http.Request) {
+	idString := r.PathValue("id")
+	id, err := strconv.Atoi(idString)
+	if err != nil {
+		writeError(w, invalidID())
+		return
 	}
 
-	err = s.db.Preload("Model").Save(&car).Error
+	version, err := ifMatchVersion(r, func() (uint, error) {
+		car, err := s.repo.Get(r.Context(), uint(id))
+		return car.Version, err
+	})
 	if err != nil {
-		responseError(w, http.StatusInternalServerError, err)
+		writeError(w, err)
 		return
 	}
 
+	if err = s.repo.Delete(r.Context(), uint(id), version); err != nil {
+		writeDeleteError(w, err)
+		return
+	}
 	response(w, http.StatusNoContent, nil)
 }
 
-func (s *CarService) Delete(w http.ResponseWriter, r *http.Request) {
+// Restore brings back a deleted car together with the trips that were
+// archived along with it.
+func (s *CarService) Restore(w http.ResponseWriter, r *http.Request) {
 	idString := r.PathValue("id")
 	id, err := strconv.Atoi(idString)
 	if err != nil {
-		responseError(w, http.StatusBadRequest, err)
+		writeError(w, invalidID())
 		return
 	}
 
-	if err = s.db.Delete(models.Car{}, id).Error; err != nil {
-		responseError(w, http.StatusInternalServerError, err)
+	car, err := s.repo.Restore(r.Context(), uint(id))
+	if err != nil {
+		writeError(w, err)
 		return
 	}
-	response(w, http.StatusNoContent, nil)
+
+	setETag(w, car.Version)
+	response(w, http.StatusOK, car)
 }
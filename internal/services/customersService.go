@@ -1,33 +1,29 @@
 package services
 
 import (
-	"encoding/json"
-	"errors"
 	"net/http"
 	"strconv"
 	"taksopark/internal/DTO"
 	"taksopark/internal/models"
-
-	"gorm.io/gorm"
+	"taksopark/internal/repository"
 )
 
 type CustomerService struct {
-	db *gorm.DB
+	repo repository.CustomerRepository
 }
 
-func NewCustomerService(init_db *gorm.DB) CustomerService {
+func NewCustomerService(repo repository.CustomerRepository) CustomerService {
 	return CustomerService{
-		db: init_db,
+		repo: repo,
 	}
 }
 
 func (s *CustomerService) Create(w http.ResponseWriter, r *http.Request) {
 	req := new(DTO.CreateCustomerRequest)
-	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
-		responseError(w, http.StatusBadRequest, err)
+	if err := decode(r, req); err != nil {
+		writeError(w, err)
 		return
 	}
-	defer r.Body.Close()
 
 	customer := &models.Customer{
 		FirstName: req.FirstName,
@@ -35,40 +31,57 @@ func (s *CustomerService) Create(w http.ResponseWriter, r *http.Request) {
 		Phone:     req.Phone,
 	}
 
-	if err := s.db.Create(customer).Error; err != nil {
-		responseError(w, http.StatusInternalServerError, err)
+	if err := s.repo.Create(r.Context(), customer); err != nil {
+		writeError(w, err)
 		return
 	}
 
+	setETag(w, customer.Version)
 	response(w, http.StatusCreated, customer)
 }
 
+var customerListSpec = listSpec{
+	pk:   "customer_id",
+	sort: []string{"customer_id", "first_name", "last_name", "phone"},
+	filters: map[string]filterSpec{
+		"phone_prefix": {column: "phone", op: repository.OpPrefix, parse: parseString},
+	},
+	deleted: true,
+}
+
 func (s *CustomerService) GetAll(w http.ResponseWriter, r *http.Request) {
-	var customers []models.Customer
-	err := s.db.Find(&customers).Error
+	params, err := parseListParams(r, customerListSpec)
 	if err != nil {
-		responseError(w, http.StatusInternalServerError, err)
+		responseError(w, http.StatusBadRequest, err)
 		return
 	}
-	response(w, http.StatusOK, customers)
+
+	customers, total, err := s.repo.List(r.Context(), params)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	responseList(w, r, newPage(r, customerListSpec, params, customers, total, func(c models.Customer) uint { return c.CustomerID }))
 }
 
 func (s *CustomerService) Get(w http.ResponseWriter, r *http.Request) {
-	var customer models.Customer
-
 	idString := r.PathValue("id")
 	id, err := strconv.Atoi(idString)
 	if err != nil {
-		responseError(w, http.StatusBadRequest, errors.New("invalid id"))
+		writeError(w, invalidID())
 		return
 	}
 
-	err = s.db.Find(&customer, id).Error
+	customer, err := s.repo.Get(r.Context(), uint(id))
 	if err != nil {
-		responseError(w, http.StatusNotFound, err)
+		writeError(w, err)
 		return
 	}
 
+	if notModified(w, r, etag(customer.Version)) {
+		return
+	}
 	response(w, http.StatusOK, customer)
 }
 
@@ -76,25 +89,24 @@ func (s *CustomerService) Update(w http.ResponseWriter, r *http.Request) {
 	idString := r.PathValue("id")
 	id, err := strconv.Atoi(idString)
 	if err != nil {
-		responseError(w, http.StatusBadRequest, errors.New("invalid id"))
+		writeError(w, invalidID())
 		return
 	}
 
 	req := new(DTO.UpdateCustomerRequest)
-	err = json.NewDecoder(r.Body).Decode(req)
+	err = decode(r, req)
 	if err != nil {
-		responseError(w, http.StatusBadRequest, err)
+		writeError(w, err)
 		return
 	}
 
-	var customer models.Customer
-	err = s.db.Find(&customer, id).Error
+	customer, err := s.repo.Get(r.Context(), uint(id))
 	if err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			responseError(w, http.StatusNotFound, err)
-			return
-		}
-		responseError(w, http.StatusInternalServerError, err)
+		writeError(w, err)
+		return
+	}
+	if err := checkIfMatch(r, customer.Version); err != nil {
+		writeError(w, err)
 		return
 	}
 
@@ -102,71 +114,99 @@ func (s *CustomerService) Update(w http.ResponseWriter, r *http.Request) {
 	customer.LastName = req.LastName
 	customer.Phone = req.Phone
 
-	err = s.db.Save(&customer).Error
+	err = s.repo.Update(r.Context(), &customer)
 	if err != nil {
-		response(w, http.StatusInternalServerError, err)
+		writeError(w, err)
 		return
 	}
 
-	response(w, http.StatusOK, nil)
+	setETag(w, customer.Version)
+	response(w, http.StatusOK, customer)
 }
 
 func (s *CustomerService) UpdateSomething(w http.ResponseWriter, r *http.Request) {
 	idString := r.PathValue("id")
 	id, err := strconv.Atoi(idString)
 	if err != nil {
-		responseError(w, http.StatusBadRequest, errors.New("invalid id"))
+		writeError(w, invalidID())
 		return
 	}
 
-	var customer models.Customer
-	err = s.db.Find(&customer, id).Error
+	customer, err := s.repo.Get(r.Context(), uint(id))
 	if err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			responseError(w, http.StatusNotFound, err)
-			return
-		}
-		responseError(w, http.StatusInternalServerError, err)
+		writeError(w, err)
+		return
+	}
+	if err := checkIfMatch(r, customer.Version); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	req := &DTO.UpdateSomethingCustomerRequest{
+		FirstName: customer.FirstName,
+		LastName:  customer.LastName,
+		Phone:     customer.Phone,
+	}
+	if err := decodePatch(r, req); err != nil {
+		writeError(w, err)
 		return
 	}
 
-	req := new(DTO.UpdateSomethingCustomerRequest)
-	err = json.NewDecoder(r.Body).Decode(req)
+	customer.FirstName = req.FirstName
+	customer.LastName = req.LastName
+	customer.Phone = req.Phone
+
+	err = s.repo.Update(r.Context(), &customer)
 	if err != nil {
-		responseError(w, http.StatusInternalServerError, err)
+		writeError(w, err)
 		return
 	}
 
-	switch {
-	case req.FirstName != nil:
-		customer.FirstName = *req.FirstName
-	case req.LastName != nil:
-		customer.LastName = *req.LastName
-	case req.Phone != nil:
-		customer.Phone = *req.Phone
+	setETag(w, customer.Version)
+	response(w, http.StatusOK, customer)
+}
+
+func (s *CustomerService) Delete(w http.ResponseWriter, r *http.Request) {
+	idString := r.PathValue("id")
+	id, err := strconv.Atoi(idString)
+	if err != nil {
+		writeError(w, invalidID())
+		return
 	}
 
-	err = s.db.Save(&customer).Error
+	version, err := ifMatchVersion(r, func() (uint, error) {
+		customer, err := s.repo.Get(r.Context(), uint(id))
+		return customer.Version, err
+	})
 	if err != nil {
-		responseError(w, http.StatusInternalServerError, err)
+		writeError(w, err)
 		return
 	}
 
+	err = s.repo.Delete(r.Context(), uint(id), version)
+	if err != nil {
+		writeDeleteError(w, err)
+		return
+	}
 	response(w, http.StatusNoContent, nil)
 }
 
-func (s *CustomerService) Delete(w http.ResponseWriter, r *http.Request) {
+// Restore brings back a deleted customer together with the trips that were
+// archived along with it.
+func (s *CustomerService) Restore(w http.ResponseWriter, r *http.Request) {
 	idString := r.PathValue("id")
 	id, err := strconv.Atoi(idString)
 	if err != nil {
-		responseError(w, http.StatusBadRequest, errors.New("invalid id"))
+		writeError(w, invalidID())
 		return
 	}
 
-	err = s.db.Delete(models.Customer{}, id).Error
+	customer, err := s.repo.Restore(r.Context(), uint(id))
 	if err != nil {
-		responseError(w, http.StatusInternalServerError, err)
+		writeError(w, err)
 		return
 	}
-	response(w, http.StatusNoContent, nil)
+
+	setETag(w, customer.Version)
+	response(w, http.StatusOK, customer)
 }
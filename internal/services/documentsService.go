@@ -0,0 +1,353 @@
+package services
+
+import (
+	"errors"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"taksopark/internal/DTO"
+	"taksopark/internal/files"
+	"taksopark/internal/models"
+	"taksopark/internal/repository"
+	"time"
+	"unicode/utf8"
+)
+
+// DocumentService serves the documents of a driver under
+// /drivers/{id}/documents. Scans of the documents are kept in files.
+type DocumentService struct {
+	repo    repository.DocumentRepository
+	drivers repository.DriverRepository
+	files   files.Store
+}
+
+func NewDocumentService(repo repository.DocumentRepository, drivers repository.DriverRepository, files files.Store) DocumentService {
+	return DocumentService{
+		repo:    repo,
+		drivers: drivers,
+		files:   files,
+	}
+}
+
+func documentWriteError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, repository.ErrNotFound):
+		writeError(w, newError(http.StatusNotFound, CodeNotFound, "document not found"))
+	case errors.Is(err, repository.ErrForeignKey):
+		writeError(w, newError(http.StatusNotFound, CodeNotFound, "driver not found"))
+	default:
+		writeError(w, err)
+	}
+}
+
+// licenceCategories are the categories a driving licence may list.
+var licenceCategories = []string{"A", "A1", "B", "B1", "BE", "C", "C1", "CE", "C1E", "D", "D1", "DE", "D1E", "M", "Tm", "Tb"}
+
+// documentFields checks the licence categories, which only a licence has.
+func documentFields(req *DTO.DocumentRequest) []DTO.FieldError {
+	if req.Categories == "" {
+		return nil
+	}
+	if req.Kind != string(models.DocumentLicence) {
+		return []DTO.FieldError{{Field: "categories", Message: "must be empty for documents other than a licence"}}
+	}
+	for _, c := range strings.Split(req.Categories, ",") {
+		if !slices.Contains(licenceCategories, strings.TrimSpace(c)) {
+			return []DTO.FieldError{{Field: "categories", Message: "must be licence categories separated by commas, e.g. B,C"}}
+		}
+	}
+	return nil
+}
+
+func applyDocumentRequest(doc *models.DriverDocument, req *DTO.DocumentRequest) {
+	categories := strings.Split(req.Categories, ",")
+	for i := range categories {
+		categories[i] = strings.TrimSpace(categories[i])
+	}
+
+	doc.Kind = models.DocumentKind(req.Kind)
+	doc.Number = req.Number
+	doc.Categories = strings.Join(categories, ",")
+	doc.IssuedAt = nil
+	if req.IssuedAt != nil {
+		issued := req.IssuedAt.UTC().Truncate(time.Microsecond)
+		doc.IssuedAt = &issued
+	}
+	doc.ExpiresAt = req.ExpiresAt.UTC().Truncate(time.Microsecond)
+}
+
+// documentPath returns the driver and document ids of the request path.
+func documentPath(r *http.Request) (driverID, docID uint, err error) {
+	driver, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		return 0, 0, invalidID()
+	}
+	doc, err := strconv.Atoi(r.PathValue("doc"))
+	if err != nil {
+		return 0, 0, invalidID()
+	}
+	return uint(driver), uint(doc), nil
+}
+
+// document loads the document of the request path. Documents of other
+// drivers are reported as missing.
+func (s *DocumentService) document(r *http.Request) (models.DriverDocument, error) {
+	driverID, docID, err := documentPath(r)
+	if err != nil {
+		return models.DriverDocument{}, err
+	}
+	doc, err := s.repo.Get(r.Context(), docID)
+	if err == nil && doc.DriverID != driverID {
+		err = repository.ErrNotFound
+	}
+	return doc, err
+}
+
+// modify changes the document of the request path.
+func (s *DocumentService) modify(r *http.Request, fn func(doc *models.DriverDocument)) (models.DriverDocument, error) {
+	driverID, docID, err := documentPath(r)
+	if err != nil {
+		return models.DriverDocument{}, err
+	}
+	return s.repo.Modify(r.Context(), docID, func(doc *models.DriverDocument) error {
+		if doc.DriverID != driverID {
+			return repository.ErrNotFound
+		}
+		if err := checkIfMatch(r, doc.Version); err != nil {
+			return err
+		}
+		fn(doc)
+		return nil
+	})
+}
+
+func (s *DocumentService) Create(w http.ResponseWriter, r *http.Request) {
+	idString := r.PathValue("id")
+	id, err := strconv.Atoi(idString)
+	if err != nil {
+		writeError(w, invalidID())
+		return
+	}
+
+	req := new(DTO.DocumentRequest)
+	if err := decode(r, req, func() []DTO.FieldError { return documentFields(req) }); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	doc := &models.DriverDocument{DriverID: uint(id)}
+	applyDocumentRequest(doc, req)
+
+	if err := s.repo.Create(r.Context(), doc); err != nil {
+		documentWriteError(w, err)
+		return
+	}
+
+	setETag(w, doc.Version)
+	response(w, http.StatusCreated, doc)
+}
+
+func (s *DocumentService) Get(w http.ResponseWriter, r *http.Request) {
+	doc, err := s.document(r)
+	if err != nil {
+		documentWriteError(w, err)
+		return
+	}
+
+	if notModified(w, r, etag(doc.Version)) {
+		return
+	}
+	response(w, http.StatusOK, doc)
+}
+
+var documentListSpec = listSpec{
+	pk:   "document_id",
+	sort: []string{"document_id", "expires_at"},
+	filters: map[string]filterSpec{
+		"kind": {column: "kind", op: repository.OpEq, parse: parseString},
+	},
+}
+
+func (s *DocumentService) GetAll(w http.ResponseWriter, r *http.Request) {
+	idString := r.PathValue("id")
+	id, err := strconv.Atoi(idString)
+	if err != nil {
+		writeError(w, invalidID())
+		return
+	}
+
+	params, err := parseListParams(r, documentListSpec)
+	if err != nil {
+		responseError(w, http.StatusBadRequest, err)
+		return
+	}
+	params.Filters = append(params.Filters, repository.Filter{Column: "driver_id", Op: repository.OpEq, Value: uint(id)})
+
+	if _, err := s.drivers.Get(r.Context(), uint(id)); err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			err = repository.ErrForeignKey
+		}
+		documentWriteError(w, err)
+		return
+	}
+
+	docs, total, err := s.repo.List(r.Context(), params)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	responseList(w, r, newPage(r, documentListSpec, params, docs, total, func(d models.DriverDocument) uint { return d.DocumentID }))
+}
+
+// Update replaces the fields of a document, its file stays.
+func (s *DocumentService) Update(w http.ResponseWriter, r *http.Request) {
+	req := new(DTO.DocumentRequest)
+	if err := decode(r, req, func() []DTO.FieldError { return documentFields(req) }); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	doc, err := s.modify(r, func(doc *models.DriverDocument) {
+		applyDocumentRequest(doc, req)
+	})
+	if err != nil {
+		documentWriteError(w, err)
+		return
+	}
+
+	setETag(w, doc.Version)
+	response(w, http.StatusOK, doc)
+}
+
+// Delete removes a document together with its file.
+func (s *DocumentService) Delete(w http.ResponseWriter, r *http.Request) {
+	doc, err := s.document(r)
+	if err != nil {
+		documentWriteError(w, err)
+		return
+	}
+
+	version, err := ifMatchVersion(r, func() (uint, error) { return doc.Version, nil })
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if err := s.repo.Delete(r.Context(), doc.DocumentID, version); err != nil {
+		documentWriteError(w, err)
+		return
+	}
+	if doc.FileKey != "" {
+		if err := s.files.Remove(doc.FileKey); err != nil {
+			log.Printf("remove document file %s: %v", doc.FileKey, err)
+		}
+	}
+	response(w, http.StatusNoContent, nil)
+}
+
+// documentTypes are the media types of files that can be uploaded.
+var documentTypes = []string{"application/pdf", "image/jpeg", "image/png"}
+
+// maxFileName is the length of the file_name column in characters.
+const maxFileName = 255
+
+// fileName returns the file name of a Content-Disposition header, or def.
+// Names that do not fit the file_name column are refused.
+func fileName(header, def string) (string, error) {
+	_, params, err := mime.ParseMediaType(header)
+	if err != nil || params["filename"] == "" {
+		return def, nil
+	}
+	name := filepath.Base(params["filename"])
+	if utf8.RuneCountInString(name) > maxFileName {
+		return "", newError(http.StatusBadRequest, CodeBadRequest, "file name exceeds "+strconv.Itoa(maxFileName)+" characters")
+	}
+	return name, nil
+}
+
+// Upload stores the request body as the file of a document, replacing the
+// previous one. The file name is taken from Content-Disposition.
+func (s *DocumentService) Upload(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	contentType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || !slices.Contains(documentTypes, contentType) {
+		writeError(w, newError(http.StatusUnsupportedMediaType, CodeUnsupportedMedia, "file must be "+strings.Join(documentTypes, ", ")))
+		return
+	}
+	name, err := fileName(r.Header.Get("Content-Disposition"), "document")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	key, size, err := s.files.Save(r.Body)
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge):
+		writeError(w, newError(http.StatusRequestEntityTooLarge, CodeTooLarge, "file exceeds "+strconv.FormatInt(tooLarge.Limit, 10)+" bytes"))
+		return
+	case err != nil:
+		writeError(w, err)
+		return
+	case size == 0:
+		s.files.Remove(key)
+		writeError(w, newError(http.StatusBadRequest, CodeBadRequest, "file is empty"))
+		return
+	}
+
+	var previous string
+	doc, err := s.modify(r, func(doc *models.DriverDocument) {
+		previous = doc.FileKey
+		doc.FileName = name
+		doc.ContentType = contentType
+		doc.FileSize = size
+		doc.FileKey = key
+	})
+	if err != nil {
+		s.files.Remove(key)
+		documentWriteError(w, err)
+		return
+	}
+	if previous != "" {
+		if err := s.files.Remove(previous); err != nil {
+			log.Printf("remove document file %s: %v", previous, err)
+		}
+	}
+
+	setETag(w, doc.Version)
+	response(w, http.StatusOK, doc)
+}
+
+// Download sends the file of a document.
+func (s *DocumentService) Download(w http.ResponseWriter, r *http.Request) {
+	doc, err := s.document(r)
+	if err != nil {
+		documentWriteError(w, err)
+		return
+	}
+	if doc.FileKey == "" {
+		writeError(w, newError(http.StatusNotFound, CodeNotFound, "document has no file"))
+		return
+	}
+
+	f, err := s.files.Open(doc.FileKey)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer f.Close()
+
+	w.Header().Set("Content-Type", doc.ContentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(doc.FileSize, 10))
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": doc.FileName}))
+	if _, err := io.Copy(w, f); err != nil {
+		log.Println(err)
+	}
+}
@@ -1,33 +1,29 @@
 package services
 
 import (
-	"encoding/json"
-	"errors"
 	"net/http"
 	"strconv"
 	"taksopark/internal/DTO"
 	"taksopark/internal/models"
-
-	"gorm.io/gorm"
+	"taksopark/internal/repository"
 )
 
 type DriverService struct {
-	db *gorm.DB
+	repo repository.DriverRepository
 }
 
-func NewDriverService(init_db *gorm.DB) DriverService {
+func NewDriverService(repo repository.DriverRepository) DriverService {
 	return DriverService{
-		db: init_db,
+		repo: repo,
 	}
 }
 
 func (s *DriverService) Create(w http.ResponseWriter, r *http.Request) {
 	req := new(DTO.CreateDriverRequest)
-	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
-		responseError(w, http.StatusBadRequest, err)
+	if err := decode(r, req); err != nil {
+		writeError(w, err)
 		return
 	}
-	defer r.Body.Close()
 
 	driver := &models.Driver{
 		FirstName:     req.FirstName,
@@ -35,40 +31,59 @@ func (s *DriverService) Create(w http.ResponseWriter, r *http.Request) {
 		LisenceNumber: req.LisenceNumber,
 	}
 
-	if err := s.db.Create(driver).Error; err != nil {
-		responseError(w, http.StatusInternalServerError, err)
+	if err := s.repo.Create(r.Context(), driver); err != nil {
+		writeError(w, err)
 		return
 	}
 
+	setETag(w, driver.Version)
 	response(w, http.StatusCreated, driver)
 }
 
+var driverListSpec = listSpec{
+	pk:   "driver_id",
+	sort: []string{"driver_id", "first_name", "last_name", "lisence_number"},
+	filters: map[string]filterSpec{
+		"first_name": {column: "first_name", op: repository.OpEq, parse: parseString},
+		"last_name":  {column: "last_name", op: repository.OpEq, parse: parseString},
+		"online":     {column: "online", op: repository.OpEq, parse: parseBool},
+	},
+	deleted: true,
+}
+
 func (s *DriverService) GetAll(w http.ResponseWriter, r *http.Request) {
-	var drivers []models.Driver
-	err := s.db.Find(&drivers).Error
+	params, err := parseListParams(r, driverListSpec)
 	if err != nil {
-		responseError(w, http.StatusInternalServerError, err)
+		responseError(w, http.StatusBadRequest, err)
 		return
 	}
-	response(w, http.StatusOK, drivers)
+
+	drivers, total, err := s.repo.List(r.Context(), params)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	responseList(w, r, newPage(r, driverListSpec, params, drivers, total, func(d models.Driver) uint { return d.DriverID }))
 }
 
 func (s *DriverService) Get(w http.ResponseWriter, r *http.Request) {
-	var driver models.Driver
-
 	idString := r.PathValue("id")
 	id, err := strconv.Atoi(idString)
 	if err != nil {
-		responseError(w, http.StatusBadRequest, errors.New("invalid id"))
+		writeError(w, invalidID())
 		return
 	}
 
-	err = s.db.Find(&driver, id).Error
+	driver, err := s.repo.Get(r.Context(), uint(id))
 	if err != nil {
-		responseError(w, http.StatusNotFound, err)
+		writeError(w, err)
 		return
 	}
 
+	if notModified(w, r, etag(driver.Version)) {
+		return
+	}
 	response(w, http.StatusOK, driver)
 }
 
@@ -76,97 +91,124 @@ func (s *DriverService) Update(w http.ResponseWriter, r *http.Request) {
 	idString := r.PathValue("id")
 	id, err := strconv.Atoi(idString)
 	if err != nil {
-		responseError(w, http.StatusBadRequest, errors.New("invalid id"))
+		writeError(w, invalidID())
 		return
 	}
 
 	req := new(DTO.UpdateDriverRequest)
-	err = json.NewDecoder(r.Body).Decode(req)
+	err = decode(r, req)
 	if err != nil {
-		responseError(w, http.StatusBadRequest, err)
+		writeError(w, err)
 		return
 	}
 
-	var driver models.Driver
-	err = s.db.Find(&driver, id).Error
+	driver, err := s.repo.Get(r.Context(), uint(id))
 	if err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			responseError(w, http.StatusNotFound, err)
-			return
-		}
-		responseError(w, http.StatusInternalServerError, err)
+		writeError(w, err)
+		return
+	}
+	if err := checkIfMatch(r, driver.Version); err != nil {
+		writeError(w, err)
 		return
 	}
 
 	driver.FirstName = req.FirstName
 	driver.LastName = req.LastName
-	driver.LisenceNumber = req.LicenseNumber
+	driver.LisenceNumber = req.LisenceNumber
 
-	err = s.db.Save(&driver).Error
+	err = s.repo.Update(r.Context(), &driver)
 	if err != nil {
-		response(w, http.StatusInternalServerError, err)
+		writeError(w, err)
 		return
 	}
 
-	response(w, http.StatusOK, nil)
+	setETag(w, driver.Version)
+	response(w, http.StatusOK, driver)
 }
 
 func (s *DriverService) UpdateSomething(w http.ResponseWriter, r *http.Request) {
 	idString := r.PathValue("id")
 	id, err := strconv.Atoi(idString)
 	if err != nil {
-		responseError(w, http.StatusBadRequest, errors.New("invalid id"))
+		writeError(w, invalidID())
 		return
 	}
 
-	var driver models.Driver
-	err = s.db.Find(&driver, id).Error
+	driver, err := s.repo.Get(r.Context(), uint(id))
 	if err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			responseError(w, http.StatusNotFound, err)
-			return
-		}
-		responseError(w, http.StatusInternalServerError, err)
+		writeError(w, err)
+		return
+	}
+	if err := checkIfMatch(r, driver.Version); err != nil {
+		writeError(w, err)
 		return
 	}
 
-	req := new(DTO.UpdateSomethingDriverRequest)
-	err = json.NewDecoder(r.Body).Decode(req)
+	req := &DTO.UpdateSomethingDriverRequest{
+		FirstName:     driver.FirstName,
+		LastName:      driver.LastName,
+		LisenceNumber: driver.LisenceNumber,
+	}
+	if err := decodePatch(r, req); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	driver.FirstName = req.FirstName
+	driver.LastName = req.LastName
+	driver.LisenceNumber = req.LisenceNumber
+
+	err = s.repo.Update(r.Context(), &driver)
 	if err != nil {
-		responseError(w, http.StatusInternalServerError, err)
+		writeError(w, err)
 		return
 	}
 
-	switch {
-	case req.FirstName != nil:
-		driver.FirstName = *req.FirstName
-	case req.LastName != nil:
-		driver.LastName = *req.LastName
-	case req.LisenceNumber != nil:
-		driver.LisenceNumber = *req.LisenceNumber
+	setETag(w, driver.Version)
+	response(w, http.StatusOK, driver)
+}
+
+func (s *DriverService) Delete(w http.ResponseWriter, r *http.Request) {
+	idString := r.PathValue("id")
+	id, err := strconv.Atoi(idString)
+	if err != nil {
+		writeError(w, invalidID())
+		return
 	}
 
-	err = s.db.Save(&driver).Error
+	version, err := ifMatchVersion(r, func() (uint, error) {
+		driver, err := s.repo.Get(r.Context(), uint(id))
+		return driver.Version, err
+	})
 	if err != nil {
-		responseError(w, http.StatusInternalServerError, err)
+		writeError(w, err)
 		return
 	}
 
+	err = s.repo.Delete(r.Context(), uint(id), version)
+	if err != nil {
+		writeDeleteError(w, err)
+		return
+	}
 	response(w, http.StatusNoContent, nil)
 }
 
-func (s *DriverService) Delete(w http.ResponseWriter, r *http.Request) {
+// Restore brings back a deleted driver together with the trips that were
+// archived along with it.
+func (s *DriverService) Restore(w http.ResponseWriter, r *http.Request) {
 	idString := r.PathValue("id")
 	id, err := strconv.Atoi(idString)
 	if err != nil {
-		responseError(w, http.StatusBadRequest, errors.New("invalid id"))
+		writeError(w, invalidID())
 		return
 	}
 
-	err = s.db.Delete(models.Driver{}, id).Error
+	driver, err := s.repo.Restore(r.Context(), uint(id))
 	if err != nil {
-		responseError(w, http.StatusInternalServerError, err)
+		writeError(w, err)
 		return
 	}
-	response(w, http.StatusNoContent, nil)
+
+	setETag(w, driver.Version)
+	response(w, http.StatusOK, driver)
 }
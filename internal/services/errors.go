@@ -0,0 +1,148 @@
+package services
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"taksopark/internal/DTO"
+	"taksopark/internal/models"
+	"taksopark/internal/repository"
+)
+
+const (
+	CodeBadRequest         = "bad_request"
+	CodeUnauthorized       = "unauthorized"
+	CodeForbidden          = "forbidden"
+	CodeNotFound           = "not_found"
+	CodeConflict           = "conflict"
+	CodeDuplicate          = "duplicate"
+	CodeInUse              = "in_use"
+	CodeInvalidReference   = "invalid_reference"
+	CodeOverlap            = "overlap"
+	CodeIllegalTransition  = "illegal_transition"
+	CodeNoTariff           = "no_tariff"
+	CodeValidation         = "validation_failed"
+	CodeTooLarge           = "too_large"
+	CodeUnsupportedMedia   = "unsupported_media_type"
+	CodePatchFailed        = "patch_failed"
+	CodePreconditionFailed = "precondition_failed"
+	CodeRevoked            = "revoked"
+	CodeDriverOffline      = "driver_offline"
+	CodeNoShift            = "no_shift"
+	CodeDocumentsExpired   = "documents_expired"
+	CodeCarUnavailable     = "car_unavailable"
+	CodeWrongClass         = "wrong_class"
+	CodeInternal           = "internal_error"
+)
+
+var statusCodes = map[int]string{
+	http.StatusBadRequest:          CodeBadRequest,
+	http.StatusUnauthorized:        CodeUnauthorized,
+	http.StatusForbidden:           CodeForbidden,
+	http.StatusNotFound:            CodeNotFound,
+	http.StatusConflict:            CodeConflict,
+	http.StatusUnprocessableEntity: CodeValidation,
+	http.StatusInternalServerError: CodeInternal,
+}
+
+// apiError is an error that already knows how it is reported to the client.
+type apiError struct {
+	status int
+	body   DTO.Error
+}
+
+func (e *apiError) Error() string {
+	return e.body.Message
+}
+
+func newError(status int, code, message string) *apiError {
+	return &apiError{status: status, body: DTO.Error{Code: code, Message: message}}
+}
+
+func (e *apiError) withDetail(key string, value any) *apiError {
+	if e.body.Details == nil {
+		e.body.Details = map[string]any{}
+	}
+	e.body.Details[key] = value
+	return e
+}
+
+func validationError(fields ...DTO.FieldError) *apiError {
+	e := newError(http.StatusUnprocessableEntity, CodeValidation, "request is invalid")
+	e.body.Fields = fields
+	return e
+}
+
+// responseError writes err with the given status. An apiError keeps its own
+// status and body. The text of server errors is logged, not sent, so that
+// database details do not leak to clients.
+func responseError(w http.ResponseWriter, code int, err error) {
+	var body DTO.Error
+	var ae *apiError
+	if errors.As(err, &ae) {
+		code, body = ae.status, ae.body
+	} else {
+		body = DTO.Error{Code: statusCodes[code], Message: err.Error()}
+	}
+	if body.Code == "" {
+		body.Code = http.StatusText(code)
+	}
+
+	body.RequestID = w.Header().Get(requestIDHeader)
+	if code >= http.StatusInternalServerError {
+		log.Printf("request %s: %v", body.RequestID, err)
+		body.Message = "internal server error"
+	}
+	response(w, code, DTO.ErrorResponse{Error: body})
+}
+
+// writeError reports an error returned by a repository or a model.
+func writeError(w http.ResponseWriter, err error) {
+	var overlap *repository.OverlapError
+	switch {
+	case errors.As(err, new(*apiError)):
+		responseError(w, http.StatusInternalServerError, err)
+	case errors.Is(err, repository.ErrNotFound):
+		writeError(w, newError(http.StatusNotFound, CodeNotFound, "record not found"))
+	case errors.Is(err, repository.ErrDuplicate):
+		writeError(w, newError(http.StatusConflict, CodeDuplicate, "a record with the same unique value already exists"))
+	case errors.Is(err, repository.ErrStale):
+		writeError(w, newError(http.StatusConflict, CodeConflict, "record was changed by another request, reload it and retry"))
+	case errors.Is(err, repository.ErrForeignKey):
+		writeError(w, newError(http.StatusUnprocessableEntity, CodeInvalidReference, "referenced record does not exist"))
+	case errors.As(err, &overlap):
+		writeError(w, newError(http.StatusConflict, CodeOverlap, overlap.Error()).
+			withDetail("trip_id", overlap.TripID).
+			withDetail("resource", overlap.Resource).
+			withDetail("id", overlap.ID))
+	case errors.Is(err, repository.ErrNoShift):
+		writeError(w, newError(http.StatusConflict, CodeNoShift, err.Error()))
+	case errors.Is(err, models.ErrIllegalTransition):
+		writeError(w, newError(http.StatusConflict, CodeIllegalTransition, err.Error()))
+	case errors.Is(err, models.ErrInvalidTimes):
+		writeError(w, validationError(DTO.FieldError{Field: "end_time", Message: err.Error()}))
+	default:
+		responseError(w, http.StatusInternalServerError, err)
+	}
+}
+
+// writeDeleteError reports a failed delete. A foreign key violation means
+// the record is still referenced.
+func writeDeleteError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, repository.ErrForeignKey):
+		writeError(w, newError(http.StatusConflict, CodeInUse, "record is still referenced by other records"))
+	case errors.Is(err, repository.ErrActiveTrips):
+		writeError(w, newError(http.StatusConflict, CodeInUse, "record has trips that are not finished yet"))
+	default:
+		writeError(w, err)
+	}
+}
+
+func invalidID() *apiError {
+	return newError(http.StatusBadRequest, CodeBadRequest, "invalid id")
+}
+
+func invalidBody(err error) *apiError {
+	return newError(http.StatusBadRequest, CodeBadRequest, "invalid request body: "+err.Error())
+}
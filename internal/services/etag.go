@@ -0,0 +1,97 @@
+package services
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// etag is the entity tag of a record at the given version.
+func etag(version uint) string {
+	return `"` + strconv.FormatUint(uint64(version), 10) + `"`
+}
+
+func setETag(w http.ResponseWriter, version uint) {
+	w.Header().Set("ETag", etag(version))
+}
+
+// matchesETag reports whether header, an If-Match or If-None-Match list,
+// contains tag. The weak comparison ignores the W/ prefix.
+func matchesETag(header, tag string, weak bool) bool {
+	if weak {
+		tag = strings.TrimPrefix(tag, "W/")
+	}
+	for _, t := range strings.Split(header, ",") {
+		t = strings.TrimSpace(t)
+		if weak {
+			t = strings.TrimPrefix(t, "W/")
+		}
+		if t == "*" || t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// checkIfMatch fails with 412 when the request has an If-Match header that
+// does not name the current version of the record.
+func checkIfMatch(r *http.Request, version uint) error {
+	header := r.Header.Get("If-Match")
+	if header == "" || matchesETag(header, etag(version), false) {
+		return nil
+	}
+	return newError(http.StatusPreconditionFailed, CodePreconditionFailed, "record has changed since the version in If-Match").
+		withDetail("version", version)
+}
+
+// ifMatchVersion returns the version a conditional request expects after
+// checking it against the current one, or 0 for an unconditional request.
+func ifMatchVersion(r *http.Request, current func() (uint, error)) (uint, error) {
+	if r.Header.Get("If-Match") == "" {
+		return 0, nil
+	}
+	version, err := current()
+	if err != nil {
+		return 0, err
+	}
+	return version, checkIfMatch(r, version)
+}
+
+// notModified sets the ETag header and answers 304 when the If-None-Match
+// header shows that the client already has this representation.
+func notModified(w http.ResponseWriter, r *http.Request, tag string) bool {
+	w.Header().Set("ETag", tag)
+	header := r.Header.Get("If-None-Match")
+	if header == "" || !matchesETag(header, tag, true) {
+		return false
+	}
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// responseList writes a list with a weak ETag computed from its body, so
+// that clients polling it get 304 until something in it changes.
+func responseList(w http.ResponseWriter, r *http.Request, data any) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(data); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	sum := sha256.Sum256(buf.Bytes())
+	if notModified(w, r, `W/"`+hex.EncodeToString(sum[:16])+`"`) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		log.Println(err)
+	}
+}
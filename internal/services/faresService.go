@@ -0,0 +1,126 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"taksopark/internal/DTO"
+	"taksopark/internal/config"
+	"taksopark/internal/models"
+	"taksopark/internal/repository"
+	"time"
+)
+
+var errNoTariff = errors.New("no tariff for car class")
+
+// pricer finds the tariff for a car and prices trips with it.
+type pricer struct {
+	tariffs repository.TariffRepository
+	cars    repository.CarRepository
+	loc     *time.Location
+	speed   float64
+}
+
+func newPricer(tariffs repository.TariffRepository, cars repository.CarRepository, cfg config.FaresConfig) pricer {
+	// The time zone is checked by config.Validate.
+	loc, err := cfg.Location()
+	if err != nil {
+		loc = time.UTC
+	}
+	return pricer{tariffs: tariffs, cars: cars, loc: loc, speed: float64(cfg.AverageSpeed)}
+}
+
+func (p pricer) forClass(ctx context.Context, class string) (models.Tariff, error) {
+	tariffs, _, err := p.tariffs.List(ctx, repository.ListParams{
+		Filters: []repository.Filter{{Column: "class", Op: repository.OpEq, Value: class}},
+		Limit:   1,
+	})
+	if err != nil {
+		return models.Tariff{}, err
+	}
+	if len(tariffs) == 0 {
+		return models.Tariff{}, fmt.Errorf("%w %q", errNoTariff, class)
+	}
+	return tariffs[0], nil
+}
+
+// forCar returns the tariff of the car's class. A missing car is reported
+// as a foreign key violation, like a trip referencing it would be.
+func (p pricer) forCar(ctx context.Context, carID uint) (models.Tariff, error) {
+	car, err := p.cars.Get(ctx, carID)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return models.Tariff{}, repository.ErrForeignKey
+		}
+		return models.Tariff{}, err
+	}
+	return p.forClass(ctx, car.Model.Class)
+}
+
+// price sets the fare of a completed trip.
+func (p pricer) price(ctx context.Context, trip *models.Trip) error {
+	if trip.Status != models.TripCompleted || trip.CarID == nil {
+		return nil
+	}
+	tariff, err := p.forCar(ctx, *trip.CarID)
+	if err != nil {
+		return err
+	}
+	trip.Price(&tariff, p.loc)
+	return nil
+}
+
+type FareService struct {
+	pricer pricer
+}
+
+func NewFareService(tariffs repository.TariffRepository, cars repository.CarRepository, cfg config.FaresConfig) FareService {
+	return FareService{
+		pricer: newPricer(tariffs, cars, cfg),
+	}
+}
+
+func (s *FareService) Estimate(w http.ResponseWriter, r *http.Request) {
+	req := new(DTO.FareEstimateRequest)
+	if err := decode(r, req); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	class := req.Class
+	if class == "" {
+		class = models.DefaultClass
+	}
+
+	var tariff models.Tariff
+	var err error
+	if req.CarID != nil {
+		tariff, err = s.pricer.forCar(r.Context(), *req.CarID)
+	} else {
+		tariff, err = s.pricer.forClass(r.Context(), class)
+	}
+	switch {
+	case errors.Is(err, repository.ErrForeignKey):
+		writeError(w, newError(http.StatusUnprocessableEntity, CodeInvalidReference, "car does not exist"))
+		return
+	case errors.Is(err, errNoTariff):
+		writeError(w, newError(http.StatusNotFound, CodeNoTariff, err.Error()))
+		return
+	case err != nil:
+		writeError(w, err)
+		return
+	}
+
+	km := models.Distance(req.StartLat, req.StartLon, req.EndLat, req.EndLon)
+	start := time.Now()
+	if req.StartTime != nil {
+		start = *req.StartTime
+	}
+	duration := time.Duration(km / s.pricer.speed * float64(time.Hour))
+	if req.DurationMinutes != nil {
+		duration = time.Duration(*req.DurationMinutes * float64(time.Minute))
+	}
+
+	response(w, http.StatusOK, tariff.Fare(km, start, duration, s.pricer.loc))
+}
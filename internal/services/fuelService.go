@@ -0,0 +1,278 @@
+package services
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"taksopark/internal/DTO"
+	"taksopark/internal/models"
+	"taksopark/internal/repository"
+	"time"
+)
+
+// FuelService serves the odometer readings and fuel entries of cars.
+type FuelService struct {
+	readings repository.OdometerRepository
+	entries  repository.FuelRepository
+}
+
+func NewFuelService(readings repository.OdometerRepository, entries repository.FuelRepository) FuelService {
+	return FuelService{
+		readings: readings,
+		entries:  entries,
+	}
+}
+
+// fuelWriteError reports a reading that contradicts the other readings of
+// the car as an invalid odometer. what names the missing row.
+func fuelWriteError(w http.ResponseWriter, err error, what string) {
+	var order *repository.OdometerError
+	switch {
+	case errors.As(err, &order):
+		writeError(w, validationError(DTO.FieldError{Field: "odometer", Message: order.Error()}))
+	case errors.Is(err, repository.ErrNotFound):
+		writeError(w, newError(http.StatusNotFound, CodeNotFound, what+" not found"))
+	case errors.Is(err, repository.ErrForeignKey):
+		writeError(w, newError(http.StatusUnprocessableEntity, CodeInvalidReference, "car does not exist"))
+	default:
+		writeError(w, err)
+	}
+}
+
+// readTime returns t, or now when t is nil.
+func readTime(t *time.Time) time.Time {
+	if t == nil {
+		return now()
+	}
+	return t.UTC().Truncate(time.Microsecond)
+}
+
+func notInFuture(field string, t *time.Time) []DTO.FieldError {
+	if t != nil && t.After(time.Now()) {
+		return []DTO.FieldError{{Field: field, Message: "must not be in the future"}}
+	}
+	return nil
+}
+
+// CreateReading records the odometer of a car, it must not be lower than
+// earlier readings nor higher than later ones.
+func (s *FuelService) CreateReading(w http.ResponseWriter, r *http.Request) {
+	req := new(DTO.OdometerRequest)
+	if err := decode(r, req, func() []DTO.FieldError { return notInFuture("read_at", req.ReadAt) }); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	reading := &models.OdometerReading{CarID: req.CarID, Odometer: *req.Odometer, ReadAt: readTime(req.ReadAt)}
+	if err := s.readings.Create(r.Context(), reading); err != nil {
+		fuelWriteError(w, err, "odometer reading")
+		return
+	}
+
+	setETag(w, reading.Version)
+	response(w, http.StatusCreated, reading)
+}
+
+func (s *FuelService) GetReading(w http.ResponseWriter, r *http.Request) {
+	idString := r.PathValue("id")
+	id, err := strconv.Atoi(idString)
+	if err != nil {
+		writeError(w, invalidID())
+		return
+	}
+
+	reading, err := s.readings.Get(r.Context(), uint(id))
+	if err != nil {
+		fuelWriteError(w, err, "odometer reading")
+		return
+	}
+
+	if notModified(w, r, etag(reading.Version)) {
+		return
+	}
+	response(w, http.StatusOK, reading)
+}
+
+var readingListSpec = listSpec{
+	pk:   "reading_id",
+	sort: []string{"reading_id", "read_at"},
+	filters: map[string]filterSpec{
+		"car_id":    {column: "car_id", op: repository.OpEq, parse: parseUint},
+		"read_from": {column: "read_at", op: repository.OpGte, parse: parseTime},
+		"read_to":   {column: "read_at", op: repository.OpLte, parse: parseTime},
+	},
+}
+
+func (s *FuelService) GetReadings(w http.ResponseWriter, r *http.Request) {
+	params, err := parseListParams(r, readingListSpec)
+	if err != nil {
+		responseError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	readings, total, err := s.readings.List(r.Context(), params)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	responseList(w, r, newPage(r, readingListSpec, params, readings, total, func(o models.OdometerReading) uint { return o.ReadingID }))
+}
+
+func (s *FuelService) DeleteReading(w http.ResponseWriter, r *http.Request) {
+	idString := r.PathValue("id")
+	id, err := strconv.Atoi(idString)
+	if err != nil {
+		writeError(w, invalidID())
+		return
+	}
+
+	version, err := ifMatchVersion(r, func() (uint, error) {
+		reading, err := s.readings.Get(r.Context(), uint(id))
+		return reading.Version, err
+	})
+	if err != nil {
+		fuelWriteError(w, err, "odometer reading")
+		return
+	}
+
+	if err = s.readings.Delete(r.Context(), uint(id), version); err != nil {
+		fuelWriteError(w, err, "odometer reading")
+		return
+	}
+	response(w, http.StatusNoContent, nil)
+}
+
+// applyFuelRequest copies req into entry, it was filled now unless
+// filled_at is given.
+func applyFuelRequest(entry *models.FuelEntry, req *DTO.FuelRequest) {
+	entry.CarID = req.CarID
+	entry.FilledAt = readTime(req.FilledAt)
+	entry.Odometer = *req.Odometer
+	entry.Amount = req.Amount
+	entry.Unit = models.FuelUnit(req.Unit)
+	entry.Price = req.Price
+	entry.Station = req.Station
+}
+
+// Create records a refuelling or a charge, its odometer is checked like an
+// odometer reading.
+func (s *FuelService) Create(w http.ResponseWriter, r *http.Request) {
+	req := new(DTO.FuelRequest)
+	if err := decode(r, req, func() []DTO.FieldError { return notInFuture("filled_at", req.FilledAt) }); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	entry := &models.FuelEntry{}
+	applyFuelRequest(entry, req)
+
+	if err := s.entries.Create(r.Context(), entry); err != nil {
+		fuelWriteError(w, err, "fuel entry")
+		return
+	}
+
+	setETag(w, entry.Version)
+	response(w, http.StatusCreated, entry)
+}
+
+func (s *FuelService) Get(w http.ResponseWriter, r *http.Request) {
+	idString := r.PathValue("id")
+	id, err := strconv.Atoi(idString)
+	if err != nil {
+		writeError(w, invalidID())
+		return
+	}
+
+	entry, err := s.entries.Get(r.Context(), uint(id))
+	if err != nil {
+		fuelWriteError(w, err, "fuel entry")
+		return
+	}
+
+	if notModified(w, r, etag(entry.Version)) {
+		return
+	}
+	response(w, http.StatusOK, entry)
+}
+
+var fuelListSpec = listSpec{
+	pk:   "entry_id",
+	sort: []string{"entry_id", "filled_at"},
+	filters: map[string]filterSpec{
+		"car_id":      {column: "car_id", op: repository.OpEq, parse: parseUint},
+		"unit":        {column: "unit", op: repository.OpEq, parse: parseString},
+		"filled_from": {column: "filled_at", op: repository.OpGte, parse: parseTime},
+		"filled_to":   {column: "filled_at", op: repository.OpLte, parse: parseTime},
+	},
+}
+
+func (s *FuelService) GetAll(w http.ResponseWriter, r *http.Request) {
+	params, err := parseListParams(r, fuelListSpec)
+	if err != nil {
+		responseError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	entries, total, err := s.entries.List(r.Context(), params)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	responseList(w, r, newPage(r, fuelListSpec, params, entries, total, func(e models.FuelEntry) uint { return e.EntryID }))
+}
+
+func (s *FuelService) Update(w http.ResponseWriter, r *http.Request) {
+	idString := r.PathValue("id")
+	id, err := strconv.Atoi(idString)
+	if err != nil {
+		writeError(w, invalidID())
+		return
+	}
+
+	req := new(DTO.FuelRequest)
+	if err := decode(r, req, func() []DTO.FieldError { return notInFuture("filled_at", req.FilledAt) }); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	entry, err := s.entries.Modify(r.Context(), uint(id), func(entry *models.FuelEntry) error {
+		if err := checkIfMatch(r, entry.Version); err != nil {
+			return err
+		}
+		applyFuelRequest(entry, req)
+		return nil
+	})
+	if err != nil {
+		fuelWriteError(w, err, "fuel entry")
+		return
+	}
+
+	setETag(w, entry.Version)
+	response(w, http.StatusOK, entry)
+}
+
+func (s *FuelService) Delete(w http.ResponseWriter, r *http.Request) {
+	idString := r.PathValue("id")
+	id, err := strconv.Atoi(idString)
+	if err != nil {
+		writeError(w, invalidID())
+		return
+	}
+
+	version, err := ifMatchVersion(r, func() (uint, error) {
+		entry, err := s.entries.Get(r.Context(), uint(id))
+		return entry.Version, err
+	})
+	if err != nil {
+		fuelWriteError(w, err, "fuel entry")
+		return
+	}
+
+	if err = s.entries.Delete(r.Context(), uint(id), version); err != nil {
+		fuelWriteError(w, err, "fuel entry")
+		return
+	}
+	response(w, http.StatusNoContent, nil)
+}
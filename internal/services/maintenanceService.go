@@ -0,0 +1,424 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"taksopark/internal/DTO"
+	"taksopark/internal/models"
+	"taksopark/internal/repository"
+	"time"
+)
+
+// MaintenanceService serves the maintenance records of cars and the
+// maintenance rules of car models, and tells whether a car can take trips.
+type MaintenanceService struct {
+	repo  repository.MaintenanceRepository
+	rules repository.MaintenanceRuleRepository
+	query repository.QueryRepository
+}
+
+func NewMaintenanceService(repo repository.MaintenanceRepository, rules repository.MaintenanceRuleRepository, query repository.QueryRepository) MaintenanceService {
+	return MaintenanceService{
+		repo:  repo,
+		rules: rules,
+		query: query,
+	}
+}
+
+func maintenanceWriteError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, repository.ErrNotFound):
+		writeError(w, newError(http.StatusNotFound, CodeNotFound, "maintenance record not found"))
+	case errors.Is(err, repository.ErrForeignKey):
+		writeError(w, newError(http.StatusUnprocessableEntity, CodeInvalidReference, "car does not exist"))
+	case errors.Is(err, models.ErrMaintenanceFinished):
+		writeError(w, newError(http.StatusConflict, CodeConflict, err.Error()))
+	default:
+		writeError(w, err)
+	}
+}
+
+// maintenanceFields checks that the service did not start or finish in
+// the future.
+func maintenanceFields(req *DTO.MaintenanceRequest) []DTO.FieldError {
+	var fields []DTO.FieldError
+	if req.StartedAt != nil && req.StartedAt.After(time.Now()) {
+		fields = append(fields, DTO.FieldError{Field: "started_at", Message: "must not be in the future"})
+	}
+	if req.FinishedAt != nil && req.FinishedAt.After(time.Now()) {
+		fields = append(fields, DTO.FieldError{Field: "finished_at", Message: "must not be in the future"})
+	}
+	return fields
+}
+
+// applyMaintenanceRequest copies req into rec. The service starts now
+// unless started_at is given.
+func applyMaintenanceRequest(rec *models.MaintenanceRecord, req *DTO.MaintenanceRequest) {
+	rec.CarID = req.CarID
+	rec.ServiceType = strings.TrimSpace(req.ServiceType)
+	rec.Workshop = req.Workshop
+	rec.Odometer = *req.Odometer
+	rec.Cost = req.Cost
+	rec.StartedAt = now()
+	if req.StartedAt != nil {
+		rec.StartedAt = req.StartedAt.UTC().Truncate(time.Microsecond)
+	}
+	rec.FinishedAt = nil
+	if req.FinishedAt != nil {
+		finished := req.FinishedAt.UTC().Truncate(time.Microsecond)
+		rec.FinishedAt = &finished
+	}
+}
+
+// Create records a service. Without finished_at the car is in the
+// workshop from then on.
+func (s *MaintenanceService) Create(w http.ResponseWriter, r *http.Request) {
+	req := new(DTO.MaintenanceRequest)
+	if err := decode(r, req, func() []DTO.FieldError { return maintenanceFields(req) }); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	rec := &models.MaintenanceRecord{}
+	applyMaintenanceRequest(rec, req)
+
+	if err := s.repo.Create(r.Context(), rec); err != nil {
+		maintenanceWriteError(w, err)
+		return
+	}
+
+	setETag(w, rec.Version)
+	response(w, http.StatusCreated, rec)
+}
+
+func (s *MaintenanceService) Get(w http.ResponseWriter, r *http.Request) {
+	idString := r.PathValue("id")
+	id, err := strconv.Atoi(idString)
+	if err != nil {
+		writeError(w, invalidID())
+		return
+	}
+
+	rec, err := s.repo.Get(r.Context(), uint(id))
+	if err != nil {
+		maintenanceWriteError(w, err)
+		return
+	}
+
+	if notModified(w, r, etag(rec.Version)) {
+		return
+	}
+	response(w, http.StatusOK, rec)
+}
+
+var maintenanceListSpec = listSpec{
+	pk:   "record_id",
+	sort: []string{"record_id", "started_at"},
+	filters: map[string]filterSpec{
+		"car_id":       {column: "car_id", op: repository.OpEq, parse: parseUint},
+		"service_type": {column: "service_type", op: repository.OpEq, parse: parseString},
+		"open":         {column: "finished_at", op: repository.OpNull, parse: parseBool},
+		"started_from": {column: "started_at", op: repository.OpGte, parse: parseTime},
+		"started_to":   {column: "started_at", op: repository.OpLte, parse: parseTime},
+	},
+}
+
+func (s *MaintenanceService) GetAll(w http.ResponseWriter, r *http.Request) {
+	params, err := parseListParams(r, maintenanceListSpec)
+	if err != nil {
+		responseError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	records, total, err := s.repo.List(r.Context(), params)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	responseList(w, r, newPage(r, maintenanceListSpec, params, records, total, func(m models.MaintenanceRecord) uint { return m.RecordID }))
+}
+
+// modify changes the record of the request path.
+func (s *MaintenanceService) modify(w http.ResponseWriter, r *http.Request, fn func(rec *models.MaintenanceRecord) error) {
+	idString := r.PathValue("id")
+	id, err := strconv.Atoi(idString)
+	if err != nil {
+		writeError(w, invalidID())
+		return
+	}
+
+	rec, err := s.repo.Modify(r.Context(), uint(id), func(rec *models.MaintenanceRecord) error {
+		if err := checkIfMatch(r, rec.Version); err != nil {
+			return err
+		}
+		return fn(rec)
+	})
+	if err != nil {
+		maintenanceWriteError(w, err)
+		return
+	}
+
+	setETag(w, rec.Version)
+	response(w, http.StatusOK, rec)
+}
+
+func (s *MaintenanceService) Update(w http.ResponseWriter, r *http.Request) {
+	req := new(DTO.MaintenanceRequest)
+	if err := decode(r, req, func() []DTO.FieldError { return maintenanceFields(req) }); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	s.modify(w, r, func(rec *models.MaintenanceRecord) error {
+		applyMaintenanceRequest(rec, req)
+		return nil
+	})
+}
+
+// Finish ends a service now, the car leaves the workshop.
+func (s *MaintenanceService) Finish(w http.ResponseWriter, r *http.Request) {
+	req := new(DTO.FinishMaintenanceRequest)
+	if err := decode(r, req); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	s.modify(w, r, func(rec *models.MaintenanceRecord) error {
+		return rec.Finish(*req.Cost, now())
+	})
+}
+
+func (s *MaintenanceService) Delete(w http.ResponseWriter, r *http.Request) {
+	idString := r.PathValue("id")
+	id, err := strconv.Atoi(idString)
+	if err != nil {
+		writeError(w, invalidID())
+		return
+	}
+
+	version, err := ifMatchVersion(r, func() (uint, error) {
+		rec, err := s.repo.Get(r.Context(), uint(id))
+		return rec.Version, err
+	})
+	if err != nil {
+		maintenanceWriteError(w, err)
+		return
+	}
+
+	if err = s.repo.Delete(r.Context(), uint(id), version); err != nil {
+		maintenanceWriteError(w, err)
+		return
+	}
+	response(w, http.StatusNoContent, nil)
+}
+
+func ruleWriteError(w http.ResponseWriter, rule *models.MaintenanceRule, err error) {
+	switch {
+	case errors.Is(err, repository.ErrNotFound):
+		writeError(w, newError(http.StatusNotFound, CodeNotFound, "maintenance rule not found"))
+	case errors.Is(err, repository.ErrForeignKey):
+		writeError(w, newError(http.StatusUnprocessableEntity, CodeInvalidReference, "model does not exist"))
+	case errors.Is(err, repository.ErrDuplicate):
+		writeError(w, newError(http.StatusConflict, CodeDuplicate, fmt.Sprintf("model %d already has a rule for %q", rule.ModelID, rule.ServiceType)))
+	default:
+		writeError(w, err)
+	}
+}
+
+func ruleFields(req *DTO.MaintenanceRuleRequest) []DTO.FieldError {
+	if req.IntervalKm == nil && req.IntervalMonths == nil {
+		return []DTO.FieldError{{Field: "interval_km", Message: "is required when interval_months is not given"}}
+	}
+	return nil
+}
+
+func applyRuleRequest(rule *models.MaintenanceRule, req *DTO.MaintenanceRuleRequest) {
+	rule.ModelID = req.ModelID
+	rule.ServiceType = strings.TrimSpace(req.ServiceType)
+	rule.IntervalKm = req.IntervalKm
+	rule.IntervalMonths = req.IntervalMonths
+}
+
+func (s *MaintenanceService) CreateRule(w http.ResponseWriter, r *http.Request) {
+	req := new(DTO.MaintenanceRuleRequest)
+	if err := decode(r, req, func() []DTO.FieldError { return ruleFields(req) }); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	rule := &models.MaintenanceRule{}
+	applyRuleRequest(rule, req)
+
+	if err := s.rules.Create(r.Context(), rule); err != nil {
+		ruleWriteError(w, rule, err)
+		return
+	}
+
+	setETag(w, rule.Version)
+	response(w, http.StatusCreated, rule)
+}
+
+func (s *MaintenanceService) GetRule(w http.ResponseWriter, r *http.Request) {
+	idString := r.PathValue("id")
+	id, err := strconv.Atoi(idString)
+	if err != nil {
+		writeError(w, invalidID())
+		return
+	}
+
+	rule, err := s.rules.Get(r.Context(), uint(id))
+	if err != nil {
+		ruleWriteError(w, &rule, err)
+		return
+	}
+
+	if notModified(w, r, etag(rule.Version)) {
+		return
+	}
+	response(w, http.StatusOK, rule)
+}
+
+var ruleListSpec = listSpec{
+	pk:   "rule_id",
+	sort: []string{"rule_id", "service_type"},
+	filters: map[string]filterSpec{
+		"model_id":     {column: "model_id", op: repository.OpEq, parse: parseUint},
+		"service_type": {column: "service_type", op: repository.OpEq, parse: parseString},
+	},
+}
+
+func (s *MaintenanceService) GetRules(w http.ResponseWriter, r *http.Request) {
+	params, err := parseListParams(r, ruleListSpec)
+	if err != nil {
+		responseError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	rules, total, err := s.rules.List(r.Context(), params)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	responseList(w, r, newPage(r, ruleListSpec, params, rules, total, func(m models.MaintenanceRule) uint { return m.RuleID }))
+}
+
+func (s *MaintenanceService) UpdateRule(w http.ResponseWriter, r *http.Request) {
+	idString := r.PathValue("id")
+	id, err := strconv.Atoi(idString)
+	if err != nil {
+		writeError(w, invalidID())
+		return
+	}
+
+	req := new(DTO.MaintenanceRuleRequest)
+	if err := decode(r, req, func() []DTO.FieldError { return ruleFields(req) }); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	rule, err := s.rules.Get(r.Context(), uint(id))
+	if err != nil {
+		ruleWriteError(w, &rule, err)
+		return
+	}
+	if err := checkIfMatch(r, rule.Version); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	applyRuleRequest(&rule, req)
+
+	if err := s.rules.Update(r.Context(), &rule); err != nil {
+		ruleWriteError(w, &rule, err)
+		return
+	}
+
+	setETag(w, rule.Version)
+	response(w, http.StatusOK, rule)
+}
+
+func (s *MaintenanceService) DeleteRule(w http.ResponseWriter, r *http.Request) {
+	idString := r.PathValue("id")
+	id, err := strconv.Atoi(idString)
+	if err != nil {
+		writeError(w, invalidID())
+		return
+	}
+
+	version, err := ifMatchVersion(r, func() (uint, error) {
+		rule, err := s.rules.Get(r.Context(), uint(id))
+		return rule.Version, err
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if err = s.rules.Delete(r.Context(), uint(id), version); err != nil {
+		writeDeleteError(w, err)
+		return
+	}
+	response(w, http.StatusNoContent, nil)
+}
+
+// carUnavailableError tells why a car cannot take trips: it is in the
+// workshop or overdue for the services ServiceTypes.
+type carUnavailableError struct {
+	CarID        uint
+	Reason       string
+	ServiceTypes []string
+}
+
+const (
+	reasonInWorkshop = "in_workshop"
+	reasonOverdue    = "maintenance_overdue"
+)
+
+func (e *carUnavailableError) Error() string {
+	if e.Reason == reasonInWorkshop {
+		return fmt.Sprintf("car %d is in the workshop for %s", e.CarID, strings.Join(e.ServiceTypes, ", "))
+	}
+	return fmt.Sprintf("car %d is overdue for %s", e.CarID, strings.Join(e.ServiceTypes, ", "))
+}
+
+// checkCar refuses cars that are in the workshop or overdue for a service.
+// Trips the car already has are not affected.
+func (s *MaintenanceService) checkCar(ctx context.Context, carID uint) error {
+	open, _, err := s.repo.List(ctx, repository.ListParams{
+		Filters: []repository.Filter{
+			{Column: "car_id", Op: repository.OpEq, Value: carID},
+			{Column: "finished_at", Op: repository.OpNull, Value: true},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if len(open) > 0 {
+		e := &carUnavailableError{CarID: carID, Reason: reasonInWorkshop}
+		for _, rec := range open {
+			e.ServiceTypes = append(e.ServiceTypes, rec.ServiceType)
+		}
+		return e
+	}
+
+	due, err := s.query.DueMaintenance(ctx, time.Now(), carID)
+	if err != nil {
+		return err
+	}
+	e := &carUnavailableError{CarID: carID, Reason: reasonOverdue}
+	for _, d := range due {
+		if d.Overdue {
+			e.ServiceTypes = append(e.ServiceTypes, d.ServiceType)
+		}
+	}
+	if len(e.ServiceTypes) > 0 {
+		return e
+	}
+	return nil
+}
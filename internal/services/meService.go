@@ -0,0 +1,302 @@
+package services
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"taksopark/internal/DTO"
+	"taksopark/internal/models"
+	"taksopark/internal/repository"
+	"time"
+)
+
+// MeService serves /me, the namespace of the driver whose key makes the
+// request. It reuses the driver, trip and query handlers with the driver
+// filled in, so drivers see exactly what the office sees about them.
+type MeService struct {
+	drivers DriverService
+	trips   TripService
+	shifts  ShiftService
+	query   QueryService
+}
+
+func NewMeService(drivers DriverService, trips TripService, shifts ShiftService, query QueryService) MeService {
+	return MeService{
+		drivers: drivers,
+		trips:   trips,
+		shifts:  shifts,
+		query:   query,
+	}
+}
+
+// me returns the driver the caller is. Callers that are not drivers get
+// 403, which includes every caller when authentication is disabled.
+func me(w http.ResponseWriter, r *http.Request) (uint, bool) {
+	id, ok := driverScope(r.Context())
+	if !ok {
+		writeError(w, newError(http.StatusForbidden, CodeForbidden, "only drivers have a /me namespace"))
+	}
+	return id, ok
+}
+
+// asDriver makes the {id} of r the driver id for the handlers of /drivers.
+func asDriver(r *http.Request, id uint) {
+	r.SetPathValue("id", strconv.FormatUint(uint64(id), 10))
+}
+
+func (s *MeService) Profile(w http.ResponseWriter, r *http.Request) {
+	id, ok := me(w, r)
+	if !ok {
+		return
+	}
+	asDriver(r, id)
+	s.drivers.Get(w, r)
+}
+
+// Trips lists the caller's trips, with the filters and paging of /trips.
+func (s *MeService) Trips(w http.ResponseWriter, r *http.Request) {
+	if _, ok := me(w, r); ok {
+		s.trips.GetAll(w, r)
+	}
+}
+
+func (s *MeService) Trip(w http.ResponseWriter, r *http.Request) {
+	if _, ok := me(w, r); ok {
+		s.trips.Get(w, r)
+	}
+}
+
+// CurrentTrip returns the trip the caller is assigned to and has not
+// finished yet. A driver has at most one, since trips of a driver may not
+// overlap.
+func (s *MeService) CurrentTrip(w http.ResponseWriter, r *http.Request) {
+	id, ok := me(w, r)
+	if !ok {
+		return
+	}
+
+	trips, _, err := s.trips.repo.List(r.Context(), repository.ListParams{
+		Filters: []repository.Filter{
+			{Column: "driver_id", Op: repository.OpEq, Value: id},
+			{Column: "status", Op: repository.OpIn, Value: []any{string(models.TripAssigned), string(models.TripEnRoute), string(models.TripInProgress)}},
+		},
+		Limit: 1,
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if len(trips) == 0 {
+		writeError(w, newError(http.StatusNotFound, CodeNotFound, "no current trip"))
+		return
+	}
+
+	if notModified(w, r, etag(trips[0].Version)) {
+		return
+	}
+	response(w, http.StatusOK, trips[0])
+}
+
+// Earnings sums up the caller's completed trips like
+// GET /drivers/{id}/earnings.
+func (s *MeService) Earnings(w http.ResponseWriter, r *http.Request) {
+	id, ok := me(w, r)
+	if !ok {
+		return
+	}
+	asDriver(r, id)
+	s.query.DriverEarnings(w, r)
+}
+
+func (s *MeService) setOnline(w http.ResponseWriter, r *http.Request, online bool) {
+	id, ok := me(w, r)
+	if !ok {
+		return
+	}
+
+	driver, err := s.drivers.repo.Get(r.Context(), id)
+	if err == nil && driver.Online != online {
+		driver, err = s.drivers.repo.Modify(r.Context(), id, func(driver *models.Driver) error {
+			driver.SetOnline(online, time.Now().UTC().Truncate(time.Microsecond))
+			return nil
+		})
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	setETag(w, driver.Version)
+	response(w, http.StatusOK, driver)
+}
+
+// Online makes the caller available for offers.
+func (s *MeService) Online(w http.ResponseWriter, r *http.Request) {
+	s.setOnline(w, r, true)
+}
+
+// Offline stops new offers. Offers already made and the current trip stay.
+func (s *MeService) Offline(w http.ResponseWriter, r *http.Request) {
+	s.setOnline(w, r, false)
+}
+
+// Offers lists the requested trips offered to the caller, with the paging
+// of /trips.
+func (s *MeService) Offers(w http.ResponseWriter, r *http.Request) {
+	id, ok := me(w, r)
+	if !ok {
+		return
+	}
+
+	params, err := parseListParams(r, tripListSpec)
+	if err != nil {
+		responseError(w, http.StatusBadRequest, err)
+		return
+	}
+	params.Filters = append(params.Filters,
+		repository.Filter{Column: "offered_driver_id", Op: repository.OpEq, Value: id},
+		repository.Filter{Column: "status", Op: repository.OpEq, Value: string(models.TripRequested)})
+
+	trips, total, err := s.trips.repo.List(r.Context(), params)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	responseList(w, r, newPage(r, tripListSpec, params, trips, total, func(t models.Trip) uint { return t.TripID }))
+}
+
+// answer accepts or declines the offer of the trip {id}. Trips that are not
+// offered to the caller are reported as missing.
+func (s *MeService) answer(w http.ResponseWriter, r *http.Request, fn func(trip *models.Trip, driverID uint) error) {
+	driverID, ok := me(w, r)
+	if !ok {
+		return
+	}
+
+	idString := r.PathValue("id")
+	id, err := strconv.Atoi(idString)
+	if err != nil {
+		writeError(w, invalidID())
+		return
+	}
+
+	trip, err := s.trips.repo.Modify(r.Context(), uint(id), func(trip *models.Trip) error {
+		if !trip.OfferedTo(driverID) {
+			return models.ErrNotOffered
+		}
+		if err := checkIfMatch(r, trip.Version); err != nil {
+			return err
+		}
+		return fn(trip, driverID)
+	})
+	if err != nil {
+		tripWriteError(w, err)
+		return
+	}
+
+	setETag(w, trip.Version)
+	response(w, http.StatusOK, trip)
+}
+
+// Accept assigns the offered trip to the caller with the offered car.
+// Drivers with expired documents cannot accept, neither can anybody while
+// the offered car is in the workshop or overdue for a service. The car is
+// checked beforehand, so the offer must still be for it when the trip is
+// saved.
+func (s *MeService) Accept(w http.ResponseWriter, r *http.Request) {
+	if id, ok := driverScope(r.Context()); ok {
+		if err := s.trips.checkDocuments(r.Context(), id); err != nil {
+			tripWriteError(w, err)
+			return
+		}
+	}
+
+	var carID *uint
+	if id, err := strconv.Atoi(r.PathValue("id")); err == nil {
+		if trip, err := s.trips.repo.Get(r.Context(), uint(id)); err == nil && trip.OfferedCarID != nil {
+			if err := s.trips.maintenance.checkCar(r.Context(), *trip.OfferedCarID); err != nil {
+				tripWriteError(w, err)
+				return
+			}
+			carID = trip.OfferedCarID
+		}
+	}
+
+	s.answer(w, r, func(trip *models.Trip, driverID uint) error {
+		if trip.OfferedCarID != nil && (carID == nil || *carID != *trip.OfferedCarID) {
+			return errTripChanged
+		}
+		return trip.Accept(driverID, time.Now())
+	})
+}
+
+// Decline withdraws the offer, the trip goes back to the dispatchers.
+func (s *MeService) Decline(w http.ResponseWriter, r *http.Request) {
+	s.answer(w, r, func(trip *models.Trip, driverID uint) error {
+		return trip.Decline(driverID)
+	})
+}
+
+// CurrentShift returns the open shift of the caller.
+func (s *MeService) CurrentShift(w http.ResponseWriter, r *http.Request) {
+	id, ok := me(w, r)
+	if !ok {
+		return
+	}
+
+	shift, err := s.shifts.current(r.Context(), id)
+	if errors.Is(err, repository.ErrNotFound) {
+		writeError(w, newError(http.StatusNotFound, CodeNotFound, "no open shift"))
+		return
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if notModified(w, r, etag(shift.Version)) {
+		return
+	}
+	response(w, http.StatusOK, shift)
+}
+
+// OpenShift starts a shift of the caller in the given car now.
+func (s *MeService) OpenShift(w http.ResponseWriter, r *http.Request) {
+	id, ok := me(w, r)
+	if !ok {
+		return
+	}
+
+	req := new(DTO.OpenShiftRequest)
+	if err := decode(r, req); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	s.shifts.open(w, r, &models.Shift{
+		DriverID:   id,
+		CarID:      req.CarID,
+		OdometerIn: *req.OdometerIn,
+	})
+}
+
+// CloseShift ends the open shift of the caller like POST /shifts/{id}/close.
+func (s *MeService) CloseShift(w http.ResponseWriter, r *http.Request) {
+	id, ok := me(w, r)
+	if !ok {
+		return
+	}
+
+	shift, err := s.shifts.current(r.Context(), id)
+	if errors.Is(err, repository.ErrNotFound) {
+		writeError(w, newError(http.StatusNotFound, CodeNotFound, "no open shift"))
+		return
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	s.shifts.close(w, r, shift.ShiftID)
+}
@@ -1,73 +1,116 @@
 package services
 
 import (
-	"encoding/json"
-	"errors"
 	"net/http"
 	"strconv"
 	"taksopark/internal/DTO"
 	"taksopark/internal/models"
-
-	"gorm.io/gorm"
+	"taksopark/internal/repository"
 )
 
 type ModelService struct {
-	db *gorm.DB
+	repo repository.ModelRepository
 }
 
-func NewModelService(init_db *gorm.DB) ModelService {
+func NewModelService(repo repository.ModelRepository) ModelService {
 	return ModelService{
-		db: init_db,
+		repo: repo,
 	}
 }
 
 func (s *ModelService) Create(w http.ResponseWriter, r *http.Request) {
 	req := new(DTO.CreateModelRequest)
-	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
-		responseError(w, http.StatusBadRequest, err)
+	if err := decode(r, req); err != nil {
+		writeError(w, err)
 		return
 	}
-	defer r.Body.Close()
 
 	model := &models.CarModel{
-		ModelName:    req.ModelName,
-		Manufacturer: req.Manufacturer,
-	}
-
-	if err := s.db.Create(model).Error; err != nil {
-		responseError(w, http.StatusInternalServerError, err)
+		ModelName:            req.ModelName,
+		Manufacturer:         req.Manufacturer,
+		Class:                req.Class,
+		Seats:                req.Seats,
+		LuggageL:             req.LuggageL,
+		FuelType:             models.FuelType(req.FuelType),
+		WheelchairAccessible: req.WheelchairAccessible,
+		LowFloor:             req.LowFloor,
+	}
+	setModelDefaults(model)
+
+	if err := s.repo.Create(r.Context(), model); err != nil {
+		writeError(w, err)
 		return
 	}
 
+	setETag(w, model.Version)
 	response(w, http.StatusCreated, model)
 }
 
+// setModelDefaults fills in the class, seats and fuel type a model was
+// created or replaced without.
+func setModelDefaults(model *models.CarModel) {
+	if model.Class == "" {
+		model.Class = models.DefaultClass
+	}
+	if model.Seats == 0 {
+		model.Seats = models.DefaultSeats
+	}
+	if model.FuelType == "" {
+		model.FuelType = models.FuelPetrol
+	}
+}
+
+// modelFilters select car models by their attributes, GET /cars accepts
+// them too to select cars by their model.
+var modelFilters = map[string]filterSpec{
+	"manufacturer":          {column: "manufacturer", op: repository.OpEq, parse: parseString},
+	"class":                 {column: "class", op: repository.OpEq, parse: parseString},
+	"seats_min":             {column: "seats", op: repository.OpGte, parse: parseUint},
+	"luggage_min":           {column: "luggage_l", op: repository.OpGte, parse: parseUint},
+	"fuel_type":             {column: "fuel_type", op: repository.OpEq, parse: parseString},
+	"wheelchair_accessible": {column: "wheelchair_accessible", op: repository.OpEq, parse: parseBool},
+	"low_floor":             {column: "low_floor", op: repository.OpEq, parse: parseBool},
+}
+
+var modelListSpec = listSpec{
+	pk:      "model_id",
+	sort:    []string{"model_id", "model_name", "manufacturer", "class", "seats", "luggage_l"},
+	filters: modelFilters,
+}
+
 func (s *ModelService) GetAll(w http.ResponseWriter, r *http.Request) {
-	var models []models.CarModel
-	err := s.db.Find(&models).Error
+	params, err := parseListParams(r, modelListSpec)
+	if err != nil {
+		responseError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	carModels, total, err := s.repo.List(r.Context(), params)
 	if err != nil {
-		responseError(w, http.StatusInternalServerError, err)
+		writeError(w, err)
 		return
 	}
-	response(w, http.StatusOK, models)
+
+	responseList(w, r, newPage(r, modelListSpec, params, carModels, total, func(m models.CarModel) uint { return m.ModelID }))
 }
 
 func (s *ModelService) Get(w http.ResponseWriter, r *http.Request) {
-	var model models.CarModel
-
 	idString := r.PathValue("id")
 	id, err := strconv.Atoi(idString)
 	if err != nil {
-		responseError(w, http.StatusBadRequest, errors.New("invalid id"))
+		writeError(w, invalidID())
 		return
 	}
 
-	err = s.db.Find(&model, id).Error
+	model, err := s.repo.Get(r.Context(), uint(id))
 	if err != nil {
-		responseError(w, http.StatusNotFound, err)
+		writeError(w, err)
 		return
 	}
 
+	if notModified(w, r, etag(model.Version)) {
+		return
+	}
 	response(w, http.StatusOK, model)
 }
 
@@ -75,93 +118,119 @@ func (s *ModelService) Update(w http.ResponseWriter, r *http.Request) {
 	idString := r.PathValue("id")
 	id, err := strconv.Atoi(idString)
 	if err != nil {
-		responseError(w, http.StatusBadRequest, errors.New("invalid id"))
+		writeError(w, invalidID())
 		return
 	}
 
 	req := new(DTO.UpdateModelRequest)
-	err = json.NewDecoder(r.Body).Decode(req)
+	err = decode(r, req)
 	if err != nil {
-		responseError(w, http.StatusBadRequest, err)
+		writeError(w, err)
 		return
 	}
 
-	var model models.CarModel
-	err = s.db.Find(&model, id).Error
+	model, err := s.repo.Get(r.Context(), uint(id))
 	if err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			responseError(w, http.StatusNotFound, err)
-			return
-		}
-		responseError(w, http.StatusInternalServerError, err)
+		writeError(w, err)
+		return
+	}
+	if err := checkIfMatch(r, model.Version); err != nil {
+		writeError(w, err)
 		return
 	}
 
 	model.ModelName = req.ModelName
 	model.Manufacturer = req.Manufacturer
-
-	err = s.db.Save(&model).Error
+	model.Class = req.Class
+	model.Seats = req.Seats
+	model.LuggageL = req.LuggageL
+	model.FuelType = models.FuelType(req.FuelType)
+	model.WheelchairAccessible = req.WheelchairAccessible
+	model.LowFloor = req.LowFloor
+	setModelDefaults(&model)
+
+	err = s.repo.Update(r.Context(), &model)
 	if err != nil {
-		response(w, http.StatusInternalServerError, err)
+		writeError(w, err)
 		return
 	}
 
-	response(w, http.StatusOK, nil)
+	setETag(w, model.Version)
+	response(w, http.StatusOK, model)
 }
 
 func (s *ModelService) UpdateSomething(w http.ResponseWriter, r *http.Request) {
 	idString := r.PathValue("id")
 	id, err := strconv.Atoi(idString)
 	if err != nil {
-		responseError(w, http.StatusBadRequest, errors.New("invalid id"))
+		writeError(w, invalidID())
 		return
 	}
 
-	var model models.CarModel
-	err = s.db.Find(&model, id).Error
+	model, err := s.repo.Get(r.Context(), uint(id))
 	if err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			responseError(w, http.StatusNotFound, err)
-			return
-		}
-		responseError(w, http.StatusInternalServerError, err)
+		writeError(w, err)
 		return
 	}
-
-	req := new(DTO.UpdateSomethingModelRequest)
-	err = json.NewDecoder(r.Body).Decode(req)
-	if err != nil {
-		responseError(w, http.StatusInternalServerError, err)
+	if err := checkIfMatch(r, model.Version); err != nil {
+		writeError(w, err)
 		return
 	}
 
-	switch {
-	case req.ModelName != nil:
-		model.ModelName = *req.ModelName
-	case req.Manufacturer != nil:
-		model.Manufacturer = *req.Manufacturer
+	req := &DTO.UpdateSomethingModelRequest{
+		ModelName:            model.ModelName,
+		Manufacturer:         model.Manufacturer,
+		Class:                model.Class,
+		Seats:                model.Seats,
+		LuggageL:             model.LuggageL,
+		FuelType:             string(model.FuelType),
+		WheelchairAccessible: model.WheelchairAccessible,
+		LowFloor:             model.LowFloor,
+	}
+	if err := decodePatch(r, req); err != nil {
+		writeError(w, err)
+		return
 	}
 
-	err = s.db.Save(&model).Error
+	model.ModelName = req.ModelName
+	model.Manufacturer = req.Manufacturer
+	model.Class = req.Class
+	model.Seats = req.Seats
+	model.LuggageL = req.LuggageL
+	model.FuelType = models.FuelType(req.FuelType)
+	model.WheelchairAccessible = req.WheelchairAccessible
+	model.LowFloor = req.LowFloor
+
+	err = s.repo.Update(r.Context(), &model)
 	if err != nil {
-		responseError(w, http.StatusInternalServerError, err)
+		writeError(w, err)
 		return
 	}
 
-	response(w, http.StatusNoContent, nil)
+	setETag(w, model.Version)
+	response(w, http.StatusOK, model)
 }
 
 func (s *ModelService) Delete(w http.ResponseWriter, r *http.Request) {
 	idString := r.PathValue("id")
 	id, err := strconv.Atoi(idString)
 	if err != nil {
-		responseError(w, http.StatusBadRequest, errors.New("invalid id"))
+		writeError(w, invalidID())
+		return
+	}
+
+	version, err := ifMatchVersion(r, func() (uint, error) {
+		model, err := s.repo.Get(r.Context(), uint(id))
+		return model.Version, err
+	})
+	if err != nil {
+		writeError(w, err)
 		return
 	}
 
-	err = s.db.Delete(models.CarModel{}, id).Error
+	err = s.repo.Delete(r.Context(), uint(id), version)
 	if err != nil {
-		responseError(w, http.StatusInternalServerError, err)
+		writeDeleteError(w, err)
 		return
 	}
 	response(w, http.StatusNoContent, nil)
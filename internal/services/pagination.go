@@ -0,0 +1,250 @@
+package services
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"taksopark/internal/DTO"
+	"taksopark/internal/repository"
+	"time"
+)
+
+const (
+	defaultLimit = 50
+	maxLimit     = 500
+)
+
+type filterSpec struct {
+	column string
+	op     repository.FilterOp
+	parse  func(string) (any, error)
+}
+
+// listSpec whitelists the query parameters a GetAll endpoint accepts:
+// sortable columns and filters keyed by their query parameter name.
+// Fields inside the JSON columns in json are filtered by parameters named
+// like notes.color or notes.specs.seats. Endpoints of soft-deleted tables
+// also accept deleted=true.
+type listSpec struct {
+	pk      string
+	sort    []string
+	filters map[string]filterSpec
+	json    []string
+	deleted bool
+}
+
+var jsonFieldPattern = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+// parseJSONValue reads the value of a JSON field filter: a JSON number,
+// boolean, null or quoted string, anything else is taken as a string.
+func parseJSONValue(s string) (any, error) {
+	var v any
+	if err := json.Unmarshal([]byte(s), &v); err != nil {
+		return s, nil
+	}
+	switch v.(type) {
+	case map[string]any, []any:
+		return nil, errors.New("objects and arrays cannot be compared")
+	}
+	return v, nil
+}
+
+// jsonFilters parses the filters on fields of the JSON columns of spec.
+// Parameters are read in order of their names so that errors do not
+// depend on map order.
+func jsonFilters(q url.Values, spec listSpec) ([]repository.Filter, error) {
+	var filters []repository.Filter
+	names := make([]string, 0, len(q))
+	for name := range q {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	for _, name := range names {
+		column, path, ok := strings.Cut(name, ".")
+		if !ok || !slices.Contains(spec.json, column) {
+			continue
+		}
+		fields := strings.Split(path, ".")
+		for _, field := range fields {
+			if !jsonFieldPattern.MatchString(field) {
+				return nil, fmt.Errorf("invalid field %q in %s, use letters, digits and _", field, name)
+			}
+		}
+		value, err := parseJSONValue(q.Get(name))
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for %s: %w", name, err)
+		}
+		filters = append(filters, repository.Filter{Column: column, Path: fields, Op: repository.OpEq, Value: value})
+	}
+	return filters, nil
+}
+
+func parseUint(s string) (any, error) {
+	v, err := strconv.ParseUint(s, 10, 64)
+	return uint(v), err
+}
+
+func parseFloat(s string) (any, error) {
+	return strconv.ParseFloat(s, 64)
+}
+
+func parseTime(s string) (any, error) {
+	t, err := time.Parse(time.RFC3339, s)
+	return t.UTC(), err
+}
+
+func parseBool(s string) (any, error) {
+	return strconv.ParseBool(s)
+}
+
+func parseString(s string) (any, error) {
+	return s, nil
+}
+
+func encodeCursor(id uint) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatUint(uint64(id), 10)))
+}
+
+func decodeCursor(s string) (uint, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return 0, errors.New("invalid cursor")
+	}
+	id, err := strconv.ParseUint(string(raw), 10, 64)
+	if err != nil || id == 0 {
+		return 0, errors.New("invalid cursor")
+	}
+	return uint(id), nil
+}
+
+// pkOrder reports whether rows are ordered by the primary key alone, the
+// only order cursor pagination supports.
+func (spec listSpec) pkOrder(p repository.ListParams) bool {
+	return len(p.Sort) == 0 || (len(p.Sort) == 1 && p.Sort[0].Column == spec.pk)
+}
+
+// parseFilters reads the filters given in q.
+func parseFilters(q url.Values, specs map[string]filterSpec) ([]repository.Filter, error) {
+	var filters []repository.Filter
+	for name, f := range specs {
+		v := q.Get(name)
+		if v == "" {
+			continue
+		}
+		value, err := f.parse(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value %q for %s", v, name)
+		}
+		filters = append(filters, repository.Filter{Column: f.column, Op: f.op, Value: value})
+	}
+	return filters, nil
+}
+
+func parseListParams(r *http.Request, spec listSpec) (repository.ListParams, error) {
+	q := r.URL.Query()
+	p := repository.ListParams{Limit: defaultLimit}
+
+	if v := q.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxLimit {
+			return p, fmt.Errorf("limit must be between 1 and %d", maxLimit)
+		}
+		p.Limit = limit
+	}
+
+	if v := q.Get("offset"); v != "" {
+		offset, err := strconv.Atoi(v)
+		if err != nil || offset < 0 {
+			return p, errors.New("offset must be a non-negative integer")
+		}
+		p.Offset = offset
+	}
+
+	if v := q.Get("sort"); v != "" {
+		for _, field := range strings.Split(v, ",") {
+			s := repository.Sort{Column: strings.TrimPrefix(field, "-"), Desc: strings.HasPrefix(field, "-")}
+			if !slices.Contains(spec.sort, s.Column) {
+				return p, fmt.Errorf("cannot sort by %q, allowed: %s", s.Column, strings.Join(spec.sort, ", "))
+			}
+			p.Sort = append(p.Sort, s)
+		}
+	}
+
+	filters, err := parseFilters(q, spec.filters)
+	if err != nil {
+		return p, err
+	}
+	p.Filters = filters
+
+	filters, err = jsonFilters(q, spec)
+	if err != nil {
+		return p, err
+	}
+	p.Filters = append(p.Filters, filters...)
+
+	if v := q.Get("deleted"); v != "" {
+		if !spec.deleted {
+			return p, errors.New("deleted records cannot be listed here")
+		}
+		deleted, err := strconv.ParseBool(v)
+		if err != nil {
+			return p, fmt.Errorf("invalid value %q for deleted", v)
+		}
+		p.Deleted = deleted
+	}
+
+	if v := q.Get("cursor"); v != "" {
+		if p.Offset > 0 {
+			return p, errors.New("cursor and offset cannot be used together")
+		}
+		if !spec.pkOrder(p) {
+			return p, fmt.Errorf("cursor pagination supports only sorting by %s", spec.pk)
+		}
+		cursor, err := decodeCursor(v)
+		if err != nil {
+			return p, err
+		}
+		p.Cursor = cursor
+	}
+
+	return p, nil
+}
+
+// newPage wraps items into the list envelope and builds the link to the
+// next page, keeping the pagination mode of the current request.
+func newPage[T any](r *http.Request, spec listSpec, p repository.ListParams, items []T, total int64, id func(T) uint) DTO.Page[T] {
+	page := DTO.Page[T]{
+		Items:  items,
+		Total:  total,
+		Limit:  p.Limit,
+		Offset: p.Offset,
+	}
+
+	if spec.pkOrder(p) && len(items) == p.Limit {
+		page.NextCursor = encodeCursor(id(items[len(items)-1]))
+	}
+
+	q := r.URL.Query()
+	switch {
+	case p.Cursor != 0 && page.NextCursor != "":
+		q.Set("cursor", page.NextCursor)
+	case p.Cursor == 0 && int64(p.Offset+len(items)) < total:
+		q.Set("offset", strconv.Itoa(p.Offset+len(items)))
+	default:
+		return page
+	}
+	q.Set("limit", strconv.Itoa(p.Limit))
+
+	next := *r.URL
+	next.RawQuery = q.Encode()
+	page.Next = next.RequestURI()
+	return page
+}
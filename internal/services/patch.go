@@ -0,0 +1,76 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/http"
+	"reflect"
+	"taksopark/internal/DTO"
+	"taksopark/internal/patch"
+)
+
+const (
+	mergePatchType = "application/merge-patch+json"
+	jsonPatchType  = "application/json-patch+json"
+)
+
+// decodePatch applies the body of a PATCH request to dst, which holds the
+// current values of the resource. The body is a JSON Patch when sent as
+// application/json-patch+json and a JSON Merge Patch otherwise. The patched
+// document is then decoded and checked like a full update, so a field the
+// patch removes is treated as null.
+func decodePatch(r *http.Request, dst any, checks ...func() []DTO.FieldError) error {
+	defer r.Body.Close()
+
+	current, err := json.Marshal(dst)
+	if err != nil {
+		return err
+	}
+	var doc any
+	if err := json.Unmarshal(current, &doc); err != nil {
+		return err
+	}
+
+	mediaType := "application/json"
+	if ct := r.Header.Get("Content-Type"); ct != "" {
+		if mediaType, _, err = mime.ParseMediaType(ct); err != nil {
+			return invalidBody(err)
+		}
+	}
+
+	switch mediaType {
+	case jsonPatchType:
+		var ops []patch.Operation
+		if err := decodeStrict(r.Body, &ops); err != nil {
+			return err
+		}
+		doc, err = patch.Apply(doc, ops)
+		switch {
+		case errors.Is(err, patch.ErrFailed):
+			return newError(http.StatusConflict, CodePatchFailed, err.Error())
+		case err != nil:
+			return invalidBody(err)
+		}
+	case mergePatchType, "application/json":
+		var merge map[string]any
+		if err := decodeStrict(r.Body, &merge); err != nil {
+			return err
+		}
+		doc = patch.Merge(doc, merge)
+	default:
+		return newError(http.StatusUnsupportedMediaType, CodeUnsupportedMedia,
+			"PATCH accepts "+mergePatchType+", "+jsonPatchType+" or application/json")
+	}
+
+	patched, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	reflect.ValueOf(dst).Elem().SetZero()
+	if err := decodeStrict(bytes.NewReader(patched), dst); err != nil {
+		return err
+	}
+	return checkFields(dst, checks...)
+}
@@ -0,0 +1,171 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"taksopark/internal/DTO"
+	"taksopark/internal/models"
+	"taksopark/internal/repository"
+	"time"
+)
+
+// PositionService records the GPS pings of cars and finds free cars near a
+// point. history is how many positions are kept per car.
+type PositionService struct {
+	repo    repository.PositionRepository
+	shifts  ShiftService
+	query   repository.QueryRepository
+	history int
+}
+
+func NewPositionService(repo repository.PositionRepository, shifts ShiftService, query repository.QueryRepository, history int) PositionService {
+	return PositionService{
+		repo:    repo,
+		shifts:  shifts,
+		query:   query,
+		history: history,
+	}
+}
+
+// Record stores a ping of the car id. Drivers may only report the car of
+// their open shift.
+func (s *PositionService) Record(w http.ResponseWriter, r *http.Request) {
+	idString := r.PathValue("id")
+	id, err := strconv.Atoi(idString)
+	if err != nil {
+		writeError(w, invalidID())
+		return
+	}
+
+	req := new(DTO.PositionRequest)
+	if err := decode(r, req, func() []DTO.FieldError { return notInFuture("recorded_at", req.RecordedAt) }); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if driverID, ok := driverScope(r.Context()); ok {
+		shift, err := s.shifts.current(r.Context(), driverID)
+		if errors.Is(err, repository.ErrNotFound) || (err == nil && shift.CarID != uint(id)) {
+			writeError(w, newError(http.StatusForbidden, CodeForbidden, "drivers may only report the car of their open shift"))
+			return
+		}
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+
+	pos := &models.CarPosition{
+		CarID:      uint(id),
+		Lat:        *req.Lat,
+		Lon:        *req.Lon,
+		SpeedKmh:   req.SpeedKmh,
+		Heading:    req.Heading,
+		RecordedAt: readTime(req.RecordedAt),
+	}
+	if err := s.repo.Record(r.Context(), pos, s.history); err != nil {
+		if errors.Is(err, repository.ErrForeignKey) {
+			writeError(w, newError(http.StatusNotFound, CodeNotFound, "car not found"))
+			return
+		}
+		writeError(w, err)
+		return
+	}
+	response(w, http.StatusCreated, pos)
+}
+
+var positionListSpec = listSpec{
+	pk:   "position_id",
+	sort: []string{"position_id", "recorded_at"},
+	filters: map[string]filterSpec{
+		"car_id":        {column: "car_id", op: repository.OpEq, parse: parseUint},
+		"recorded_from": {column: "recorded_at", op: repository.OpGte, parse: parseTime},
+		"recorded_to":   {column: "recorded_at", op: repository.OpLte, parse: parseTime},
+	},
+}
+
+// GetAll lists the kept positions, the history of a car with car_id.
+func (s *PositionService) GetAll(w http.ResponseWriter, r *http.Request) {
+	params, err := parseListParams(r, positionListSpec)
+	if err != nil {
+		responseError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	positions, total, err := s.repo.List(r.Context(), params)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	responseList(w, r, newPage(r, positionListSpec, params, positions, total, func(p models.CarPosition) uint { return p.PositionID }))
+}
+
+// finite reports whether v is neither NaN nor infinite, ParseFloat accepts
+// both.
+func finite(v float64) bool {
+	return !math.IsNaN(v) && !math.IsInf(v, 0)
+}
+
+// queryCoordinate reads the required parameter name, a coordinate between
+// -limit and limit.
+func queryCoordinate(r *http.Request, name string, limit float64) (float64, error) {
+	s := r.URL.Query().Get(name)
+	if s == "" {
+		return 0, fmt.Errorf("%s is required", name)
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || !finite(v) || v < -limit || v > limit {
+		return 0, fmt.Errorf("%s must be a number between %v and %v", name, -limit, limit)
+	}
+	return v, nil
+}
+
+// Nearby lists the free cars within radius km of lat and lon, nearest
+// first. Cars that have not reported for minutes minutes are left out, as
+// are cars of other classes than class when it is given.
+func (s *PositionService) Nearby(w http.ResponseWriter, r *http.Request) {
+	lat, err := queryCoordinate(r, "lat", 90)
+	if err != nil {
+		responseError(w, http.StatusBadRequest, err)
+		return
+	}
+	lon, err := queryCoordinate(r, "lon", 180)
+	if err != nil {
+		responseError(w, http.StatusBadRequest, err)
+		return
+	}
+	radius := 5.0
+	if v := r.URL.Query().Get("radius"); v != "" {
+		radius, err = strconv.ParseFloat(v, 64)
+		if err != nil || !finite(radius) || radius <= 0 {
+			responseError(w, http.StatusBadRequest, errors.New("radius must be a positive number of km"))
+			return
+		}
+	}
+	minutes, err := queryCount(r, "minutes", 15)
+	if err != nil {
+		responseError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	since := time.Now().UTC().Add(-time.Duration(minutes) * time.Minute)
+	cars, err := s.query.NearbyCars(r.Context(), lat, lon, radius, since)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if class := r.URL.Query().Get("class"); class != "" {
+		res := []DTO.NearbyCar{}
+		for _, car := range cars {
+			if car.Class == class {
+				res = append(res, car)
+			}
+		}
+		cars = res
+	}
+	response(w, http.StatusOK, cars)
+}
@@ -2,27 +2,28 @@ package services
 
 import (
 	"errors"
+	"fmt"
+	"math"
 	"net/http"
+	"slices"
 	"strconv"
 	"taksopark/internal/DTO"
-	"taksopark/internal/models"
-
-	"gorm.io/gorm"
+	"taksopark/internal/repository"
+	"time"
 )
 
 type QueryService struct {
-	db *gorm.DB
+	repo repository.QueryRepository
 }
 
-func NewQueryService(init_db *gorm.DB) QueryService {
+func NewQueryService(repo repository.QueryRepository) QueryService {
 	return QueryService{
-		db: init_db,
+		repo: repo,
 	}
 }
 
 func (q *QueryService) CarOfYear(w http.ResponseWriter, r *http.Request) {
 
-	var res []DTO.CarWithModel
 	yearString := r.PathValue("year")
 	year, err := strconv.Atoi(yearString)
 	if err != nil {
@@ -30,15 +31,9 @@ func (q *QueryService) CarOfYear(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	query := q.db.Raw(`
-	select car_id, license_plate, cars.year, model_name, manufacturer
-	from
-	cars inner join car_models cm on cars.model_id = cm.model_id
-	where cars.year = ?
-	`, year).Scan(&res)
-
-	if query.Error != nil {
-		responseError(w, http.StatusBadRequest, query.Error)
+	res, err := q.repo.CarsOfYear(r.Context(), year)
+	if err != nil {
+		writeError(w, err)
 		return
 	}
 
@@ -47,16 +42,9 @@ func (q *QueryService) CarOfYear(w http.ResponseWriter, r *http.Request) {
 
 func (q *QueryService) DriverTripCounter(w http.ResponseWriter, r *http.Request) {
 
-	var res []DTO.PersonCount
-
-	query := q.db.Model(models.Driver{}).
-		Select("first_name, last_name, count(trips.trip_id) count").
-		Joins("left join trips on trips.driver_id=drivers.driver_id").
-		Group("drivers.driver_id").
-		Order("count desc").Scan(&res)
-
-	if query.Error != nil {
-		responseError(w, http.StatusBadRequest, query.Error)
+	res, err := q.repo.DriverTripCounts(r.Context())
+	if err != nil {
+		writeError(w, err)
 		return
 	}
 
@@ -65,17 +53,9 @@ func (q *QueryService) DriverTripCounter(w http.ResponseWriter, r *http.Request)
 
 func (q *QueryService) DriverTripAutoCounter(w http.ResponseWriter, r *http.Request) {
 
-	var res []DTO.DriverCount
-
-	query := q.db.Model(models.Driver{}).
-		Select("first_name, last_name, license_plate, count(t.trip_id) count").
-		Joins("left join trips t on t.driver_id = drivers.driver_id").
-		Joins("join cars c on c.car_id = t.car_id").
-		Group("c.car_id, first_name, last_name, license_plate").
-		Scan(&res)
-
-	if query.Error != nil {
-		responseError(w, http.StatusBadRequest, query.Error)
+	res, err := q.repo.DriverCarTripCounts(r.Context())
+	if err != nil {
+		writeError(w, err)
 		return
 	}
 
@@ -84,7 +64,6 @@ func (q *QueryService) DriverTripAutoCounter(w http.ResponseWriter, r *http.Requ
 
 func (q *QueryService) ClientTripMoreThan(w http.ResponseWriter, r *http.Request) {
 
-	var res []DTO.PersonCount
 	nString := r.PathValue("n")
 	n, err := strconv.Atoi(nString)
 	if err != nil {
@@ -92,16 +71,9 @@ func (q *QueryService) ClientTripMoreThan(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	query := q.db.Raw(`
-	select c.first_name, c.last_name, count(t.trip_id) as count
-	from customers c
-	left join trips t on c.customer_id = t.customer_id
-	group by c.customer_id
-	having count(t.trip_id) > ?
-	`, n).Scan(&res)
-
-	if query.Error != nil {
-		responseError(w, http.StatusBadRequest, query.Error)
+	res, err := q.repo.CustomersWithTripsMoreThan(r.Context(), n)
+	if err != nil {
+		writeError(w, err)
 		return
 	}
 
@@ -110,44 +82,237 @@ func (q *QueryService) ClientTripMoreThan(w http.ResponseWriter, r *http.Request
 
 func (q *QueryService) BestDrivers(w http.ResponseWriter, r *http.Request) {
 
-	var res []DTO.Person
+	res, err := q.repo.BestDrivers(r.Context())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
 
-	subQuery := q.db.Model(&models.Trip{}).
-		Select("driver_id, count(trip_id) as trip_count").
-		Group("driver_id")
+	response(w, http.StatusOK, res)
+}
+
+func (q *QueryService) Statistic(w http.ResponseWriter, r *http.Request) {
+
+	res, err := q.repo.TripDurationStatistic(r.Context())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
 
-	maxTripCountSubQuery := q.db.Model(&models.Trip{}).
-		Table("(?) as t", subQuery).
-		Select("max(t.trip_count)")
+	response(w, http.StatusOK, res)
+}
 
-	query := q.db.Model(&models.Driver{}).
-		Select("drivers.first_name, drivers.last_name").
-		Joins("JOIN (?) AS t ON drivers.driver_id = t.driver_id", subQuery).
-		Where("t.trip_count = (?)", maxTripCountSubQuery).
-		Scan(&res)
+func (q *QueryService) DriverDistances(w http.ResponseWriter, r *http.Request) {
 
-	if query.Error != nil {
-		responseError(w, http.StatusBadRequest, query.Error)
+	res, err := q.repo.DriverDistances(r.Context())
+	if err != nil {
+		writeError(w, err)
 		return
 	}
 
 	response(w, http.StatusOK, res)
 }
 
-func (q *QueryService) Statistic(w http.ResponseWriter, r *http.Request) {
+func (q *QueryService) CarDistances(w http.ResponseWriter, r *http.Request) {
 
-	res := new(DTO.Statistic)
+	res, err := q.repo.CarDistances(r.Context())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
 
-	query := q.db.Model(models.Trip{}).
-		Select("min(timestampdiff(minute, start_time, end_time)) as min",
-			"avg(timestampdiff(minute, start_time, end_time)) as avg",
-			"max(timestampdiff(minute, start_time, end_time)) as max").
-		Scan(&res)
+	response(w, http.StatusOK, res)
+}
+
+func (q *QueryService) DailyDistances(w http.ResponseWriter, r *http.Request) {
+
+	res, err := q.repo.DailyDistances(r.Context())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	response(w, http.StatusOK, res)
+}
+
+// period reads the optional from and to query parameters.
+func period(r *http.Request) (from, to *time.Time, err error) {
+	params := []struct {
+		name string
+		dst  **time.Time
+	}{{"from", &from}, {"to", &to}}
+	for _, p := range params {
+		s := r.URL.Query().Get(p.name)
+		if s == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid %s, expected an RFC 3339 time", p.name)
+		}
+		*p.dst = &t
+	}
+	if from != nil && to != nil && !to.After(*from) {
+		return nil, nil, errors.New("to must be after from")
+	}
+	return from, to, nil
+}
 
-	if query.Error != nil {
-		responseError(w, http.StatusBadRequest, query.Error)
+// DriverEarnings sums up the completed trips of a driver that ended between
+// the optional from and to.
+func (q *QueryService) DriverEarnings(w http.ResponseWriter, r *http.Request) {
+	idString := r.PathValue("id")
+	id, err := strconv.Atoi(idString)
+	if err != nil {
+		writeError(w, invalidID())
+		return
+	}
+
+	from, to, err := period(r)
+	if err != nil {
+		responseError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	res, err := q.repo.DriverEarnings(r.Context(), uint(id), from, to)
+	if errors.Is(err, repository.ErrNotFound) {
+		writeError(w, newError(http.StatusNotFound, CodeNotFound, "driver not found"))
+		return
+	}
+	if err != nil {
+		writeError(w, err)
 		return
 	}
 
 	response(w, http.StatusOK, res)
 }
+
+// DriverHours sums up the hours drivers spent on shifts between the
+// optional from and to, the part of a shift outside the period is left out.
+func (q *QueryService) DriverHours(w http.ResponseWriter, r *http.Request) {
+	from, to, err := period(r)
+	if err != nil {
+		responseError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	// Open shifts have not lasted past now yet.
+	start, end := time.Time{}, time.Now().UTC()
+	if from != nil {
+		start = *from
+	}
+	if to != nil && to.Before(end) {
+		end = *to
+	}
+
+	res, err := q.repo.DriverHours(r.Context(), start, end)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	response(w, http.StatusOK, res)
+}
+
+// queryCount reads the non-negative integer query parameter name, def
+// when it is missing.
+func queryCount(r *http.Request, name string, def int) (int, error) {
+	s := r.URL.Query().Get(name)
+	if s == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%s must be a non-negative integer", name)
+	}
+	return n, nil
+}
+
+// ExpiringDocuments lists the documents of drivers that expire within the
+// next days days or have expired already. Renewed documents are left out.
+func (q *QueryService) ExpiringDocuments(w http.ResponseWriter, r *http.Request) {
+	days, err := queryCount(r, "days", 30)
+	if err != nil {
+		responseError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	now := time.Now().UTC()
+	res, err := q.repo.ExpiringDocuments(r.Context(), now.AddDate(0, 0, days))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	for i := range res {
+		res[i].Expired = !res[i].ExpiresAt.After(now)
+	}
+
+	response(w, http.StatusOK, res)
+}
+
+// DueMaintenance lists the services cars need within the next km
+// kilometres or days days, and the ones they are overdue for.
+func (q *QueryService) DueMaintenance(w http.ResponseWriter, r *http.Request) {
+	days, err := queryCount(r, "days", 30)
+	if err != nil {
+		responseError(w, http.StatusBadRequest, err)
+		return
+	}
+	km, err := queryCount(r, "km", 1000)
+	if err != nil {
+		responseError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	now := time.Now().UTC()
+	due, err := q.repo.DueMaintenance(r.Context(), now)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	before := now.AddDate(0, 0, days)
+	res := slices.DeleteFunc(due, func(d DTO.DueMaintenance) bool {
+		soon := d.Overdue ||
+			(d.DueOdometer != nil && *d.DueOdometer <= d.Odometer+uint(km)) ||
+			(d.DueAt != nil && d.DueAt.Before(before))
+		return !soon
+	})
+	response(w, http.StatusOK, res)
+}
+
+// FuelConsumption reports what cars used per 100 km and per kilometre of
+// trips between from and to, of the cars car_id when it is given. Cars that
+// deviate from the average of their model by more than threshold percent
+// are flagged as anomalies.
+func (q *QueryService) FuelConsumption(w http.ResponseWriter, r *http.Request) {
+	from, to, err := period(r)
+	if err != nil {
+		responseError(w, http.StatusBadRequest, err)
+		return
+	}
+	var carIDs []uint
+	for _, s := range r.URL.Query()["car_id"] {
+		id, err := strconv.ParseUint(s, 10, 64)
+		if err != nil || id == 0 {
+			responseError(w, http.StatusBadRequest, errors.New("car_id must be a positive integer"))
+			return
+		}
+		carIDs = append(carIDs, uint(id))
+	}
+	threshold, err := queryCount(r, "threshold", 20)
+	if err != nil {
+		responseError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	res, err := q.repo.FuelConsumption(r.Context(), from, to, carIDs...)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	for i, c := range res {
+		res[i].Anomaly = c.DeviationPct != nil && math.Abs(*c.DeviationPct) > float64(threshold)
+	}
+	response(w, http.StatusOK, res)
+}
@@ -0,0 +1,124 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"taksopark/internal/DTO"
+	"taksopark/internal/models"
+	"taksopark/internal/repository"
+)
+
+// Permission allows one kind of request. Routes name the permission they
+// need in cmd/main.go.
+type Permission string
+
+const (
+	PermCarsRead         Permission = "cars:read"
+	PermCarsWrite        Permission = "cars:write"
+	PermModelsRead       Permission = "models:read"
+	PermModelsWrite      Permission = "models:write"
+	PermDriversRead      Permission = "drivers:read"
+	PermDriversWrite     Permission = "drivers:write"
+	PermCustomersRead    Permission = "customers:read"
+	PermCustomersWrite   Permission = "customers:write"
+	PermTripsRead        Permission = "trips:read"
+	PermTripsCreate      Permission = "trips:create"
+	PermTripsWrite       Permission = "trips:write"
+	PermTripsAssign      Permission = "trips:assign"
+	PermTripsDrive       Permission = "trips:drive"
+	PermTariffsRead      Permission = "tariffs:read"
+	PermTariffsWrite     Permission = "tariffs:write"
+	PermFaresEstimate    Permission = "fares:estimate"
+	PermReportsRead      Permission = "reports:read"
+	PermAuditRead        Permission = "audit:read"
+	PermKeysManage       Permission = "keys:manage"
+	PermSelfService      Permission = "self:service"
+	PermShiftsRead       Permission = "shifts:read"
+	PermShiftsWrite      Permission = "shifts:write"
+	PermMaintenanceRead  Permission = "maintenance:read"
+	PermMaintenanceWrite Permission = "maintenance:write"
+	PermPositionsWrite   Permission = "positions:write"
+)
+
+var allPermissions = []Permission{
+	PermCarsRead, PermCarsWrite, PermModelsRead, PermModelsWrite,
+	PermDriversRead, PermDriversWrite, PermCustomersRead, PermCustomersWrite,
+	PermTripsRead, PermTripsCreate, PermTripsWrite, PermTripsAssign, PermTripsDrive,
+	PermTariffsRead, PermTariffsWrite, PermFaresEstimate, PermReportsRead,
+	PermAuditRead, PermKeysManage, PermSelfService, PermShiftsRead, PermShiftsWrite,
+	PermMaintenanceRead, PermMaintenanceWrite, PermPositionsWrite,
+}
+
+// rolePermissions lists what every role may do. Drivers only see and drive
+// their own trips, see driverScope.
+var rolePermissions = map[models.Role][]Permission{
+	models.RoleAdmin: allPermissions,
+	models.RoleDispatcher: {
+		PermCarsRead, PermModelsRead, PermDriversRead, PermCustomersRead, PermCustomersWrite,
+		PermTripsRead, PermTripsCreate, PermTripsAssign, PermTripsDrive,
+		PermTariffsRead, PermFaresEstimate, PermShiftsRead, PermShiftsWrite, PermMaintenanceRead,
+	},
+	models.RoleAccountant: {
+		PermCarsRead, PermModelsRead, PermDriversRead, PermCustomersRead,
+		PermTripsRead, PermTariffsRead, PermFaresEstimate, PermReportsRead, PermShiftsRead,
+		PermMaintenanceRead,
+	},
+	models.RoleDriver: {
+		PermTripsRead, PermTripsDrive, PermSelfService, PermPositionsWrite,
+	},
+}
+
+func (p Principal) can(perm Permission) bool {
+	return slices.Contains(rolePermissions[p.Role], perm)
+}
+
+// Require lets only principals whose role has perm reach next, the others
+// get 403. Everything is allowed when authentication is disabled.
+func (a *Auth) Require(perm Permission, next http.HandlerFunc) http.Handler {
+	if !a.cfg.Enabled {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, _ := PrincipalFrom(r.Context())
+		if !p.can(perm) {
+			writeError(w, newError(http.StatusForbidden, CodeForbidden, "role "+string(p.Role)+" lacks permission "+string(perm)).
+				withDetail("permission", perm))
+			return
+		}
+		next(w, r)
+	})
+}
+
+// driverScope returns the driver whose trips are the only ones the caller
+// may see, if the caller is a driver.
+func driverScope(ctx context.Context) (uint, bool) {
+	p, ok := PrincipalFrom(ctx)
+	if !ok || p.Role != models.RoleDriver {
+		return 0, false
+	}
+	return p.DriverID, true
+}
+
+// ownTrip reports whether the caller may see trip. Trips of other drivers
+// are reported as missing rather than forbidden.
+func ownTrip(ctx context.Context, trip *models.Trip) error {
+	id, ok := driverScope(ctx)
+	if ok && (trip.DriverID == nil || *trip.DriverID != id) {
+		return repository.ErrNotFound
+	}
+	return nil
+}
+
+// Roles lists the roles and their permissions.
+func Roles(w http.ResponseWriter, r *http.Request) {
+	res := make([]DTO.Role, 0, len(models.Roles))
+	for _, role := range models.Roles {
+		perms := make([]string, 0, len(rolePermissions[role]))
+		for _, perm := range rolePermissions[role] {
+			perms = append(perms, string(perm))
+		}
+		res = append(res, DTO.Role{Role: string(role), Permissions: perms})
+	}
+	response(w, http.StatusOK, res)
+}
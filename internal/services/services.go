@@ -2,43 +2,120 @@ package services
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"log"
 	"net/http"
-
-	"gorm.io/gorm"
+	"taksopark/internal/DTO"
+	"taksopark/internal/config"
+	"taksopark/internal/files"
+	"taksopark/internal/repository"
+	"taksopark/internal/validate"
 )
 
 type Service struct {
-	Cars      CarService
-	Models    ModelService
-	Drivers   DriverService
-	Customers CustomerService
-	Trips     TripService
-	Query     QueryService
+	Cars        CarService
+	Models      ModelService
+	Drivers     DriverService
+	Customers   CustomerService
+	Trips       TripService
+	Tariffs     TariffService
+	Fares       FareService
+	Query       QueryService
+	Audit       AuditService
+	APIKeys     APIKeyService
+	Auth        Auth
+	Shifts      ShiftService
+	Documents   DocumentService
+	Maintenance MaintenanceService
+	Fuel        FuelService
+	Positions   PositionService
+	Me          MeService
+	Features    config.FeaturesConfig
 }
 
 func response(w http.ResponseWriter, code int, data any) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(code)
 	if data != nil {
-		err := json.NewEncoder(w).Encode(data)
+		enc := json.NewEncoder(w)
+		enc.SetEscapeHTML(false)
+		err := enc.Encode(data)
 		if err != nil {
 			log.Println(err)
 		}
 	}
 }
 
-func responseError(w http.ResponseWriter, code int, err error) {
-	response(w, code, map[string]string{"error :": err.Error()})
+// decode reads a single JSON object from the body of r into dst and checks
+// it against the validate tags of dst and any extra checks, so that every
+// invalid field is reported at once.
+func decode(r *http.Request, dst any, checks ...func() []DTO.FieldError) error {
+	defer r.Body.Close()
+
+	if err := decodeStrict(r.Body, dst); err != nil {
+		return err
+	}
+	return checkFields(dst, checks...)
+}
+
+// decodeStrict reads a single JSON value into dst, rejecting unknown fields
+// and trailing data.
+func decodeStrict(body io.Reader, dst any) error {
+	dec := json.NewDecoder(body)
+	dec.DisallowUnknownFields()
+	err := dec.Decode(dst)
+	if err == nil && dec.Decode(new(json.RawMessage)) != io.EOF {
+		err = errors.New("body must contain a single JSON value")
+	}
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge):
+		return newError(http.StatusRequestEntityTooLarge, CodeTooLarge, fmt.Sprintf("request body exceeds %d bytes", tooLarge.Limit))
+	case err != nil:
+		return invalidBody(err)
+	}
+	return nil
 }
 
-func NewService(db *gorm.DB) Service {
-	return Service{
-		Cars:      NewCarService(db),
-		Query:     NewQueryService(db),
-		Models:    NewModelService(db),
-		Drivers:   NewDriverService(db),
-		Customers: NewCustomerService(db),
-		Trips:     NewTripService(db),
+func checkFields(dst any, checks ...func() []DTO.FieldError) error {
+	fields := validate.Struct(dst)
+	for _, check := range checks {
+		fields = append(fields, check()...)
 	}
+	if len(fields) > 0 {
+		return validationError(fields...)
+	}
+	return nil
+}
+
+func NewService(repos repository.Repositories, cfg config.Config) Service {
+	maintenance := NewMaintenanceService(repos.Maintenance, repos.MaintenanceRules, repos.Query)
+	s := Service{
+		Cars:        NewCarService(repos.Cars, repos.Models, cfg.Cars),
+		Query:       NewQueryService(repos.Query),
+		Models:      NewModelService(repos.Models),
+		Drivers:     NewDriverService(repos.Drivers),
+		Customers:   NewCustomerService(repos.Customers),
+		Trips:       NewTripService(repos.Trips, repos.Drivers, repos.Documents, maintenance, repos.Tariffs, repos.Cars, cfg.Fares),
+		Tariffs:     NewTariffService(repos.Tariffs),
+		Shifts:      NewShiftService(repos.Shifts),
+		Documents:   NewDocumentService(repos.Documents, repos.Drivers, files.NewStore(cfg.Documents.Dir)),
+		Maintenance: maintenance,
+		Fuel:        NewFuelService(repos.Odometer, repos.Fuel),
+		Fares:       NewFareService(repos.Tariffs, repos.Cars, cfg.Fares),
+		Audit:       NewAuditService(repos.Audit),
+		APIKeys:     NewAPIKeyService(repos.APIKeys),
+		Auth:        NewAuth(repos.APIKeys, cfg.Auth),
+		Features:    cfg.Features,
+	}
+	s.Me = NewMeService(s.Drivers, s.Trips, s.Shifts, s.Query)
+	s.Positions = NewPositionService(repos.Positions, s.Shifts, repos.Query, cfg.Cars.PositionHistory)
+	return s
+}
+
+// Health answers liveness probes, it needs no credentials.
+func Health(w http.ResponseWriter, r *http.Request) {
+	response(w, http.StatusOK, map[string]string{"status": "ok"})
 }
@@ -0,0 +1,207 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"taksopark/internal/DTO"
+	"taksopark/internal/models"
+	"taksopark/internal/repository"
+	"time"
+)
+
+type ShiftService struct {
+	repo repository.ShiftRepository
+}
+
+func NewShiftService(repo repository.ShiftRepository) ShiftService {
+	return ShiftService{
+		repo: repo,
+	}
+}
+
+func shiftWriteError(w http.ResponseWriter, err error) {
+	var overlap *repository.ShiftOverlapError
+	switch {
+	case errors.Is(err, repository.ErrNotFound):
+		writeError(w, newError(http.StatusNotFound, CodeNotFound, "shift not found"))
+	case errors.Is(err, repository.ErrForeignKey):
+		writeError(w, newError(http.StatusUnprocessableEntity, CodeInvalidReference, "driver or car does not exist"))
+	case errors.As(err, &overlap):
+		writeError(w, newError(http.StatusConflict, CodeOverlap, overlap.Error()).
+			withDetail("shift_id", overlap.ShiftID).
+			withDetail("resource", overlap.Resource).
+			withDetail("id", overlap.ID))
+	case errors.Is(err, repository.ErrActiveTrips):
+		writeError(w, newError(http.StatusConflict, CodeInUse, "driver has trips in that car that are not finished yet"))
+	case errors.Is(err, models.ErrShiftClosed):
+		writeError(w, newError(http.StatusConflict, CodeConflict, err.Error()))
+	case errors.Is(err, models.ErrOdometer):
+		writeError(w, validationError(DTO.FieldError{Field: "odometer_out", Message: err.Error()}))
+	default:
+		writeError(w, err)
+	}
+}
+
+func now() time.Time {
+	return time.Now().UTC().Truncate(time.Microsecond)
+}
+
+// shiftFields checks the fields of a shift that depend on each other.
+func shiftFields(req *DTO.CreateShiftRequest) []DTO.FieldError {
+	var fields []DTO.FieldError
+	if req.StartedAt != nil && req.StartedAt.After(time.Now()) {
+		fields = append(fields, DTO.FieldError{Field: "started_at", Message: "must not be in the future"})
+	}
+	switch {
+	case req.EndedAt != nil && req.EndedAt.After(time.Now()):
+		fields = append(fields, DTO.FieldError{Field: "ended_at", Message: "must not be in the future"})
+	case req.EndedAt != nil && req.OdometerOut == nil:
+		fields = append(fields, DTO.FieldError{Field: "odometer_out", Message: "is required when ended_at is given"})
+	case req.EndedAt == nil && req.OdometerOut != nil:
+		fields = append(fields, DTO.FieldError{Field: "ended_at", Message: "is required when odometer_out is given"})
+	}
+	return fields
+}
+
+// open creates shift, starting now unless it has a start already.
+func (s *ShiftService) open(w http.ResponseWriter, r *http.Request, shift *models.Shift) {
+	if shift.StartedAt.IsZero() {
+		shift.StartedAt = now()
+	}
+
+	if err := s.repo.Create(r.Context(), shift); err != nil {
+		shiftWriteError(w, err)
+		return
+	}
+
+	setETag(w, shift.Version)
+	response(w, http.StatusCreated, shift)
+}
+
+// Create opens a shift, or records a past one when ended_at is given.
+func (s *ShiftService) Create(w http.ResponseWriter, r *http.Request) {
+	req := new(DTO.CreateShiftRequest)
+	if err := decode(r, req, func() []DTO.FieldError { return shiftFields(req) }); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	shift := &models.Shift{
+		DriverID:   req.DriverID,
+		CarID:      req.CarID,
+		OdometerIn: *req.OdometerIn,
+	}
+	if req.StartedAt != nil {
+		shift.StartedAt = req.StartedAt.UTC().Truncate(time.Microsecond)
+	}
+	if req.EndedAt != nil {
+		if err := shift.Close(*req.OdometerOut, req.EndedAt.UTC().Truncate(time.Microsecond)); err != nil {
+			shiftWriteError(w, err)
+			return
+		}
+	}
+
+	s.open(w, r, shift)
+}
+
+func (s *ShiftService) Get(w http.ResponseWriter, r *http.Request) {
+	idString := r.PathValue("id")
+	id, err := strconv.Atoi(idString)
+	if err != nil {
+		writeError(w, invalidID())
+		return
+	}
+
+	shift, err := s.repo.Get(r.Context(), uint(id))
+	if err != nil {
+		shiftWriteError(w, err)
+		return
+	}
+
+	if notModified(w, r, etag(shift.Version)) {
+		return
+	}
+	response(w, http.StatusOK, shift)
+}
+
+var shiftListSpec = listSpec{
+	pk:   "shift_id",
+	sort: []string{"shift_id", "started_at"},
+	filters: map[string]filterSpec{
+		"driver_id":    {column: "driver_id", op: repository.OpEq, parse: parseUint},
+		"car_id":       {column: "car_id", op: repository.OpEq, parse: parseUint},
+		"open":         {column: "ended_at", op: repository.OpNull, parse: parseBool},
+		"started_from": {column: "started_at", op: repository.OpGte, parse: parseTime},
+		"started_to":   {column: "started_at", op: repository.OpLte, parse: parseTime},
+	},
+}
+
+func (s *ShiftService) GetAll(w http.ResponseWriter, r *http.Request) {
+	params, err := parseListParams(r, shiftListSpec)
+	if err != nil {
+		responseError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	shifts, total, err := s.repo.List(r.Context(), params)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	responseList(w, r, newPage(r, shiftListSpec, params, shifts, total, func(s models.Shift) uint { return s.ShiftID }))
+}
+
+// current returns the open shift of a driver.
+func (s *ShiftService) current(ctx context.Context, driverID uint) (models.Shift, error) {
+	shifts, _, err := s.repo.List(ctx, repository.ListParams{
+		Filters: []repository.Filter{
+			{Column: "driver_id", Op: repository.OpEq, Value: driverID},
+			{Column: "ended_at", Op: repository.OpNull, Value: true},
+		},
+		Limit: 1,
+	})
+	if err != nil {
+		return models.Shift{}, err
+	}
+	if len(shifts) == 0 {
+		return models.Shift{}, repository.ErrNotFound
+	}
+	return shifts[0], nil
+}
+
+// close ends the shift id now. The driver's trips in the car of the shift
+// have to be finished first.
+func (s *ShiftService) close(w http.ResponseWriter, r *http.Request, id uint) {
+	req := new(DTO.CloseShiftRequest)
+	if err := decode(r, req); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	shift, err := s.repo.Modify(r.Context(), id, func(shift *models.Shift) error {
+		if err := checkIfMatch(r, shift.Version); err != nil {
+			return err
+		}
+		return shift.Close(*req.OdometerOut, now())
+	})
+	if err != nil {
+		shiftWriteError(w, err)
+		return
+	}
+
+	setETag(w, shift.Version)
+	response(w, http.StatusOK, shift)
+}
+
+func (s *ShiftService) Close(w http.ResponseWriter, r *http.Request) {
+	idString := r.PathValue("id")
+	id, err := strconv.Atoi(idString)
+	if err != nil {
+		writeError(w, invalidID())
+		return
+	}
+	s.close(w, r, uint(id))
+}
@@ -0,0 +1,180 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"taksopark/internal/DTO"
+	"taksopark/internal/models"
+	"taksopark/internal/repository"
+)
+
+type TariffService struct {
+	repo repository.TariffRepository
+}
+
+func NewTariffService(repo repository.TariffRepository) TariffService {
+	return TariffService{
+		repo: repo,
+	}
+}
+
+// applyTariffRequest copies req into tariff, filling in the defaults for
+// omitted multipliers and night hours.
+func applyTariffRequest(tariff *models.Tariff, req *DTO.TariffRequest) {
+	tariff.Class = req.Class
+	tariff.BaseFare = req.BaseFare
+	tariff.PerKm = req.PerKm
+	tariff.PerMinute = req.PerMinute
+	tariff.MinimumFare = req.MinimumFare
+	tariff.NightMultiplier, tariff.WeekendMultiplier = 1, 1
+	tariff.NightStart, tariff.NightEnd = 22, 6
+
+	if req.NightMultiplier != nil {
+		tariff.NightMultiplier = *req.NightMultiplier
+	}
+	if req.WeekendMultiplier != nil {
+		tariff.WeekendMultiplier = *req.WeekendMultiplier
+	}
+	if req.NightStart != nil {
+		tariff.NightStart = *req.NightStart
+	}
+	if req.NightEnd != nil {
+		tariff.NightEnd = *req.NightEnd
+	}
+}
+
+func tariffWriteError(w http.ResponseWriter, tariff *models.Tariff, err error) {
+	switch {
+	case errors.Is(err, repository.ErrNotFound):
+		writeError(w, newError(http.StatusNotFound, CodeNotFound, "tariff not found"))
+	case errors.Is(err, repository.ErrDuplicate):
+		writeError(w, newError(http.StatusConflict, CodeDuplicate, fmt.Sprintf("tariff for class %q already exists", tariff.Class)))
+	default:
+		writeError(w, err)
+	}
+}
+
+func (s *TariffService) Create(w http.ResponseWriter, r *http.Request) {
+	req := new(DTO.TariffRequest)
+	if err := decode(r, req); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	tariff := &models.Tariff{}
+	applyTariffRequest(tariff, req)
+
+	if err := s.repo.Create(r.Context(), tariff); err != nil {
+		tariffWriteError(w, tariff, err)
+		return
+	}
+
+	setETag(w, tariff.Version)
+	response(w, http.StatusCreated, tariff)
+}
+
+func (s *TariffService) Get(w http.ResponseWriter, r *http.Request) {
+	idString := r.PathValue("id")
+	id, err := strconv.Atoi(idString)
+	if err != nil {
+		writeError(w, invalidID())
+		return
+	}
+
+	tariff, err := s.repo.Get(r.Context(), uint(id))
+
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if notModified(w, r, etag(tariff.Version)) {
+		return
+	}
+	response(w, http.StatusOK, tariff)
+}
+
+var tariffListSpec = listSpec{
+	pk:   "tariff_id",
+	sort: []string{"tariff_id", "class"},
+	filters: map[string]filterSpec{
+		"class": {column: "class", op: repository.OpEq, parse: parseString},
+	},
+}
+
+func (s *TariffService) GetAll(w http.ResponseWriter, r *http.Request) {
+	params, err := parseListParams(r, tariffListSpec)
+	if err != nil {
+		responseError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	tariffs, total, err := s.repo.List(r.Context(), params)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	responseList(w, r, newPage(r, tariffListSpec, params, tariffs, total, func(t models.Tariff) uint { return t.TariffID }))
+}
+
+func (s *TariffService) Update(w http.ResponseWriter, r *http.Request) {
+	idString := r.PathValue("id")
+	id, err := strconv.Atoi(idString)
+	if err != nil {
+		writeError(w, invalidID())
+		return
+	}
+
+	req := new(DTO.TariffRequest)
+	if err := decode(r, req); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	tariff, err := s.repo.Get(r.Context(), uint(id))
+	if err != nil {
+		tariffWriteError(w, &tariff, err)
+		return
+	}
+	if err := checkIfMatch(r, tariff.Version); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	applyTariffRequest(&tariff, req)
+
+	if err := s.repo.Update(r.Context(), &tariff); err != nil {
+		tariffWriteError(w, &tariff, err)
+		return
+	}
+
+	setETag(w, tariff.Version)
+	response(w, http.StatusOK, tariff)
+}
+
+func (s *TariffService) Delete(w http.ResponseWriter, r *http.Request) {
+	idString := r.PathValue("id")
+	id, err := strconv.Atoi(idString)
+	if err != nil {
+		writeError(w, invalidID())
+		return
+	}
+
+	version, err := ifMatchVersion(r, func() (uint, error) {
+		tariff, err := s.repo.Get(r.Context(), uint(id))
+		return tariff.Version, err
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if err = s.repo.Delete(r.Context(), uint(id), version); err != nil {
+		writeDeleteError(w, err)
+		return
+	}
+	response(w, http.StatusNoContent, nil)
+}
@@ -1,36 +1,141 @@
 package services
 
 import (
-	"encoding/json"
+	"context"
 	"errors"
+	"fmt"
 	"net/http"
 	"taksopark/internal/DTO"
+	"taksopark/internal/config"
 	"taksopark/internal/models"
+	"taksopark/internal/repository"
 
 	"strconv"
-
-	"gorm.io/gorm"
+	"strings"
+	"time"
 )
 
 type TripService struct {
-	db *gorm.DB
+	repo        repository.TripRepository
+	drivers     repository.DriverRepository
+	documents   repository.DocumentRepository
+	maintenance MaintenanceService
+	pricer      pricer
 }
 
-func NewTripService(init_db *gorm.DB) TripService {
+func NewTripService(repo repository.TripRepository, drivers repository.DriverRepository, documents repository.DocumentRepository, maintenance MaintenanceService, tariffs repository.TariffRepository, cars repository.CarRepository, cfg config.FaresConfig) TripService {
 	return TripService{
-		db: init_db,
+		repo:        repo,
+		drivers:     drivers,
+		documents:   documents,
+		maintenance: maintenance,
+		pricer:      newPricer(tariffs, cars, cfg),
+	}
+}
+
+// statusFields lists the fields that do not fit the status a trip is created
+// with. A requested trip gets its driver, car and times later; a completed
+// one is recorded after the fact and needs all of them.
+func statusFields(req *DTO.CreateTripRequest) []DTO.FieldError {
+	completed := req.Status == string(models.TripCompleted)
+	set := map[string]bool{
+		"driver_id":  req.DriverID != nil,
+		"car_id":     req.CarID != nil,
+		"start_time": req.StartTime != nil,
+		"end_time":   req.EndTime != nil,
+	}
+
+	var fields []DTO.FieldError
+	for _, name := range []string{"driver_id", "car_id", "start_time", "end_time"} {
+		switch {
+		case completed && !set[name]:
+			fields = append(fields, DTO.FieldError{Field: name, Message: "is required for a completed trip"})
+		case !completed && set[name]:
+			fields = append(fields, DTO.FieldError{Field: name, Message: "must be empty for a requested trip, use POST /trips/{id}/assign"})
+		}
+	}
+	return fields
+}
+
+// changed reports whether a and b differ, nil pointers are equal.
+func changed[T comparable](a, b *T) bool {
+	if a == nil || b == nil {
+		return a != b
+	}
+	return *a != *b
+}
+
+// changedTime reports whether a and b are different instants, nil pointers
+// are equal.
+func changedTime(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a != b
+	}
+	return !a.Equal(*b)
+}
+
+// lifecycleFields lists the fields an update of stored to trip changes
+// although only the lifecycle sets them on trips that are not completed.
+// Completed trips keep their driver and car.
+func lifecycleFields(stored, trip *models.Trip) []DTO.FieldError {
+	var fields []DTO.FieldError
+	if stored.Status == models.TripCompleted {
+		if trip.DriverID == nil {
+			fields = append(fields, DTO.FieldError{Field: "driver_id", Message: "is required for a completed trip"})
+		}
+		if trip.CarID == nil {
+			fields = append(fields, DTO.FieldError{Field: "car_id", Message: "is required for a completed trip"})
+		}
+		return fields
+	}
+
+	set := map[string]bool{
+		"driver_id":  changed(stored.DriverID, trip.DriverID),
+		"car_id":     changed(stored.CarID, trip.CarID),
+		"start_time": changedTime(stored.StartTime, trip.StartTime),
+		"end_time":   changedTime(stored.EndTime, trip.EndTime),
+	}
+	for _, name := range []string{"driver_id", "car_id", "start_time", "end_time"} {
+		if set[name] {
+			fields = append(fields, DTO.FieldError{Field: name, Message: "cannot be changed while the trip is " + string(stored.Status) + ", use the lifecycle endpoints"})
+		}
+	}
+	return fields
+}
+
+// checkUpdate refuses a PUT or PATCH of stored to trip that bypasses the
+// lifecycle, and a new driver or car of a completed trip that could not be
+// assigned.
+func (s *TripService) checkUpdate(ctx context.Context, stored, trip *models.Trip) error {
+	if fields := lifecycleFields(stored, trip); len(fields) > 0 {
+		return validationError(fields...)
+	}
+	if changed(stored.DriverID, trip.DriverID) || changed(stored.CarID, trip.CarID) {
+		return s.checkAssignment(ctx, *trip.DriverID, *trip.CarID)
 	}
+	return nil
+}
+
+// fareChanged reports whether an update of stored to trip changes what the
+// fare is worked out from. Completed trips keep their fare otherwise, later
+// tariff changes must not reach them.
+func fareChanged(stored, trip *models.Trip) bool {
+	return changed(stored.CarID, trip.CarID) ||
+		stored.StartLat != trip.StartLat || stored.StartLon != trip.StartLon ||
+		stored.EndLat != trip.EndLat || stored.EndLon != trip.EndLon ||
+		changedTime(stored.StartTime, trip.StartTime) || changedTime(stored.EndTime, trip.EndTime)
 }
 
 func (s *TripService) Create(w http.ResponseWriter, r *http.Request) {
 	req := new(DTO.CreateTripRequest)
-	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
-		responseError(w, http.StatusBadRequest, err)
+	if err := decode(r, req, func() []DTO.FieldError { return statusFields(req) }); err != nil {
+		writeError(w, err)
 		return
 	}
-	defer r.Body.Close()
 
+	now := time.Now()
 	trip := &models.Trip{
+		Status:     models.TripStatus(req.Status),
 		DriverID:   req.DriverID,
 		CarID:      req.CarID,
 		CustomerID: req.CustomerID,
@@ -40,14 +145,45 @@ func (s *TripService) Create(w http.ResponseWriter, r *http.Request) {
 		EndLon:     req.EndLon,
 		StartTime:  req.StartTime,
 		EndTime:    req.EndTime,
-		Cost:       req.Cost,
+		Class:      req.Class,
+	}
+
+	if trip.Status == models.TripCompleted {
+		trip.PickedUpAt = trip.StartTime
+		trip.CompletedAt = trip.EndTime
+	} else {
+		trip.Status = models.TripRequested
+		trip.RequestedAt = &now
 	}
 
-	if err := s.db.Create(trip).Error; err != nil {
-		responseError(w, http.StatusInternalServerError, err)
+	if err := trip.ValidateTimes(); err != nil {
+		writeError(w, err)
 		return
 	}
 
+	if trip.DriverID != nil && trip.CarID != nil {
+		if err := s.checkAssignment(r.Context(), *trip.DriverID, *trip.CarID); err != nil {
+			tripWriteError(w, err)
+			return
+		}
+	}
+
+	if err := s.checkTripClass(r.Context(), trip); err != nil {
+		tripWriteError(w, err)
+		return
+	}
+
+	if err := s.pricer.price(r.Context(), trip); err != nil {
+		tripWriteError(w, err)
+		return
+	}
+
+	if err := s.repo.Create(r.Context(), trip); err != nil {
+		tripWriteError(w, err)
+		return
+	}
+
+	setETag(w, trip.Version)
 	response(w, http.StatusCreated, trip)
 }
 
@@ -55,32 +191,63 @@ func (s *TripService) Get(w http.ResponseWriter, r *http.Request) {
 	idString := r.PathValue("id")
 	id, err := strconv.Atoi(idString)
 	if err != nil {
-		responseError(w, http.StatusBadRequest, errors.New("invalid id"))
+		writeError(w, invalidID())
 		return
 	}
 
-	var trip models.Trip
-	err = s.db.Preload("Customer").Preload("Driver").Preload("Car").Preload("Car.Model").First(&trip, id).Error
+	trip, err := s.repo.Get(r.Context(), uint(id))
+	if err == nil {
+		err = ownTrip(r.Context(), &trip)
+	}
 
-	switch {
-	case err == nil:
-		response(w, http.StatusOK, trip)
-	case errors.Is(err, gorm.ErrRecordNotFound):
-		responseError(w, http.StatusNotFound, errors.New("record not found"))
-	default:
-		responseError(w, http.StatusInternalServerError, err)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if notModified(w, r, etag(trip.Version)) {
+		return
 	}
+	response(w, http.StatusOK, trip)
+}
+
+var tripListSpec = listSpec{
+	pk:   "trip_id",
+	sort: []string{"trip_id", "start_time", "end_time", "cost", "distance_km"},
+	filters: map[string]filterSpec{
+		"status":            {column: "status", op: repository.OpEq, parse: parseString},
+		"class":             {column: "class", op: repository.OpEq, parse: parseString},
+		"driver_id":         {column: "driver_id", op: repository.OpEq, parse: parseUint},
+		"car_id":            {column: "car_id", op: repository.OpEq, parse: parseUint},
+		"offered_driver_id": {column: "offered_driver_id", op: repository.OpEq, parse: parseUint},
+		"customer_id":       {column: "customer_id", op: repository.OpEq, parse: parseUint},
+		"start_time_from":   {column: "start_time", op: repository.OpGte, parse: parseTime},
+		"start_time_to":     {column: "start_time", op: repository.OpLte, parse: parseTime},
+		"cost_min":          {column: "cost", op: repository.OpGte, parse: parseFloat},
+		"cost_max":          {column: "cost", op: repository.OpLte, parse: parseFloat},
+		"distance_min":      {column: "distance_km", op: repository.OpGte, parse: parseFloat},
+		"distance_max":      {column: "distance_km", op: repository.OpLte, parse: parseFloat},
+	},
+	deleted: true,
 }
 
 func (s *TripService) GetAll(w http.ResponseWriter, r *http.Request) {
-	var trips []models.Trip
-	err := s.db.Preload("Customer").Preload("Driver").Preload("Car").Preload("Car.Model").Find(&trips).Error
+	params, err := parseListParams(r, tripListSpec)
 	if err != nil {
-		responseError(w, http.StatusInternalServerError, err)
+		responseError(w, http.StatusBadRequest, err)
 		return
 	}
+	if driverID, ok := driverScope(r.Context()); ok {
+		params.Filters = append(params.Filters, repository.Filter{Column: "driver_id", Op: repository.OpEq, Value: driverID})
+	}
 
-	response(w, http.StatusOK, trips)
+	trips, total, err := s.repo.List(r.Context(), params)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	responseList(w, r, newPage(r, tripListSpec, params, trips, total, func(t models.Trip) uint { return t.TripID }))
 }
 
 func (s *TripService) Update(w http.ResponseWriter, r *http.Request) {
@@ -88,26 +255,102 @@ func (s *TripService) Update(w http.ResponseWriter, r *http.Request) {
 	idString := r.PathValue("id")
 	id, err := strconv.Atoi(idString)
 	if err != nil {
-		responseError(w, http.StatusBadRequest, errors.New("invalid id"))
+		writeError(w, invalidID())
 		return
 	}
 
 	req := new(DTO.UpdateTripRequest)
-	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
-		responseError(w, http.StatusBadRequest, err)
+	if err := decode(r, req); err != nil {
+		writeError(w, err)
 		return
 	}
-	defer r.Body.Close()
 
-	var trip models.Trip
-	if err := s.db.Preload("Customer").Preload("Driver").Preload("Car").First(&trip, id).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			responseError(w, http.StatusNotFound, errors.New("trip not found"))
+	trip, err := s.repo.Get(r.Context(), uint(id))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := checkIfMatch(r, trip.Version); err != nil {
+		writeError(w, err)
+		return
+	}
+	stored := trip
+
+	trip.DriverID = req.DriverID
+	trip.CarID = req.CarID
+	trip.CustomerID = req.CustomerID
+	trip.StartLat = req.StartLat
+	trip.StartLon = req.StartLon
+	trip.EndLat = req.EndLat
+	trip.EndLon = req.EndLon
+	trip.StartTime = req.StartTime
+	trip.EndTime = req.EndTime
+
+	if err := trip.ValidateTimes(); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if err := s.checkUpdate(r.Context(), &stored, &trip); err != nil {
+		tripWriteError(w, err)
+		return
+	}
+
+	if err := s.checkTripClass(r.Context(), &trip); err != nil {
+		tripWriteError(w, err)
+		return
+	}
+
+	if fareChanged(&stored, &trip) {
+		if err := s.pricer.price(r.Context(), &trip); err != nil {
+			tripWriteError(w, err)
 			return
 		}
-		responseError(w, http.StatusInternalServerError, err)
+	}
+
+	if err := s.repo.Update(r.Context(), &trip); err != nil {
+		tripWriteError(w, err)
+		return
+	}
+
+	setETag(w, trip.Version)
+	response(w, http.StatusOK, trip)
+}
+
+func (s *TripService) UpdateSomething(w http.ResponseWriter, r *http.Request) {
+	idString := r.PathValue("id")
+	id, err := strconv.Atoi(idString)
+	if err != nil {
+		writeError(w, invalidID())
+		return
+	}
+
+	trip, err := s.repo.Get(r.Context(), uint(id))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := checkIfMatch(r, trip.Version); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	req := &DTO.UpdateSomethingTripRequest{
+		DriverID:   trip.DriverID,
+		CarID:      trip.CarID,
+		CustomerID: trip.CustomerID,
+		StartLat:   trip.StartLat,
+		StartLon:   trip.StartLon,
+		EndLat:     trip.EndLat,
+		EndLon:     trip.EndLon,
+		StartTime:  trip.StartTime,
+		EndTime:    trip.EndTime,
+	}
+	if err := decodePatch(r, req); err != nil {
+		writeError(w, err)
 		return
 	}
+	stored := trip
 
 	trip.DriverID = req.DriverID
 	trip.CarID = req.CarID
@@ -118,81 +361,372 @@ func (s *TripService) Update(w http.ResponseWriter, r *http.Request) {
 	trip.EndLon = req.EndLon
 	trip.StartTime = req.StartTime
 	trip.EndTime = req.EndTime
-	trip.Cost = req.Cost
 
-	if err := s.db.Save(&trip).Error; err != nil {
-		responseError(w, http.StatusInternalServerError, err)
+	if err := trip.ValidateTimes(); err != nil {
+		writeError(w, err)
 		return
 	}
 
+	if err := s.checkUpdate(r.Context(), &stored, &trip); err != nil {
+		tripWriteError(w, err)
+		return
+	}
+
+	if err := s.checkTripClass(r.Context(), &trip); err != nil {
+		tripWriteError(w, err)
+		return
+	}
+
+	if fareChanged(&stored, &trip) {
+		if err := s.pricer.price(r.Context(), &trip); err != nil {
+			tripWriteError(w, err)
+			return
+		}
+	}
+
+	err = s.repo.Update(r.Context(), &trip)
+	if err != nil {
+		tripWriteError(w, err)
+		return
+	}
+
+	setETag(w, trip.Version)
 	response(w, http.StatusOK, trip)
 }
 
-func (s *TripService) UpdateSomething(w http.ResponseWriter, r *http.Request) {
+func (s *TripService) Delete(w http.ResponseWriter, r *http.Request) {
 	idString := r.PathValue("id")
 	id, err := strconv.Atoi(idString)
 	if err != nil {
-		responseError(w, http.StatusBadRequest, err)
+		writeError(w, invalidID())
 		return
 	}
 
-	req := new(DTO.UpdateSomethingTripRequest)
-	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
-		responseError(w, http.StatusBadRequest, err)
+	version, err := ifMatchVersion(r, func() (uint, error) {
+		trip, err := s.repo.Get(r.Context(), uint(id))
+		return trip.Version, err
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if err = s.repo.Delete(r.Context(), uint(id), version); err != nil {
+		writeDeleteError(w, err)
+		return
+	}
+	response(w, http.StatusNoContent, nil)
+}
+
+func (s *TripService) Restore(w http.ResponseWriter, r *http.Request) {
+	idString := r.PathValue("id")
+	id, err := strconv.Atoi(idString)
+	if err != nil {
+		writeError(w, invalidID())
 		return
 	}
-	r.Body.Close()
 
-	var trip models.Trip
-	err = s.db.First(&trip, id).Error
+	trip, err := s.repo.Restore(r.Context(), uint(id))
 	if err != nil {
-		responseError(w, http.StatusBadRequest, err)
+		writeError(w, err)
+		return
+	}
+
+	setETag(w, trip.Version)
+	response(w, http.StatusOK, trip)
+}
+
+// transition runs one step of the trip lifecycle. apply may change the trip
+// after the status check, the whole step is atomic.
+func (s *TripService) transition(w http.ResponseWriter, r *http.Request, to models.TripStatus, apply func(trip *models.Trip) error) {
+	idString := r.PathValue("id")
+	id, err := strconv.Atoi(idString)
+	if err != nil {
+		writeError(w, invalidID())
+		return
+	}
+
+	trip, err := s.repo.Modify(r.Context(), uint(id), func(trip *models.Trip) error {
+		if err := ownTrip(r.Context(), trip); err != nil {
+			return err
+		}
+		if err := checkIfMatch(r, trip.Version); err != nil {
+			return err
+		}
+		if err := trip.Transition(to, time.Now()); err != nil {
+			return err
+		}
+		if apply != nil {
+			return apply(trip)
+		}
+		return nil
+	})
+
+	if err != nil {
+		tripWriteError(w, err)
 		return
 	}
 
+	setETag(w, trip.Version)
+	response(w, http.StatusOK, trip)
+}
+
+// tripWriteError reports a failed trip write. Clashes with other trips and
+// with the lifecycle are conflicts.
+func tripWriteError(w http.ResponseWriter, err error) {
+	var expired *documentsExpiredError
+	var unavailable *carUnavailableError
+	var wrongClass *wrongClassError
 	switch {
-	case req.DriverID != nil:
-		trip.DriverID = *req.DriverID
-	case req.CarID != nil:
-		trip.CarID = *req.CarID
-	case req.CustomerID != nil:
-		trip.CustomerID = *req.CustomerID
-	case req.StartLon != nil:
-		trip.StartLon = *req.StartLon
-	case req.StartLat != nil:
-		trip.StartLat = *req.StartLat
-	case req.EndLat != nil:
-		trip.EndLat = *req.EndLat
-	case req.EndLon != nil:
-		trip.EndLon = *req.EndLon
-	case req.StartTime != nil:
-		trip.StartTime = *req.StartTime
-	case req.EndTime != nil:
-		trip.EndTime = *req.EndTime
-	case req.Cost != nil:
-		trip.Cost = *req.Cost
-	}
-
-	err = s.db.Preload("Customer").Preload("Driver").Preload("Car").Save(&trip).Error
-	if err != nil {
-		responseError(w, http.StatusInternalServerError, err)
+	case errors.Is(err, repository.ErrNotFound):
+		writeError(w, newError(http.StatusNotFound, CodeNotFound, "trip not found"))
+	case errors.Is(err, repository.ErrForeignKey):
+		writeError(w, newError(http.StatusUnprocessableEntity, CodeInvalidReference, "driver, car or customer does not exist"))
+	case errors.Is(err, errNoTariff):
+		writeError(w, newError(http.StatusConflict, CodeNoTariff, err.Error()))
+	case errors.Is(err, errTripChanged):
+		writeError(w, newError(http.StatusConflict, CodeConflict, err.Error()))
+	case errors.Is(err, errDriverOffline):
+		writeError(w, newError(http.StatusConflict, CodeDriverOffline, err.Error()))
+	case errors.Is(err, models.ErrNotOffered):
+		writeError(w, newError(http.StatusNotFound, CodeNotFound, "offer not found"))
+	case errors.As(err, &expired):
+		writeError(w, newError(http.StatusConflict, CodeDocumentsExpired, expired.Error()).
+			withDetail("driver_id", expired.DriverID).
+			withDetail("kinds", expired.Kinds))
+	case errors.As(err, &unavailable):
+		writeError(w, newError(http.StatusConflict, CodeCarUnavailable, unavailable.Error()).
+			withDetail("car_id", unavailable.CarID).
+			withDetail("reason", unavailable.Reason).
+			withDetail("service_types", unavailable.ServiceTypes))
+	case errors.As(err, &wrongClass):
+		writeError(w, newError(http.StatusConflict, CodeWrongClass, wrongClass.Error()).
+			withDetail("car_id", wrongClass.CarID).
+			withDetail("class", wrongClass.Class).
+			withDetail("requested_class", wrongClass.Requested))
+	default:
+		writeError(w, err)
+	}
+}
+
+func (s *TripService) Assign(w http.ResponseWriter, r *http.Request) {
+	req := new(DTO.AssignTripRequest)
+	if err := decode(r, req); err != nil {
+		writeError(w, err)
 		return
 	}
 
-	response(w, http.StatusNoContent, nil)
+	if err := s.checkAssignment(r.Context(), req.DriverID, req.CarID); err != nil {
+		tripWriteError(w, err)
+		return
+	}
+	class, err := s.carClass(r.Context(), req.CarID)
+	if err != nil {
+		tripWriteError(w, err)
+		return
+	}
+
+	s.transition(w, r, models.TripAssigned, func(trip *models.Trip) error {
+		if err := checkClass(trip, req.CarID, class); err != nil {
+			return err
+		}
+		trip.DriverID = &req.DriverID
+		trip.CarID = &req.CarID
+		return nil
+	})
 }
 
-func (s *TripService) Delete(w http.ResponseWriter, r *http.Request) {
+// documentsExpiredError lists the kinds of mandatory documents a driver
+// has only expired ones of.
+type documentsExpiredError struct {
+	DriverID uint
+	Kinds    []models.DocumentKind
+}
+
+func (e *documentsExpiredError) Error() string {
+	kinds := make([]string, len(e.Kinds))
+	for i, kind := range e.Kinds {
+		kinds[i] = string(kind)
+	}
+	return fmt.Sprintf("driver %d has expired documents: %s", e.DriverID, strings.Join(kinds, ", "))
+}
+
+// checkDocuments refuses drivers whose licence, medical certificate or
+// taxi permit has expired. Trips the driver already has are not affected.
+func (s *TripService) checkDocuments(ctx context.Context, driverID uint) error {
+	docs, _, err := s.documents.List(ctx, repository.ListParams{
+		Filters: []repository.Filter{{Column: "driver_id", Op: repository.OpEq, Value: driverID}},
+	})
+	if err != nil {
+		return err
+	}
+	if kinds := models.ExpiredDocuments(docs, time.Now()); len(kinds) > 0 {
+		return &documentsExpiredError{DriverID: driverID, Kinds: kinds}
+	}
+	return nil
+}
+
+// checkAssignment refuses drivers with expired documents and cars that
+// are in the workshop or overdue for a service.
+func (s *TripService) checkAssignment(ctx context.Context, driverID, carID uint) error {
+	if err := s.checkDocuments(ctx, driverID); err != nil {
+		return err
+	}
+	return s.maintenance.checkCar(ctx, carID)
+}
+
+// wrongClassError reports a car of another class than its trip was
+// requested in.
+type wrongClassError struct {
+	CarID     uint
+	Class     string
+	Requested string
+}
+
+func (e *wrongClassError) Error() string {
+	return fmt.Sprintf("car %d is of class %s, the trip was requested in class %s", e.CarID, e.Class, e.Requested)
+}
+
+// carClass returns the class of the model of the car carID. A missing car
+// is reported as a foreign key violation, like a trip referencing it would
+// be.
+func (s *TripService) carClass(ctx context.Context, carID uint) (string, error) {
+	car, err := s.pricer.cars.Get(ctx, carID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return "", repository.ErrForeignKey
+	}
+	return car.Model.Class, err
+}
+
+// checkClass refuses the car carID of class for a trip requested in
+// another class.
+func checkClass(trip *models.Trip, carID uint, class string) error {
+	if trip.Class == "" || trip.Class == class {
+		return nil
+	}
+	return &wrongClassError{CarID: carID, Class: class, Requested: trip.Class}
+}
+
+// checkTripClass refuses the car of a trip written with PUT, PATCH or as
+// completed when the trip was requested in another class.
+func (s *TripService) checkTripClass(ctx context.Context, trip *models.Trip) error {
+	if trip.Class == "" || trip.CarID == nil {
+		return nil
+	}
+	class, err := s.carClass(ctx, *trip.CarID)
+	if err != nil {
+		return err
+	}
+	return checkClass(trip, *trip.CarID, class)
+}
+
+var errDriverOffline = errors.New("driver is offline")
+
+// Offer proposes a requested trip to an online driver, who accepts or
+// declines it under /me/offers.
+func (s *TripService) Offer(w http.ResponseWriter, r *http.Request) {
+	req := new(DTO.AssignTripRequest)
+	if err := decode(r, req); err != nil {
+		writeError(w, err)
+		return
+	}
+
 	idString := r.PathValue("id")
 	id, err := strconv.Atoi(idString)
 	if err != nil {
-		responseError(w, http.StatusBadRequest, err)
+		writeError(w, invalidID())
 		return
 	}
 
-	if err = s.db.Delete(models.Trip{}, id).Error; err != nil {
-		responseError(w, http.StatusInternalServerError, err)
+	driver, err := s.drivers.Get(r.Context(), req.DriverID)
+	switch {
+	case errors.Is(err, repository.ErrNotFound):
+		err = repository.ErrForeignKey
+	case err == nil && !driver.Online:
+		err = errDriverOffline
+	case err == nil:
+		err = s.checkAssignment(r.Context(), req.DriverID, req.CarID)
+	}
+	var class string
+	if err == nil {
+		class, err = s.carClass(r.Context(), req.CarID)
+	}
+	if err != nil {
+		tripWriteError(w, err)
 		return
 	}
-	response(w, http.StatusNoContent, nil)
+
+	trip, err := s.repo.Modify(r.Context(), uint(id), func(trip *models.Trip) error {
+		if err := checkIfMatch(r, trip.Version); err != nil {
+			return err
+		}
+		if err := checkClass(trip, req.CarID, class); err != nil {
+			return err
+		}
+		return trip.Offer(req.DriverID, req.CarID, time.Now())
+	})
+	if err != nil {
+		tripWriteError(w, err)
+		return
+	}
+
+	setETag(w, trip.Version)
+	response(w, http.StatusOK, trip)
+}
+
+func (s *TripService) Depart(w http.ResponseWriter, r *http.Request) {
+	s.transition(w, r, models.TripEnRoute, nil)
+}
+
+func (s *TripService) Pickup(w http.ResponseWriter, r *http.Request) {
+	s.transition(w, r, models.TripInProgress, nil)
+}
+
+var errTripChanged = errors.New("trip was changed concurrently, try again")
+
+// Complete finishes the ride and prices it with the tariff of the trip's
+// car. The tariff is looked up beforehand, so the car must still be the
+// same when the trip is saved.
+func (s *TripService) Complete(w http.ResponseWriter, r *http.Request) {
+	idString := r.PathValue("id")
+	id, err := strconv.Atoi(idString)
+	if err != nil {
+		writeError(w, invalidID())
+		return
+	}
+
+	trip, err := s.repo.Get(r.Context(), uint(id))
+	if err != nil {
+		tripWriteError(w, err)
+		return
+	}
+
+	var tariff models.Tariff
+	if trip.Status == models.TripInProgress && trip.CarID != nil {
+		if tariff, err = s.pricer.forCar(r.Context(), *trip.CarID); err != nil {
+			tripWriteError(w, err)
+			return
+		}
+	}
+
+	s.transition(w, r, models.TripCompleted, func(t *models.Trip) error {
+		if t.CarID == nil || trip.CarID == nil || *t.CarID != *trip.CarID {
+			return errTripChanged
+		}
+		t.Price(&tariff, s.pricer.loc)
+		return nil
+	})
+}
+
+func (s *TripService) Cancel(w http.ResponseWriter, r *http.Request) {
+	req := new(DTO.CancelTripRequest)
+	if err := decode(r, req); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	s.transition(w, r, models.TripCancelled, func(trip *models.Trip) error {
+		trip.CancelReason = req.Reason
+		return nil
+	})
 }
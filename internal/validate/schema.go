@@ -0,0 +1,203 @@
+package validate
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"taksopark/internal/DTO"
+	"unicode/utf8"
+)
+
+// schemaKeywords are the JSON Schema keywords Schema understands. The
+// annotations among them are accepted and ignored.
+var schemaKeywords = []string{
+	"$schema", "$id", "title", "description",
+	"type", "enum", "properties", "required", "additionalProperties", "items",
+	"minimum", "maximum", "minLength", "maxLength", "pattern",
+}
+
+var schemaTypes = []string{"object", "array", "string", "number", "integer", "boolean", "null"}
+
+// Schema is the subset of JSON Schema needed to describe free-form JSON
+// such as the notes of a car. A schema using any other keyword is rejected
+// when it is parsed, so no rule is silently ignored.
+type Schema struct {
+	Type                 types              `json:"type"`
+	Enum                 []any              `json:"enum"`
+	Properties           map[string]*Schema `json:"properties"`
+	Required             []string           `json:"required"`
+	AdditionalProperties *bool              `json:"additionalProperties"`
+	Items                *Schema            `json:"items"`
+	Minimum              *float64           `json:"minimum"`
+	Maximum              *float64           `json:"maximum"`
+	MinLength            *int               `json:"minLength"`
+	MaxLength            *int               `json:"maxLength"`
+	Pattern              string             `json:"pattern"`
+
+	pattern *regexp.Regexp
+}
+
+// types is the type keyword, a single type name or a list of them.
+type types []string
+
+func (t *types) UnmarshalJSON(b []byte) error {
+	var one string
+	if err := json.Unmarshal(b, &one); err == nil {
+		*t = types{one}
+	} else if err := json.Unmarshal(b, (*[]string)(t)); err != nil {
+		return errors.New("type must be a type name or a list of them")
+	}
+	for _, name := range *t {
+		if !slices.Contains(schemaTypes, name) {
+			return fmt.Errorf("unknown type %q", name)
+		}
+	}
+	return nil
+}
+
+// ParseSchema reads a JSON Schema document.
+func ParseSchema(data []byte) (*Schema, error) {
+	s := new(Schema)
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("invalid schema: %w", err)
+	}
+	return s, nil
+}
+
+func (s *Schema) UnmarshalJSON(b []byte) error {
+	var keywords map[string]json.RawMessage
+	if err := json.Unmarshal(b, &keywords); err != nil {
+		return errors.New("schema must be an object")
+	}
+	for _, k := range sortedKeys(keywords) {
+		if !slices.Contains(schemaKeywords, k) {
+			return fmt.Errorf("unsupported keyword %q", k)
+		}
+	}
+
+	type plain Schema
+	if err := json.Unmarshal(b, (*plain)(s)); err != nil {
+		return err
+	}
+	if s.Pattern != "" {
+		pattern, err := regexp.Compile(s.Pattern)
+		if err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", s.Pattern, err)
+		}
+		s.pattern = pattern
+	}
+	return nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// Validate checks v, a value decoded by encoding/json into any, and returns
+// every violation. Fields are named by their path below field, such as
+// notes.color or notes.tags[0].
+func (s *Schema) Validate(field string, v any) []DTO.FieldError {
+	if msg := s.checkType(v); msg != "" {
+		return []DTO.FieldError{{Field: field, Message: msg}}
+	}
+	if len(s.Enum) > 0 && !slices.ContainsFunc(s.Enum, func(e any) bool { return reflect.DeepEqual(e, v) }) {
+		allowed := make([]string, len(s.Enum))
+		for i, e := range s.Enum {
+			b, _ := json.Marshal(e)
+			allowed[i] = string(b)
+		}
+		return []DTO.FieldError{{Field: field, Message: "must be one of: " + strings.Join(allowed, ", ")}}
+	}
+
+	var errs []DTO.FieldError
+	switch v := v.(type) {
+	case map[string]any:
+		for _, name := range s.Required {
+			if _, ok := v[name]; !ok {
+				errs = append(errs, DTO.FieldError{Field: field + "." + name, Message: "is required"})
+			}
+		}
+		for _, name := range sortedKeys(v) {
+			if prop, ok := s.Properties[name]; ok {
+				errs = append(errs, prop.Validate(field+"."+name, v[name])...)
+			} else if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+				errs = append(errs, DTO.FieldError{Field: field + "." + name, Message: "is not allowed"})
+			}
+		}
+	case []any:
+		if s.Items != nil {
+			for i, item := range v {
+				errs = append(errs, s.Items.Validate(field+"["+strconv.Itoa(i)+"]", item)...)
+			}
+		}
+	case string:
+		if msg := s.checkString(v); msg != "" {
+			errs = append(errs, DTO.FieldError{Field: field, Message: msg})
+		}
+	case float64:
+		if s.Minimum != nil && v < *s.Minimum {
+			errs = append(errs, DTO.FieldError{Field: field, Message: fmt.Sprintf("must be at least %v", *s.Minimum)})
+		}
+		if s.Maximum != nil && v > *s.Maximum {
+			errs = append(errs, DTO.FieldError{Field: field, Message: fmt.Sprintf("must be at most %v", *s.Maximum)})
+		}
+	}
+	return errs
+}
+
+func (s *Schema) checkType(v any) string {
+	if len(s.Type) == 0 {
+		return ""
+	}
+	var actual []string
+	switch v := v.(type) {
+	case map[string]any:
+		actual = []string{"object"}
+	case []any:
+		actual = []string{"array"}
+	case string:
+		actual = []string{"string"}
+	case float64:
+		actual = []string{"number"}
+		if v == math.Trunc(v) {
+			actual = append(actual, "integer")
+		}
+	case bool:
+		actual = []string{"boolean"}
+	case nil:
+		actual = []string{"null"}
+	}
+	for _, t := range actual {
+		if slices.Contains(s.Type, t) {
+			return ""
+		}
+	}
+	if len(s.Type) == 1 {
+		return "must be of type " + s.Type[0]
+	}
+	return "must be of type " + strings.Join(s.Type, " or ")
+}
+
+func (s *Schema) checkString(v string) string {
+	n := utf8.RuneCountInString(v)
+	switch {
+	case s.MinLength != nil && n < *s.MinLength:
+		return fmt.Sprintf("must be at least %d characters", *s.MinLength)
+	case s.MaxLength != nil && n > *s.MaxLength:
+		return fmt.Sprintf("must be at most %d characters", *s.MaxLength)
+	case s.pattern != nil && !s.pattern.MatchString(v):
+		return "must match " + s.Pattern
+	}
+	return ""
+}
@@ -0,0 +1,166 @@
+package validate
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"taksopark/internal/DTO"
+	"time"
+	"unicode/utf8"
+)
+
+var phonePattern = regexp.MustCompile(`^\+?[0-9]{5,14}$`)
+
+// Struct checks v, a struct or a pointer to one, against the rules in its
+// validate tags and returns every violation. Fields are named by their json
+// tags.
+//
+// Rules are separated by commas. A field left at its zero value, or a nil
+// pointer, is only checked by required; the other rules apply to values
+// that are present.
+//
+//	required      the value must be present and, for strings, not blank
+//	notblank      a present string must not be blank
+//	min=N, max=N  bounds for numbers, length bounds for strings
+//	positive      the number must be greater than zero
+//	oneof=a b     the string must be one of the listed values
+//	lat, lon      a latitude or longitude in degrees
+//	phone         digits with an optional leading +
+//	object        raw JSON holding an object or null
+//	after=field   a time later than the time in the named field
+func Struct(v any) []DTO.FieldError {
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	rt := rv.Type()
+
+	var errs []DTO.FieldError
+	for i := 0; i < rt.NumField(); i++ {
+		tag := rt.Field(i).Tag.Get("validate")
+		if tag == "" {
+			continue
+		}
+		if msg := check(rv, rv.Field(i), tag); msg != "" {
+			errs = append(errs, DTO.FieldError{Field: jsonName(rt.Field(i)), Message: msg})
+		}
+	}
+	return errs
+}
+
+func jsonName(f reflect.StructField) string {
+	name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+	if name == "" {
+		return f.Name
+	}
+	return name
+}
+
+func check(parent, fv reflect.Value, tag string) string {
+	present := !fv.IsZero()
+	if fv.Kind() == reflect.Pointer && present {
+		fv = fv.Elem()
+	}
+
+	for _, rule := range strings.Split(tag, ",") {
+		name, arg, _ := strings.Cut(rule, "=")
+		if name == "required" {
+			if !present || (fv.Kind() == reflect.String && strings.TrimSpace(fv.String()) == "") {
+				return "is required"
+			}
+			continue
+		}
+		if !present {
+			return ""
+		}
+		if msg := apply(parent, fv, name, arg); msg != "" {
+			return msg
+		}
+	}
+	return ""
+}
+
+func number(v reflect.Value) (float64, bool) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), true
+	}
+	return 0, false
+}
+
+func field(parent reflect.Value, name string) reflect.Value {
+	rt := parent.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		if jsonName(rt.Field(i)) == name {
+			return parent.Field(i)
+		}
+	}
+	panic(fmt.Sprintf("validate: no field %q in %s", name, rt))
+}
+
+func apply(parent, v reflect.Value, name, arg string) string {
+	n, isNumber := number(v)
+	switch name {
+	case "notblank":
+		if strings.TrimSpace(v.String()) == "" {
+			return "must not be blank"
+		}
+	case "min", "max":
+		bound, err := strconv.ParseFloat(arg, 64)
+		if err != nil {
+			panic(fmt.Sprintf("validate: invalid bound %q", arg))
+		}
+		unit := ""
+		if v.Kind() == reflect.String {
+			n, unit = float64(utf8.RuneCountInString(v.String())), " characters"
+		}
+		if name == "min" && n < bound {
+			return fmt.Sprintf("must be at least %s%s", arg, unit)
+		}
+		if name == "max" && n > bound {
+			return fmt.Sprintf("must be at most %s%s", arg, unit)
+		}
+	case "positive":
+		if isNumber && n <= 0 {
+			return "must be greater than 0"
+		}
+	case "oneof":
+		allowed := strings.Fields(arg)
+		if !slices.Contains(allowed, v.String()) {
+			return "must be one of: " + strings.Join(allowed, ", ")
+		}
+	case "lat":
+		if n < -90 || n > 90 {
+			return "must be between -90 and 90"
+		}
+	case "lon":
+		if n < -180 || n > 180 {
+			return "must be between -180 and 180"
+		}
+	case "phone":
+		if !phonePattern.MatchString(v.String()) {
+			return "must be a phone number of 5 to 14 digits with an optional leading +"
+		}
+	case "object":
+		var obj map[string]json.RawMessage
+		if json.Unmarshal(v.Bytes(), &obj) != nil {
+			return "must be a JSON object"
+		}
+	case "after":
+		other := reflect.Indirect(field(parent, arg))
+		if !other.IsValid() || other.IsZero() {
+			return ""
+		}
+		if !v.Interface().(time.Time).After(other.Interface().(time.Time)) {
+			return "must be after " + arg
+		}
+	default:
+		panic(fmt.Sprintf("validate: unknown rule %q", name))
+	}
+	return ""
+}